@@ -0,0 +1,7 @@
+// Package merklev1 holds the Go bindings of merkle.proto: the messages, the
+// MerkleService client, and the interface a server implements, as
+// merkle.Server.GRPCService does. The bindings are generated; after changing
+// merkle.proto, regenerate them with protoc-gen-go and protoc-gen-go-grpc.
+package merklev1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative merkle/v1/merkle.proto
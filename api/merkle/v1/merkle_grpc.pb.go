@@ -0,0 +1,262 @@
+// Remote interface of file-change-detector. The messages mirror the JSON
+// reports and snapshot descriptions of the HTTP API, and the methods the
+// Client methods of pkg/merkle they wrap.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: merkle/v1/merkle.proto
+
+package merklev1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	MerkleService_CreateSnapshot_FullMethodName   = "/merkle.v1.MerkleService/CreateSnapshot"
+	MerkleService_CompareSnapshots_FullMethodName = "/merkle.v1.MerkleService/CompareSnapshots"
+	MerkleService_GetRootHash_FullMethodName      = "/merkle.v1.MerkleService/GetRootHash"
+	MerkleService_StreamChanges_FullMethodName    = "/merkle.v1.MerkleService/StreamChanges"
+)
+
+// MerkleServiceClient is the client API for MerkleService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MerkleServiceClient interface {
+	// CreateSnapshot scans a folder and saves its snapshot (operator)
+	CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest, opts ...grpc.CallOption) (*SnapshotInfo, error)
+	// CompareSnapshots compares two stored snapshots by ID (viewer)
+	CompareSnapshots(ctx context.Context, in *CompareSnapshotsRequest, opts ...grpc.CallOption) (*ChangeReport, error)
+	// GetRootHash describes the latest snapshot of a folder (viewer)
+	GetRootHash(ctx context.Context, in *GetRootHashRequest, opts ...grpc.CallOption) (*SnapshotInfo, error)
+	// StreamChanges watches a folder and sends a report whenever it changes,
+	// until the caller cancels (viewer)
+	StreamChanges(ctx context.Context, in *StreamChangesRequest, opts ...grpc.CallOption) (MerkleService_StreamChangesClient, error)
+}
+
+type merkleServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMerkleServiceClient(cc grpc.ClientConnInterface) MerkleServiceClient {
+	return &merkleServiceClient{cc}
+}
+
+func (c *merkleServiceClient) CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest, opts ...grpc.CallOption) (*SnapshotInfo, error) {
+	out := new(SnapshotInfo)
+	err := c.cc.Invoke(ctx, MerkleService_CreateSnapshot_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *merkleServiceClient) CompareSnapshots(ctx context.Context, in *CompareSnapshotsRequest, opts ...grpc.CallOption) (*ChangeReport, error) {
+	out := new(ChangeReport)
+	err := c.cc.Invoke(ctx, MerkleService_CompareSnapshots_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *merkleServiceClient) GetRootHash(ctx context.Context, in *GetRootHashRequest, opts ...grpc.CallOption) (*SnapshotInfo, error) {
+	out := new(SnapshotInfo)
+	err := c.cc.Invoke(ctx, MerkleService_GetRootHash_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *merkleServiceClient) StreamChanges(ctx context.Context, in *StreamChangesRequest, opts ...grpc.CallOption) (MerkleService_StreamChangesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MerkleService_ServiceDesc.Streams[0], MerkleService_StreamChanges_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &merkleServiceStreamChangesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MerkleService_StreamChangesClient interface {
+	Recv() (*ChangeReport, error)
+	grpc.ClientStream
+}
+
+type merkleServiceStreamChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *merkleServiceStreamChangesClient) Recv() (*ChangeReport, error) {
+	m := new(ChangeReport)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MerkleServiceServer is the server API for MerkleService service.
+// All implementations must embed UnimplementedMerkleServiceServer
+// for forward compatibility
+type MerkleServiceServer interface {
+	// CreateSnapshot scans a folder and saves its snapshot (operator)
+	CreateSnapshot(context.Context, *CreateSnapshotRequest) (*SnapshotInfo, error)
+	// CompareSnapshots compares two stored snapshots by ID (viewer)
+	CompareSnapshots(context.Context, *CompareSnapshotsRequest) (*ChangeReport, error)
+	// GetRootHash describes the latest snapshot of a folder (viewer)
+	GetRootHash(context.Context, *GetRootHashRequest) (*SnapshotInfo, error)
+	// StreamChanges watches a folder and sends a report whenever it changes,
+	// until the caller cancels (viewer)
+	StreamChanges(*StreamChangesRequest, MerkleService_StreamChangesServer) error
+	mustEmbedUnimplementedMerkleServiceServer()
+}
+
+// UnimplementedMerkleServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedMerkleServiceServer struct {
+}
+
+func (UnimplementedMerkleServiceServer) CreateSnapshot(context.Context, *CreateSnapshotRequest) (*SnapshotInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSnapshot not implemented")
+}
+func (UnimplementedMerkleServiceServer) CompareSnapshots(context.Context, *CompareSnapshotsRequest) (*ChangeReport, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompareSnapshots not implemented")
+}
+func (UnimplementedMerkleServiceServer) GetRootHash(context.Context, *GetRootHashRequest) (*SnapshotInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRootHash not implemented")
+}
+func (UnimplementedMerkleServiceServer) StreamChanges(*StreamChangesRequest, MerkleService_StreamChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamChanges not implemented")
+}
+func (UnimplementedMerkleServiceServer) mustEmbedUnimplementedMerkleServiceServer() {}
+
+// UnsafeMerkleServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MerkleServiceServer will
+// result in compilation errors.
+type UnsafeMerkleServiceServer interface {
+	mustEmbedUnimplementedMerkleServiceServer()
+}
+
+func RegisterMerkleServiceServer(s grpc.ServiceRegistrar, srv MerkleServiceServer) {
+	s.RegisterService(&MerkleService_ServiceDesc, srv)
+}
+
+func _MerkleService_CreateSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MerkleServiceServer).CreateSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MerkleService_CreateSnapshot_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MerkleServiceServer).CreateSnapshot(ctx, req.(*CreateSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MerkleService_CompareSnapshots_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompareSnapshotsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MerkleServiceServer).CompareSnapshots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MerkleService_CompareSnapshots_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MerkleServiceServer).CompareSnapshots(ctx, req.(*CompareSnapshotsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MerkleService_GetRootHash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRootHashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MerkleServiceServer).GetRootHash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MerkleService_GetRootHash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MerkleServiceServer).GetRootHash(ctx, req.(*GetRootHashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MerkleService_StreamChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MerkleServiceServer).StreamChanges(m, &merkleServiceStreamChangesServer{stream})
+}
+
+type MerkleService_StreamChangesServer interface {
+	Send(*ChangeReport) error
+	grpc.ServerStream
+}
+
+type merkleServiceStreamChangesServer struct {
+	grpc.ServerStream
+}
+
+func (x *merkleServiceStreamChangesServer) Send(m *ChangeReport) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// MerkleService_ServiceDesc is the grpc.ServiceDesc for MerkleService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MerkleService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "merkle.v1.MerkleService",
+	HandlerType: (*MerkleServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateSnapshot",
+			Handler:    _MerkleService_CreateSnapshot_Handler,
+		},
+		{
+			MethodName: "CompareSnapshots",
+			Handler:    _MerkleService_CompareSnapshots_Handler,
+		},
+		{
+			MethodName: "GetRootHash",
+			Handler:    _MerkleService_GetRootHash_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamChanges",
+			Handler:       _MerkleService_StreamChanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "merkle/v1/merkle.proto",
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+const ackUsage = `Usage: go run main.go ack [--namespace=<ns>] [--by=<name>] [--note=<text>] <folder_path> <file_path>...
+       go run main.go ack [--namespace=<ns>] [--by=<name>] [--note=<text>] --all <folder_path>
+       go run main.go ack list [--namespace=<ns>] <folder_path>`
+
+// runAck marks changes as reviewed and expected, so later reports and
+// verifies tell them from new findings. Files are acknowledged as they are
+// now; --all acknowledges every change since the folder's baseline, or its
+// latest snapshot when it has none.
+func runAck(args []string) {
+	list := len(args) > 0 && args[0] == "list"
+	if list {
+		args = args[1:]
+	}
+
+	storageDir := defaultStorageDir
+	by := os.Getenv("USER")
+	note := ""
+	all := false
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case strings.HasPrefix(arg, "--by=") && !list:
+			by = strings.TrimPrefix(arg, "--by=")
+		case strings.HasPrefix(arg, "--note=") && !list:
+			note = strings.TrimPrefix(arg, "--note=")
+		case arg == "--all" && !list:
+			all = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	client := newClient(storageDir)
+	switch {
+	case list && len(positional) == 1:
+		listAcknowledgements(client, positional[0])
+	case !list && all && len(positional) == 1:
+		acknowledgeAll(client, positional[0], by, note)
+	case !list && !all && len(positional) > 1:
+		if err := client.AcknowledgeFiles(positional[0], positional[1:], by, note); err != nil {
+			fmt.Printf("Error acknowledging changes: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Acknowledged %d file(s) of %s\n", len(positional)-1, filepath.Base(positional[0]))
+	default:
+		fmt.Println(ackUsage)
+		os.Exit(1)
+	}
+}
+
+// acknowledgeAll acknowledges every change a verify of the folder would
+// report now
+func acknowledgeAll(client merkle.Client, folderPath, by, note string) {
+	previousFile, err := baselineSnapshot(client, folderPath)
+	if err != nil {
+		previousFile, err = client.FindLatestSnapshot(folderPath)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	previousState, err := client.LoadSnapshot(previousFile)
+	if err != nil {
+		fmt.Printf("Error loading %s: %v\n", previousFile, err)
+		os.Exit(1)
+	}
+	currentState, err := client.CreateSnapshot(folderPath)
+	if err != nil {
+		fmt.Printf("Error creating Merkle tree: %v\n", err)
+		os.Exit(1)
+	}
+	report, err := client.CompareSnapshots(previousState, currentState)
+	if err != nil {
+		fmt.Printf("Error comparing states: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := client.AcknowledgeChanges(folderPath, report.Changes, by, note); err != nil {
+		fmt.Printf("Error acknowledging changes: %v\n", err)
+		os.Exit(1)
+	}
+	for _, change := range report.Changes {
+		fmt.Printf("  [%s] %s\n", merkle.GetChangeTypeString(change.ChangeType), change.FileName)
+	}
+	fmt.Printf("Acknowledged %d change(s) since %s\n", len(report.Changes), previousFile)
+}
+
+// listAcknowledgements prints the acknowledgements of a folder, oldest first
+func listAcknowledgements(client merkle.Client, folderPath string) {
+	acks, err := client.Acknowledgements(folderPath)
+	if err != nil {
+		fmt.Printf("Error reading acknowledgements: %v\n", err)
+		os.Exit(1)
+	}
+	if len(acks) == 0 {
+		fmt.Printf("No acknowledged changes for %s\n", filepath.Base(folderPath))
+		return
+	}
+
+	for _, ack := range acks {
+		state := "deleted"
+		if ack.Hash != nil {
+			state = fmt.Sprintf("%x", ack.Hash[:min(8, len(ack.Hash))])
+		}
+		fmt.Printf("%s  %s  %s", ack.At.Format("2006-01-02 15:04:05"), ack.FileName, state)
+		if ack.By != "" {
+			fmt.Printf("  by %s", ack.By)
+		}
+		if ack.Note != "" {
+			fmt.Printf(": %s", ack.Note)
+		}
+		fmt.Println()
+	}
+}
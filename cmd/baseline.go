@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+const baselineUsage = `Usage: go run main.go baseline set [--namespace=<ns>] [--force] <folder_path> <name> [snapshot-id]
+       go run main.go baseline promote [--namespace=<ns>] <folder_path> [snapshot-id]
+       go run main.go baseline list [--namespace=<ns>] <folder_path>`
+
+// runBaseline manages the baselines of a folder: named copies of snapshots
+// kept apart from the history, and the baseline verify compares against.
+// Without a snapshot ID, set and promote use the folder's latest snapshot.
+func runBaseline(args []string) {
+	if len(args) == 0 {
+		fmt.Println(baselineUsage)
+		os.Exit(1)
+	}
+	command := args[0]
+
+	storageDir := defaultStorageDir
+	force := false
+	var positional []string
+	for _, arg := range args[1:] {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case arg == "--force" && command == "set":
+			force = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	client := newClient(storageDir)
+	switch {
+	case command == "set" && (len(positional) == 2 || len(positional) == 3):
+		file := snapshotArg(client, positional[0], positional[2:])
+		saved, err := client.SaveNamedBaseline(positional[0], positional[1], file, force)
+		if err != nil {
+			fmt.Printf("Error saving baseline: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Baseline %s saved from %s: %s\n", positional[1], file, saved)
+	case command == "promote" && (len(positional) == 1 || len(positional) == 2):
+		file := snapshotArg(client, positional[0], positional[1:])
+		if err := client.SetBaseline(positional[0], file, "manual"); err != nil {
+			fmt.Printf("Error promoting baseline: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Baseline promoted (manual): %s\n", file)
+	case command == "list" && len(positional) == 1:
+		listBaselines(client, positional[0])
+	default:
+		fmt.Println(baselineUsage)
+		os.Exit(1)
+	}
+}
+
+// snapshotArg resolves an optional snapshot ID, defaulting to the folder's
+// latest snapshot
+func snapshotArg(client merkle.Client, folderPath string, id []string) string {
+	var file string
+	var err error
+	if len(id) == 0 {
+		file, err = client.FindLatestSnapshot(folderPath)
+	} else {
+		file, err = client.ResolveSnapshot(id[0])
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	return file
+}
+
+// listBaselines prints the baseline verify uses and the named baselines of a folder
+func listBaselines(client merkle.Client, folderPath string) {
+	baseline, err := client.Baseline(folderPath)
+	if err != nil {
+		fmt.Printf("Error reading baselines: %v\n", err)
+		os.Exit(1)
+	}
+	named, err := client.NamedBaselines(folderPath)
+	if err != nil {
+		fmt.Printf("Error reading baselines: %v\n", err)
+		os.Exit(1)
+	}
+
+	if baseline != nil {
+		fmt.Printf("(verify)  %s (%s, %s)\n", baseline.Snapshot, baseline.Reason,
+			baseline.Promoted.Format("2006-01-02 15:04:05"))
+	}
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%-9s %s\n", name, named[name])
+	}
+	if baseline == nil && len(names) == 0 {
+		fmt.Printf("No baselines for %s\n", filepath.Base(folderPath))
+	}
+}
+
+// baselineSnapshot returns the baseline snapshot of a folder, failing when no
+// snapshot has been promoted yet
+func baselineSnapshot(client merkle.Client, folderPath string) (string, error) {
+	baseline, err := client.Baseline(folderPath)
+	if err != nil {
+		return "", err
+	}
+	if baseline == nil {
+		return "", fmt.Errorf("no baseline has been promoted for %s", filepath.Base(folderPath))
+	}
+	return baseline.Snapshot, nil
+}
+
+// promoteBaseline applies the promotion rules given on the command line,
+// reporting the outcome to w
+func promoteBaseline(w io.Writer, client merkle.Client, folderPath string, policy merkle.PromotionPolicy) {
+	if policy == (merkle.PromotionPolicy{}) {
+		return
+	}
+	baseline, err := client.PromoteBaseline(folderPath, policy)
+	if err != nil {
+		fmt.Fprintf(w, "Error promoting baseline: %v\n", err)
+		return
+	}
+	if baseline != nil {
+		fmt.Fprintf(w, "\nBaseline promoted (%s): %s\n", baseline.Reason, baseline.Snapshot)
+	}
+}
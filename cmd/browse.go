@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+// runBrowse opens an interactive, directory-at-a-time view of a stored snapshot
+func runBrowse(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: go run main.go browse <snapshot.csv>")
+		os.Exit(1)
+	}
+
+	client := newClient(defaultStorageDir)
+	state, err := client.LoadSnapshot(args[0])
+	if err != nil {
+		fmt.Printf("Error loading snapshot: %v\n", err)
+		os.Exit(1)
+	}
+
+	root := merkle.BuildDirTree(state)
+	current := root
+
+	fmt.Printf("Browsing %s (%d files, root %x)\n", args[0], root.FileCount(), root.RollupHash()[:8])
+	fmt.Println("Commands: ls, cd <dir>, cd .., info <name>, help, quit")
+	listDir(current)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Printf("/%s> ", current.Path)
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+
+		command, arg, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		arg = strings.TrimSpace(arg)
+
+		switch command {
+		case "":
+		case "ls":
+			listDir(current)
+		case "cd":
+			current = changeDir(root, current, arg)
+		case "info":
+			showInfo(state, current, arg)
+		case "help":
+			fmt.Println("  ls            list the current directory")
+			fmt.Println("  cd <dir>      enter a directory (.. for parent, / for root)")
+			fmt.Println("  info <name>   show hashes, size and digests of an entry")
+			fmt.Println("  quit          leave the browser")
+		case "quit", "exit", "q":
+			return
+		default:
+			fmt.Printf("Unknown command '%s' (try help)\n", command)
+		}
+	}
+}
+
+// listDir prints the entries of a directory with their rollup hashes
+func listDir(dir *merkle.DirNode) {
+	for _, child := range dir.Children() {
+		if child.IsDir {
+			fmt.Printf("  [DIR]  %-40s %x  (%d files)\n", child.Name+"/", child.RollupHash()[:8], child.FileCount())
+		} else {
+			fmt.Printf("  [FILE] %-40s %x\n", child.Name, child.Hash[:min(8, len(child.Hash))])
+		}
+	}
+}
+
+// changeDir resolves a cd argument relative to the current directory
+func changeDir(root, current *merkle.DirNode, arg string) *merkle.DirNode {
+	switch arg {
+	case "", "/":
+		return root
+	case "..":
+		if current.Parent != nil {
+			return current.Parent
+		}
+		return current
+	}
+
+	target := current
+	for _, part := range strings.Split(strings.Trim(arg, "/"), "/") {
+		child, ok := target.Child(part)
+		if !ok || !child.IsDir {
+			fmt.Printf("No such directory: %s\n", arg)
+			return current
+		}
+		target = child
+	}
+	return target
+}
+
+// showInfo prints the details of an entry in the current directory
+func showInfo(state *merkle.TreeState, current *merkle.DirNode, name string) {
+	node, ok := current.Child(name)
+	if !ok {
+		fmt.Printf("No such entry: %s\n", name)
+		return
+	}
+
+	fmt.Printf("  Path:    %s\n", node.Path)
+	if node.IsDir {
+		fmt.Printf("  Rollup:  %x\n", node.RollupHash())
+		fmt.Printf("  Files:   %d\n", node.FileCount())
+		return
+	}
+
+	fmt.Printf("  SHA-256: %x\n", node.Hash)
+	fmt.Printf("  Size:    %d bytes\n", state.FileSizes[node.Path])
+	fmt.Printf("  Content: %s\n", merkle.GetContentKindString(state.Content[node.Path]))
+	for algorithm, digest := range state.Digests[node.Path] {
+		fmt.Printf("  %-8s %x\n", strings.ToUpper(algorithm)+":", digest)
+	}
+}
@@ -2,76 +2,1673 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/Ridwan414/file-change-detector/pkg/merkle"
 )
 
+// defaultStorageDir is where snapshots and other state are kept
+const defaultStorageDir = "merkle_states"
+
+// errorStatus is the exit status of errors. Most commands exit 1, but runs that
+// may exit with the severity of the changes they found, where 1 means info
+// drift, exit merkle.ExitError instead.
+var errorStatus = 1
+
+// newClient creates a client for a storage directory, which keeps using its
+// SQLite database once --sqlite created one
+func newClient(storageDir string, opts ...merkle.Option) merkle.Client {
+	if merkle.HasSQLiteStorage(storageDir) {
+		opts = append(opts, merkle.WithSQLiteStorage())
+	}
+	return merkle.NewClient(storageDir, opts...)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "apply" {
+		runApply(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		runGC(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "prune" {
+		runPrune(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		runDiff(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		runHistory(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "runs" {
+		runRuns(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "snapshots" {
+		runSnapshots(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "label" {
+		runLabel(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "file-history" {
+		runFileHistory(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "show" {
+		runShow(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		runStatus(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "baseline" {
+		runBaseline(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "browse" {
+		runBrowse(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ack" {
+		runAck(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "prove" {
+		runProve(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-all" {
+		runVerifyAll(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-proof" {
+		runVerifyProof(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		runServe(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "upload" {
+		runUpload(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		runSchema(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 2 && os.Args[1] == "hash" {
+		// "hash <folder> [flags]" is shorthand for "<folder> [flags] --hash-only"
+		os.Args = append(append([]string{os.Args[0]}, os.Args[2:]...), "--hash-only")
+	}
+	if len(os.Args) > 2 && os.Args[1] == "watch" {
+		// "watch <folder> [flags]" is shorthand for "<folder> [flags] --watch"
+		os.Args = append(append([]string{os.Args[0]}, os.Args[2:]...), "--watch")
+	}
+	if len(os.Args) > 2 && os.Args[1] == "verify" {
+		// "verify <folder> [flags]" is shorthand for "<folder> [flags] --verify"
+		os.Args = append(append([]string{os.Args[0]}, os.Args[2:]...), "--verify")
+	}
+
+	// Severity takes exit statuses 1 to 3, so errors must not look like drift
+	errorStatus = merkle.ExitError
 	if len(os.Args) < 2 {
-		fmt.Println("Usage: go run main.go <folder_path> [--compare]")
+		fmt.Println("Usage: go run main.go <folder_path> [--compare [--exit-code]] [--full] [--quiet] [--namespace=<ns>] [--no-inode-dedup] [--include-special|--exclude-special] [--one-file-system] [--case-insensitive] [--compat-change-types] [--no-renames] [--snapshot-format=<csv|binary|json>] [--sqlite] [--hash-timeout=<duration>]")
+		fmt.Println("       [--load-mode=<auto|strict|lenient>] [--profile=network] [--leaf-order=<bytewise|hierarchical>] [--workers=<n|auto>]")
+		fmt.Println("       [--watch [--watch-poll] [--watch-interval=<d>] [--watch-folder=<path>[=<priority>]]... [--worker-budget=<n>] [--priority=<high|normal|low>]] [--verify [--recheck-delay=<d>]] [--baseline <name>] [--path <prefix>] [--promote-marker=<file>] [--promote-clean-for=<d>]")
+		fmt.Println("       [--snapshot-create=<cmd> [--snapshot-release=<cmd>] | --btrfs-snapshot=<dir>]")
+		fmt.Println("       [--export-patch=<bundle.tar.gz>] [--digests=<algo,...>] [--since=<time>]")
+		fmt.Println("       [--max-files=<n>] [--max-bytes=<n>] [--max-file-growth=<n>] [--max-byte-growth=<n>]")
+		fmt.Println("       [--notify-cmd=<cmd> [--notify-digest=<window>] [--notify-min-interval=<d>]")
+		fmt.Println("        [--notify-suppress=<d>] [--notify-path-cooldown=<d>]] [--hook=<types>[:<pattern>]=<cmd>...]")
+		fmt.Println("       [--severity=<types>[:<pattern>]=<severity>...] [--severity-rules=<file>]")
+		fmt.Println("       [--evidence-dir=<dir> [--evidence-path=<pattern>...]]")
+		fmt.Println("       [--tree-depth=<n>] [--tree-max-children=<n>]")
+		fmt.Println("       [-o|--output <file> [--append] [--output-tree] [--format=text|forensic|json|yaml]] [--template <file>] [--humanize[=times,sizes]]")
+		fmt.Println("       [--hash-algorithm=<name>] [--hasher-cmd=<cmd> [--hasher-name=<name>]]")
+		fmt.Println("       [--chunk-size=<n>] [--normalize-eol=<pattern>] [--strip-lines=<pattern>:<regexp>] [--files-from=<file|->]")
+		fmt.Println("       [--exclude=<pattern>...] [--include=<pattern>...] [--ignore-files[=<name,...>]]")
+		fmt.Println("       [--owner=<user>[:<group>]...] [--exclude-owner=<user>[:<group>]...] [--newer-than=<age>] [--older-than=<age>]")
+		fmt.Println("       go run main.go @<set_name> --manifest=<sets.json> [options]")
+		fmt.Println("       go run main.go oci:<layout_dir>|docker-archive:<image.tar> [--image-ref=<ref>] [options]")
+		fmt.Println("       go run main.go apply <bundle.tar.gz> <target_folder>")
+		fmt.Println("       go run main.go gc [--namespace=<ns>] [--max-age=<age>] [--archive=<dir>] [--dry-run]")
+		fmt.Println("       go run main.go prune [--namespace=<ns>] [--keep-last=<n>] [--keep-daily=<n>] [--keep-weekly=<n>] [--max-age=<age>] [--archive=<dir>] [--dry-run] <folder_path>")
+		fmt.Println("       go run main.go diff [--compat-change-types] [--no-renames] [--format=text|json|yaml] [--humanize[=times,sizes]] <old_snapshot> <new_snapshot> | <folder_a> <folder_b>")
+		fmt.Println("       go run main.go migrate [--namespace=<ns>] [--snapshot-format=<format>] [--sqlite] [--load-mode=<mode>] [--dry-run]")
+		fmt.Println("       go run main.go history [--namespace=<ns>] [--format=text|json] <file_path>")
+		fmt.Println("       go run main.go file-history [--namespace=<ns>] <folder_path> <file_path>")
+		fmt.Println("       go run main.go runs [--namespace=<ns>] [--since=<time>] [--format=text|json] <folder_path>")
+		fmt.Println("       go run main.go snapshots [--namespace=<ns>] [--since=<time>] [--until=<time>] [--label=<label>...] [--newest-first]")
+		fmt.Println("                                [--limit=<n>] [--offset=<n>] [--after=<id>] [--format=text|json] <folder_path>")
+		fmt.Println("       go run main.go label [--namespace=<ns>] [--remove] <snapshot_id> <label>...")
+		fmt.Println("       go run main.go browse <snapshot.csv>")
+		fmt.Println("       go run main.go status [--namespace=<ns>]")
+		fmt.Println("       go run main.go baseline set|promote|list [--namespace=<ns>] <folder_path> ...")
+		fmt.Println("       go run main.go ack [list] [--namespace=<ns>] [--by=<name>] [--note=<text>] [--all] <folder_path> [<file_path>...]")
+		fmt.Println("       go run main.go show [--namespace=<ns>] [--prefix=<dir>] [--format=text|csv|json] <snapshot-id>")
+		fmt.Println("       go run main.go prove [--namespace=<ns>] <snapshot-id> <file_path>")
+		fmt.Println("       go run main.go verify-proof <proof.json> <root_hash> <file_hash>")
+		fmt.Println("       go run main.go schema <name> | --dir=<dir>")
+		fmt.Println("       go run main.go serve [--addr=<host:port>] [--grpc-addr=<host:port>] [--tokens=<file>] [--webhooks=<file>] [--folder=<path>...] [--namespace-folder=<namespace>=<path>...]")
+		fmt.Println("       go run main.go upload --server=<url> [--token=<token>] [--namespace=<ns>] [--remote-namespace=<ns>] [--chunk-size=<n>] <snapshot-id|file>")
+		fmt.Println("       go run main.go hash <folder_path> [scan flags]")
+		fmt.Println("       go run main.go verify <folder_path> [flags]")
+		fmt.Println("       go run main.go verify-all [--namespace=<ns>] [--worker-budget=<n>] [--recheck-delay=<d>] [--format=text|json] [<folder_path>...]")
+		fmt.Println("       go run main.go watch <folder_path> [flags]")
 		fmt.Println("  --compare: Compare with the most recent saved state")
-		os.Exit(1)
+		fmt.Println("  --hash-only: Print only the root hash; nothing is saved")
+		fmt.Println("  --full: Hash every file again instead of reusing the hashes of the latest snapshot for files")
+		fmt.Println("          whose size, modification time, change time and inode are unchanged (--verify always does)")
+		fmt.Println("  --quiet: Do not show scan progress on the terminal")
+		fmt.Println("  --watch: Keep running and report, save and act on changes as they happen (Ctrl+C stops)")
+		fmt.Println("  --watch-poll: Look for changes every --watch-interval instead of on change notifications from the OS")
+		fmt.Println("               (implied by --profile=network, and used when notifications are unavailable)")
+		fmt.Println("  --watch-interval=<d>: How often a polling --watch looks for changes (default 2s); only changed files are re-hashed")
+		fmt.Println("  --watch-folder=<path>[=<priority>]: Watch this folder as well; may be repeated")
+		fmt.Println("  --priority=<high|normal|low>: Priority of the folder to watch; high priority folders are scanned")
+		fmt.Println("                                4x as often and first, and skip notification digests; low ones 4x less often")
+		fmt.Println("  --worker-budget=<n>: Files hashed at once across all watched folders, served per folder in turn")
+		fmt.Println("                       so a large folder cannot starve small ones (default: number of CPUs)")
+		fmt.Println("  --exit-code: With --compare, exit 1, 2 or 3 when the highest severity of the unacknowledged")
+		fmt.Println("               changes is info, warning or critical; 0 when there are none, and 4 when the")
+		fmt.Println("               comparison failed or files could not be hashed. Errors always exit 4 here and in")
+		fmt.Println("               verify-all, 1 in other commands")
+		fmt.Println("  --verify: Compare with the folder's baseline instead; nothing is saved, exits like --exit-code")
+		fmt.Println("  --recheck-delay=<d>: With --verify, hash mismatched files again after this delay (default 2s, 0 disables)")
+		fmt.Println("  --baseline <name>: Compare with a named baseline saved by 'baseline set'")
+		fmt.Println("  --promote-marker=<file>: Promote the first state taken after this file is touched to baseline")
+		fmt.Println("  --promote-clean-for=<d>: Promote a state to baseline once it has stayed unchanged this long (e.g. 24h)")
+		fmt.Println("  --namespace=<ns>: Keep snapshots and history in a separate per-tenant area")
+		fmt.Println("  --since=<time>: Compare with the newest state at or before a date (2024-01-01) or age (7d, 12h)")
+		fmt.Println("  --no-inode-dedup: Hash every hardlinked path separately")
+		fmt.Println("  --include-special: Record sockets, FIFOs and device nodes as special entries")
+		fmt.Println("  --exclude-special: Skip sockets, FIFOs and device nodes (default)")
+		fmt.Println("  --snapshot-format=<format>: Save snapshots as csv (the default), binary (compact and indexed) or json")
+		fmt.Println("                              (versioned, with paths that need no escaping)")
+		fmt.Println("  --binary-snapshots: Same as --snapshot-format=binary")
+		fmt.Println("  --sqlite: Keep snapshots in one SQLite database (snapshots.db) instead of one file each; once")
+		fmt.Println("            created, the database is used without the flag, and migrate moves old files into it")
+		fmt.Println("  --case-insensitive: Treat paths that differ only in letter case as the same file")
+		fmt.Println("  --compat-change-types: Report renamed and moved files as a deletion plus an addition")
+		fmt.Println("  --no-renames: Never pair a deletion and an addition as a rename, move or case rename")
+		fmt.Println("  --load-mode=<mode>: How to load snapshots with malformed values: strict fails, lenient warns")
+		fmt.Println("                      (auto, the default, is strict for current formats only)")
+		fmt.Println("  --leaf-order=<order>: Order leaves by path bytes (bytewise, the default) or component by component")
+		fmt.Println("                        with paths normalized to NFC (hierarchical); the order is recorded as the")
+		fmt.Println("                        snapshot's tree version")
+		fmt.Println("  --one-file-system: Do not descend into other mounted file systems")
+		fmt.Println("  --hash-timeout=<duration>: Give up on files that take longer than this to hash (e.g. 30s)")
+		fmt.Println("  --profile=network: Tune for NFS/SMB shares: longer timeout, stale handle retries, mtime tolerance")
+		fmt.Println("  --workers=<n>: Hash n files at the same time instead of tuning the number from read latency")
+		fmt.Println("                 and throughput while scanning (auto, the default, between 1 and 4x the CPUs)")
+		fmt.Println("  --snapshot-create=<cmd>: Scan the filesystem snapshot whose path this command prints")
+		fmt.Println("  --snapshot-release=<cmd>: Command that releases the snapshot (gets FCD_FOLDER and FCD_VIEW)")
+		fmt.Println("  --btrfs-snapshot=<dir>: Scan a read-only Btrfs snapshot created in <dir>")
+		fmt.Println("  --manifest=<file>: Load named file sets; scan one by passing @<set_name> instead of a folder")
+		fmt.Println("  --files-from=<file|->: Scan only the files listed (newline or NUL separated; - reads stdin)")
+		fmt.Println("  --exclude=<pattern>: Leave out paths matching a .gitignore style pattern (e.g. node_modules/, *.log)")
+		fmt.Println("  --include=<pattern>: Scan only files matching one of these patterns (e.g. *.go)")
+		fmt.Println("  --ignore-files[=<names>]: Honor ignore files in the folder and below (default: .gitignore,.merkleignore)")
+		fmt.Println("  --owner=<user>[:<group>]: Scan only files of this owner and/or group (e.g. root, :wheel); may be repeated")
+		fmt.Println("  --exclude-owner=<user>[:<group>]: Leave out files of this owner and/or group; may be repeated")
+		fmt.Println("  --newer-than=<age>: Scan only files modified less than this long ago (e.g. 7d, 12h)")
+		fmt.Println("  --older-than=<age>: Leave out files modified less than this long ago")
+		fmt.Println("  --image-ref=<ref>: Image to scan when an OCI layout or docker save tarball holds several")
+		fmt.Printf("  --digests=<algo,...>: Also store digests for every file, any of %s\n", strings.Join(merkle.HashAlgorithms(), ", "))
+		fmt.Println("  --notify-cmd=<cmd>: Pipe a summary of detected changes into a shell command (e.g. mail)")
+		fmt.Println("  --notify-digest=<window>: Batch notifications into one summary per window (e.g. 1h, 1d)")
+		fmt.Println("  --notify-min-interval=<d>: Send at most one notification per interval")
+		fmt.Println("  --notify-suppress=<d>: Drop notifications identical to the previous one within this time")
+		fmt.Println("  --notify-path-cooldown=<d>: Collapse repeated changes to the same file within this time")
+		fmt.Println("  --hook=<types>[:<pattern>]=<cmd>: Run cmd when files matching pattern change in one of the")
+		fmt.Println("                     given ways, e.g. --hook='modified:conf.d/=systemctl reload nginx'")
+		fmt.Println("  --severity=<types>[:<pattern>]=<severity>: Flag matching changes as info, warning or critical;")
+		fmt.Println("                     the last matching rule wins, e.g. --severity='*:etc/passwd=critical'")
+		fmt.Println("  --severity-rules=<file>: Read severity rules from a file, one per line")
+		fmt.Println("  --evidence-dir=<dir>: Copy added and modified files into a timestamped evidence folder")
+		fmt.Println("  --evidence-path=<pattern>: Only capture evidence for matching paths (dir/ or glob; repeatable)")
+		fmt.Println("  -o, --output <file>: Write the change report to a file instead of stdout")
+		fmt.Println("  --append: Append to the output file instead of replacing it (for rolling logs)")
+		fmt.Println("  --output-tree: Also write the tree dump to the output file (text reports only)")
+		fmt.Println("  --format=forensic: Report owner, mode, times, inode and SHA-256/MD5 digests of every changed file")
+		fmt.Println("  --format=json: Write the change report and its size statistics as JSON; when it goes to stdout,")
+		fmt.Println("                 everything else the run prints goes to stderr")
+		fmt.Println("  --format=yaml: Write the same fields as --format=json as YAML")
+		fmt.Println("  --template <file>: Render the change report through a Go text/template")
+		fmt.Println("  --humanize[=times,sizes]: Show times with how long ago they were and sizes in KiB, MiB, ... in text reports")
+		fmt.Println("  --tree-depth=<n>: Collapse tree nodes below depth n")
+		fmt.Println("  --tree-max-children=<n>: List at most n files under a tree node")
+		fmt.Printf("  --hash-algorithm=<name>: Hash file content and combine tree nodes with %s\n", strings.Join(merkle.HashAlgorithms(), ", "))
+		fmt.Println("                           (default sha256; xxhash, md5 and sha1 are not collision resistant)")
+		fmt.Println("  --hasher-cmd=<cmd>: Hash each file with an external command; it gets the path in FCD_FILE and the")
+		fmt.Println("                      content on stdin, and prints the hex digest first (e.g. 'sha256sum \"$FCD_FILE\"')")
+		fmt.Println("  --hasher-name=<name>: Digest name recorded for --hasher-cmd, so snapshots of other hashers are not compared")
+		fmt.Println("  --chunk-size=<n>: Hash files in chunks of about n bytes to estimate how much of a modified file changed")
+		fmt.Println("  --normalize-eol=<pattern>: Treat CRLF as LF when hashing files matching pattern (e.g. *.txt)")
+		fmt.Println("  --strip-lines=<pattern>:<regexp>: Ignore lines matching regexp when hashing files matching pattern")
+		fmt.Println("  --max-files, --max-bytes: Alert when the folder holds more files or bytes than this")
+		fmt.Println("  --max-file-growth, --max-byte-growth: Alert when the folder grew by more than this since the last state")
+		fmt.Println("  --path <prefix>: With --compare, --verify or --baseline, only scan and compare files under this")
+		fmt.Println("                   subdirectory of the folder; the state is not saved")
+		fmt.Println("  --export-patch=<file>: With --compare, package added/modified files and deletions into a tar.gz bundle")
+		os.Exit(errorStatus)
 	}
 
 	folderPath := os.Args[1]
 	compareMode := false
+	patchPath := ""
+	var since time.Time
+	var quotas merkle.QuotaRules
+	notifyCmd := ""
+	var hooks []merkle.ActionHook
+	var evidence merkle.EvidencePolicy
+	var digestWindow time.Duration
+	var rateLimit merkle.RateLimitNotifier
+	storageDir := defaultStorageDir
+	var treeLimits merkle.TreeLimits
+	var opts []merkle.Option
+	var snapshotCmds *merkle.CommandSnapshotProvider
+	var hasher merkle.CommandLeafHasher
+	hashAlgorithm := ""
+
+	var output outputOptions
+	hashOnly := false
+	full := false
+	quiet := false
+	verify := false
+	exitCode := false
+	watch := false
+	watchInterval := merkle.DefaultWatchInterval
+	watchPoll := false
+	watchFolders := []watchFolder{{}}
+	workerBudget := 0
+	baselineName := ""
+	pathScope := ""
+	recheckDelay := 2 * time.Second
+	var promotion merkle.PromotionPolicy
+
+	// Parse flags
+	args := os.Args[2:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-o" || arg == "--output":
+			if i+1 >= len(args) {
+				fmt.Printf("Error: %s requires a file name\n", arg)
+				os.Exit(errorStatus)
+			}
+			i++
+			output.path = args[i]
+		case strings.HasPrefix(arg, "--output="):
+			output.path = strings.TrimPrefix(arg, "--output=")
+		case arg == "--template" || strings.HasPrefix(arg, "--template="):
+			templatePath := strings.TrimPrefix(arg, "--template=")
+			if arg == "--template" {
+				if i+1 >= len(args) {
+					fmt.Printf("Error: %s requires a file name\n", arg)
+					os.Exit(errorStatus)
+				}
+				i++
+				templatePath = args[i]
+			}
+			tmpl, err := merkle.LoadReportTemplate(templatePath)
+			if err != nil {
+				fmt.Printf("Error: Invalid report template: %v\n", err)
+				os.Exit(errorStatus)
+			}
+			output.template = tmpl
+		case arg == "--hash-only":
+			hashOnly = true
+		case arg == "--full":
+			full = true
+		case arg == "--quiet":
+			quiet = true
+		case arg == "--append":
+			output.append = true
+		case arg == "--output-tree":
+			output.tree = true
+		case strings.HasPrefix(arg, "--format="):
+			output.format = strings.TrimPrefix(arg, "--format=")
+			if output.format != "text" && output.format != "forensic" && output.format != "json" && output.format != "yaml" {
+				fmt.Printf("Error: Unsupported output format '%s'\n", output.format)
+				os.Exit(errorStatus)
+			}
+		case arg == "--humanize" || strings.HasPrefix(arg, "--humanize="):
+			output.style = parseHumanize(arg)
+		case arg == "--compare":
+			compareMode = true
+		case arg == "--exit-code":
+			exitCode = true
+		case arg == "--watch":
+			watch = true
+		case strings.HasPrefix(arg, "--watch-interval="):
+			watchInterval = parseDurationFlag(arg, "--watch-interval=")
+		case arg == "--watch-poll":
+			watchPoll = true
+		case strings.HasPrefix(arg, "--watch-folder="):
+			watchFolders = append(watchFolders, parseWatchFolder(strings.TrimPrefix(arg, "--watch-folder=")))
+		case strings.HasPrefix(arg, "--priority="):
+			priority, err := merkle.ParsePriority(strings.TrimPrefix(arg, "--priority="))
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(errorStatus)
+			}
+			watchFolders[0].priority = priority
+		case strings.HasPrefix(arg, "--worker-budget="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--worker-budget="))
+			if err != nil || n < 1 {
+				fmt.Printf("Error: Invalid worker budget '%s'\n", strings.TrimPrefix(arg, "--worker-budget="))
+				os.Exit(errorStatus)
+			}
+			workerBudget = n
+		case arg == "--verify":
+			verify = true
+			compareMode = true
+		case arg == "--baseline" || strings.HasPrefix(arg, "--baseline="):
+			baselineName = strings.TrimPrefix(arg, "--baseline=")
+			if arg == "--baseline" {
+				if i+1 >= len(args) {
+					fmt.Printf("Error: %s requires a baseline name\n", arg)
+					os.Exit(errorStatus)
+				}
+				i++
+				baselineName = args[i]
+			}
+			compareMode = true
+		case arg == "--path" || strings.HasPrefix(arg, "--path="):
+			pathScope = strings.TrimPrefix(arg, "--path=")
+			if arg == "--path" {
+				if i+1 >= len(args) {
+					fmt.Printf("Error: %s requires a path prefix\n", arg)
+					os.Exit(errorStatus)
+				}
+				i++
+				pathScope = args[i]
+			}
+			opts = append(opts, merkle.WithPathScope(pathScope))
+		case strings.HasPrefix(arg, "--recheck-delay="):
+			recheckDelay = parseDurationFlag(arg, "--recheck-delay=")
+		case strings.HasPrefix(arg, "--promote-marker="):
+			promotion.DeployMarker = strings.TrimPrefix(arg, "--promote-marker=")
+		case strings.HasPrefix(arg, "--promote-clean-for="):
+			promotion.CleanFor = parseDurationFlag(arg, "--promote-clean-for=")
+		case arg == "--no-inode-dedup":
+			opts = append(opts, merkle.WithInodeDedup(false))
+		case arg == "--include-special":
+			opts = append(opts, merkle.WithSpecialFiles(true))
+		case arg == "--exclude-special":
+			opts = append(opts, merkle.WithSpecialFiles(false))
+		case arg == "--binary-snapshots":
+			opts = append(opts, merkle.WithSnapshotFormat(merkle.FormatBinary))
+		case strings.HasPrefix(arg, "--snapshot-format="):
+			opts = append(opts, merkle.WithSnapshotFormat(parseSnapshotFormat(strings.TrimPrefix(arg, "--snapshot-format="))))
+		case arg == "--sqlite":
+			opts = append(opts, merkle.WithSQLiteStorage())
+		case arg == "--case-insensitive":
+			opts = append(opts, merkle.WithCaseInsensitivePaths(true))
+		case arg == "--compat-change-types":
+			opts = append(opts, merkle.WithCompatChangeTypes(true))
+		case arg == "--no-renames":
+			opts = append(opts, merkle.WithRenameDetection(false))
+		case strings.HasPrefix(arg, "--load-mode="):
+			opts = append(opts, merkle.WithLoadMode(parseLoadMode(strings.TrimPrefix(arg, "--load-mode="))))
+		case strings.HasPrefix(arg, "--leaf-order="):
+			order, err := merkle.ParseLeafOrder(strings.TrimPrefix(arg, "--leaf-order="))
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(errorStatus)
+			}
+			opts = append(opts, merkle.WithLeafOrder(order))
+		case arg == "--one-file-system":
+			opts = append(opts, merkle.WithOneFileSystem(true))
+		case strings.HasPrefix(arg, "--hash-timeout="):
+			timeout, err := time.ParseDuration(strings.TrimPrefix(arg, "--hash-timeout="))
+			if err != nil {
+				fmt.Printf("Error: Invalid hash timeout: %v\n", err)
+				os.Exit(errorStatus)
+			}
+			opts = append(opts, merkle.WithHashTimeout(timeout))
+		case arg == "--workers=auto":
+			opts = append(opts, merkle.WithAdaptiveHashWorkers(0))
+		case strings.HasPrefix(arg, "--workers="):
+			workers, err := strconv.Atoi(strings.TrimPrefix(arg, "--workers="))
+			if err != nil || workers < 1 {
+				fmt.Printf("Error: Invalid worker count '%s'\n", strings.TrimPrefix(arg, "--workers="))
+				os.Exit(errorStatus)
+			}
+			opts = append(opts, merkle.WithHashWorkers(workers))
+		case strings.HasPrefix(arg, "--profile="):
+			if profile := strings.TrimPrefix(arg, "--profile="); profile != "network" {
+				fmt.Printf("Error: Unknown scan profile '%s'\n", profile)
+				os.Exit(errorStatus)
+			}
+			// Applied first so that individual flags override the profile
+			opts = append([]merkle.Option{merkle.WithNetworkProfile()}, opts...)
+			// Shares do not deliver change notifications for remote writes
+			watchPoll = true
+		case strings.HasPrefix(arg, "--snapshot-create="):
+			if snapshotCmds == nil {
+				snapshotCmds = &merkle.CommandSnapshotProvider{}
+			}
+			snapshotCmds.CreateCommand = strings.TrimPrefix(arg, "--snapshot-create=")
+		case strings.HasPrefix(arg, "--snapshot-release="):
+			if snapshotCmds == nil {
+				snapshotCmds = &merkle.CommandSnapshotProvider{}
+			}
+			snapshotCmds.ReleaseCommand = strings.TrimPrefix(arg, "--snapshot-release=")
+		case strings.HasPrefix(arg, "--since="):
+			t, err := parseSince(strings.TrimPrefix(arg, "--since="), time.Now())
+			if err != nil {
+				fmt.Printf("Error: Invalid --since value: %v\n", err)
+				os.Exit(errorStatus)
+			}
+			since = t
+			compareMode = true
+		case strings.HasPrefix(arg, "--max-files="):
+			quotas.MaxFiles = int(parseLimit(arg, "--max-files="))
+		case strings.HasPrefix(arg, "--max-bytes="):
+			quotas.MaxBytes = parseLimit(arg, "--max-bytes=")
+		case strings.HasPrefix(arg, "--max-file-growth="):
+			quotas.MaxFileGrowth = int(parseLimit(arg, "--max-file-growth="))
+			compareMode = true
+		case strings.HasPrefix(arg, "--max-byte-growth="):
+			quotas.MaxByteGrowth = parseLimit(arg, "--max-byte-growth=")
+			compareMode = true
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case strings.HasPrefix(arg, "--hook="):
+			hook, err := merkle.ParseActionHook(strings.TrimPrefix(arg, "--hook="))
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(errorStatus)
+			}
+			hooks = append(hooks, hook)
+			compareMode = true
+		case strings.HasPrefix(arg, "--severity="):
+			rule, err := merkle.ParseSeverityRule(strings.TrimPrefix(arg, "--severity="))
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(errorStatus)
+			}
+			opts = append(opts, merkle.WithSeverityRules(rule))
+		case strings.HasPrefix(arg, "--severity-rules="):
+			rules, err := merkle.ReadSeverityRules(strings.TrimPrefix(arg, "--severity-rules="))
+			if err != nil {
+				fmt.Printf("Error reading severity rules: %v\n", err)
+				os.Exit(errorStatus)
+			}
+			opts = append(opts, merkle.WithSeverityRules(rules...))
+		case strings.HasPrefix(arg, "--evidence-dir="):
+			evidence.Dir = strings.TrimPrefix(arg, "--evidence-dir=")
+			compareMode = true
+		case strings.HasPrefix(arg, "--evidence-path="):
+			evidence.Patterns = append(evidence.Patterns, strings.TrimPrefix(arg, "--evidence-path="))
+		case strings.HasPrefix(arg, "--notify-cmd="):
+			notifyCmd = strings.TrimPrefix(arg, "--notify-cmd=")
+		case strings.HasPrefix(arg, "--notify-digest="):
+			digestWindow = parseDurationFlag(arg, "--notify-digest=")
+		case strings.HasPrefix(arg, "--notify-min-interval="):
+			rateLimit.MinInterval = parseDurationFlag(arg, "--notify-min-interval=")
+		case strings.HasPrefix(arg, "--notify-suppress="):
+			rateLimit.SuppressFor = parseDurationFlag(arg, "--notify-suppress=")
+		case strings.HasPrefix(arg, "--notify-path-cooldown="):
+			rateLimit.PathCooldown = parseDurationFlag(arg, "--notify-path-cooldown=")
+		case strings.HasPrefix(arg, "--tree-depth="):
+			treeLimits.MaxDepth = int(parseLimit(arg, "--tree-depth="))
+		case strings.HasPrefix(arg, "--tree-max-children="):
+			treeLimits.MaxChildren = int(parseLimit(arg, "--tree-max-children="))
+		case strings.HasPrefix(arg, "--hash-algorithm="):
+			hashAlgorithm = strings.TrimPrefix(arg, "--hash-algorithm=")
+			if !slices.Contains(merkle.HashAlgorithms(), hashAlgorithm) {
+				fmt.Printf("Error: Unsupported hash algorithm '%s'\n", hashAlgorithm)
+				os.Exit(errorStatus)
+			}
+		case strings.HasPrefix(arg, "--hasher-cmd="):
+			hasher.Command = strings.TrimPrefix(arg, "--hasher-cmd=")
+		case strings.HasPrefix(arg, "--hasher-name="):
+			hasher.Name = strings.TrimPrefix(arg, "--hasher-name=")
+			if strings.ContainsAny(hasher.Name, ";,\n") {
+				fmt.Printf("Error: Invalid hasher name '%s'\n", hasher.Name)
+				os.Exit(errorStatus)
+			}
+		case strings.HasPrefix(arg, "--chunk-size="):
+			opts = append(opts, merkle.WithChunkHashing(int(parseLimit(arg, "--chunk-size="))))
+		case strings.HasPrefix(arg, "--normalize-eol="):
+			pattern := strings.TrimPrefix(arg, "--normalize-eol=")
+			opts = append(opts, merkle.WithTransformer("eol", pattern, merkle.NormalizeLineEndings()))
+		case strings.HasPrefix(arg, "--strip-lines="):
+			pattern, expr, ok := strings.Cut(strings.TrimPrefix(arg, "--strip-lines="), ":")
+			re, err := regexp.Compile(expr)
+			if !ok || err != nil {
+				fmt.Printf("Error: Invalid --strip-lines value, expected <pattern>:<regexp>\n")
+				os.Exit(errorStatus)
+			}
+			opts = append(opts, merkle.WithTransformer("strip "+re.String(), pattern, merkle.StripLinesMatching(re)))
+		case strings.HasPrefix(arg, "--digests="):
+			algorithms := strings.Split(strings.TrimPrefix(arg, "--digests="), ",")
+			opts = append(opts, merkle.WithExtraDigests(algorithms...))
+		case strings.HasPrefix(arg, "--manifest="):
+			manifest, err := merkle.LoadManifest(strings.TrimPrefix(arg, "--manifest="))
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(errorStatus)
+			}
+			opts = append(opts, merkle.WithManifest(manifest))
+		case strings.HasPrefix(arg, "--image-ref="):
+			opts = append(opts, merkle.WithImageRef(strings.TrimPrefix(arg, "--image-ref=")))
+		case strings.HasPrefix(arg, "--files-from="):
+			paths := readFileList(strings.TrimPrefix(arg, "--files-from="), folderPath)
+			opts = append(opts, merkle.WithFileList(paths))
+		case strings.HasPrefix(arg, "--exclude="):
+			opts = append(opts, merkle.WithExcludes(strings.TrimPrefix(arg, "--exclude=")))
+		case strings.HasPrefix(arg, "--include="):
+			opts = append(opts, merkle.WithIncludes(strings.TrimPrefix(arg, "--include=")))
+		case strings.HasPrefix(arg, "--owner="):
+			opts = append(opts, merkle.WithOwners(strings.TrimPrefix(arg, "--owner=")))
+		case strings.HasPrefix(arg, "--exclude-owner="):
+			opts = append(opts, merkle.WithExcludeOwners(strings.TrimPrefix(arg, "--exclude-owner=")))
+		case strings.HasPrefix(arg, "--newer-than="):
+			opts = append(opts, merkle.WithNewerThan(parseDurationFlag(arg, "--newer-than=")))
+		case strings.HasPrefix(arg, "--older-than="):
+			opts = append(opts, merkle.WithOlderThan(parseDurationFlag(arg, "--older-than=")))
+		case arg == "--ignore-files":
+			opts = append(opts, merkle.WithIgnoreFiles(merkle.GitIgnoreFile, merkle.MerkleIgnoreFile))
+		case strings.HasPrefix(arg, "--ignore-files="):
+			opts = append(opts, merkle.WithIgnoreFiles(strings.Split(strings.TrimPrefix(arg, "--ignore-files="), ",")...))
+		case strings.HasPrefix(arg, "--export-patch="):
+			patchPath = strings.TrimPrefix(arg, "--export-patch=")
+		case strings.HasPrefix(arg, "--btrfs-snapshot="):
+			opts = append(opts, merkle.WithSnapshotProvider(&merkle.BtrfsSnapshotProvider{
+				SnapshotDir: strings.TrimPrefix(arg, "--btrfs-snapshot="),
+			}))
+		default:
+			fmt.Printf("Error: Unknown flag '%s'\n", arg)
+			os.Exit(errorStatus)
+		}
+	}
+	output.checkStyle()
 
-	// Check for --compare flag
-	if len(os.Args) > 2 && os.Args[2] == "--compare" {
-		compareMode = true
+	// Baseline comparisons skip the history and notification cursor, which
+	// follow consecutive saved states
+	fromBaseline := verify || baselineName != ""
+	if fromBaseline && !since.IsZero() {
+		fmt.Println("Error: --verify and --baseline compare with a baseline and cannot be combined with --since")
+		os.Exit(errorStatus)
 	}
 
-	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
-		fmt.Printf("Error: Folder '%s' does not exist\n", folderPath)
-		os.Exit(1)
+	if hasher.Command != "" && hashAlgorithm != "" {
+		fmt.Println("Error: --hash-algorithm and --hasher-cmd cannot be combined")
+		os.Exit(errorStatus)
+	}
+	if hasher.Command != "" {
+		opts = append(opts, merkle.WithLeafHasher(hasher))
+	} else if hasher.Name != "" {
+		fmt.Println("Error: --hasher-name requires --hasher-cmd")
+		os.Exit(errorStatus)
+	}
+	if hashAlgorithm != "" {
+		opts = append(opts, merkle.WithHashAlgorithm(hashAlgorithm))
+	}
+
+	// Only files whose stat changed since the latest snapshot are hashed,
+	// except by a verify, which must not trust a stat an intruder could restore
+	opts = append(opts, merkle.WithIncrementalSnapshots(true), merkle.WithFullRehash(full || verify))
+
+	if watch && (fromBaseline || !since.IsZero() || hashOnly || patchPath != "" || pathScope != "") {
+		fmt.Println("Error: --watch compares each state with the one before and cannot be combined with")
+		fmt.Println("       --verify, --baseline, --since, --hash-only, --export-patch or --path")
+		os.Exit(errorStatus)
+	}
+	if exitCode && (!compareMode || watch) {
+		fmt.Println("Error: --exit-code requires --compare and cannot be used with --watch")
+		os.Exit(errorStatus)
+	}
+	if pathScope != "" && !compareMode && !hashOnly {
+		fmt.Println("Error: --path requires --compare, --verify, --baseline or --hash-only")
+		os.Exit(errorStatus)
+	}
+	if output.tree && output.machineReadable() {
+		fmt.Printf("Error: --output-tree only applies to text reports, not --format=%s\n", output.format)
+		os.Exit(errorStatus)
+	}
+	if !watch && (len(watchFolders) > 1 || workerBudget > 0 || watchFolders[0].priority != merkle.PriorityNormal) {
+		fmt.Println("Error: --watch-folder, --worker-budget and --priority require --watch")
+		os.Exit(errorStatus)
+	}
+	watchFolders[0].path = folderPath
+
+	if snapshotCmds != nil {
+		if snapshotCmds.CreateCommand == "" {
+			fmt.Println("Error: --snapshot-release requires --snapshot-create")
+			os.Exit(errorStatus)
+		}
+		opts = append(opts, merkle.WithSnapshotProvider(snapshotCmds))
+	}
+
+	// Long scans show they are alive; watches scan continuously and would
+	// redraw the line all the time
+	if !quiet && !watch && isTerminal(os.Stderr) {
+		bar := &progressBar{out: os.Stderr}
+		opts = append(opts, merkle.WithProgress(bar.update))
+	}
+
+	// File sets are resolved from the manifest and images are read when the
+	// tree is built
+	for _, folder := range watchFolders {
+		path := folder.path
+		if _, err := os.Stat(path); os.IsNotExist(err) && !merkle.IsSetPath(path) && !merkle.IsImagePath(path) {
+			fmt.Printf("Error: Folder '%s' does not exist\n", path)
+			os.Exit(errorStatus)
+		}
 	}
 
 	// Create client with storage directory
-	client := merkle.NewClient("merkle_states")
+	client := newClient(storageDir, opts...)
 
-	fmt.Printf("Creating Merkle tree for folder: %s\n", folderPath)
+	var notifier merkle.Notifier
+	if notifyCmd != "" {
+		notifier = &merkle.CommandNotifier{Command: notifyCmd}
+		if rateLimit.MinInterval > 0 || rateLimit.SuppressFor > 0 || rateLimit.PathCooldown > 0 {
+			rateLimit.Next = notifier
+			rateLimit.StatePath = filepath.Join(storageDir, "notify_state.json")
+			notifier = &rateLimit
+		}
+		if digestWindow > 0 {
+			notifier = &merkle.DigestNotifier{
+				Next:      notifier,
+				Window:    digestWindow,
+				StatePath: filepath.Join(storageDir, "digest_pending.csv"),
+			}
+		}
+	}
+
+	// Notifications cover everything since the last reported snapshot, so a
+	// failed or interrupted run is retried rather than lost
+	var cursor *merkle.ReportCursor
+	if notifier != nil && compareMode && since.IsZero() && !fromBaseline && pathScope == "" {
+		cursor = &merkle.ReportCursor{Path: filepath.Join(storageDir, "notify_cursor.json")}
+	}
+	notifyFailed := false
+
+	if watch {
+		runWatch(client, watchFolders, watchInterval, watchPoll, workerBudget, watchActions{
+			output:   output,
+			hooks:    hooks,
+			evidence: evidence,
+			notifier: notifier,
+		})
+		return
+	}
+
+	// Print nothing but the root hash so scripts can capture it
+	if hashOnly {
+		tree, err := client.GetTree(folderPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating Merkle tree: %v\n", err)
+			os.Exit(errorStatus)
+		}
+		fmt.Printf("%x\n", tree.Root.Hash)
+		return
+	}
+
+	// Reports go to stdout unless --output names a file
+	reportOut, err := output.open()
+	if err != nil {
+		fmt.Printf("Error opening output file: %v\n", err)
+		os.Exit(errorStatus)
+	}
+	defer reportOut.Close()
+
+	// A JSON or YAML report on stdout must parse as a whole, so everything
+	// else the run prints goes to stderr
+	logOut := io.Writer(os.Stdout)
+	if output.machineReadable() && output.path == "" {
+		logOut = os.Stderr
+	}
+
+	fmt.Fprintf(logOut, "Creating Merkle tree for folder: %s\n", folderPath)
+	started := time.Now()
+
+	treeOut := logOut
+	if output.tree {
+		treeOut = reportOut
+	}
 
 	// Get the Merkle tree
 	tree, err := client.GetTree(folderPath)
 	if err != nil {
-		fmt.Printf("Error creating Merkle tree: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(logOut, "Error creating Merkle tree: %v\n", err)
+		os.Exit(errorStatus)
 	}
 
-	fmt.Printf("\nMerkle Tree Root Hash: %x\n", tree.Root.Hash)
-	fmt.Println("\nTree Structure:")
-	merkle.PrintTree(tree.Root, 0)
+	fmt.Fprintf(logOut, "\nMerkle Tree Root Hash: %x\n", tree.Root.Hash)
+	stats := tree.Stats()
+	fmt.Fprintf(logOut, "Files: %d, depth: %d, size: %d bytes\n", stats.Leaves, stats.Depth, stats.Bytes)
+	fmt.Fprintln(treeOut, "\nTree Structure:")
+	merkle.WriteTree(treeOut, tree.Root, 0, treeLimits)
 
 	// Create current snapshot
 	currentState, err := client.CreateSnapshot(folderPath)
 	if err != nil {
-		fmt.Printf("Error creating snapshot: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(logOut, "Error creating snapshot: %v\n", err)
+		os.Exit(errorStatus)
+	}
+
+	for fileName, reason := range currentState.Errors {
+		fmt.Fprintf(logOut, "Warning: could not hash %s: %s (will retry next run)\n", fileName, reason)
+	}
+
+	for fileName := range currentState.Unstable {
+		fmt.Fprintf(logOut, "Warning: %s changed while it was being hashed\n", fileName)
+	}
+
+	// Verification does not save a state, so the rules only see stored ones
+	if verify {
+		promoteBaseline(logOut, client, folderPath, promotion)
 	}
 
 	// Compare with previous state if requested
+	var previousState *merkle.TreeState
+	var runReport *merkle.ChangeReport // the comparison of this run, for its summary
+	status := 0                        // exit status of --exit-code and --verify
 	if compareMode {
-		latestFile, err := client.FindLatestSnapshot(folderPath)
+		var latestFile string
+		if baselineName != "" {
+			latestFile, err = client.NamedBaseline(folderPath, baselineName)
+		} else if verify {
+			latestFile, err = baselineSnapshot(client, folderPath)
+		} else if since.IsZero() {
+			latestFile, err = client.FindLatestSnapshot(folderPath)
+		} else {
+			latestFile, err = client.FindSnapshotAt(folderPath, since)
+		}
 		if err != nil {
-			fmt.Printf("\nNo previous state to compare with: %v\n", err)
+			fmt.Fprintf(logOut, "\nNo previous state to compare with: %v\n", err)
 		} else {
-			fmt.Printf("\nLoading previous state from: %s\n", latestFile)
-			previousState, err := client.LoadSnapshot(latestFile)
+			fmt.Fprintf(logOut, "\nLoading previous state from: %s\n", latestFile)
+			previousState, err = client.LoadSnapshot(latestFile)
+			printLoadWarnings(logOut, previousState)
 			if err != nil {
-				fmt.Printf("Error loading previous state: %v\n", err)
+				fmt.Fprintf(logOut, "Error loading previous state: %v\n", err)
+				notifyFailed = true
+				status = merkle.ExitError
+			} else if report, err := client.CompareScoped(previousState, currentState, pathScope); err != nil {
+				fmt.Fprintf(logOut, "Error comparing states: %v\n", err)
+				status = merkle.ExitError
 			} else {
-				report := client.CompareSnapshots(previousState, currentState)
-				merkle.PrintChangeReport(report)
+				// Drift that was reviewed as expected does not fail a verify
+				if err := client.MarkAcknowledged(folderPath, report); err != nil {
+					fmt.Fprintf(logOut, "Error reading acknowledged changes: %v\n", err)
+				}
+				status = report.ExitCode()
+				runReport = report
+
+				// Tell files caught mid-write from changes that persist
+				if verify && status > 0 && recheckDelay > 0 {
+					fmt.Fprintf(logOut, "\nRe-hashing %d changed file(s) in %s\n", len(report.Changes), recheckDelay)
+					if err := client.RecheckChanges(folderPath, report, recheckDelay); err != nil {
+						fmt.Fprintf(logOut, "Error re-hashing changed files: %v\n", err)
+					}
+				}
+				if err := output.writeReport(reportOut, folderPath, report); err != nil {
+					fmt.Fprintf(logOut, "Error rendering report template: %v\n", err)
+				}
+
+				if !fromBaseline && pathScope == "" {
+					if err := client.RecordChanges(folderPath, report); err != nil {
+						fmt.Fprintf(logOut, "Error recording change history: %v\n", err)
+					}
+				}
+
+				if evidence.Dir != "" {
+					captured, err := merkle.CaptureEvidence(folderPath, report, evidence)
+					if err != nil {
+						fmt.Fprintf(logOut, "Error capturing evidence: %v\n", err)
+					}
+					if captured != "" {
+						fmt.Fprintf(logOut, "\nEvidence captured in: %s\n", captured)
+					}
+				}
+
+				if err := merkle.RunActionHooks(folderPath, report, hooks); err != nil {
+					fmt.Fprintf(logOut, "Error running hooks: %v\n", err)
+				}
+
+				notifyReport := report
+				if cursor != nil {
+					notifyReport, err = unreportedChanges(logOut, client, *cursor, folderPath, latestFile, report, currentState)
+					if err != nil {
+						fmt.Fprintf(logOut, "Error loading last reported state: %v\n", err)
+						notifyReport, notifyFailed = report, true
+					}
+				}
+
+				if notifier != nil && len(notifyReport.Changes) > 0 {
+					if err := notifier.Notify(merkle.NewNotification(folderPath, notifyReport)); err != nil {
+						fmt.Fprintf(logOut, "Error sending notification: %v\n", err)
+						notifyFailed = true
+					}
+				}
+
+				if patchPath != "" {
+					if err := client.ExportPatch(report, folderPath, patchPath); err != nil {
+						fmt.Fprintf(logOut, "Error exporting patch bundle: %v\n", err)
+						os.Exit(errorStatus)
+					}
+					fmt.Fprintf(logOut, "\nPatch bundle written to: %s\n", patchPath)
+				}
 			}
 		}
 	}
 
+	if digest, ok := notifier.(*merkle.DigestNotifier); ok {
+		if err := digest.Poll(); err != nil {
+			fmt.Fprintf(logOut, "Error sending notification digest: %v\n", err)
+		}
+	}
+
+	// Growth is measured over the same part of the folder as the scan
+	if previousState != nil {
+		previousState = previousState.Scope(pathScope)
+	}
+	// Alerts are text and only join text reports
+	alertsOut := io.Writer(reportOut)
+	if output.machineReadable() {
+		alertsOut = logOut
+	}
+	merkle.WriteQuotaAlerts(alertsOut, merkle.CheckQuotas(previousState, currentState, quotas))
+
+	// Summaries of every run outlive pruned snapshots, so trends can be
+	// charted over any period; a scoped state covers only part of the folder
+	if pathScope == "" {
+		kind := "snapshot"
+		switch {
+		case verify:
+			kind = "verify"
+		case runReport != nil:
+			kind = "compare"
+		}
+		summary := merkle.NewRunSummary(folderPath, kind, currentState, runReport, time.Since(started))
+		if err := client.RecordRun(summary); err != nil {
+			fmt.Fprintf(logOut, "Error recording run summary: %v\n", err)
+		}
+	}
+
+	// A scoped state covers only part of the folder and is not saved
+	if verify || pathScope != "" {
+		if err := reportOut.Close(); err != nil {
+			fmt.Fprintf(logOut, "Error writing output file: %v\n", err)
+			os.Exit(errorStatus)
+		}
+		if output.path != "" {
+			fmt.Fprintf(logOut, "\nReport written to: %s\n", output.path)
+		}
+		if !verify {
+			fmt.Fprintf(logOut, "\nScoped to %s; tree state not saved\n", pathScope)
+			if exitCode {
+				os.Exit(status)
+			}
+			return
+		}
+		if previousState == nil || status == merkle.ExitError {
+			fmt.Fprintf(logOut, "\nVerification failed\n")
+			os.Exit(merkle.ExitError)
+		}
+		if status > 0 {
+			fmt.Fprintf(logOut, "\nVerification failed: highest severity %s\n", severityName(status))
+			os.Exit(status)
+		}
+		fmt.Fprintf(logOut, "\nVerification passed\n")
+		return
+	}
+
 	// Save current state
 	if err := client.SaveSnapshot(currentState, folderPath); err != nil {
-		fmt.Printf("Error saving tree state: %v\n", err)
+		fmt.Fprintf(logOut, "Error saving tree state: %v\n", err)
+		os.Exit(errorStatus)
+	}
+
+	// Only move past this snapshot once its changes are known to be reported
+	if cursor != nil && !notifyFailed {
+		if err := cursor.Advance(folderPath, currentState.Timestamp); err != nil {
+			fmt.Fprintf(logOut, "Error saving notification cursor: %v\n", err)
+		}
+	}
+
+	promoteBaseline(logOut, client, folderPath, promotion)
+
+	if err := reportOut.Close(); err != nil {
+		fmt.Fprintf(logOut, "Error writing output file: %v\n", err)
+		os.Exit(errorStatus)
+	}
+	if output.path != "" {
+		fmt.Fprintf(logOut, "\nReport written to: %s\n", output.path)
+	}
+
+	fmt.Fprintf(logOut, "\nTree state saved successfully\n")
+	if exitCode {
+		os.Exit(status)
+	}
+}
+
+// severityName names the highest severity behind a non-zero exit status
+func severityName(status int) string {
+	return merkle.Severity(status - 1).String()
+}
+
+// unreportedChanges returns the changes since the last snapshot whose changes
+// were reported. That is normally the previous snapshot, already compared in
+// report, but is older when an earlier notification failed or was interrupted.
+func unreportedChanges(logOut io.Writer, client merkle.Client, cursor merkle.ReportCursor, folderPath, previousFile string,
+	report *merkle.ChangeReport, currentState *merkle.TreeState) (*merkle.ChangeReport, error) {
+	reported, err := cursor.Reported(folderPath)
+	if err != nil || reported.IsZero() {
+		return report, err
+	}
+
+	baselineFile, err := client.FindSnapshotAt(folderPath, reported)
+	if err != nil || baselineFile == previousFile {
+		// The baseline may have been garbage collected; report what we can
+		return report, nil
+	}
+
+	fmt.Fprintf(logOut, "Including unreported changes since: %s\n", baselineFile)
+	baseline, err := client.LoadSnapshot(baselineFile)
+	if err != nil {
+		return nil, err
+	}
+	unreported, err := client.CompareSnapshots(baseline, currentState)
+	if err != nil {
+		// Hashed under an older scheme; only the previous snapshot is comparable
+		return report, nil
+	}
+	return unreported, nil
+}
+
+// outputOptions describes where reports are written
+type outputOptions struct {
+	path   string // file to write to; stdout when empty
+	append bool   // append to the file instead of replacing it
+	tree   bool   // also write the tree dump to the file
+	format string // output format: "text", "forensic", "json" or "yaml"
+
+	template *template.Template // renders change reports instead of the text format
+	style    merkle.ReportStyle // how the text format shows times and sizes
+}
+
+// machineReadable reports whether reports are written as JSON or YAML, which
+// nothing else may be mixed into
+func (o outputOptions) machineReadable() bool {
+	return o.template == nil && (o.format == "json" || o.format == "yaml")
+}
+
+// writeReport writes a change report in the selected format or through the
+// report template
+func (o outputOptions) writeReport(w io.Writer, folderPath string, report *merkle.ChangeReport) error {
+	switch {
+	case o.template != nil:
+		return merkle.WriteTemplateReport(w, o.template, report)
+	case o.format == "forensic":
+		merkle.WriteForensicReport(w, folderPath, report)
+	case o.format == "json":
+		return merkle.WriteJSONReport(w, report)
+	case o.format == "yaml":
+		return merkle.WriteYAMLReport(w, report)
+	default:
+		merkle.WriteStyledChangeReport(w, report, o.style)
+	}
+	return nil
+}
+
+// parseHumanize parses --humanize, which turns on both relative times and
+// human sizes, or --humanize=<times,sizes>
+func parseHumanize(arg string) merkle.ReportStyle {
+	if arg == "--humanize" {
+		return merkle.ReportStyle{RelativeTimes: true, HumanSizes: true}
+	}
+	var style merkle.ReportStyle
+	for _, kind := range strings.Split(strings.TrimPrefix(arg, "--humanize="), ",") {
+		switch kind {
+		case "times":
+			style.RelativeTimes = true
+		case "sizes":
+			style.HumanSizes = true
+		default:
+			fmt.Printf("Error: --humanize takes times and/or sizes, not '%s'\n", kind)
+			os.Exit(errorStatus)
+		}
+	}
+	return style
+}
+
+// checkStyle rejects --humanize for outputs it does not apply to: JSON, YAML
+// and forensic reports keep exact values for tools and evidence
+func (o outputOptions) checkStyle() {
+	if o.style == (merkle.ReportStyle{}) {
+		return
+	}
+	if o.template != nil {
+		fmt.Println("Error: --humanize does not apply to --template; use the ago and humanBytes template functions")
+		os.Exit(errorStatus)
+	}
+	if o.format != "" && o.format != "text" {
+		fmt.Printf("Error: --humanize only applies to text reports, not --format=%s\n", o.format)
+		os.Exit(errorStatus)
+	}
+}
+
+// open returns the destination for reports, creating parent directories of
+// the output file as needed
+func (o outputOptions) open() (io.WriteCloser, error) {
+	if o.path == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+
+	if dir := filepath.Dir(o.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if o.append {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	return os.OpenFile(o.path, flags, 0644)
+}
+
+// nopCloser lets stdout stand in for an output file
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// runApply applies a patch bundle produced with --export-patch to a folder
+func runApply(args []string) {
+	if len(args) != 2 {
+		fmt.Println("Usage: go run main.go apply <bundle.tar.gz> <target_folder>")
+		os.Exit(1)
+	}
+
+	if err := merkle.ApplyPatch(args[0], args[1]); err != nil {
+		fmt.Printf("Error applying patch bundle: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Patch bundle %s applied to %s\n", args[0], args[1])
+}
+
+// parseSince turns a --since value into a point in time. It accepts dates
+// ("2024-01-01", "2024-01-01 15:04:05", RFC 3339) and ages relative to now
+// ("7d", "12h", "90m").
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := parseAge(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("expected a date like 2024-01-01 or an age like 7d: %s", value)
+}
+
+// readFileList reads the paths for --files-from from a file, or stdin for "-",
+// exiting on errors. Absolute paths are made relative to the scanned folder.
+func readFileList(source, folderPath string) []string {
+	in := os.Stdin
+	if source != "-" {
+		f, err := os.Open(source)
+		if err != nil {
+			fmt.Printf("Error reading file list: %v\n", err)
+			os.Exit(errorStatus)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	paths, err := merkle.ReadFileList(in)
+	if err != nil {
+		fmt.Printf("Error reading file list: %v\n", err)
+		os.Exit(errorStatus)
+	}
+
+	absFolder, err := filepath.Abs(folderPath)
+	if err != nil {
+		fmt.Printf("Error reading file list: %v\n", err)
+		os.Exit(errorStatus)
+	}
+	for i, path := range paths {
+		if filepath.IsAbs(path) {
+			if rel, err := filepath.Rel(absFolder, path); err == nil {
+				paths[i] = rel
+			}
+		}
+	}
+	return paths
+}
+
+// namespaceStorageDir returns the storage directory of a namespace, exiting on
+// invalid names
+func namespaceStorageDir(namespace string) string {
+	dir, err := merkle.NamespaceDir(defaultStorageDir, namespace)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(errorStatus)
+	}
+	return dir
+}
+
+// parseLimit parses the numeric value of a quota flag, accepting K, M and G
+// suffixes (powers of 1024) for convenience
+func parseLimit(arg, prefix string) int64 {
+	value := strings.TrimPrefix(arg, prefix)
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30} {
+		if trimmed, ok := strings.CutSuffix(value, suffix); ok {
+			value, multiplier = trimmed, m
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		fmt.Printf("Error: Invalid value for %s%s\n", prefix, strings.TrimPrefix(arg, prefix))
+		os.Exit(errorStatus)
+	}
+	return n * multiplier
+}
+
+// parseDurationFlag parses the age value of a flag, exiting on invalid input
+func parseDurationFlag(arg, prefix string) time.Duration {
+	d, err := parseAge(strings.TrimPrefix(arg, prefix))
+	if err != nil {
+		fmt.Printf("Error: Invalid value for %s%s\n", prefix, strings.TrimPrefix(arg, prefix))
+		os.Exit(errorStatus)
+	}
+	return d
+}
+
+// parseAge parses a duration that may also be given in days ("30d")
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// runGC collects snapshots of folders that no longer exist or are stale
+func runGC(args []string) {
+	var policy merkle.GCPolicy
+	storageDir := defaultStorageDir
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case arg == "--dry-run":
+			policy.DryRun = true
+		case strings.HasPrefix(arg, "--max-age="):
+			age, err := parseAge(strings.TrimPrefix(arg, "--max-age="))
+			if err != nil {
+				fmt.Printf("Error: Invalid max age: %v\n", err)
+				os.Exit(1)
+			}
+			policy.MaxAge = age
+		case strings.HasPrefix(arg, "--archive="):
+			policy.ArchiveDir = strings.TrimPrefix(arg, "--archive=")
+		default:
+			fmt.Printf("Error: Unknown flag '%s'\n", arg)
+			os.Exit(1)
+		}
+	}
+
+	client := newClient(storageDir)
+	collected, err := client.CollectGarbage(policy)
+	if err != nil {
+		fmt.Printf("Error collecting snapshots: %v\n", err)
+		os.Exit(1)
+	}
+
+	action := "Removed"
+	switch {
+	case policy.DryRun:
+		action = "Would collect"
+	case policy.ArchiveDir != "":
+		action = "Archived"
+	}
+	for _, file := range collected {
+		fmt.Printf("%s: %s\n", action, file)
+	}
+	fmt.Printf("\n%d snapshot(s) collected\n", len(collected))
+}
+
+// parseLoadMode parses the value of --load-mode
+func parseLoadMode(value string) merkle.LoadMode {
+	switch value {
+	case "auto":
+		return merkle.LoadAuto
+	case "strict":
+		return merkle.LoadStrict
+	case "lenient":
+		return merkle.LoadLenient
+	default:
+		fmt.Printf("Error: Invalid --load-mode value '%s' (want auto, strict or lenient)\n", value)
+		os.Exit(errorStatus)
+		return merkle.LoadAuto
+	}
+}
+
+// parseSnapshotFormat parses the value of --snapshot-format, exiting on bad input
+func parseSnapshotFormat(value string) merkle.SnapshotFormat {
+	switch value {
+	case "csv":
+		return merkle.FormatCSV
+	case "binary":
+		return merkle.FormatBinary
+	case "json":
+		return merkle.FormatJSON
+	default:
+		fmt.Printf("Error: Invalid --snapshot-format value '%s' (want csv, binary or json)\n", value)
+		os.Exit(errorStatus)
+		return merkle.FormatCSV
+	}
+}
+
+// printLoadWarnings reports the malformed values a lenient load skipped to w;
+// a state that failed to load is nil and has none
+func printLoadWarnings(w io.Writer, state *merkle.TreeState) {
+	if state == nil {
+		return
+	}
+	for _, warning := range state.Warnings {
+		fmt.Fprintf(w, "Warning: previous state %s\n", warning)
+	}
+}
+
+// runDiff compares two snapshot files. Binary snapshots are compared through
+// memory mappings, so they may be larger than the available memory.
+func runDiff(args []string) {
+	var opts []merkle.Option
+	pairing := true
+	output := outputOptions{format: "text"}
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "--compat-change-types":
+			opts = append(opts, merkle.WithCompatChangeTypes(true))
+			pairing = false
+		case arg == "--no-renames":
+			opts = append(opts, merkle.WithRenameDetection(false))
+			pairing = false
+		case strings.HasPrefix(arg, "--format="):
+			output.format = strings.TrimPrefix(arg, "--format=")
+			if output.format != "text" && output.format != "json" && output.format != "yaml" {
+				fmt.Printf("Error: Unsupported diff format '%s'\n", output.format)
+				os.Exit(1)
+			}
+		case arg == "--humanize" || strings.HasPrefix(arg, "--humanize="):
+			output.style = parseHumanize(arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	output.checkStyle()
+	if len(positional) != 2 {
+		fmt.Println("Usage: go run main.go diff [--compat-change-types] [--no-renames] [--format=text|json|yaml] [--humanize[=times,sizes]] <old_snapshot> <new_snapshot>")
+		fmt.Println("       go run main.go diff [--compat-change-types] [--no-renames] [--format=text|json|yaml] [--humanize[=times,sizes]] <folder_a> <folder_b>")
+		os.Exit(1)
+	}
+
+	client := newClient(defaultStorageDir, opts...)
+	if isDir(positional[0]) && isDir(positional[1]) {
+		report, err := client.CompareFolders(positional[0], positional[1])
+		if err != nil {
+			fmt.Printf("Error comparing folders: %v\n", err)
+			os.Exit(1)
+		}
+		writeDiff(output, positional[1], report)
+		return
+	}
+
+	// Mapped comparisons always pair renames and moves
+	var report *merkle.ChangeReport
+	var err error
+	if pairing {
+		report, err = diffMapped(positional[0], positional[1])
+	}
+	if !pairing || err != nil {
+		report, err = client.CompareSnapshotFiles(positional[0], positional[1])
+	}
+	if err != nil {
+		fmt.Printf("Error comparing snapshots: %v\n", err)
+		os.Exit(1)
+	}
+
+	writeDiff(output, positional[1], report)
+}
+
+// writeDiff writes the report of diff to stdout in the selected format
+func writeDiff(output outputOptions, folderPath string, report *merkle.ChangeReport) {
+	if err := output.writeReport(os.Stdout, folderPath, report); err != nil {
+		fmt.Printf("Error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// isDir reports whether path names a directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// diffMapped compares two binary snapshots without loading them
+func diffMapped(oldFile, newFile string) (*merkle.ChangeReport, error) {
+	oldSnapshot, err := merkle.OpenMappedSnapshot(oldFile)
+	if err != nil {
+		return nil, err
+	}
+	defer oldSnapshot.Close()
+
+	newSnapshot, err := merkle.OpenMappedSnapshot(newFile)
+	if err != nil {
+		return nil, err
+	}
+	defer newSnapshot.Close()
+
+	return merkle.CompareMappedSnapshots(oldSnapshot, newSnapshot)
+}
+
+// runMigrate rewrites snapshots stored in older formats into the current one
+func runMigrate(args []string) {
+	storageDir := defaultStorageDir
+	dryRun := false
+	var opts []merkle.Option
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case arg == "--binary-snapshots":
+			opts = append(opts, merkle.WithSnapshotFormat(merkle.FormatBinary))
+		case strings.HasPrefix(arg, "--snapshot-format="):
+			opts = append(opts, merkle.WithSnapshotFormat(parseSnapshotFormat(strings.TrimPrefix(arg, "--snapshot-format="))))
+		case strings.HasPrefix(arg, "--load-mode="):
+			opts = append(opts, merkle.WithLoadMode(parseLoadMode(strings.TrimPrefix(arg, "--load-mode="))))
+		case arg == "--sqlite":
+			opts = append(opts, merkle.WithSQLiteStorage())
+		case arg == "--dry-run":
+			dryRun = true
+		default:
+			fmt.Printf("Error: Unknown flag '%s'\n", arg)
+			os.Exit(1)
+		}
+	}
+
+	client := newClient(storageDir, opts...)
+	migrated, err := client.MigrateSnapshots(dryRun)
+	for _, file := range migrated {
+		if dryRun {
+			fmt.Printf("Would migrate: %s\n", file)
+		} else {
+			fmt.Printf("Migrated: %s\n", file)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error migrating snapshots: %v\n", err)
 		os.Exit(1)
 	}
+	fmt.Printf("\n%d snapshot(s) migrated\n", len(migrated))
+}
+
+// runFileHistory prints every hash a file has had across a folder's snapshots
+func runFileHistory(args []string) {
+	storageDir := defaultStorageDir
+	if len(args) > 0 && strings.HasPrefix(args[0], "--namespace=") {
+		storageDir = namespaceStorageDir(strings.TrimPrefix(args[0], "--namespace="))
+		args = args[1:]
+	}
+	if len(args) != 2 {
+		fmt.Println("Usage: go run main.go file-history [--namespace=<ns>] <folder_path> <file_path>")
+		os.Exit(1)
+	}
+
+	client := newClient(storageDir)
+	periods, err := client.FileHistory(args[0], filepath.ToSlash(args[1]))
+	if err != nil {
+		fmt.Printf("Error reading snapshots: %v\n", err)
+		os.Exit(1)
+	}
+
+	merkle.PrintFileHistory(args[1], periods)
+}
+
+// runHistory prints every recorded change to a file
+func runHistory(args []string) {
+	storageDir := defaultStorageDir
+	format := "text"
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+			if format != "text" && format != "json" {
+				fmt.Printf("Error: Unsupported history format '%s'\n", format)
+				os.Exit(1)
+			}
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 1 {
+		fmt.Println("Usage: go run main.go history [--namespace=<ns>] [--format=text|json] <file_path>")
+		os.Exit(1)
+	}
+
+	client := newClient(storageDir)
+	events, err := client.HistoryForPath(positional[0])
+	if err != nil {
+		fmt.Printf("Error reading change history: %v\n", err)
+		os.Exit(1)
+	}
+
+	if format == "json" {
+		if err := merkle.WriteJSONEvents(os.Stdout, events); err != nil {
+			fmt.Printf("Error writing change history: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	merkle.PrintHistory(positional[0], events)
+}
 
-	fmt.Printf("\nTree state saved successfully\n")
+// runRuns prints the run summaries of a folder
+func runRuns(args []string) {
+	storageDir := defaultStorageDir
+	format := "text"
+	var since time.Time
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+			if format != "text" && format != "json" {
+				fmt.Printf("Error: Unsupported runs format '%s'\n", format)
+				os.Exit(1)
+			}
+		case strings.HasPrefix(arg, "--since="):
+			t, err := parseSince(strings.TrimPrefix(arg, "--since="), time.Now())
+			if err != nil {
+				fmt.Printf("Error: Invalid --since: %v\n", err)
+				os.Exit(1)
+			}
+			since = t
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 1 {
+		fmt.Println("Usage: go run main.go runs [--namespace=<ns>] [--since=<time>] [--format=text|json] <folder_path>")
+		os.Exit(1)
+	}
+
+	client := newClient(storageDir)
+	runs, err := client.RunSummaries(positional[0], since)
+	if err != nil {
+		fmt.Printf("Error reading run summaries: %v\n", err)
+		os.Exit(1)
+	}
+
+	if format == "json" {
+		if err := merkle.WriteJSONRuns(os.Stdout, runs); err != nil {
+			fmt.Printf("Error writing run summaries: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	merkle.PrintRuns(filepath.Base(positional[0]), runs)
+}
+
+// runSnapshots lists the stored snapshots of a folder, filtered by time and
+// label and a page at a time
+func runSnapshots(args []string) {
+	storageDir := defaultStorageDir
+	format := "text"
+	var query merkle.SnapshotQuery
+	var positional []string
+	now := time.Now()
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+			if format != "text" && format != "json" {
+				fmt.Printf("Error: Unsupported snapshots format '%s'\n", format)
+				os.Exit(1)
+			}
+		case strings.HasPrefix(arg, "--since="):
+			t, err := parseSince(strings.TrimPrefix(arg, "--since="), now)
+			if err != nil {
+				fmt.Printf("Error: Invalid --since: %v\n", err)
+				os.Exit(1)
+			}
+			query.Since = t
+		case strings.HasPrefix(arg, "--until="):
+			t, err := parseSince(strings.TrimPrefix(arg, "--until="), now)
+			if err != nil {
+				fmt.Printf("Error: Invalid --until: %v\n", err)
+				os.Exit(1)
+			}
+			query.Until = t
+		case strings.HasPrefix(arg, "--label="):
+			query.Labels = append(query.Labels, strings.TrimPrefix(arg, "--label="))
+		case arg == "--newest-first":
+			query.Newest = true
+		case strings.HasPrefix(arg, "--after="):
+			query.After = strings.TrimPrefix(arg, "--after=")
+		case strings.HasPrefix(arg, "--limit="), strings.HasPrefix(arg, "--offset="):
+			name, value, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				fmt.Printf("Error: Invalid --%s: %s\n", name, value)
+				os.Exit(1)
+			}
+			if name == "limit" {
+				query.Limit = n
+			} else {
+				query.Offset = n
+			}
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 1 {
+		fmt.Println("Usage: go run main.go snapshots [--namespace=<ns>] [--since=<time>] [--until=<time>] [--label=<label>...] [--newest-first]")
+		fmt.Println("                                [--limit=<n>] [--offset=<n>] [--after=<id>] [--format=text|json] <folder_path>")
+		os.Exit(1)
+	}
+
+	client := newClient(storageDir)
+	page, err := client.QuerySnapshots(positional[0], query)
+	if err != nil {
+		fmt.Printf("Error listing snapshots: %v\n", err)
+		os.Exit(1)
+	}
+
+	if format == "json" {
+		if err := merkle.WriteJSONSnapshots(os.Stdout, positional[0], page); err != nil {
+			fmt.Printf("Error writing snapshots: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	merkle.PrintSnapshots(filepath.Base(positional[0]), page)
+}
+
+// runLabel attaches labels to a stored snapshot, or removes them with --remove
+func runLabel(args []string) {
+	storageDir := defaultStorageDir
+	remove := false
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case arg == "--remove":
+			remove = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) < 2 {
+		fmt.Println("Usage: go run main.go label [--namespace=<ns>] [--remove] <snapshot_id> <label>...")
+		os.Exit(1)
+	}
+
+	client := newClient(storageDir)
+	id, labels := positional[0], positional[1:]
+	if remove {
+		if err := client.UnlabelSnapshot(id, labels...); err != nil {
+			fmt.Printf("Error removing labels: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %s from %s\n", strings.Join(labels, ", "), id)
+		return
+	}
+	if err := client.LabelSnapshot(id, labels...); err != nil {
+		fmt.Printf("Error labeling snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Labeled %s with %s\n", id, strings.Join(labels, ", "))
 }
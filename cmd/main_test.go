@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// runMain runs the command line with args in dir and returns what it wrote to
+// stdout and stderr
+func runMain(t *testing.T, dir string, args ...string) (string, string) {
+	t.Helper()
+	stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	savedArgs, savedStdout, savedStderr := os.Args, os.Stdout, os.Stderr
+	defer func() {
+		os.Args, os.Stdout, os.Stderr = savedArgs, savedStdout, savedStderr
+		os.Chdir(wd)
+	}()
+	os.Args = append([]string{"fcd"}, args...)
+	os.Stdout, os.Stderr = stdout, stderr
+	main()
+	if os.Stdout != stdout {
+		t.Error("the run replaced os.Stdout")
+	}
+
+	out, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	errOut, err := os.ReadFile(stderr.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), string(errOut)
+}
+
+// With --format=json and no output file, stdout holds the report and nothing
+// else, for --compare and --verify alike
+func TestJSONReportIsAllOfStdout(t *testing.T) {
+	work := t.TempDir()
+	folder := filepath.Join(work, "app")
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, "a.txt"), []byte("alpha"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runMain(t, work, folder)
+	runMain(t, work, "baseline", "promote", folder)
+
+	var report struct {
+		MaxSeverity string            `json:"max_severity"`
+		Changes     []json.RawMessage `json:"changes"`
+	}
+	stdout, stderr := runMain(t, work, "verify", folder, "--format=json")
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("verify stdout is not one JSON document: %v\nstdout:\n%s", err, stdout)
+	}
+	if len(report.Changes) != 0 || report.MaxSeverity != "none" {
+		t.Errorf("clean verify: %d changes, max_severity %q, want none", len(report.Changes), report.MaxSeverity)
+	}
+	if stderr == "" {
+		t.Error("verify printed no progress to stderr")
+	}
+
+	if err := os.WriteFile(filepath.Join(folder, "b.txt"), []byte("beta"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _ = runMain(t, work, folder, "--compare", "--format=json")
+	report.Changes = nil
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("compare stdout is not one JSON document: %v\nstdout:\n%s", err, stdout)
+	}
+	if len(report.Changes) != 1 || report.MaxSeverity != "info" {
+		t.Errorf("compare: %d changes, max_severity %q, want 1 and info", len(report.Changes), report.MaxSeverity)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+// progressWidth is the number of columns a progress line takes at most
+const progressWidth = 79
+
+// progressBar draws the progress of scans on one terminal line, which is
+// cleared once a scan is done so the report starts on a clean line
+type progressBar struct {
+	out io.Writer
+}
+
+// isTerminal reports whether a file is a terminal rather than a pipe or file,
+// where a redrawn line would only add noise
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// update redraws the line; it is passed to merkle.WithProgress
+func (b *progressBar) update(p merkle.ScanProgress) {
+	if p.Done() {
+		fmt.Fprint(b.out, "\r\033[K")
+		return
+	}
+
+	var line string
+	if p.WalkDone && p.Found > 0 {
+		// The total is known, so draw a bar
+		const barWidth = 20
+		filled := barWidth * p.Processed / p.Found
+		line = fmt.Sprintf("[%s%s] %3d%% %d/%d files, %s",
+			strings.Repeat("#", filled), strings.Repeat(".", barWidth-filled),
+			100*p.Processed/p.Found, p.Processed, p.Found, merkle.FormatBytes(p.Bytes))
+	} else {
+		line = fmt.Sprintf("Scanning: %d files found, %d hashed, %s", p.Found, p.Processed, merkle.FormatBytes(p.Bytes))
+	}
+
+	// Show as much of the current path as fits, keeping its end
+	if room := progressWidth - len(line) - 1; p.Path != "" && room > 3 {
+		path := p.Path
+		if len(path) > room {
+			path = "..." + path[len(path)-room+3:]
+		}
+		line += " " + path
+	}
+	fmt.Fprintf(b.out, "\r\033[K%s", line)
+}
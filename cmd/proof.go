@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+// runProve prints a JSON proof that a file is part of a stored snapshot,
+// which verify-proof checks against the snapshot's root hash alone
+func runProve(args []string) {
+	storageDir := defaultStorageDir
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 2 {
+		fmt.Println("Usage: go run main.go prove [--namespace=<ns>] <snapshot-id> <file_path>")
+		os.Exit(1)
+	}
+
+	client := newClient(storageDir)
+	file, err := client.ResolveSnapshot(positional[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	state, err := client.LoadSnapshot(file)
+	if err != nil {
+		fmt.Printf("Error loading snapshot: %v\n", err)
+		os.Exit(1)
+	}
+
+	proof, err := state.GenerateProof(filepath.ToSlash(positional[1]))
+	if err != nil {
+		fmt.Printf("Error generating proof: %v\n", err)
+		os.Exit(1)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(proof); err != nil {
+		fmt.Printf("Error writing proof: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runVerifyProof checks a proof written by prove against a root hash and the
+// hash of the file it is about. It exits with 1 when the proof does not hold.
+func runVerifyProof(args []string) {
+	if len(args) != 3 {
+		fmt.Println("Usage: go run main.go verify-proof <proof.json> <root_hash> <file_hash>")
+		os.Exit(1)
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Printf("Error reading proof: %v\n", err)
+		os.Exit(1)
+	}
+	var proof merkle.Proof
+	if err := json.Unmarshal(data, &proof); err != nil {
+		fmt.Printf("Error parsing proof: %v\n", err)
+		os.Exit(1)
+	}
+	rootHash, err := hex.DecodeString(args[1])
+	if err != nil {
+		fmt.Printf("Error: invalid root hash: %v\n", err)
+		os.Exit(1)
+	}
+	fileHash, err := hex.DecodeString(args[2])
+	if err != nil {
+		fmt.Printf("Error: invalid file hash: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !merkle.VerifyProof(rootHash, proof.FileName, fileHash, &proof) {
+		fmt.Printf("Proof INVALID: %s is not part of the tree with root %s\n", proof.FileName, args[1])
+		os.Exit(1)
+	}
+	fmt.Printf("Proof valid: %s is leaf %d of %d in the tree with root %s\n",
+		proof.FileName, proof.Index+1, proof.LeafCount, args[1])
+}
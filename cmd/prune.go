@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+// pruneUsage is printed when prune is called without a folder or rule
+const pruneUsage = "Usage: go run main.go prune [--namespace=<ns>] [--keep-last=<n>] [--keep-daily=<n>] [--keep-weekly=<n>] [--max-age=<age>] [--archive=<dir>] [--dry-run] <folder_path>"
+
+// runPrune applies a retention policy to the snapshots of a folder
+func runPrune(args []string) {
+	var policy merkle.PrunePolicy
+	storageDir := defaultStorageDir
+	folderPath := ""
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case strings.HasPrefix(arg, "--keep-last="):
+			policy.KeepLast = parseKeepCount(arg, "--keep-last=")
+		case strings.HasPrefix(arg, "--keep-daily="):
+			policy.KeepDaily = parseKeepCount(arg, "--keep-daily=")
+		case strings.HasPrefix(arg, "--keep-weekly="):
+			policy.KeepWeekly = parseKeepCount(arg, "--keep-weekly=")
+		case strings.HasPrefix(arg, "--max-age="):
+			policy.MaxAge = parseDurationFlag(arg, "--max-age=")
+		case strings.HasPrefix(arg, "--archive="):
+			policy.ArchiveDir = strings.TrimPrefix(arg, "--archive=")
+		case arg == "--dry-run":
+			policy.DryRun = true
+		case strings.HasPrefix(arg, "--"):
+			fmt.Printf("Error: Unknown flag '%s'\n", arg)
+			os.Exit(1)
+		case folderPath == "":
+			folderPath = arg
+		default:
+			fmt.Println(pruneUsage)
+			os.Exit(1)
+		}
+	}
+	if folderPath == "" {
+		fmt.Println(pruneUsage)
+		os.Exit(1)
+	}
+	// Without any rule every snapshot would be kept
+	if policy.KeepLast == 0 && policy.KeepDaily == 0 && policy.KeepWeekly == 0 && policy.MaxAge == 0 {
+		fmt.Println("Error: prune needs at least one of --keep-last, --keep-daily, --keep-weekly and --max-age")
+		os.Exit(1)
+	}
+
+	client := newClient(storageDir)
+	pruned, err := client.PruneSnapshots(folderPath, policy)
+	if err != nil {
+		fmt.Printf("Error pruning snapshots: %v\n", err)
+		os.Exit(1)
+	}
+
+	merkle.WritePruneReport(os.Stdout, pruned, policy)
+}
+
+// parseKeepCount parses the number of a --keep-* flag
+func parseKeepCount(arg, prefix string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, prefix))
+	if err != nil || n < 0 {
+		fmt.Printf("Error: Invalid value for %s%s\n", prefix, strings.TrimPrefix(arg, prefix))
+		os.Exit(1)
+	}
+	return n
+}
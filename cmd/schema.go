@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+// runSchema prints the JSON Schema of one JSON output, or writes every schema
+// into a directory as <name>.schema.json for validation and code generation
+func runSchema(args []string) {
+	dir := ""
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--dir="):
+			dir = strings.TrimPrefix(arg, "--dir=")
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if (dir == "" && len(positional) != 1) || (dir != "" && len(positional) != 0) {
+		fmt.Println("Usage: go run main.go schema <name>")
+		fmt.Println("       go run main.go schema --dir=<dir>")
+		fmt.Printf("Schemas: %s\n", strings.Join(merkle.SchemaNames(), ", "))
+		os.Exit(1)
+	}
+
+	if dir == "" {
+		schema, err := merkle.JSONSchema(positional[0])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(schema))
+		return
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Printf("Error creating %s: %v\n", dir, err)
+		os.Exit(1)
+	}
+	for _, name := range merkle.SchemaNames() {
+		schema, err := merkle.JSONSchema(name)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		filename := filepath.Join(dir, name+".schema.json")
+		if err := os.WriteFile(filename, append(schema, '\n'), 0644); err != nil {
+			fmt.Printf("Error writing %s: %v\n", filename, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s\n", filename)
+	}
+}
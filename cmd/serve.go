@@ -0,0 +1,149 @@
+//go:build !readonly
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	merklev1 "github.com/Ridwan414/file-change-detector/api/merkle/v1"
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+	"google.golang.org/grpc"
+)
+
+// defaultServeAddr keeps the API private to the host unless told otherwise
+const defaultServeAddr = "127.0.0.1:8080"
+
+// runServe serves the storage directory over HTTP, and over gRPC with
+// --grpc-addr, until Ctrl+C or SIGTERM
+func runServe(args []string) {
+	addr := defaultServeAddr
+	grpcAddr := ""
+	tokenFile := ""
+	webhookFile := ""
+	var folders []string
+	namespaceFolders := make(map[string][]string)
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--addr="):
+			addr = strings.TrimPrefix(arg, "--addr=")
+		case strings.HasPrefix(arg, "--grpc-addr="):
+			grpcAddr = strings.TrimPrefix(arg, "--grpc-addr=")
+		case strings.HasPrefix(arg, "--tokens="):
+			tokenFile = strings.TrimPrefix(arg, "--tokens=")
+		case strings.HasPrefix(arg, "--webhooks="):
+			webhookFile = strings.TrimPrefix(arg, "--webhooks=")
+		case strings.HasPrefix(arg, "--folder="):
+			folders = append(folders, strings.TrimPrefix(arg, "--folder="))
+		case strings.HasPrefix(arg, "--namespace-folder="):
+			namespace, folder, ok := strings.Cut(strings.TrimPrefix(arg, "--namespace-folder="), "=")
+			if !ok || !merkle.ValidNamespace(namespace) || folder == "" {
+				fmt.Println("Error: --namespace-folder takes <namespace>=<path>")
+				os.Exit(1)
+			}
+			namespaceFolders[namespace] = append(namespaceFolders[namespace], folder)
+		default:
+			fmt.Println("Usage: go run main.go serve [--addr=<host:port>] [--grpc-addr=<host:port>] [--tokens=<file>] [--webhooks=<file>] [--folder=<path>...] [--namespace-folder=<namespace>=<path>...]")
+			os.Exit(1)
+		}
+	}
+
+	server := &merkle.Server{
+		StorageDir:       defaultStorageDir,
+		Options:          []merkle.Option{merkle.WithComparisonCache(64), merkle.WithIncrementalSnapshots(true)},
+		Folders:          folders,
+		NamespaceFolders: namespaceFolders,
+	}
+	if tokenFile != "" {
+		tokens, err := merkle.LoadTokenFile(tokenFile)
+		if err != nil {
+			fmt.Printf("Error loading token file: %v\n", err)
+			os.Exit(1)
+		}
+		server.Tokens = tokens
+	} else {
+		fmt.Println("Warning: no --tokens file given; every caller has full access")
+	}
+
+	if webhookFile != "" {
+		hooks, err := merkle.LoadWebhookFile(webhookFile)
+		if err != nil {
+			fmt.Printf("Error loading webhook file: %v\n", err)
+			os.Exit(1)
+		}
+		server.Webhooks = hooks
+		server.OnWebhook = logWebhook
+	}
+
+	httpServer := &http.Server{Addr: addr, Handler: server, ReadHeaderTimeout: 10 * time.Second}
+	var grpcServer *grpc.Server
+	if grpcAddr != "" {
+		listener, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		grpcServer = grpc.NewServer()
+		merklev1.RegisterMerkleServiceServer(grpcServer, server.GRPCService())
+		go grpcServer.Serve(listener)
+		fmt.Printf("Serving %s over gRPC on %s\n", defaultStorageDir, grpcAddr)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		// Let requests in progress finish, but do not wait for long scans
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if grpcServer != nil {
+			// Change streams only end when their callers go away
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			defer func() {
+				select {
+				case <-stopped:
+				case <-shutdownCtx.Done():
+					grpcServer.Stop()
+				}
+			}()
+		}
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving %s on http://%s\n", defaultStorageDir, addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	<-shutdown
+}
+
+// logWebhook prints the outcome of a webhook call
+func logWebhook(result merkle.WebhookResult) {
+	hook := result.Hook
+	prefix := fmt.Sprintf("[%s] webhook %s: %s of %s", time.Now().Format("2006-01-02 15:04:05"), hook.Name, hook.Action, hook.Folder)
+	switch {
+	case result.Err != nil:
+		fmt.Printf("%s failed: %v\n", prefix, result.Err)
+	case result.Report == nil:
+		fmt.Printf("%s: first snapshot saved\n", prefix)
+	case result.Report.ExitCode() == 0:
+		fmt.Printf("%s: no changes to review\n", prefix)
+	default:
+		fmt.Printf("%s: %d change(s), highest severity %s\n", prefix, len(result.Report.Changes), result.Report.MaxSeverity())
+	}
+}
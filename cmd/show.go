@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+// showEntry is one file of a snapshot as printed by show
+type showEntry struct {
+	Path    string            `json:"path"`
+	Hash    string            `json:"hash,omitempty"`
+	Size    int64             `json:"size"`
+	Content string            `json:"content,omitempty"`
+	Status  string            `json:"status,omitempty"`
+	Digests map[string]string `json:"digests,omitempty"`
+}
+
+// runShow lists the files of a stored snapshot
+func runShow(args []string) {
+	storageDir := defaultStorageDir
+	prefix := ""
+	format := "text"
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case strings.HasPrefix(arg, "--prefix="):
+			prefix = strings.Trim(strings.TrimPrefix(arg, "--prefix="), "/")
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+			if format != "text" && format != "csv" && format != "json" {
+				fmt.Printf("Error: Unsupported show format '%s'\n", format)
+				os.Exit(1)
+			}
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 1 {
+		fmt.Println("Usage: go run main.go show [--namespace=<ns>] [--prefix=<dir>] [--format=text|csv|json] <snapshot-id>")
+		os.Exit(1)
+	}
+
+	client := newClient(storageDir)
+	file, err := client.ResolveSnapshot(positional[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	state, err := client.LoadSnapshot(file)
+	if err != nil {
+		fmt.Printf("Error loading snapshot: %v\n", err)
+		os.Exit(1)
+	}
+
+	entries := snapshotEntries(state, prefix)
+	switch format {
+	case "csv":
+		err = writeShowCSV(entries)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(entries)
+	default:
+		printShowText(file, state, entries)
+	}
+	if err != nil {
+		fmt.Printf("Error writing snapshot contents: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// snapshotEntries returns the files of a state under prefix (a directory or a
+// single file; empty for all) in path order
+func snapshotEntries(state *merkle.TreeState, prefix string) []showEntry {
+	matches := func(path string) bool {
+		return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+	}
+
+	entries := []showEntry{}
+	for path, hash := range state.FileHashes {
+		if !matches(path) {
+			continue
+		}
+		entry := showEntry{
+			Path: path,
+			Hash: hex.EncodeToString(hash),
+			Size: state.FileSizes[path],
+		}
+		if kind := state.Content[path]; kind != merkle.ContentUnknown {
+			entry.Content = merkle.GetContentKindString(kind)
+		}
+		if state.Unstable[path] {
+			entry.Status = "unstable"
+		}
+		if len(state.Digests[path]) > 0 {
+			entry.Digests = make(map[string]string, len(state.Digests[path]))
+			for algorithm, digest := range state.Digests[path] {
+				entry.Digests[algorithm] = hex.EncodeToString(digest)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	for path, reason := range state.Errors {
+		if _, hashed := state.FileHashes[path]; !hashed && matches(path) {
+			entries = append(entries, showEntry{Path: path, Status: "error: " + reason})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
+
+// printShowText prints the snapshot's metadata followed by one line per file
+func printShowText(file string, state *merkle.TreeState, entries []showEntry) {
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	fmt.Printf("Snapshot: %s\n", file)
+	fmt.Printf("Taken:    %s\n", state.Timestamp.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Root:     %x\n", state.RootHash)
+	fmt.Printf("Scheme:   %s\n", state.Scheme)
+	fmt.Printf("Files:    %d (%d bytes)\n\n", len(entries), total)
+
+	for _, entry := range entries {
+		hash := entry.Hash
+		if hash == "" {
+			hash = strings.Repeat("-", 64)
+		}
+		fmt.Printf("%s %12d %-7s %s", hash, entry.Size, entry.Content, entry.Path)
+		if entry.Status != "" {
+			fmt.Printf(" (%s)", entry.Status)
+		}
+		fmt.Println()
+	}
+}
+
+// writeShowCSV writes the entries as CSV with digests as "algo=hex;algo=hex"
+func writeShowCSV(entries []showEntry) error {
+	writer := csv.NewWriter(os.Stdout)
+	if err := writer.Write([]string{"path", "hash", "size", "content", "status", "digests"}); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		algorithms := make([]string, 0, len(entry.Digests))
+		for algorithm := range entry.Digests {
+			algorithms = append(algorithms, algorithm)
+		}
+		sort.Strings(algorithms)
+		digests := make([]string, len(algorithms))
+		for i, algorithm := range algorithms {
+			digests[i] = algorithm + "=" + entry.Digests[algorithm]
+		}
+
+		row := []string{entry.Path, entry.Hash, strconv.FormatInt(entry.Size, 10), entry.Content, entry.Status, strings.Join(digests, ";")}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
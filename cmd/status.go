@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+// runStatus prints a summary of the storage directory and exits with status 1
+// if any snapshot or log is corrupt
+func runStatus(args []string) {
+	storageDir := defaultStorageDir
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		default:
+			fmt.Println("Usage: go run main.go status [--namespace=<ns>]")
+			os.Exit(1)
+		}
+	}
+
+	client := newClient(storageDir)
+	status, err := client.StorageStatus()
+	if err != nil {
+		fmt.Printf("Error reading storage: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Notifications pick up from the last reported snapshot of each folder
+	cursor := merkle.ReportCursor{Path: filepath.Join(storageDir, "notify_cursor.json")}
+
+	const layout = "2006-01-02 15:04:05"
+	snapshots := 0
+	for _, folder := range status.Folders {
+		snapshots += folder.Snapshots
+	}
+	fmt.Printf("Storage:   %s\n", status.Dir)
+	fmt.Printf("Disk used: %d bytes\n", status.TotalBytes)
+	fmt.Printf("Folders:   %d (%d snapshots)\n", len(status.Folders), snapshots)
+
+	for _, folder := range status.Folders {
+		fmt.Printf("\n%s\n", folder.Name)
+		switch {
+		case folder.Path == "":
+			fmt.Println("  Path:      unknown")
+		case folder.Missing:
+			fmt.Printf("  Path:      %s (missing)\n", folder.Path)
+		default:
+			fmt.Printf("  Path:      %s\n", folder.Path)
+		}
+		fmt.Printf("  Snapshots: %d (%d bytes)\n", folder.Snapshots, folder.Bytes)
+		if !folder.Oldest.IsZero() {
+			fmt.Printf("  Oldest:    %s\n", folder.Oldest.Format(layout))
+			fmt.Printf("  Newest:    %s (%s ago)\n", folder.Newest.Format(layout), time.Since(folder.Newest).Round(time.Second))
+		}
+		if folder.Baseline != nil {
+			fmt.Printf("  Baseline:  %s (%s, %s)\n", filepath.Base(folder.Baseline.Snapshot),
+				folder.Baseline.Reason, folder.Baseline.Promoted.Format(layout))
+		}
+		if len(folder.Named) > 0 {
+			fmt.Printf("  Named:     %s\n", strings.Join(folder.Named, ", "))
+		}
+		if reported, err := cursor.Reported(folder.Name); err == nil && !reported.IsZero() {
+			fmt.Printf("  Reported:  up to %s\n", reported.Format(layout))
+		}
+		if len(folder.Corrupt) > 0 {
+			fmt.Printf("  Corrupt:   %d snapshot(s)\n", len(folder.Corrupt))
+		}
+	}
+
+	if len(status.Problems) == 0 {
+		fmt.Println("\nNo problems found")
+		return
+	}
+	fmt.Printf("\n%d problem(s) found:\n", len(status.Problems))
+	for _, problem := range status.Problems {
+		fmt.Printf("  %s\n", problem)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,56 @@
+//go:build !readonly
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+// runUpload sends a stored snapshot to a serve instance in resumable chunks.
+// Running it again after an interruption only sends the missing chunks.
+func runUpload(args []string) {
+	storageDir := defaultStorageDir
+	uploader := &merkle.HTTPUploader{}
+	chunkSize := int64(0)
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--server="):
+			uploader.URL = strings.TrimPrefix(arg, "--server=")
+		case strings.HasPrefix(arg, "--token="):
+			uploader.Token = strings.TrimPrefix(arg, "--token=")
+		case strings.HasPrefix(arg, "--remote-namespace="):
+			uploader.Namespace = strings.TrimPrefix(arg, "--remote-namespace=")
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case strings.HasPrefix(arg, "--chunk-size="):
+			chunkSize = parseLimit(arg, "--chunk-size=")
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if uploader.URL == "" || len(positional) != 1 {
+		fmt.Println("Usage: go run main.go upload --server=<url> [--token=<token>] [--namespace=<ns>] [--remote-namespace=<ns>] [--chunk-size=<n>] <snapshot-id|file>")
+		os.Exit(1)
+	}
+
+	// A snapshot ID names a snapshot of the local storage directory
+	file := positional[0]
+	if _, err := os.Stat(file); err != nil {
+		if file, err = newClient(storageDir).ResolveSnapshot(positional[0]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	manifest, err := merkle.UploadSnapshot(file, uploader, chunkSize)
+	if err != nil {
+		fmt.Printf("Error uploading snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Uploaded %s (%d bytes, %d chunks) as upload %s\n", manifest.Name, manifest.Size, len(manifest.Chunks), manifest.ID)
+}
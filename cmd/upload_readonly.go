@@ -0,0 +1,14 @@
+//go:build readonly
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runUpload is left out of read-only builds, which carry no network code
+func runUpload(args []string) {
+	fmt.Println("Error: upload is not available in builds with the readonly tag")
+	os.Exit(1)
+}
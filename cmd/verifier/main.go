@@ -0,0 +1,189 @@
+// Command verifier compares a folder with its baseline and exits with the
+// highest severity of the changes found, like "verify" of the full tool. It
+// only reads the storage directory and has no other commands; built with
+//
+//	go build -tags readonly -o verifier ./cmd/verifier
+//
+// it carries no network code and cannot write snapshots, for hardened images.
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+// defaultStorageDir is where the full tool keeps its snapshots
+const defaultStorageDir = "merkle_states"
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: verifier [--storage=<dir>|--namespace=<ns>] [--baseline=<name>|--snapshot=<file>] [--hash-algorithm=<algo>]")
+	fmt.Fprintln(os.Stderr, "       [--exclude=<pattern>...] [--include=<pattern>...] [--ignore-files[=<name,...>]] [--severity-rules=<file>]")
+	fmt.Fprintln(os.Stderr, "       [--format=text|json|yaml] <folder_path>")
+	fmt.Fprintln(os.Stderr, "  --storage=<dir>: Storage directory holding the baseline (default merkle_states)")
+	fmt.Fprintln(os.Stderr, "  --namespace=<ns>: Use the storage namespace <ns> of the default storage directory")
+	fmt.Fprintln(os.Stderr, "  --baseline=<name>: Compare with the named baseline instead of the promoted one")
+	fmt.Fprintln(os.Stderr, "  --snapshot=<file>: Compare with this snapshot instead of a baseline")
+	fmt.Fprintln(os.Stderr, "  --hash-algorithm=<algo>: Hash files with this algorithm (default: the baseline's)")
+	fmt.Fprintln(os.Stderr, "Exits 0 when nothing changed, 1, 2 or 3 when the highest severity of the")
+	fmt.Fprintln(os.Stderr, "unacknowledged changes is info, warning or critical, and 4 on errors, including")
+	fmt.Fprintln(os.Stderr, "files that could not be hashed.")
+	os.Exit(merkle.ExitError)
+}
+
+// fail reports an error and exits as a failed verification
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
+	os.Exit(merkle.ExitError)
+}
+
+func main() {
+	storageDir := defaultStorageDir
+	baselineName := ""
+	snapshotFile := ""
+	hashAlgorithm := ""
+	format := "text"
+	folderPath := ""
+	var opts []merkle.Option
+	for _, arg := range os.Args[1:] {
+		switch {
+		case strings.HasPrefix(arg, "--storage="):
+			storageDir = strings.TrimPrefix(arg, "--storage=")
+		case strings.HasPrefix(arg, "--namespace="):
+			dir, err := merkle.NamespaceDir(defaultStorageDir, strings.TrimPrefix(arg, "--namespace="))
+			if err != nil {
+				fail("%v", err)
+			}
+			storageDir = dir
+		case strings.HasPrefix(arg, "--baseline="):
+			baselineName = strings.TrimPrefix(arg, "--baseline=")
+		case strings.HasPrefix(arg, "--snapshot="):
+			snapshotFile = strings.TrimPrefix(arg, "--snapshot=")
+		case strings.HasPrefix(arg, "--hash-algorithm="):
+			hashAlgorithm = strings.TrimPrefix(arg, "--hash-algorithm=")
+			if !slices.Contains(merkle.HashAlgorithms(), hashAlgorithm) {
+				fail("Unsupported hash algorithm '%s'", hashAlgorithm)
+			}
+		case strings.HasPrefix(arg, "--exclude="):
+			opts = append(opts, merkle.WithExcludes(strings.TrimPrefix(arg, "--exclude=")))
+		case strings.HasPrefix(arg, "--include="):
+			opts = append(opts, merkle.WithIncludes(strings.TrimPrefix(arg, "--include=")))
+		case arg == "--ignore-files":
+			opts = append(opts, merkle.WithIgnoreFiles(merkle.GitIgnoreFile, merkle.MerkleIgnoreFile))
+		case strings.HasPrefix(arg, "--ignore-files="):
+			opts = append(opts, merkle.WithIgnoreFiles(strings.Split(strings.TrimPrefix(arg, "--ignore-files="), ",")...))
+		case strings.HasPrefix(arg, "--severity-rules="):
+			rules, err := merkle.ReadSeverityRules(strings.TrimPrefix(arg, "--severity-rules="))
+			if err != nil {
+				fail("reading severity rules: %v", err)
+			}
+			opts = append(opts, merkle.WithSeverityRules(rules...))
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+			if format != "text" && format != "json" && format != "yaml" {
+				fail("--format must be text, json or yaml")
+			}
+		case strings.HasPrefix(arg, "--"):
+			fmt.Fprintf(os.Stderr, "Error: Unknown flag '%s'\n", arg)
+			usage()
+		case folderPath == "":
+			folderPath = arg
+		default:
+			usage()
+		}
+	}
+	if folderPath == "" {
+		usage()
+	}
+	if baselineName != "" && snapshotFile != "" {
+		fail("--baseline and --snapshot cannot be combined")
+	}
+	if _, err := os.Stat(folderPath); err != nil {
+		fail("%v", err)
+	}
+
+	client := newClient(storageDir, opts...)
+
+	// Find and load the state to verify against
+	if snapshotFile == "" && baselineName != "" {
+		file, err := client.NamedBaseline(folderPath, baselineName)
+		if err != nil {
+			fail("%v", err)
+		}
+		snapshotFile = file
+	} else if snapshotFile == "" {
+		baseline, err := client.Baseline(folderPath)
+		if err != nil {
+			fail("reading baselines: %v", err)
+		}
+		if baseline == nil {
+			fail("no baseline has been promoted for %s", folderPath)
+		}
+		snapshotFile = baseline.Snapshot
+	}
+	baselineState, err := client.LoadSnapshot(snapshotFile)
+	if err != nil {
+		fail("loading %s: %v", snapshotFile, err)
+	}
+
+	// Hash the folder the way the baseline was hashed unless told otherwise
+	if hashAlgorithm == "" && slices.Contains(merkle.HashAlgorithms(), baselineState.Scheme.Algorithm) {
+		hashAlgorithm = baselineState.Scheme.Algorithm
+	}
+	if hashAlgorithm != "" {
+		client = newClient(storageDir, append(opts, merkle.WithHashAlgorithm(hashAlgorithm))...)
+	}
+
+	// Every file is hashed; a stat cache an intruder could restore is never
+	// trusted, nor written
+	currentState, err := client.CreateSnapshot(folderPath)
+	if err != nil {
+		fail("scanning %s: %v", folderPath, err)
+	}
+	report, err := client.CompareSnapshots(baselineState, currentState)
+	if err != nil {
+		fail("comparing with %s: %v", snapshotFile, err)
+	}
+	// Drift that was reviewed as expected does not fail the verification
+	if err := client.MarkAcknowledged(folderPath, report); err != nil {
+		fail("reading acknowledged changes: %v", err)
+	}
+
+	switch format {
+	case "json":
+		err = merkle.WriteJSONReport(os.Stdout, report)
+	case "yaml":
+		err = merkle.WriteYAMLReport(os.Stdout, report)
+	default:
+		merkle.WriteChangeReport(os.Stdout, report)
+	}
+	if err != nil {
+		fail("writing report: %v", err)
+	}
+
+	// A file that could not be hashed may hide any change, so the report
+	// lists it and exits ExitError
+	status := report.ExitCode()
+	switch {
+	case status == merkle.ExitError:
+		fmt.Fprintf(os.Stderr, "Verification failed: %d file(s) could not be hashed\n", len(report.Errors))
+	case status > 0:
+		fmt.Fprintf(os.Stderr, "Verification failed: highest severity %s\n", merkle.Severity(status-1))
+	default:
+		fmt.Fprintln(os.Stderr, "Verification passed")
+		return
+	}
+	os.Exit(status)
+}
+
+// newClient creates a client for a storage directory, reading snapshots from
+// its SQLite database when it has one
+func newClient(storageDir string, opts ...merkle.Option) merkle.Client {
+	if merkle.HasSQLiteStorage(storageDir) {
+		opts = append(opts, merkle.WithSQLiteStorage())
+	}
+	return merkle.NewClient(storageDir, opts...)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+const verifyAllUsage = `Usage: go run main.go verify-all [--namespace=<ns>] [--worker-budget=<n>] [--recheck-delay=<d>] [--format=text|json]
+       [--hash-algorithm=<name>] [--exclude=<pattern>...] [--include=<pattern>...] [--ignore-files[=<name,...>]]
+       [--severity-rules=<file>] [<folder_path>...]`
+
+// runVerifyAll verifies every folder with a baseline, or the folders given,
+// at the same time and exits with the highest exit code of any of them, as
+// verify does for one folder. Folders are reported on stderr as they finish,
+// so a JSON report on stdout stays parseable.
+func runVerifyAll(args []string) {
+	errorStatus = merkle.ExitError
+	storageDir := defaultStorageDir
+	format := "text"
+	workerBudget := 0
+	recheckDelay := 2 * time.Second
+	var opts []merkle.Option
+	var folders []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--namespace="):
+			storageDir = namespaceStorageDir(strings.TrimPrefix(arg, "--namespace="))
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+			if format != "text" && format != "json" {
+				fmt.Printf("Error: Unsupported verify-all format '%s'\n", format)
+				os.Exit(errorStatus)
+			}
+		case strings.HasPrefix(arg, "--worker-budget="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--worker-budget="))
+			if err != nil || n < 1 {
+				fmt.Printf("Error: Invalid worker budget '%s'\n", strings.TrimPrefix(arg, "--worker-budget="))
+				os.Exit(errorStatus)
+			}
+			workerBudget = n
+		case strings.HasPrefix(arg, "--recheck-delay="):
+			recheckDelay = parseDurationFlag(arg, "--recheck-delay=")
+		case strings.HasPrefix(arg, "--hash-algorithm="):
+			hashAlgorithm := strings.TrimPrefix(arg, "--hash-algorithm=")
+			if !slices.Contains(merkle.HashAlgorithms(), hashAlgorithm) {
+				fmt.Printf("Error: Unsupported hash algorithm '%s'\n", hashAlgorithm)
+				os.Exit(errorStatus)
+			}
+			opts = append(opts, merkle.WithHashAlgorithm(hashAlgorithm))
+		case strings.HasPrefix(arg, "--exclude="):
+			opts = append(opts, merkle.WithExcludes(strings.TrimPrefix(arg, "--exclude=")))
+		case strings.HasPrefix(arg, "--include="):
+			opts = append(opts, merkle.WithIncludes(strings.TrimPrefix(arg, "--include=")))
+		case arg == "--ignore-files":
+			opts = append(opts, merkle.WithIgnoreFiles(merkle.GitIgnoreFile, merkle.MerkleIgnoreFile))
+		case strings.HasPrefix(arg, "--ignore-files="):
+			opts = append(opts, merkle.WithIgnoreFiles(strings.Split(strings.TrimPrefix(arg, "--ignore-files="), ",")...))
+		case strings.HasPrefix(arg, "--severity-rules="):
+			rules, err := merkle.ReadSeverityRules(strings.TrimPrefix(arg, "--severity-rules="))
+			if err != nil {
+				fmt.Printf("Error reading severity rules: %v\n", err)
+				os.Exit(errorStatus)
+			}
+			opts = append(opts, merkle.WithSeverityRules(rules...))
+		case strings.HasPrefix(arg, "--"):
+			fmt.Printf("Error: Unknown flag '%s'\n", arg)
+			fmt.Println(verifyAllUsage)
+			os.Exit(errorStatus)
+		default:
+			folders = append(folders, arg)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := newClient(storageDir, opts...)
+	var done sync.Mutex
+	finished := 0
+	report, err := client.VerifyAll(ctx, merkle.VerifyAllOptions{
+		Folders:      folders,
+		Budget:       merkle.NewWorkerBudget(workerBudget),
+		RecheckDelay: recheckDelay,
+		OnDone: func(folder merkle.FolderVerification) {
+			done.Lock()
+			defer done.Unlock()
+			finished++
+			fmt.Fprintf(os.Stderr, "[%d] %s: %s\n", finished, folder.Folder, folder.Status())
+		},
+	})
+	if err != nil {
+		fmt.Printf("Error verifying folders: %v\n", err)
+		os.Exit(errorStatus)
+	}
+	if len(report.Folders) == 0 {
+		fmt.Println("Error: No folder has a baseline; promote one with 'baseline promote'")
+		os.Exit(errorStatus)
+	}
+
+	// Every verified folder counts towards the trends of runs, as verify does
+	for _, folder := range report.Folders {
+		if folder.Err == nil {
+			if err := client.RecordRun(folder.Run); err != nil {
+				fmt.Printf("Error recording run summary: %v\n", err)
+			}
+		}
+	}
+
+	if format == "json" {
+		if err := merkle.WriteJSONVerification(os.Stdout, report); err != nil {
+			fmt.Printf("Error writing verification: %v\n", err)
+			os.Exit(errorStatus)
+		}
+		os.Exit(report.ExitCode())
+	}
+
+	// The changes of failing folders come first, the summary last
+	for _, folder := range report.Folders {
+		if folder.Status() == "failed" {
+			fmt.Printf("\n--- %s (baseline %s) ---\n", folder.Folder, folder.Baseline)
+			merkle.WriteChangeReport(os.Stdout, folder.Report)
+		}
+	}
+	fmt.Println()
+	merkle.WriteVerificationReport(os.Stdout, report)
+	os.Exit(report.ExitCode())
+}
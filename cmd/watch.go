@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Ridwan414/file-change-detector/pkg/merkle"
+)
+
+// watchActions is what a watch does with every change report, set up from
+// the same flags as an ordinary run
+type watchActions struct {
+	output   outputOptions
+	hooks    []merkle.ActionHook
+	evidence merkle.EvidencePolicy
+	notifier merkle.Notifier
+}
+
+// watchFolder is a folder to watch and its priority
+type watchFolder struct {
+	path     string
+	priority merkle.Priority
+	nested   []string // folders inside it that are watched on their own
+}
+
+// parseWatchFolder parses "<path>" or "<path>=<priority>" and exits on an
+// unknown priority
+func parseWatchFolder(value string) watchFolder {
+	path, name, ok := cutLast(value, "=")
+	if !ok {
+		return watchFolder{path: value}
+	}
+	priority, err := merkle.ParsePriority(name)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(errorStatus)
+	}
+	return watchFolder{path: path, priority: priority}
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// resolveOverlaps watches a folder given twice once, at the higher of its
+// priorities, and leaves folders inside others out of them, so no file is
+// hashed twice and every change is reported under the most specific folder
+func resolveOverlaps(folders []watchFolder) []watchFolder {
+	var unique []watchFolder
+	for _, folder := range folders {
+		duplicate := false
+		for i := range unique {
+			if merkle.SameFolder(unique[i].path, folder.path) {
+				fmt.Printf("Note: %s is the same folder as %s; watching it once\n", folder.path, unique[i].path)
+				if folder.priority > unique[i].priority {
+					unique[i].priority = folder.priority
+				}
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			unique = append(unique, folder)
+		}
+	}
+
+	paths := make([]string, len(unique))
+	for i, folder := range unique {
+		paths[i] = folder.path
+	}
+	for i := range unique {
+		unique[i].nested = merkle.NestedFolders(unique[i].path, paths)
+		for _, nested := range unique[i].nested {
+			inner := filepath.Join(unique[i].path, filepath.FromSlash(nested))
+			fmt.Printf("Note: %s is inside %s; its changes are reported under %s only\n", inner, unique[i].path, inner)
+		}
+	}
+	return unique
+}
+
+// folderReport is a change report of one of the watched folders
+type folderReport struct {
+	folder watchFolder
+	report merkle.ChangeReport
+}
+
+// runWatch monitors folders until interrupted, saving a snapshot and
+// reporting every time files change. Each folder is scanned when the OS
+// reports a change in it, or with poll on its own schedule, scaled by its
+// priority, and all of them share a budget of budget hash workers (0 for the
+// number of CPUs).
+func runWatch(client merkle.Client, folders []watchFolder, interval time.Duration, poll bool, budget int, actions watchActions) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reportOut, err := actions.output.open()
+	if err != nil {
+		fmt.Printf("Error opening output file: %v\n", err)
+		os.Exit(errorStatus)
+	}
+	defer reportOut.Close()
+
+	folders = resolveOverlaps(folders)
+
+	// Start the watches together so the first scan of a large folder does
+	// not hold up the others
+	workers := merkle.NewWorkerBudget(budget)
+	channels := make([]<-chan merkle.ChangeReport, len(folders))
+	errs := make([]error, len(folders))
+	var started sync.WaitGroup
+	names := make([]string, len(folders))
+	for i, folder := range folders {
+		i, folder := i, folder
+		names[i] = folder.path
+		if folder.priority != merkle.PriorityNormal {
+			names[i] += fmt.Sprintf(" (%s priority)", folder.priority)
+		}
+		started.Add(1)
+		go func() {
+			defer started.Done()
+			channels[i], errs[i] = client.Watch(ctx, folder.path, merkle.WatchOptions{
+				Interval:  interval,
+				Poll:      poll,
+				Save:      true,
+				WarmStart: true,
+				Budget:    workers,
+				Priority:  folder.priority,
+				Nested:    folder.nested,
+				OnError: func(err error) {
+					fmt.Printf("Error watching %s: %v\n", folder.path, err)
+				},
+			})
+		}()
+	}
+	started.Wait()
+	for i, err := range errs {
+		if err != nil {
+			fmt.Printf("Error starting watch of %s: %v\n", folders[i].path, err)
+			os.Exit(errorStatus)
+		}
+	}
+	fmt.Printf("Watching %s (Ctrl+C to stop)\n", strings.Join(names, ", "))
+
+	reports := make(chan folderReport)
+	var forwarding sync.WaitGroup
+	for i, folder := range folders {
+		i, folder := i, folder
+		forwarding.Add(1)
+		go func() {
+			defer forwarding.Done()
+			for report := range channels[i] {
+				reports <- folderReport{folder: folder, report: report}
+			}
+		}()
+	}
+	go func() {
+		forwarding.Wait()
+		close(reports)
+	}()
+
+	for received := range reports {
+		folderPath, report := received.folder.path, received.report
+		fmt.Printf("\n[%s] %s: %d change(s) detected\n", report.NewTimestamp.Format("2006-01-02 15:04:05"), folderPath, len(report.Changes))
+		if err := client.MarkAcknowledged(folderPath, &report); err != nil {
+			fmt.Printf("Error reading acknowledged changes: %v\n", err)
+		}
+		if err := actions.output.writeReport(reportOut, folderPath, &report); err != nil {
+			fmt.Printf("Error rendering report template: %v\n", err)
+		}
+		if err := client.RecordChanges(folderPath, &report); err != nil {
+			fmt.Printf("Error recording change history: %v\n", err)
+		}
+		if actions.evidence.Dir != "" {
+			captured, err := merkle.CaptureEvidence(folderPath, &report, actions.evidence)
+			if err != nil {
+				fmt.Printf("Error capturing evidence: %v\n", err)
+			}
+			if captured != "" {
+				fmt.Printf("Evidence captured in: %s\n", captured)
+			}
+		}
+		if err := merkle.RunActionHooks(folderPath, &report, actions.hooks); err != nil {
+			fmt.Printf("Error running hooks: %v\n", err)
+		}
+		if actions.notifier != nil {
+			notification := merkle.NewNotification(folderPath, &report)
+			notification.Urgent = notification.Urgent || received.folder.priority == merkle.PriorityHigh
+			if err := actions.notifier.Notify(notification); err != nil {
+				fmt.Printf("Error sending notification: %v\n", err)
+			}
+		}
+		if digest, ok := actions.notifier.(*merkle.DigestNotifier); ok {
+			if err := digest.Poll(); err != nil {
+				fmt.Printf("Error sending notification digest: %v\n", err)
+			}
+		}
+	}
+	fmt.Println("\nWatch stopped")
+}
@@ -52,7 +52,10 @@ func main() {
 	}
 
 	// Compare snapshots
-	report := client.CompareSnapshots(previousSnapshot, currentSnapshot)
+	report, err := client.CompareSnapshots(previousSnapshot, currentSnapshot)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Display changes
 	merkle.PrintChangeReport(report)
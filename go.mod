@@ -1,3 +1,21 @@
 module github.com/Ridwan414/file-change-detector
 
 go 1.21
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/mattn/go-sqlite3 v1.14.33
+	golang.org/x/text v0.14.0
+	google.golang.org/grpc v1.62.1
+	google.golang.org/protobuf v1.33.0
+	lukechampine.com/blake3 v1.3.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
+	golang.org/x/net v0.20.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240123012728-ef4313101c80 // indirect
+)
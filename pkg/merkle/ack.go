@@ -0,0 +1,231 @@
+package merkle
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ackFile is the append-only log of acknowledged changes, kept next to the
+// change log
+const ackFile = "acks.csv"
+
+var ackHeader = []string{"folder", "file_path", "hash", "acknowledged_at", "by", "note"}
+
+// Acknowledgement marks the state a file reached through a change as
+// reviewed and expected. Later changes that leave the file in that state are
+// reported as acknowledged; once the file changes again, the change is a new
+// finding.
+type Acknowledgement struct {
+	Folder   string    // name of the monitored folder
+	FileName string    // path relative to the folder
+	Hash     []byte    // content the file is expected to have; nil if it is expected to be gone
+	At       time.Time // when the change was acknowledged
+	By       string    // who acknowledged it
+	Note     string    // why the change is expected
+}
+
+// AcknowledgeChanges records the states that changes of a folder left their
+// files in as reviewed, with who reviewed them and why. A later
+// acknowledgement of the same file replaces earlier ones.
+func (c *MerkleClient) AcknowledgeChanges(folderPath string, changes []FileChange, by, note string) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	acks := make([]Acknowledgement, len(changes))
+	for i, change := range changes {
+		acks[i] = Acknowledgement{
+			Folder:   filepath.Base(folderPath),
+			FileName: change.FileName,
+			Hash:     change.NewHash,
+			At:       now,
+			By:       by,
+			Note:     note,
+		}
+		if change.ChangeType == Deleted {
+			acks[i].Hash = nil
+		}
+	}
+	return c.appendAcknowledgements(acks)
+}
+
+// AcknowledgeFiles acknowledges the files of a folder as they are now, for
+// changes found by a verify, which are not recorded in the history store. A
+// missing file is acknowledged as deleted. It fails without recording
+// anything if a file cannot be hashed.
+func (c *MerkleClient) AcknowledgeFiles(folderPath string, files []string, by, note string) error {
+	changes := make([]FileChange, len(files))
+	for i, fileName := range files {
+		fileName = filepath.ToSlash(fileName)
+		present, hash, ok := c.currentHash(folderPath, fileName)
+		if !ok {
+			return fmt.Errorf("cannot hash %s", fileName)
+		}
+		changes[i] = FileChange{FileName: fileName, ChangeType: Deleted}
+		if present {
+			changes[i].ChangeType = Modified
+			changes[i].NewHash = hash
+		}
+	}
+	return c.AcknowledgeChanges(folderPath, changes, by, note)
+}
+
+// appendAcknowledgements adds acknowledgements to the log
+func (c *MerkleClient) appendAcknowledgements(acks []Acknowledgement) error {
+	unlock, err := c.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	filename := filepath.Join(c.storageDir, ackFile)
+	_, statErr := os.Stat(filename)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if os.IsNotExist(statErr) {
+		if err := writer.Write(ackHeader); err != nil {
+			return err
+		}
+	}
+	for _, ack := range acks {
+		row := []string{ack.Folder, ack.FileName, hex.EncodeToString(ack.Hash),
+			ack.At.Format(time.RFC3339), ack.By, ack.Note}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	// Events read from now on must see the new acknowledgements
+	c.history.mu.Lock()
+	c.history.loaded = false
+	c.history.mu.Unlock()
+
+	return file.Close()
+}
+
+// Acknowledgements returns the acknowledgements of a folder, oldest first
+func (c *MerkleClient) Acknowledgements(folderPath string) ([]Acknowledgement, error) {
+	all, err := readAcknowledgements(filepath.Join(c.storageDir, ackFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var acks []Acknowledgement
+	for _, ack := range all {
+		if ack.Folder == filepath.Base(folderPath) {
+			acks = append(acks, ack)
+		}
+	}
+	return acks, nil
+}
+
+// MarkAcknowledged sets Acknowledged on every change of a report that leaves
+// its file in an acknowledged state
+func (c *MerkleClient) MarkAcknowledged(folderPath string, report *ChangeReport) error {
+	acks, err := readAcknowledgements(filepath.Join(c.storageDir, ackFile))
+	if err != nil {
+		return err
+	}
+
+	index := indexAcknowledgements(acks)
+	for i := range report.Changes {
+		report.Changes[i].Acknowledged = index.covers(filepath.Base(folderPath), report.Changes[i])
+	}
+	return nil
+}
+
+// AcknowledgedCount returns the number of changes in a report that were
+// acknowledged
+func (r *ChangeReport) AcknowledgedCount() int {
+	count := 0
+	for _, change := range r.Changes {
+		if change.Acknowledged {
+			count++
+		}
+	}
+	return count
+}
+
+// ackIndex holds the acknowledged hash of each file by folder and path; a
+// nil hash stands for a file acknowledged as deleted
+type ackIndex map[[2]string][]byte
+
+// indexAcknowledgements keeps the latest acknowledgement of every file
+func indexAcknowledgements(acks []Acknowledgement) ackIndex {
+	index := make(ackIndex, len(acks))
+	for _, ack := range acks {
+		index[[2]string{ack.Folder, ack.FileName}] = ack.Hash
+	}
+	return index
+}
+
+// covers reports whether a change leaves its file in the acknowledged state
+func (index ackIndex) covers(folder string, change FileChange) bool {
+	hash, ok := index[[2]string{folder, change.FileName}]
+	if !ok {
+		return false
+	}
+	if change.ChangeType == Deleted {
+		return hash == nil
+	}
+	return hash != nil && equalHashes(hash, change.NewHash)
+}
+
+// readAcknowledgements parses the acknowledgement log, which may not exist yet
+func readAcknowledgements(filename string) ([]Acknowledgement, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = len(ackHeader)
+	if _, err := reader.Read(); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var acks []Acknowledgement
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ack := Acknowledgement{Folder: row[0], FileName: row[1], By: row[4], Note: row[5]}
+		if row[2] != "" {
+			if ack.Hash, err = hex.DecodeString(row[2]); err != nil {
+				return nil, fmt.Errorf("invalid acknowledged hash for %s: %v", row[1], err)
+			}
+		}
+		if ack.At, err = time.Parse(time.RFC3339, row[3]); err != nil {
+			return nil, fmt.Errorf("invalid acknowledgement time for %s: %v", row[1], err)
+		}
+		acks = append(acks, ack)
+	}
+	return acks, nil
+}
@@ -0,0 +1,89 @@
+package merkle
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// acknowledged returns the acknowledged changes of a report by file name
+func acknowledged(report *ChangeReport) map[string]bool {
+	acked := make(map[string]bool)
+	for _, change := range report.Changes {
+		acked[change.FileName] = change.Acknowledged
+	}
+	return acked
+}
+
+// An acknowledged change stays acknowledged until its file changes again
+func TestAcknowledgedChanges(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "app")
+	writeFiles(t, dir, map[string]string{"config.yml": "v1", "app.bin": "v1", "old.txt": "gone"})
+	client := NewClient(t.TempDir())
+	report := patchReport(t, client, dir, func() {
+		writeFiles(t, dir, map[string]string{"config.yml": "v2", "app.bin": "v2"})
+		if err := os.Remove(filepath.Join(dir, "old.txt")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := client.RecordChanges(dir, report); err != nil {
+		t.Fatal(err)
+	}
+
+	var reviewed []FileChange
+	for _, change := range report.Changes {
+		if change.FileName != "app.bin" {
+			reviewed = append(reviewed, change)
+		}
+	}
+	if err := client.AcknowledgeChanges(dir, reviewed, "alice", "planned release"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.MarkAcknowledged(dir, report); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"config.yml": true, "old.txt": true, "app.bin": false}
+	if got := acknowledged(report); !reflect.DeepEqual(got, want) {
+		t.Errorf("acknowledged = %v, want %v", got, want)
+	}
+	if count := report.AcknowledgedCount(); count != 2 {
+		t.Errorf("AcknowledgedCount = %d, want 2", count)
+	}
+
+	// The history store shows which recorded changes were reviewed
+	events, err := client.HistoryForPath("config.yml")
+	if err != nil || len(events) != 1 || !events[0].Acknowledged {
+		t.Errorf("history of config.yml = %+v, %v; want one acknowledged change", events, err)
+	}
+
+	acks, err := client.Acknowledgements(dir)
+	if err != nil || len(acks) != 2 || acks[0].By != "alice" || acks[0].Note != "planned release" {
+		t.Errorf("Acknowledgements = %+v, %v", acks, err)
+	}
+	if acks, err := client.Acknowledgements(filepath.Join(t.TempDir(), "other")); err != nil || len(acks) != 0 {
+		t.Errorf("acknowledgements of another folder = %+v, %v", acks, err)
+	}
+
+	// Changing the file again is a new finding; acknowledging files as they
+	// now are covers it, and a file acknowledged as gone that comes back
+	// is new too
+	again := patchReport(t, client, dir, func() {
+		writeFiles(t, dir, map[string]string{"config.yml": "v3", "old.txt": "back"})
+	})
+	if err := client.MarkAcknowledged(dir, again); err != nil {
+		t.Fatal(err)
+	}
+	if got := acknowledged(again); got["config.yml"] || got["old.txt"] {
+		t.Errorf("changes since the review were acknowledged: %v", got)
+	}
+	if err := client.AcknowledgeFiles(dir, []string{"config.yml", "old.txt", "app.bin"}, "bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.MarkAcknowledged(dir, again); err != nil {
+		t.Fatal(err)
+	}
+	if count := again.AcknowledgedCount(); count != len(again.Changes) {
+		t.Errorf("after acknowledging the files as they are: %v", acknowledged(again))
+	}
+}
@@ -0,0 +1,146 @@
+package merkle
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// WithAdaptiveHashWorkers tunes the number of files hashed at the same time
+// while a scan runs, between 1 and max, from the read latency and throughput
+// it measures: a spinning disk or a network share that slows down when read
+// in parallel ends up with few workers, an NVMe drive with many. This is the
+// default, with max at 4 times GOMAXPROCS; max below 1 selects that default.
+// WithHashWorkers fixes the number instead.
+func WithAdaptiveHashWorkers(max int) Option {
+	return func(c *MerkleClient) {
+		if max < 1 {
+			max = defaultAdaptiveWorkers()
+		}
+		c.scan.workers = max
+		c.scan.adaptive = true
+	}
+}
+
+// defaultAdaptiveWorkers is the most workers adaptive tuning uses by default;
+// more than GOMAXPROCS pays off while workers wait for reads
+func defaultAdaptiveWorkers() int {
+	return 4 * runtime.GOMAXPROCS(0)
+}
+
+const (
+	// tuneWindow is the least time throughput is measured over before the
+	// number of workers is changed
+	tuneWindow = 200 * time.Millisecond
+	// tuneTolerance is the relative change in throughput that counts as a
+	// real difference rather than noise
+	tuneTolerance = 0.05
+	// tuneLatencyTolerance is the same for per-file latency, which varies more
+	tuneLatencyTolerance = 0.25
+	// tuneUnitBytes makes reading this many bytes weigh as much as opening a
+	// file, so throughput counts both small and large files
+	tuneUnitBytes = 1 << 20
+)
+
+// workerTuner limits the workers of one scan that may hash at the same time,
+// and climbs towards the limit with the best throughput: after each window
+// it moves the limit a step, about a quarter of the limit, further in the
+// direction that last improved throughput, and turns around when throughput
+// drops. When throughput stays flat it holds, unless the last step added
+// workers or per-file latency grew; then it steps down. A nil tuner does not
+// limit.
+type workerTuner struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int // workers allowed to hash at the same time
+	max    int
+	active int
+	step   int // +1 or -1, the direction of the last change; 0 while holding
+
+	// the current window
+	start   time.Time
+	files   int
+	units   float64
+	latency time.Duration
+
+	// the previous window
+	lastRate    float64 // units per second
+	lastLatency time.Duration
+}
+
+// newWorkerTuner returns a tuner for up to max workers, starting from
+// GOMAXPROCS, the number of workers of a scan without tuning
+func newWorkerTuner(max int) *workerTuner {
+	t := &workerTuner{limit: min(runtime.GOMAXPROCS(0), max), max: max, start: time.Now()}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// acquire blocks until the worker may hash a file
+func (t *workerTuner) acquire() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	for t.active >= t.limit {
+		t.cond.Wait()
+	}
+	t.active++
+	t.mu.Unlock()
+}
+
+// release records a file of size bytes that took latency to hash, and lets
+// the next worker hash
+func (t *workerTuner) release(size int64, latency time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	t.files++
+	t.units += 1 + float64(size)/tuneUnitBytes
+	t.latency += latency
+
+	// Wait until every worker finished a file or so, for a fair sample
+	if elapsed := time.Since(t.start); elapsed >= tuneWindow && t.files >= t.limit {
+		t.adjust(elapsed)
+	}
+	t.cond.Broadcast()
+}
+
+// adjust moves the limit after a window of elapsed time and starts the next
+func (t *workerTuner) adjust(elapsed time.Duration) {
+	rate := t.units / elapsed.Seconds()
+	latency := t.latency / time.Duration(t.files)
+
+	switch {
+	case t.lastRate == 0:
+		// First window: probe upwards
+		t.step = 1
+	case rate > t.lastRate*(1+tuneTolerance):
+		// Keep going the way that helped, or probe upwards after holding
+		if t.step == 0 {
+			t.step = 1
+		}
+	case rate < t.lastRate*(1-tuneTolerance):
+		// Turn around, or back off when the device got slower while holding
+		t.step = -t.step
+		if t.step == 0 {
+			t.step = -1
+		}
+	case float64(latency) > float64(t.lastLatency)*(1+tuneLatencyTolerance), t.step > 0:
+		// Throughput is flat: an extra worker only queued reads on the device
+		t.step = -1
+	default:
+		t.step = 0
+	}
+	// Steps grow with the limit, so the change in throughput they cause
+	// stays large enough to measure
+	t.limit = max(1, min(t.max, t.limit+t.step*max(1, t.limit/4)))
+
+	t.lastRate, t.lastLatency = rate, latency
+	t.start, t.files, t.units, t.latency = time.Now(), 0, 0, 0
+}
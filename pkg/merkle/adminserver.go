@@ -0,0 +1,102 @@
+//go:build !readonly
+
+package merkle
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// prune removes the snapshots of a folder that the retention policy given by
+// keep_last=, keep_daily=, keep_weekly= and max_age= does not keep, as the
+// prune command does, and lists them. dry_run=true only lists them.
+func (s *Server) prune(client *serverClient, r *http.Request) (int, any, error) {
+	folder, err := requiredParam(r, "folder")
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	params := r.URL.Query()
+	policy := PrunePolicy{}
+	for name, value := range map[string]*int{"keep_last": &policy.KeepLast, "keep_daily": &policy.KeepDaily, "keep_weekly": &policy.KeepWeekly} {
+		if params.Get(name) == "" {
+			continue
+		}
+		n, err := strconv.Atoi(params.Get(name))
+		if err != nil || n < 0 {
+			return http.StatusBadRequest, nil, fmt.Errorf("%s must be a number of snapshots", name)
+		}
+		*value = n
+	}
+	if policy.MaxAge, err = durationParam(params, "max_age"); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	if policy.DryRun, err = boolParam(params, "dry_run"); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+
+	pruned, err := client.PruneSnapshots(folder, policy)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	return http.StatusOK, retiredSnapshots(pruned), nil
+}
+
+// collectGarbage removes the snapshots of folders that no longer exist or,
+// with max_age=, were not scanned for that long, as the gc command does, and
+// lists them. dry_run=true only lists them.
+func (s *Server) collectGarbage(client *serverClient, r *http.Request) (int, any, error) {
+	params := r.URL.Query()
+	var policy GCPolicy
+	var err error
+	if policy.MaxAge, err = durationParam(params, "max_age"); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	if policy.DryRun, err = boolParam(params, "dry_run"); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+
+	collected, err := client.CollectGarbage(policy)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	return http.StatusOK, retiredSnapshots(collected), nil
+}
+
+// retiredSnapshots describes pruned or collected snapshots as a snapshot list
+func retiredSnapshots(files []string) []jsonSnapshotInfo {
+	list := make([]jsonSnapshotInfo, 0, len(files))
+	for _, file := range files {
+		folder, _ := snapshotFolderName(file)
+		list = append(list, snapshotInfo(file, folder, nil))
+	}
+	return list
+}
+
+// durationParam reads an optional query parameter holding a duration such as
+// "720h"; zero when absent
+func durationParam(params url.Values, name string) (time.Duration, error) {
+	if params.Get(name) == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(params.Get(name))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a duration such as 720h", name)
+	}
+	return d, nil
+}
+
+// boolParam reads an optional query parameter holding true or false; false
+// when absent
+func boolParam(params url.Values, name string) (bool, error) {
+	if params.Get(name) == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(params.Get(name))
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return b, nil
+}
@@ -0,0 +1,76 @@
+package merkle
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FileAttributes holds the Windows file attributes that are tracked in
+// snapshots, using the FILE_ATTRIBUTE_* bit values
+type FileAttributes uint32
+
+const (
+	AttrReadOnly FileAttributes = 0x1
+	AttrHidden   FileAttributes = 0x2
+	AttrSystem   FileAttributes = 0x4
+	AttrArchive  FileAttributes = 0x20
+
+	trackedAttributes = AttrReadOnly | AttrHidden | AttrSystem | AttrArchive
+)
+
+var attributeNames = []struct {
+	attr FileAttributes
+	name string
+}{
+	{AttrReadOnly, "readonly"},
+	{AttrHidden, "hidden"},
+	{AttrSystem, "system"},
+	{AttrArchive, "archive"},
+}
+
+// String lists the attributes that are set, e.g. "readonly,hidden", or
+// "none"
+func (a FileAttributes) String() string {
+	var names []string
+	for _, attribute := range attributeNames {
+		if a&attribute.attr != 0 {
+			names = append(names, attribute.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// parseFileAttributes decodes the output of FileAttributes.String
+func parseFileAttributes(s string) (FileAttributes, error) {
+	if s == "none" {
+		return 0, nil
+	}
+
+	var a FileAttributes
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, attribute := range attributeNames {
+			if attribute.name == name {
+				a |= attribute.attr
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown file attribute %q", name)
+		}
+	}
+	return a, nil
+}
+
+// formatAttributes encodes the recorded attributes of a file for the
+// attributes column, or "" when none were recorded
+func formatAttributes(attributes map[string]FileAttributes, fileName string) string {
+	attrs, ok := attributes[fileName]
+	if !ok {
+		return ""
+	}
+	return attrs.String()
+}
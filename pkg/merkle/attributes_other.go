@@ -0,0 +1,11 @@
+//go:build !windows
+
+package merkle
+
+import "os"
+
+// fileAttributesOf reports no attributes outside Windows; POSIX permissions
+// are not file attributes in this sense
+func fileAttributesOf(info os.FileInfo) (FileAttributes, bool) {
+	return 0, false
+}
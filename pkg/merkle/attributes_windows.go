@@ -0,0 +1,17 @@
+//go:build windows
+
+package merkle
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileAttributesOf returns the tracked attributes of a file
+func fileAttributesOf(info os.FileInfo) (FileAttributes, bool) {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return 0, false
+	}
+	return FileAttributes(data.FileAttributes) & trackedAttributes, true
+}
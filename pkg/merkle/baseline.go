@@ -0,0 +1,461 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// baselineFile records, per folder, the snapshot verify compares against
+const baselineFile = "baselines.csv"
+
+// Baseline is the snapshot a folder is verified against
+type Baseline struct {
+	Folder   string    // folder name used in snapshot file names
+	Snapshot string    // path of the baseline snapshot in the storage directory
+	Promoted time.Time // when the snapshot became the baseline
+	Reason   string    // why it was promoted, e.g. "deploy marker"
+}
+
+// PromotionPolicy holds the rules that promote a snapshot to baseline without
+// manual re-baselining. Each rule is skipped when left empty.
+type PromotionPolicy struct {
+	// DeployMarker promotes the first snapshot taken after this file was
+	// created or last touched, so a deploy script only has to touch it. A
+	// relative path is taken relative to the monitored folder.
+	DeployMarker string
+
+	// CleanFor promotes a snapshot once every snapshot taken since, spanning
+	// at least this long, found no changes and no unreadable files
+	CleanFor time.Duration
+}
+
+// Baseline returns the current baseline of a folder, or nil if none was promoted
+func (c *MerkleClient) Baseline(folderPath string) (*Baseline, error) {
+	baselines, err := c.readBaselines()
+	if err != nil {
+		return nil, err
+	}
+	baseline, ok := baselines[filepath.Base(folderPath)]
+	if !ok {
+		return nil, nil
+	}
+	return &baseline, nil
+}
+
+// SetBaseline makes a stored snapshot the baseline of a folder
+func (c *MerkleClient) SetBaseline(folderPath, snapshotFile, reason string) error {
+	unlock, err := c.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.setBaseline(folderPath, snapshotFile, reason)
+}
+
+// setBaseline records a baseline; the caller holds the storage lock
+func (c *MerkleClient) setBaseline(folderPath, snapshotFile, reason string) error {
+	name := filepath.Base(folderPath)
+	if folder, ok := snapshotFolderName(snapshotFile); !ok || folder != name {
+		return fmt.Errorf("%s is not a snapshot of folder %s", snapshotFile, name)
+	}
+	if err := c.snapshotExists(snapshotFile); err != nil {
+		return err
+	}
+	stored := filepath.Join(c.storageDir, filepath.Base(snapshotFile))
+	if _, ref, ok := splitSQLiteRef(snapshotFile); ok {
+		stored = sqliteRef(c.storageDir, ref)
+	}
+
+	baselines, err := c.readBaselines()
+	if err != nil {
+		return err
+	}
+	baselines[name] = Baseline{
+		Folder:   name,
+		Snapshot: stored,
+		Promoted: time.Now(),
+		Reason:   reason,
+	}
+	return c.writeBaselines(baselines)
+}
+
+// PromoteBaseline applies a promotion policy to the stored snapshots of a
+// folder and returns the new baseline, or nil if no rule matched. The deploy
+// marker rule is applied first, and the clean rule then works from its result.
+func (c *MerkleClient) PromoteBaseline(folderPath string, policy PromotionPolicy) (*Baseline, error) {
+	unlock, err := c.lockStorage()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	snapshots, err := c.folderSnapshots(folderPath)
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+
+	var promoted *Baseline
+	promote := func(file, reason string) error {
+		if err := c.setBaseline(folderPath, file, reason); err != nil {
+			return err
+		}
+		var err error
+		promoted, err = c.Baseline(folderPath)
+		return err
+	}
+
+	if policy.DeployMarker != "" {
+		file, err := c.afterDeployMarker(folderPath, policy.DeployMarker, snapshots)
+		if err != nil {
+			return nil, err
+		}
+		if file != "" {
+			if err := promote(file, "deploy marker"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if policy.CleanFor > 0 {
+		file, err := c.cleanSince(folderPath, policy.CleanFor, snapshots)
+		if err != nil {
+			return promoted, err
+		}
+		if file != "" {
+			if err := promote(file, fmt.Sprintf("clean for %s", policy.CleanFor)); err != nil {
+				return promoted, err
+			}
+		}
+	}
+
+	return promoted, nil
+}
+
+// afterDeployMarker returns the first snapshot taken since the deploy marker
+// was last touched, or "" if there is no marker or the baseline is newer
+func (c *MerkleClient) afterDeployMarker(folderPath, marker string, snapshots []string) (string, error) {
+	if !filepath.IsAbs(marker) {
+		marker = filepath.Join(folderPath, marker)
+	}
+	info, err := os.Stat(marker)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	// Snapshot names only keep whole seconds
+	deployed := info.ModTime().Truncate(time.Second)
+
+	baseline, err := c.Baseline(folderPath)
+	if err != nil {
+		return "", err
+	}
+	if baseline != nil {
+		if taken, err := snapshotTime(baseline.Snapshot); err == nil && !taken.Before(deployed) {
+			return "", nil
+		}
+	}
+
+	for _, file := range snapshots {
+		if taken, err := snapshotTime(file); err == nil && !taken.Before(deployed) {
+			return file, nil
+		}
+	}
+	return "", nil
+}
+
+// cleanSince returns the oldest snapshot from which every later snapshot is
+// unchanged, if that run covers at least cleanFor and does not reach back to
+// the current baseline. Snapshots are compared newest first, so only the
+// unchanged run is loaded.
+func (c *MerkleClient) cleanSince(folderPath string, cleanFor time.Duration, snapshots []string) (string, error) {
+	baseline, err := c.Baseline(folderPath)
+	if err != nil {
+		return "", err
+	}
+
+	newest, err := c.LoadSnapshot(snapshots[len(snapshots)-1])
+	if err != nil || len(newest.Errors) > 0 {
+		return "", err
+	}
+
+	clean := len(snapshots) - 1
+	for i := len(snapshots) - 2; i >= 0; i-- {
+		if baseline != nil && filepath.Base(snapshots[i+1]) == filepath.Base(baseline.Snapshot) {
+			// Unchanged since the baseline, which therefore stays
+			return "", nil
+		}
+		state, err := c.LoadSnapshot(snapshots[i])
+		if err != nil || len(state.Errors) > 0 {
+			break
+		}
+		report, err := c.CompareSnapshots(state, newest)
+		if err != nil || len(report.Changes) > 0 {
+			break
+		}
+		clean = i
+	}
+
+	file := snapshots[clean]
+	if baseline != nil && filepath.Base(file) == filepath.Base(baseline.Snapshot) {
+		return "", nil
+	}
+	first, err := snapshotTime(file)
+	if err != nil {
+		return "", err
+	}
+	last, err := snapshotTime(snapshots[len(snapshots)-1])
+	if err != nil {
+		return "", err
+	}
+	if last.Sub(first) < cleanFor {
+		return "", nil
+	}
+	return file, nil
+}
+
+// folderSnapshots lists the stored snapshots of a folder, oldest first
+func (c *MerkleClient) folderSnapshots(folderPath string) ([]string, error) {
+	name := filepath.Base(folderPath)
+	files, err := c.storedSnapshots(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The pattern also matches folders whose name starts with name_
+	snapshots := files[:0]
+	for _, file := range files {
+		if folder, ok := snapshotFolderName(file); ok && folder == name {
+			snapshots = append(snapshots, file)
+		}
+	}
+	return snapshots, nil
+}
+
+// readBaselines loads the folder name -> baseline table, which may not exist yet
+func (c *MerkleClient) readBaselines() (map[string]Baseline, error) {
+	baselines := make(map[string]Baseline)
+
+	file, err := os.Open(filepath.Join(c.storageDir, baselineFile))
+	if os.IsNotExist(err) {
+		return baselines, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) != 4 {
+			return nil, fmt.Errorf("invalid baseline row")
+		}
+		if row[0] == "folder_name" {
+			continue
+		}
+		promoted, err := time.Parse(time.RFC3339, row[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid baseline promotion time: %v", err)
+		}
+		baselines[row[0]] = Baseline{
+			Folder:   row[0],
+			Snapshot: filepath.Join(c.storageDir, filepath.FromSlash(row[1])),
+			Promoted: promoted,
+			Reason:   row[3],
+		}
+	}
+
+	return baselines, nil
+}
+
+// writeBaselines replaces the baseline table with the given entries
+func (c *MerkleClient) writeBaselines(baselines map[string]Baseline) error {
+	names := make([]string, 0, len(baselines))
+	for name := range baselines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Written aside and renamed into place, so a crash never leaves every
+	// folder without its baseline
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write([]string{"folder_name", "snapshot", "promoted_at", "reason"}); err != nil {
+		return err
+	}
+	for _, name := range names {
+		baseline := baselines[name]
+		// Relative to the storage directory, which keeps the database of a
+		// snapshot stored in one
+		stored, err := filepath.Rel(c.storageDir, baseline.Snapshot)
+		if err != nil {
+			stored = filepath.Base(baseline.Snapshot)
+		}
+		row := []string{name, filepath.ToSlash(stored), baseline.Promoted.Format(time.RFC3339), baseline.Reason}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.storageDir, baselineFile), buf.Bytes())
+}
+
+// namedBaselineDir holds named baselines, one subdirectory per folder. They
+// are copies kept outside the state_* history, so gc and migrate never touch
+// them.
+const namedBaselineDir = "baselines"
+
+// validBaselineName matches names that are safe to use as file names
+var validBaselineName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// SaveNamedBaseline stores a copy of a snapshot as a named baseline of a
+// folder, such as "golden" or "release-2.1", and returns the path of the
+// copy. An existing baseline of that name is only replaced when replace is set.
+func (c *MerkleClient) SaveNamedBaseline(folderPath, name, snapshotFile string, replace bool) (string, error) {
+	if !validBaselineName.MatchString(name) {
+		return "", fmt.Errorf("invalid baseline name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+
+	unlock, err := c.lockStorage()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	existing, err := c.namedBaselineFile(folderPath, name)
+	if err != nil {
+		return "", err
+	}
+	if existing != "" && !replace {
+		return "", fmt.Errorf("baseline %s of %s already exists", name, filepath.Base(folderPath))
+	}
+
+	dir := filepath.Join(c.storageDir, namedBaselineDir, filepath.Base(folderPath))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// Keep the extension so the copy still names its format; snapshots in a
+	// database are copied out as CSV
+	ext := strings.TrimPrefix(filepath.Base(snapshotFile), snapshotStem(snapshotFile))
+	if _, _, ok := splitSQLiteRef(snapshotFile); ok {
+		ext = FormatCSV.extension()
+	}
+	target := filepath.Join(dir, name+ext)
+
+	if err := c.copySnapshot(snapshotFile, target); err != nil {
+		return "", err
+	}
+	if existing != "" && existing != target {
+		if err := os.Remove(existing); err != nil {
+			return "", err
+		}
+	}
+	return target, nil
+}
+
+// NamedBaseline returns the path of a named baseline of a folder
+func (c *MerkleClient) NamedBaseline(folderPath, name string) (string, error) {
+	if !validBaselineName.MatchString(name) {
+		return "", fmt.Errorf("invalid baseline name %q", name)
+	}
+	file, err := c.namedBaselineFile(folderPath, name)
+	if err != nil {
+		return "", err
+	}
+	if file == "" {
+		return "", fmt.Errorf("no baseline named %s for folder %s", name, filepath.Base(folderPath))
+	}
+	return file, nil
+}
+
+// NamedBaselines lists the named baselines of a folder by name
+func (c *MerkleClient) NamedBaselines(folderPath string) (map[string]string, error) {
+	dir := filepath.Join(c.storageDir, namedBaselineDir, filepath.Base(folderPath))
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	baselines := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			baselines[snapshotStem(entry.Name())] = filepath.Join(dir, entry.Name())
+		}
+	}
+	return baselines, nil
+}
+
+// namedBaselineFile finds the file of a named baseline, or "" if there is none
+func (c *MerkleClient) namedBaselineFile(folderPath, name string) (string, error) {
+	baselines, err := c.NamedBaselines(folderPath)
+	if err != nil {
+		return "", err
+	}
+	return baselines[name], nil
+}
+
+// copySnapshot copies a snapshot file, or writes a snapshot in a database to a
+// CSV file
+func (c *MerkleClient) copySnapshot(source, target string) error {
+	if _, _, ok := splitSQLiteRef(source); !ok {
+		return copyFileAtomic(source, target)
+	}
+	state, err := c.loadSQLiteRef(source, LoadStrict)
+	if err != nil {
+		return err
+	}
+	return writeSnapshotFile(target, state, FormatCSV)
+}
+
+// copyFileAtomic copies a file through a temporary file in the target's
+// directory, so readers never see a partial copy
+func copyFileAtomic(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(target), ".baseline-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	if err := out.Chmod(0644); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), target)
+}
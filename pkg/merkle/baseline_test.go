@@ -0,0 +1,85 @@
+package merkle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// saveSnapshotsAgo saves a snapshot of a folder for each age, taken that long
+// ago, and returns their files in the same order
+func saveSnapshotsAgo(t *testing.T, client Client, folder string, ages ...time.Duration) []string {
+	t.Helper()
+	files := make([]string, len(ages))
+	for i, age := range ages {
+		state, err := client.CreateSnapshot(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.Timestamp = state.Timestamp.Add(-age)
+		if err := client.SaveSnapshot(state, folder); err != nil {
+			t.Fatal(err)
+		}
+		if files[i], err = client.FindSnapshotAt(folder, state.Timestamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+// Collecting the snapshots of a folder that is gone keeps its baseline, so the
+// folder can still be verified when it comes back
+func TestGarbageCollectionKeepsBaselines(t *testing.T) {
+	folder := filepath.Join(t.TempDir(), "app")
+	writeFiles(t, folder, map[string]string{"a.txt": "alpha"})
+	client := NewClient(t.TempDir())
+	files := saveSnapshotsAgo(t, client, folder, 2*time.Hour, time.Hour)
+	if err := client.SetBaseline(folder, files[0], "release"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(folder); err != nil {
+		t.Fatal(err)
+	}
+
+	collected, err := client.CollectGarbage(GCPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collected) != 1 || collected[0] != files[1] {
+		t.Errorf("collected %v, want only %s", collected, files[1])
+	}
+	if _, err := os.Stat(files[0]); err != nil {
+		t.Errorf("the baseline snapshot was collected: %v", err)
+	}
+	baseline, err := client.Baseline(folder)
+	if err != nil || baseline == nil || baseline.Snapshot != files[0] || baseline.Reason != "release" {
+		t.Errorf("baseline after gc = %+v, %v; want %s", baseline, err, files[0])
+	}
+
+	// Once collected, nothing more is
+	if collected, err := client.CollectGarbage(GCPolicy{}); err != nil || len(collected) != 0 {
+		t.Errorf("second gc collected %v, %v", collected, err)
+	}
+}
+
+// Snapshots of app_v2 are not snapshots of app
+func TestSnapshotLookupsIgnoreFoldersSharingAPrefix(t *testing.T) {
+	root := t.TempDir()
+	app, appV2 := filepath.Join(root, "app"), filepath.Join(root, "app_v2")
+	writeFiles(t, app, map[string]string{"a.txt": "alpha"})
+	writeFiles(t, appV2, map[string]string{"b.txt": "beta"})
+	client := NewClient(t.TempDir())
+	appFiles := saveSnapshotsAgo(t, client, app, 2*time.Hour)
+	saveSnapshotsAgo(t, client, appV2, time.Hour)
+
+	if latest, err := client.FindLatestSnapshot(app); err != nil || latest != appFiles[0] {
+		t.Errorf("FindLatestSnapshot(app) = %s, %v; want %s", latest, err, appFiles[0])
+	}
+	if at, err := client.FindSnapshotAt(app, time.Now()); err != nil || at != appFiles[0] {
+		t.Errorf("FindSnapshotAt(app) = %s, %v; want %s", at, err, appFiles[0])
+	}
+	if history, err := client.FileHistory(app, "b.txt"); err != nil || len(history) != 0 {
+		t.Errorf("FileHistory(app, b.txt) = %+v, %v; want no history", history, err)
+	}
+}
@@ -0,0 +1,85 @@
+package merkle
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// walkBatchSize is how many entries of a directory are read at a time, so a
+// directory with hundreds of thousands of entries is never held in memory
+// whole, as filepath.Walk does to sort it
+const walkBatchSize = 1024
+
+// walkFolder calls visit for root and every file and directory below it, like
+// filepath.Walk without following symbolic links. Entries are visited in the
+// order the directory lists them, a batch at a time, and subdirectories after
+// the other entries of their parent; the leaves are sorted afterwards, so the
+// order does not matter. Returning filepath.SkipDir for a directory skips it.
+// The first other error, from visit or from reading the folder, stops the walk
+// and is returned.
+func walkFolder(root string, visit func(path string, info os.FileInfo) error) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return err
+	}
+	if err := visit(root, info); err != nil || !info.IsDir() {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	return walkDir(root, visit)
+}
+
+// walkDir visits the entries of a directory and walks its subdirectories
+func walkDir(dir string, visit func(path string, info os.FileInfo) error) error {
+	subdirs, err := visitEntries(dir, visit)
+	if err != nil {
+		return err
+	}
+	for _, subdir := range subdirs {
+		if err := walkDir(subdir, visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visitEntries visits the entries of a directory a batch at a time and returns
+// the subdirectories still to walk. The directory is closed before they are,
+// so deep trees do not hold a file descriptor per level.
+func visitEntries(dir string, visit func(path string, info os.FileInfo) error) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var subdirs []string
+	for {
+		entries, err := f.ReadDir(walkBatchSize)
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			if err := visit(path, info); err != nil {
+				if err == filepath.SkipDir && info.IsDir() {
+					continue
+				}
+				return nil, err
+			}
+			if info.IsDir() {
+				subdirs = append(subdirs, path)
+			}
+		}
+		if err == io.EOF {
+			return subdirs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,627 @@
+package merkle
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotFormat selects how SaveSnapshot stores snapshots
+type SnapshotFormat int
+
+const (
+	FormatCSV    SnapshotFormat = iota // human-readable, the default
+	FormatBinary                       // compact and indexed; see OpenMappedSnapshot
+	FormatJSON                         // versioned JSON, for paths CSV tools mangle and other programs
+)
+
+// WithSnapshotFormat selects the format new snapshots are saved in. Snapshots
+// in every format can always be loaded.
+func WithSnapshotFormat(format SnapshotFormat) Option {
+	return func(c *MerkleClient) {
+		c.format = format
+	}
+}
+
+// extension returns the file extension of snapshots in the format
+func (f SnapshotFormat) extension() string {
+	switch f {
+	case FormatBinary:
+		return ".fcds"
+	case FormatJSON:
+		return ".json"
+	}
+	return ".csv"
+}
+
+// Layout of a binary snapshot:
+//
+//	magic "FCDSNAP3"
+//	header   scheme, root hash (uvarint length + bytes), timestamp (int64 ns),
+//	         restart interval (uvarint)
+//	records  one per file, sorted by path, see writeBinarySnapshot
+//	index    uint64 offset of every restart record, in the same order
+//	footer   uint64 offset of the index, uint64 number of records
+//
+// Integers in the index, footer and timestamp are big-endian. Paths are
+// prefix-compressed: each record stores how many leading bytes it shares with
+// the previous path and only the rest. Every restart interval-th record is a
+// restart point that stores its full path, so the index allows looking paths
+// up by binary search directly over a memory-mapped file and decoding at most
+// one interval of records.
+//
+// Older versions are still read. Version 2 ("FCDSNAP2") records have no
+// attributes. Version 1 ("FCDSNAP1") snapshots also have no restart interval
+// in the header, store full paths and index every record.
+var (
+	binaryMagic   = []byte("FCDSNAP3")
+	binaryMagicV2 = []byte("FCDSNAP2")
+	binaryMagicV1 = []byte("FCDSNAP1")
+)
+
+const (
+	binaryFooterLen = 16
+
+	// binaryRestartInterval trades index size and lookup cost against
+	// compression: 16 records share prefixes between restart points
+	binaryRestartInterval = 16
+)
+
+// isBinarySnapshot reports whether data starts like a binary snapshot of any
+// version
+func isBinarySnapshot(data []byte) bool {
+	return binaryVersion(data) != 0
+}
+
+// binaryVersion returns the format version of a binary snapshot, or 0 if data
+// is not one
+func binaryVersion(data []byte) int {
+	for version, magic := range [][]byte{binaryMagicV1, binaryMagicV2, binaryMagic} {
+		if bytes.HasPrefix(data, magic) {
+			return version + 1
+		}
+	}
+	return 0
+}
+
+// SnapshotEntry is one file of a stored snapshot
+type SnapshotEntry struct {
+	Path    string
+	Hash    []byte // nil for files that could not be hashed
+	Size    int64
+	Status  string // "", "unstable", or "error: <reason>" as in the CSV status column
+	Content ContentKind
+
+	digests    string // encoded as in the CSV digests column
+	chunks     string // encoded as in the CSV chunks column
+	attributes string // encoded as in the CSV attributes column
+}
+
+// sortedPaths returns every path of a state, hashed or errored, in path order
+func sortedPaths(state *TreeState) []string {
+	paths := make([]string, 0, len(state.FileHashes)+len(state.Errors))
+	for fileName := range state.FileHashes {
+		paths = append(paths, fileName)
+	}
+	for fileName := range state.Errors {
+		if _, hashed := state.FileHashes[fileName]; !hashed {
+			paths = append(paths, fileName)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// stateEntry returns the stored form of one file of a state
+func stateEntry(state *TreeState, path string) SnapshotEntry {
+	if reason, failed := state.Errors[path]; failed {
+		return SnapshotEntry{Path: path, Status: "error: " + reason}
+	}
+
+	entry := SnapshotEntry{
+		Path:    path,
+		Hash:    state.FileHashes[path],
+		Size:    state.FileSizes[path],
+		Content: state.Content[path],
+		digests: formatDigests(state.Digests[path]),
+		chunks:  formatChunks(state.Chunks[path]),
+
+		attributes: formatAttributes(state.Attributes, path),
+	}
+	if state.Unstable[path] {
+		entry.Status = "unstable"
+	}
+	return entry
+}
+
+// writeBinarySnapshot encodes a tree state in the binary format, streaming the
+// records in path order
+func writeBinarySnapshot(w io.Writer, state *TreeState) error {
+	bw := bufio.NewWriter(w)
+	var offset uint64
+	write := func(p []byte) {
+		n, _ := bw.Write(p) // errors are sticky and reported by Flush
+		offset += uint64(n)
+	}
+	var scratch [binary.MaxVarintLen64]byte
+	writeBytes := func(p []byte) {
+		write(scratch[:binary.PutUvarint(scratch[:], uint64(len(p)))])
+		write(p)
+	}
+
+	write(binaryMagic)
+	writeBytes([]byte(state.Scheme.String()))
+	writeBytes(state.RootHash)
+	write(binary.BigEndian.AppendUint64(nil, uint64(state.Timestamp.UnixNano())))
+	write(scratch[:binary.PutUvarint(scratch[:], binaryRestartInterval)])
+
+	paths := sortedPaths(state)
+	offsets := make([]uint64, 0, (len(paths)+binaryRestartInterval-1)/binaryRestartInterval)
+	prev := ""
+	for i, path := range paths {
+		shared := 0
+		if i%binaryRestartInterval == 0 {
+			offsets = append(offsets, offset)
+		} else {
+			shared = commonPrefixLen(prev, path)
+		}
+		prev = path
+
+		entry := stateEntry(state, path)
+		write(scratch[:binary.PutUvarint(scratch[:], uint64(shared))])
+		writeBytes([]byte(entry.Path[shared:]))
+		writeBytes(entry.Hash)
+		write(scratch[:binary.PutVarint(scratch[:], entry.Size)])
+		write([]byte{byte(entry.Content)})
+		writeBytes([]byte(entry.Status))
+		writeBytes([]byte(entry.digests))
+		writeBytes([]byte(entry.chunks))
+		writeBytes([]byte(entry.attributes))
+	}
+
+	indexOffset := offset
+	var word [8]byte
+	for _, o := range offsets {
+		binary.BigEndian.PutUint64(word[:], o)
+		write(word[:])
+	}
+	binary.BigEndian.PutUint64(word[:], indexOffset)
+	write(word[:])
+	binary.BigEndian.PutUint64(word[:], uint64(len(paths)))
+	write(word[:])
+
+	return bw.Flush()
+}
+
+// commonPrefixLen returns the number of leading bytes a and b share
+func commonPrefixLen(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// ErrCorruptSnapshot is returned when a binary snapshot is truncated or its
+// offsets and lengths point outside of it
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+// corruptf returns an ErrCorruptSnapshot describing what is wrong
+func corruptf(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrCorruptSnapshot}, args...)...)
+}
+
+// binarySnapshot reads a binary snapshot held in memory or mapped from disk.
+// parseBinarySnapshot validates every offset of the index, so decoding a
+// record can only fail with an error, never read outside of data.
+type binarySnapshot struct {
+	data      []byte // the header and records, without the index and footer
+	index     []byte // 8-byte offsets of the restart records
+	count     int
+	interval  int // records per restart point
+	version   int // format version, see binaryMagic
+	timestamp time.Time
+	rootHash  []byte
+	scheme    HashScheme
+}
+
+// parseBinarySnapshot validates the header and footer of a binary snapshot;
+// records are decoded on demand
+func parseBinarySnapshot(data []byte) (*binarySnapshot, error) {
+	if !isBinarySnapshot(data) || len(data) < len(binaryMagic)+binaryFooterLen {
+		return nil, corruptf("invalid binary snapshot")
+	}
+
+	footer := data[len(data)-binaryFooterLen:]
+	indexOffset := binary.BigEndian.Uint64(footer[:8])
+	count := binary.BigEndian.Uint64(footer[8:])
+	indexEnd := uint64(len(data) - binaryFooterLen)
+	if indexOffset < uint64(len(binaryMagic)) || indexOffset > indexEnd || (indexEnd-indexOffset)%8 != 0 {
+		return nil, corruptf("invalid binary snapshot index")
+	}
+	// Every record takes several bytes, so a count beyond the size of the
+	// records cannot be right
+	if count > indexOffset {
+		return nil, corruptf("invalid binary snapshot record count %d", count)
+	}
+
+	s := &binarySnapshot{
+		data:     data[:indexOffset],
+		index:    data[indexOffset:indexEnd],
+		count:    int(count),
+		interval: 1,
+		version:  binaryVersion(data),
+	}
+
+	r := binaryReader{data: s.data, pos: len(binaryMagic)}
+	schemeStr := r.bytes()
+	s.rootHash = r.bytes()
+	nanos := r.fixed64()
+	interval := uint64(1)
+	if s.version >= 2 {
+		interval = r.uvarint()
+	}
+	if r.err != nil || interval == 0 || interval > math.MaxInt32 {
+		return nil, corruptf("invalid binary snapshot header")
+	}
+	s.interval = int(interval)
+	restarts := count / interval
+	if count%interval != 0 {
+		restarts++
+	}
+	if uint64(len(s.index))/8 != restarts {
+		return nil, corruptf("invalid binary snapshot index")
+	}
+
+	// Restart records lie between the header and the index, in order
+	previous := uint64(r.pos)
+	for k := 0; k < len(s.index)/8; k++ {
+		offset := binary.BigEndian.Uint64(s.index[8*k:])
+		if offset >= indexOffset || offset < previous || (k > 0 && offset == previous) {
+			return nil, corruptf("invalid binary snapshot restart offset %d", offset)
+		}
+		previous = offset
+	}
+
+	s.timestamp = time.Unix(0, int64(nanos))
+	if len(schemeStr) > 0 {
+		scheme, err := parseHashScheme(string(schemeStr))
+		if err != nil {
+			return nil, corruptf("%v", err)
+		}
+		s.scheme = scheme
+	}
+	return s, nil
+}
+
+// binaryCursor decodes the records of a binary snapshot in order. The path
+// of the last record is rebuilt in place in one buffer, so the prefixes shared
+// between consecutive paths are never copied.
+type binaryCursor struct {
+	s    *binarySnapshot
+	i    int    // index of the next record
+	pos  int    // offset of the next record
+	path []byte // path of the last decoded record
+}
+
+// cursor returns a cursor positioned at the i-th record, decoding forward from
+// the restart point before it
+func (s *binarySnapshot) cursor(i int) (*binaryCursor, error) {
+	if i < 0 || i > s.count {
+		return nil, fmt.Errorf("record %d out of range [0, %d)", i, s.count)
+	}
+	restart := i / s.interval
+	c := &binaryCursor{s: s, i: restart * s.interval}
+	if restart < len(s.index)/8 {
+		c.pos = int(binary.BigEndian.Uint64(s.index[8*restart:]))
+	}
+	for c.i < i {
+		if _, err := c.next(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// next decodes the next record. Hash refers to the snapshot's memory.
+func (c *binaryCursor) next() (SnapshotEntry, error) {
+	if c.i >= c.s.count {
+		return SnapshotEntry{}, io.EOF
+	}
+
+	r := binaryReader{data: c.s.data, pos: c.pos}
+	if err := c.readPath(&r); err != nil {
+		return SnapshotEntry{}, err
+	}
+	entry := SnapshotEntry{
+		Path: string(c.path),
+		Hash: r.bytes(),
+		Size: r.varint(),
+	}
+	entry.Content = ContentKind(r.byte())
+	entry.Status = string(r.bytes())
+	entry.digests = string(r.bytes())
+	entry.chunks = string(r.bytes())
+	if c.s.version >= 3 {
+		entry.attributes = string(r.bytes())
+	}
+	if r.err != nil {
+		return SnapshotEntry{}, corruptf("invalid binary snapshot record %d", c.i)
+	}
+	if len(entry.Hash) == 0 {
+		entry.Hash = nil
+	}
+
+	c.i++
+	c.pos = r.pos
+	return entry, nil
+}
+
+// readPath rebuilds the path of the record at r from the previous path
+func (c *binaryCursor) readPath(r *binaryReader) error {
+	var shared uint64
+	if c.s.version >= 2 {
+		shared = r.uvarint()
+	}
+	suffix := r.bytes()
+	if r.err != nil || shared > uint64(len(c.path)) || (shared > 0 && c.i%c.s.interval == 0) {
+		return corruptf("invalid binary snapshot record %d", c.i)
+	}
+	c.path = append(c.path[:shared], suffix...)
+	return nil
+}
+
+// entry decodes the i-th record
+func (s *binarySnapshot) entry(i int) (SnapshotEntry, error) {
+	c, err := s.cursor(i)
+	if err != nil {
+		return SnapshotEntry{}, err
+	}
+	return c.next()
+}
+
+// restartPath decodes the full path stored at a restart point
+func (s *binarySnapshot) restartPath(restart int) ([]byte, error) {
+	r := binaryReader{data: s.data, pos: int(binary.BigEndian.Uint64(s.index[8*restart:]))}
+	if s.version >= 2 {
+		if shared := r.uvarint(); shared != 0 {
+			return nil, corruptf("invalid binary snapshot record %d", restart*s.interval)
+		}
+	}
+	path := r.bytes()
+	if r.err != nil {
+		return nil, corruptf("invalid binary snapshot record %d", restart*s.interval)
+	}
+	return path, nil
+}
+
+// lookup finds the record of a path by binary search over the restart points,
+// then scans forward through at most one interval of records
+func (s *binarySnapshot) lookup(path string) (SnapshotEntry, bool, error) {
+	var searchErr error
+	restarts := len(s.index) / 8
+	// The first restart point whose path is beyond the one searched for; the
+	// path can only be in the interval before it
+	restart := sort.Search(restarts, func(k int) bool {
+		p, err := s.restartPath(k)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return string(p) > path
+	})
+	if searchErr != nil {
+		return SnapshotEntry{}, false, searchErr
+	}
+	if restart == 0 {
+		return SnapshotEntry{}, false, nil
+	}
+
+	c, err := s.cursor((restart - 1) * s.interval)
+	if err != nil {
+		return SnapshotEntry{}, false, err
+	}
+	for c.i < min(restart*s.interval, s.count) {
+		entry, err := c.next()
+		if err != nil {
+			return SnapshotEntry{}, false, err
+		}
+		if entry.Path == path {
+			return entry, true, nil
+		}
+		if entry.Path > path {
+			break
+		}
+	}
+	return SnapshotEntry{}, false, nil
+}
+
+// decodeBinarySnapshot loads a whole binary snapshot into a tree state. Binary
+// snapshots are always a current format for LoadAuto.
+func decodeBinarySnapshot(data []byte, mode LoadMode) (*TreeState, error) {
+	s, err := parseBinarySnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &TreeState{
+		Timestamp:  s.timestamp,
+		RootHash:   append([]byte(nil), s.rootHash...),
+		Scheme:     s.scheme,
+		FileHashes: make(map[string][]byte, s.count),
+		FileSizes:  make(map[string]int64, s.count),
+		Errors:     make(map[string]string),
+		Unstable:   make(map[string]bool),
+		Digests:    make(map[string]map[string][]byte),
+		Chunks:     make(map[string]*FileChunks),
+		Content:    make(map[string]ContentKind, s.count),
+		Attributes: make(map[string]FileAttributes),
+	}
+
+	c, err := s.cursor(0)
+	if err != nil {
+		return nil, err
+	}
+	in := newSnapshotInterner()
+	rc := &rowChecker{strict: mode.strict(true)}
+	for i := 0; i < s.count; i++ {
+		entry, err := c.next()
+		if err != nil {
+			return nil, err
+		}
+		rc.row = i + 1
+		if err := addEntry(state, entry, in, rc); err != nil {
+			return nil, err
+		}
+	}
+	state.Warnings = rc.warnings
+	return state, nil
+}
+
+// addEntry adds a stored file to a tree state, copying its hash out of the
+// snapshot's memory
+func addEntry(state *TreeState, entry SnapshotEntry, in *snapshotInterner, rc *rowChecker) error {
+	if reason, ok := strings.CutPrefix(entry.Status, "error: "); ok {
+		state.Errors[entry.Path] = in.string(reason)
+		return nil
+	}
+
+	var hashErr error
+	if len(entry.Hash) == 0 {
+		hashErr = fmt.Errorf("missing hash")
+	}
+	var sizeErr error
+	if entry.Size < 0 {
+		sizeErr = fmt.Errorf("negative size %d", entry.Size)
+	}
+	var contentErr error
+	if entry.Content > ContentBinary {
+		contentErr = fmt.Errorf("unknown content kind %d", entry.Content)
+	}
+	digests, digestsErr := in.digests(entry.digests)
+	chunks, chunksErr := parseChunks(entry.chunks)
+	var attrs FileAttributes
+	var attrsErr error
+	if entry.attributes != "" {
+		attrs, attrsErr = parseFileAttributes(entry.attributes)
+	}
+	for _, check := range []struct {
+		column string
+		err    error
+	}{
+		{"file_hash", hashErr},
+		{"status", checkStatus(entry.Status)},
+		{"digests", digestsErr},
+		{"file_size", sizeErr},
+		{"chunks", chunksErr},
+		{"content", contentErr},
+		{"attributes", attrsErr},
+	} {
+		if err := rc.check(check.column, check.err); err != nil {
+			return err
+		}
+	}
+
+	state.FileHashes[entry.Path] = in.hash(entry.Hash)
+	if sizeErr == nil {
+		state.FileSizes[entry.Path] = entry.Size
+	}
+	if entry.Status == "unstable" {
+		state.Unstable[entry.Path] = true
+	}
+	if len(digests) > 0 {
+		state.Digests[entry.Path] = digests
+	}
+	if chunks != nil {
+		state.Chunks[entry.Path] = chunks
+	}
+	if entry.Content != ContentUnknown && contentErr == nil {
+		state.Content[entry.Path] = entry.Content
+	}
+	if entry.attributes != "" && attrsErr == nil {
+		state.Attributes[entry.Path] = attrs
+	}
+	return nil
+}
+
+// binaryReader decodes the primitive values of the binary format; the first
+// out-of-bounds or malformed read sets err and makes later reads return zero
+type binaryReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// ok reports whether no read failed and pos is within data
+func (r *binaryReader) ok() bool {
+	if r.err == nil && (r.pos < 0 || r.pos > len(r.data)) {
+		r.err = io.ErrUnexpectedEOF
+	}
+	return r.err == nil
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if !r.ok() {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	if !r.ok() {
+		return 0
+	}
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *binaryReader) bytes() []byte {
+	n := r.uvarint()
+	if !r.ok() || n > uint64(len(r.data)-r.pos) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	p := r.data[r.pos : r.pos+int(n) : r.pos+int(n)]
+	r.pos += int(n)
+	return p
+}
+
+func (r *binaryReader) byte() byte {
+	if !r.ok() || r.pos >= len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *binaryReader) fixed64() uint64 {
+	if !r.ok() || len(r.data)-r.pos < 8 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.data[r.pos:])
+	r.pos += 8
+	return v
+}
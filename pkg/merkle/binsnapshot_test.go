@@ -0,0 +1,179 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// binaryFixture encodes a snapshot of files paths with shared prefixes, spread
+// over several restart intervals
+func binaryFixture(t testing.TB, files int) []byte {
+	t.Helper()
+	state := &TreeState{
+		Timestamp:  time.Unix(1700000000, 0),
+		RootHash:   bytes.Repeat([]byte{0xab}, 32),
+		FileHashes: make(map[string][]byte),
+		FileSizes:  make(map[string]int64),
+		Scheme:     defaultScanOptions().scheme(),
+	}
+	for i := 0; i < files; i++ {
+		path := fmt.Sprintf("dir%d/sub/file%03d.txt", i%3, i)
+		sum := sha256.Sum256([]byte(path))
+		state.FileHashes[path] = sum[:]
+		state.FileSizes[path] = int64(i)
+	}
+
+	var buf bytes.Buffer
+	if err := writeBinarySnapshot(&buf, state); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// rawRecord encodes one record of a current binary snapshot by hand
+func rawRecord(shared uint64, suffix string) []byte {
+	record := binary.AppendUvarint(nil, shared)
+	record = binary.AppendUvarint(record, uint64(len(suffix)))
+	record = append(record, suffix...)
+	record = binary.AppendUvarint(record, 32)
+	record = append(record, bytes.Repeat([]byte{1}, 32)...)
+	record = binary.AppendVarint(record, 1)
+	record = append(record, byte(ContentText))
+	for i := 0; i < 4; i++ { // status, digests, chunks, attributes
+		record = binary.AppendUvarint(record, 0)
+	}
+	return record
+}
+
+// rawSnapshot assembles a binary snapshot from hand-made records. A nil index
+// or a zero count are computed from the records.
+func rawSnapshot(interval uint64, records [][]byte, index []uint64, count uint64) []byte {
+	data := append([]byte(nil), binaryMagic...)
+	data = binary.AppendUvarint(data, 0) // no scheme
+	data = binary.AppendUvarint(data, 0) // no root hash
+	data = binary.BigEndian.AppendUint64(data, 0)
+	data = binary.AppendUvarint(data, interval)
+
+	var offsets []uint64
+	for i, record := range records {
+		if interval > 0 && uint64(i)%interval == 0 {
+			offsets = append(offsets, uint64(len(data)))
+		}
+		data = append(data, record...)
+	}
+	if index == nil {
+		index = offsets
+	}
+	if count == 0 {
+		count = uint64(len(records))
+	}
+
+	indexOffset := uint64(len(data))
+	for _, offset := range index {
+		data = binary.BigEndian.AppendUint64(data, offset)
+	}
+	data = binary.BigEndian.AppendUint64(data, indexOffset)
+	return binary.BigEndian.AppendUint64(data, count)
+}
+
+// exerciseBinarySnapshot decodes data every way a snapshot is read: loading,
+// looking up paths, reading entries and comparing mapped snapshots. It fails
+// the test on a panic and returns the first error.
+func exerciseBinarySnapshot(t *testing.T, data []byte) (err error) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("decoding panicked: %v", r)
+		}
+	}()
+
+	_, loadErr := decodeBinarySnapshot(data, LoadStrict)
+	s, err := parseBinarySnapshot(data)
+	if err != nil {
+		return err
+	}
+	for i := -1; i <= s.count; i++ {
+		if entry, err := s.entry(i); err == nil {
+			s.lookup(entry.Path)
+		}
+	}
+	s.lookup("dir1/sub/file004.txt")
+	s.lookup("zzz")
+
+	mapped := &MappedSnapshot{snapshot: s, unmap: func() error { return nil }}
+	if _, err := CompareMappedSnapshots(mapped, mapped); err != nil {
+		return err
+	}
+	return loadErr
+}
+
+func TestBinarySnapshotRejectsCorruption(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"not a snapshot", []byte("FCDSNAP3")},
+		{"shared prefix longer than the previous path", rawSnapshot(16, [][]byte{rawRecord(0, "a"), rawRecord(5, "b")}, nil, 0)},
+		{"shared prefix that overflows an int", rawSnapshot(16, [][]byte{rawRecord(0, "a"), rawRecord(1<<63, "b")}, nil, 0)},
+		{"shared prefix at a restart point", rawSnapshot(16, [][]byte{rawRecord(1, "a")}, nil, 0)},
+		{"restart offset past the records", rawSnapshot(16, [][]byte{rawRecord(0, "a")}, []uint64{1 << 20}, 0)},
+		{"restart offset that overflows an int", rawSnapshot(16, [][]byte{rawRecord(0, "a")}, []uint64{1 << 63}, 0)},
+		{"restart offset inside the header", rawSnapshot(16, [][]byte{rawRecord(0, "a")}, []uint64{2}, 0)},
+		{"restart offsets out of order", rawSnapshot(1, [][]byte{rawRecord(0, "a"), rawRecord(0, "b")}, []uint64{40, 20}, 0)},
+		{"record count past the file", rawSnapshot(16, [][]byte{rawRecord(0, "a")}, nil, 1<<62)},
+		{"record count that overflows an int", rawSnapshot(16, [][]byte{rawRecord(0, "a")}, nil, 1<<63)},
+		{"restart interval of zero", rawSnapshot(0, [][]byte{rawRecord(0, "a")}, []uint64{}, 0)},
+		{"restart interval that overflows an int", rawSnapshot(1<<63, [][]byte{rawRecord(0, "a")}, nil, 0)},
+		{"suffix longer than the file", rawSnapshot(16, [][]byte{{0, 0xff, 0xff, 0xff, 0x0f}}, nil, 0)},
+		{"record cut short", rawSnapshot(16, [][]byte{rawRecord(0, "a")[:10]}, nil, 0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := exerciseBinarySnapshot(t, tc.data); !errors.Is(err, ErrCorruptSnapshot) {
+				t.Errorf("err = %v, want ErrCorruptSnapshot", err)
+			}
+		})
+	}
+}
+
+func TestBinarySnapshotTruncated(t *testing.T) {
+	data := binaryFixture(t, 40)
+	if err := exerciseBinarySnapshot(t, data); err != nil {
+		t.Fatalf("the intact snapshot fails: %v", err)
+	}
+	for n := 0; n < len(data); n++ {
+		if err := exerciseBinarySnapshot(t, data[:n]); !errors.Is(err, ErrCorruptSnapshot) {
+			t.Fatalf("truncated to %d of %d bytes: err = %v, want ErrCorruptSnapshot", n, len(data), err)
+		}
+	}
+}
+
+func TestBinarySnapshotBitFlips(t *testing.T) {
+	if testing.Short() {
+		t.Skip("flips every bit of a snapshot")
+	}
+	data := binaryFixture(t, 20)
+	flipped := make([]byte, len(data))
+	for i := range data {
+		for bit := 0; bit < 8; bit++ {
+			copy(flipped, data)
+			flipped[i] ^= 1 << bit
+			// Flips in hashes and sizes decode fine; only panics fail
+			exerciseBinarySnapshot(t, flipped)
+		}
+	}
+}
+
+func FuzzDecodeBinarySnapshot(f *testing.F) {
+	f.Add(binaryFixture(f, 0))
+	f.Add(binaryFixture(f, 3))
+	f.Add(binaryFixture(f, 40))
+	f.Add(rawSnapshot(16, [][]byte{rawRecord(0, "a"), rawRecord(1, "b")}, nil, 0))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		exerciseBinarySnapshot(t, data)
+	})
+}
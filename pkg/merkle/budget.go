@@ -0,0 +1,131 @@
+package merkle
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// WorkerBudget limits how many files are hashed at the same time across the
+// scans of several folders, such as the folders of one long-running watch.
+// Files waiting for a worker are queued per folder and the queues are served
+// in turn, so a folder with a million files to hash cannot hold up the scan
+// of a small folder such as /etc: each of its files waits behind at most one
+// file of every other busy folder. Folders of a higher Priority are served
+// before those of a lower one. A WorkerBudget is safe for concurrent use.
+type WorkerBudget struct {
+	mu      sync.Mutex
+	free    int
+	waiting map[string][]chan struct{} // per folder, files waiting for a worker
+	turns   [3][]string                // per priority, lowest first, folders with waiting files
+}
+
+// NewWorkerBudget returns a budget of n workers; n below 1 selects GOMAXPROCS
+func NewWorkerBudget(n int) *WorkerBudget {
+	if n < 1 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	return &WorkerBudget{free: n, waiting: make(map[string][]chan struct{})}
+}
+
+// acquire blocks until a worker is free for a file of the given folder, or
+// ctx, which may be nil, is cancelled. It is a no-op on a nil budget.
+func (b *WorkerBudget) acquire(ctx context.Context, folder string, priority Priority) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	if b.free > 0 && len(b.waiting) == 0 {
+		b.free--
+		b.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	class := priority.class()
+	if len(b.waiting[folder]) == 0 {
+		b.turns[class] = append(b.turns[class], folder)
+	}
+	b.waiting[folder] = append(b.waiting[folder], ready)
+	b.mu.Unlock()
+
+	var cancelled <-chan struct{}
+	if ctx != nil {
+		cancelled = ctx.Done()
+	}
+	select {
+	case <-ready:
+		return nil
+	case <-cancelled:
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-ready:
+		// The worker was handed over as the wait was cancelled; pass it on
+		b.releaseLocked()
+	default:
+		b.withdraw(folder, class, ready)
+	}
+	return ctx.Err()
+}
+
+// withdraw removes a cancelled wait from the queue of its folder, and the
+// folder from its line once nothing of it is waiting
+func (b *WorkerBudget) withdraw(folder string, class int, ready chan struct{}) {
+	queue := b.waiting[folder]
+	for i, waiting := range queue {
+		if waiting == ready {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		b.waiting[folder] = queue
+		return
+	}
+
+	delete(b.waiting, folder)
+	for i, turn := range b.turns[class] {
+		if turn == folder {
+			b.turns[class] = append(b.turns[class][:i:i], b.turns[class][i+1:]...)
+			break
+		}
+	}
+}
+
+// release hands a worker to the first waiting file of the folder whose turn it
+// is in the highest priority with waiting files, which then goes to the back
+// of its line, or returns the worker to the budget
+func (b *WorkerBudget) release() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.releaseLocked()
+}
+
+// releaseLocked is release with b.mu held
+func (b *WorkerBudget) releaseLocked() {
+	for class := len(b.turns) - 1; class >= 0; class-- {
+		if len(b.turns[class]) == 0 {
+			continue
+		}
+
+		folder := b.turns[class][0]
+		b.turns[class] = b.turns[class][1:]
+		queue := b.waiting[folder]
+		close(queue[0])
+		if len(queue) > 1 {
+			b.waiting[folder] = queue[1:]
+			b.turns[class] = append(b.turns[class], folder)
+		} else {
+			delete(b.waiting, folder)
+		}
+		return
+	}
+	b.free++
+}
@@ -0,0 +1,73 @@
+package merkle
+
+import "strings"
+
+// WithCaseInsensitivePaths compares snapshots as if paths differing only in
+// letter case were the same file, as they are on case-insensitive file systems.
+// A case-only rename is then not reported at all unless the content changed
+// too, in which case it is reported as a modification of the new name. The
+// setting is recorded in the hash scheme of snapshots, so snapshots taken with
+// and without it are not compared.
+func WithCaseInsensitivePaths(enabled bool) Option {
+	return func(c *MerkleClient) {
+		c.scan.foldCase = enabled
+	}
+}
+
+// pairCaseRenames merges each deleted file with an added file whose path only
+// differs in letter case into a single change. Such pairs become CaseRenamed
+// changes, or with foldCase a Modified change or nothing at all.
+func pairCaseRenames(changes []FileChange, foldCase bool) []FileChange {
+	deleted := make(map[string]int)
+	for i, change := range changes {
+		if change.ChangeType != Deleted {
+			continue
+		}
+		key := strings.ToLower(change.FileName)
+		if _, dup := deleted[key]; dup {
+			// Several old names fold together; pairing would be a guess
+			deleted[key] = -1
+			continue
+		}
+		deleted[key] = i
+	}
+
+	paired := make(map[int]bool)
+	for i, change := range changes {
+		if change.ChangeType != Added {
+			continue
+		}
+		j, ok := deleted[strings.ToLower(change.FileName)]
+		if !ok || j < 0 || paired[j] {
+			continue
+		}
+		old := changes[j]
+		paired[i], paired[j] = true, true
+
+		renamed := change
+		renamed.ChangeType = CaseRenamed
+		renamed.OldPath = old.FileName
+		renamed.NewPath = change.FileName
+		renamed.OldHash = old.OldHash
+		renamed.OldSize = old.OldSize
+		if foldCase {
+			if equalHashes(renamed.OldHash, renamed.NewHash) {
+				continue
+			}
+			renamed.ChangeType = Modified
+		}
+		changes = append(changes, renamed)
+	}
+
+	if len(paired) == 0 {
+		return changes
+	}
+
+	merged := changes[:0]
+	for i, change := range changes {
+		if !paired[i] {
+			merged = append(merged, change)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,175 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// chunkHashLen is the number of bytes kept from each chunk's SHA-256 hash.
+// Chunk hashes only estimate how much of a file changed, so a short prefix is enough.
+const chunkHashLen = 8
+
+// WithChunkHashing additionally hashes every file in content-defined chunks
+// averaging chunkSize bytes so that reports can estimate what fraction of a
+// modified file changed. Chunk boundaries follow the content, so an insertion
+// only affects the chunks around it. A chunkSize of zero disables chunk hashing.
+func WithChunkHashing(chunkSize int) Option {
+	return func(c *MerkleClient) {
+		c.scan.chunkSize = chunkSize
+	}
+}
+
+// FileChunks holds the chunk hashes of a single file
+type FileChunks struct {
+	ChunkSize int
+	Hashes    [][]byte
+}
+
+// gearTable drives the rolling hash used to find chunk boundaries. It is derived
+// deterministically so chunk boundaries are identical across runs and machines.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.LittleEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// chunkHasher is an io.Writer that splits its input into content-defined
+// chunks using a gear rolling hash and hashes each chunk
+type chunkHasher struct {
+	size    int
+	mask    uint64
+	minSize int
+	maxSize int
+	rolling uint64
+	filled  int
+	current hash.Hash
+	hashes  [][]byte
+}
+
+func newChunkHasher(size int) *chunkHasher {
+	// Boundaries occur where the rolling hash has its low bits clear, which
+	// happens on average once per power-of-two sized window
+	bits := 0
+	for 1<<bits < size {
+		bits++
+	}
+	return &chunkHasher{
+		size:    size,
+		mask:    1<<bits - 1,
+		minSize: size / 4,
+		maxSize: size * 4,
+		current: sha256.New(),
+	}
+}
+
+func (h *chunkHasher) Write(p []byte) (int, error) {
+	start := 0
+	for i, b := range p {
+		h.rolling = h.rolling<<1 + gearTable[b]
+		h.filled++
+
+		if (h.filled >= h.minSize && h.rolling&h.mask == 0) || h.filled >= h.maxSize {
+			h.current.Write(p[start : i+1])
+			start = i + 1
+			h.flush()
+		}
+	}
+	h.current.Write(p[start:])
+	return len(p), nil
+}
+
+func (h *chunkHasher) flush() {
+	h.hashes = append(h.hashes, h.current.Sum(nil)[:chunkHashLen])
+	h.current.Reset()
+	h.rolling = 0
+	h.filled = 0
+}
+
+// chunks returns the hashes of all chunks, including a trailing partial chunk
+func (h *chunkHasher) chunks() *FileChunks {
+	if h.filled > 0 {
+		h.flush()
+	}
+	return &FileChunks{ChunkSize: h.size, Hashes: h.hashes}
+}
+
+// changedChunks estimates how many chunks differ between two versions of a file
+// by counting the chunks that appear in only one version. ok is false when the
+// versions were chunked with different settings and cannot be compared.
+func changedChunks(oldChunks, newChunks *FileChunks) (changed, total int, ok bool) {
+	if oldChunks == nil || newChunks == nil || oldChunks.ChunkSize != newChunks.ChunkSize {
+		return 0, 0, false
+	}
+
+	remaining := make(map[string]int, len(oldChunks.Hashes))
+	for _, h := range oldChunks.Hashes {
+		remaining[string(h)]++
+	}
+
+	added := 0
+	for _, h := range newChunks.Hashes {
+		if remaining[string(h)] > 0 {
+			remaining[string(h)]--
+		} else {
+			added++
+		}
+	}
+	removed := 0
+	for _, n := range remaining {
+		removed += n
+	}
+
+	changed = max(added, removed)
+	total = max(len(oldChunks.Hashes), len(newChunks.Hashes))
+	return changed, total, true
+}
+
+// formatChunks encodes chunk hashes as "<chunk size>:<hex><hex>..."
+func formatChunks(chunks *FileChunks) string {
+	if chunks == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(chunks.ChunkSize))
+	b.WriteByte(':')
+	for _, h := range chunks.Hashes {
+		b.WriteString(hex.EncodeToString(h))
+	}
+	return b.String()
+}
+
+// parseChunks decodes the output of formatChunks
+func parseChunks(s string) (*FileChunks, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	sizeStr, hexHashes, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid chunk list")
+	}
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chunk size: %v", err)
+	}
+
+	raw, err := hex.DecodeString(hexHashes)
+	if err != nil || len(raw)%chunkHashLen != 0 {
+		return nil, fmt.Errorf("invalid chunk hashes")
+	}
+
+	chunks := &FileChunks{ChunkSize: size}
+	for i := 0; i < len(raw); i += chunkHashLen {
+		chunks.Hashes = append(chunks.Hashes, raw[i:i+chunkHashLen])
+	}
+	return chunks, nil
+}
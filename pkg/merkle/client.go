@@ -1,14 +1,18 @@
 package merkle
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/csv"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,6 +23,10 @@ const (
 	Modified ChangeType = iota
 	Added
 	Deleted
+	CaseRenamed       // path changed only in letter case; OldPath holds the previous path
+	AttributesChanged // content unchanged but Windows file attributes differ
+	Renamed           // same content under a new name in the same directory
+	Moved             // same content in another directory
 )
 
 // FileChange represents a change detected in a file
@@ -27,6 +35,67 @@ type FileChange struct {
 	ChangeType ChangeType
 	OldHash    []byte
 	NewHash    []byte
+	OldSize    int64
+	NewSize    int64
+	Content    ContentKind // text or binary, from the newest version of the file
+	Severity   Severity    // assigned by severity rules; SeverityInfo without them
+
+	// OldPath and NewPath are the paths before and after a CaseRenamed,
+	// Renamed or Moved change; NewPath is the same as FileName
+	OldPath string
+	NewPath string
+
+	// OldAttributes and NewAttributes are the Windows file attributes of a
+	// Modified or AttributesChanged file; both are zero unless both states
+	// recorded attributes
+	OldAttributes FileAttributes
+	NewAttributes FileAttributes
+
+	// ChangedChunks and TotalChunks estimate how much of a modified file
+	// changed; both are zero unless chunk hashing was enabled for both states
+	ChangedChunks int
+	TotalChunks   int
+
+	// Recheck is set by RecheckChanges to tell whether the change persisted
+	// when the file was hashed again
+	Recheck RecheckResult
+
+	// Acknowledged is set by MarkAcknowledged when the change leaves the file
+	// in a state that was reviewed as expected
+	Acknowledged bool
+}
+
+// ChangedPercent returns the estimated percentage of a modified file that
+// changed, and false when no chunk-level estimate is available
+func (fc FileChange) ChangedPercent() (float64, bool) {
+	if fc.TotalChunks == 0 {
+		return 0, false
+	}
+	return 100 * float64(fc.ChangedChunks) / float64(fc.TotalChunks), true
+}
+
+// SizeChange classifies how the size of a modified file changed
+type SizeChange int
+
+const (
+	SizeUnchanged SizeChange = iota
+	SizeGrew
+	SizeShrank
+	SizeTruncated // shrank to zero bytes
+)
+
+// SizeChange classifies the change in size between the old and new file
+func (fc FileChange) SizeChange() SizeChange {
+	switch {
+	case fc.NewSize == fc.OldSize:
+		return SizeUnchanged
+	case fc.NewSize == 0:
+		return SizeTruncated
+	case fc.NewSize > fc.OldSize:
+		return SizeGrew
+	default:
+		return SizeShrank
+	}
 }
 
 // ChangeReport contains all changes detected between two states
@@ -36,6 +105,63 @@ type ChangeReport struct {
 	OldRootHash  []byte
 	NewRootHash  []byte
 	Changes      []FileChange
+	Errors       []FileError // files that could not be hashed, so were not compared
+	BytesAdded   int64       // bytes gained across added files and growing modified files
+	BytesRemoved int64       // bytes lost across deleted files and shrinking modified files
+	Stats        DiffStats
+}
+
+// FileError is a file that could not be hashed in one or both of two compared
+// states, so whether it changed is unknown
+type FileError struct {
+	FileName string
+	OldError string // why the file could not be hashed in the old state; empty if it was
+	NewError string // why the file could not be hashed in the new state; empty if it was
+}
+
+// Reason returns why the file could not be hashed, naming the old state when
+// only that one failed
+func (e FileError) Reason() string {
+	if e.NewError != "" {
+		return e.NewError
+	}
+	return e.OldError + " (in the old state)"
+}
+
+// fileErrors lists the files that could not be hashed in either of two
+// states, given by path and reason, in path order; nil if there are none
+func fileErrors(oldErrors, newErrors map[string]string) []FileError {
+	var errs []FileError
+	for fileName, reason := range oldErrors {
+		errs = append(errs, FileError{FileName: fileName, OldError: reason, NewError: newErrors[fileName]})
+	}
+	for fileName, reason := range newErrors {
+		if _, ok := oldErrors[fileName]; !ok {
+			errs = append(errs, FileError{FileName: fileName, NewError: reason})
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].FileName < errs[j].FileName
+	})
+	return errs
+}
+
+// NetBytes returns the overall change in size described by the report
+func (r *ChangeReport) NetBytes() int64 {
+	return r.BytesAdded - r.BytesRemoved
+}
+
+// tallySizes sums the size impact of the changes into BytesAdded and
+// BytesRemoved and aggregates them into Stats
+func (r *ChangeReport) tallySizes() {
+	for _, change := range r.Changes {
+		if delta := change.NewSize - change.OldSize; delta > 0 {
+			r.BytesAdded += delta
+		} else {
+			r.BytesRemoved -= delta
+		}
+	}
+	r.Stats = computeDiffStats(r.Changes)
 }
 
 // Client interface for the Merkle tree file change detector
@@ -43,6 +169,9 @@ type Client interface {
 	// CreateSnapshot creates a Merkle tree snapshot of the specified folder
 	CreateSnapshot(folderPath string) (*TreeState, error)
 
+	// CreateSnapshotContext is CreateSnapshot, aborted when ctx is cancelled
+	CreateSnapshotContext(ctx context.Context, folderPath string) (*TreeState, error)
+
 	// SaveSnapshot saves a tree state to storage
 	SaveSnapshot(state *TreeState, folderPath string) error
 
@@ -52,23 +181,152 @@ type Client interface {
 	// FindLatestSnapshot finds the most recent snapshot for a folder
 	FindLatestSnapshot(folderPath string) (string, error)
 
-	// CompareSnapshots compares two tree states and returns a change report
-	CompareSnapshots(oldState, newState *TreeState) *ChangeReport
+	// FindSnapshotAt finds the newest snapshot for a folder taken at or before t
+	FindSnapshotAt(folderPath string, t time.Time) (string, error)
+
+	// ListSnapshots lists the stored snapshots of a folder, oldest first
+	ListSnapshots(folderPath string) ([]string, error)
+
+	// QuerySnapshots lists a page of the stored snapshots of a folder that
+	// match a time range and labels
+	QuerySnapshots(folderPath string, query SnapshotQuery) (SnapshotPage, error)
+
+	// LabelSnapshot attaches labels to a stored snapshot
+	LabelSnapshot(id string, labels ...string) error
+
+	// UnlabelSnapshot removes labels from a stored snapshot
+	UnlabelSnapshot(id string, labels ...string) error
+
+	// SnapshotLabels returns the labels of the stored snapshots by snapshot ID
+	SnapshotLabels() (map[string][]string, error)
+
+	// ResolveSnapshot finds a stored snapshot by path, file name or snapshot ID
+	ResolveSnapshot(id string) (string, error)
+
+	// CompareSnapshots compares two tree states and returns a change report;
+	// it fails if the states were hashed under incompatible schemes.
+	CompareSnapshots(oldState, newState *TreeState) (*ChangeReport, error)
+
+	// CompareScoped compares only the files at or below a path prefix and
+	// skips the comparison when the subtree hashes match
+	CompareScoped(oldState, newState *TreeState, prefix string) (*ChangeReport, error)
+
+	// CompareSnapshotFiles loads and compares two stored snapshots, serving
+	// repeated comparisons from the cache enabled by WithComparisonCache
+	CompareSnapshotFiles(oldFile, newFile string) (*ChangeReport, error)
+
+	// CompareFolders scans two folders and diffs them without saving snapshots
+	CompareFolders(pathA, pathB string) (*ChangeReport, error)
 
 	// GetTree returns the Merkle tree for a folder
 	GetTree(folderPath string) (*MerkleTree, error)
+
+	// GetTreeContext is GetTree, aborted when ctx is cancelled
+	GetTreeContext(ctx context.Context, folderPath string) (*MerkleTree, error)
+
+	// Watch sends a change report whenever files in a folder change
+	Watch(ctx context.Context, folderPath string, opts WatchOptions) (<-chan ChangeReport, error)
+
+	// CollectGarbage removes or archives snapshots of folders that are gone or stale
+	CollectGarbage(policy GCPolicy) ([]string, error)
+
+	// PruneSnapshots removes or archives the snapshots of a folder its retention policy does not keep
+	PruneSnapshots(folderPath string, policy PrunePolicy) ([]string, error)
+
+	// MigrateSnapshots rewrites stored snapshots in older formats into the current one
+	MigrateSnapshots(dryRun bool) ([]string, error)
+
+	// Baseline returns the snapshot a folder is verified against, or nil if none
+	Baseline(folderPath string) (*Baseline, error)
+
+	// SetBaseline makes a stored snapshot the baseline of a folder
+	SetBaseline(folderPath, snapshotFile, reason string) error
+
+	// PromoteBaseline promotes a snapshot to baseline if a policy rule matches
+	PromoteBaseline(folderPath string, policy PromotionPolicy) (*Baseline, error)
+
+	// VerifyAll verifies several folders against their baselines at the same time
+	VerifyAll(ctx context.Context, opts VerifyAllOptions) (*VerificationReport, error)
+
+	// RecheckChanges hashes the changed files of a report again after a delay
+	RecheckChanges(folderPath string, report *ChangeReport, delay time.Duration) error
+
+	// SaveNamedBaseline keeps a copy of a snapshot under a stable name
+	SaveNamedBaseline(folderPath, name, snapshotFile string, replace bool) (string, error)
+
+	// NamedBaseline returns the path of a named baseline of a folder
+	NamedBaseline(folderPath, name string) (string, error)
+
+	// NamedBaselines lists the named baselines of a folder by name
+	NamedBaselines(folderPath string) (map[string]string, error)
+
+	// StorageStatus summarizes the monitored folders and health of the storage directory
+	StorageStatus() (*StorageStatus, error)
+
+	// ExportPatch packages the added and modified files of a report into a
+	// patch bundle, checking them against the report's hashes
+	ExportPatch(report *ChangeReport, folderPath, bundlePath string) error
+
+	// RecordChanges appends the changes in a report to the history store
+	RecordChanges(folderPath string, report *ChangeReport) error
+
+	// HistoryForPath returns every recorded change to a file, oldest first
+	HistoryForPath(path string) ([]ChangeEvent, error)
+
+	// HistoryBetween returns every change detected in [from, to), oldest first
+	HistoryBetween(from, to time.Time) ([]ChangeEvent, error)
+
+	// RecordRun appends a run summary to the run log, which is never pruned
+	RecordRun(summary RunSummary) error
+
+	// RunSummaries returns the recorded runs of a folder since a time, oldest first
+	RunSummaries(folderPath string, since time.Time) ([]RunSummary, error)
+
+	// AcknowledgeChanges records changes as reviewed and expected
+	AcknowledgeChanges(folderPath string, changes []FileChange, by, note string) error
+
+	// AcknowledgeFiles acknowledges the current state of files of a folder
+	AcknowledgeFiles(folderPath string, files []string, by, note string) error
+
+	// Acknowledgements returns the acknowledgements of a folder, oldest first
+	Acknowledgements(folderPath string) ([]Acknowledgement, error)
+
+	// MarkAcknowledged flags the changes of a report that were acknowledged
+	MarkAcknowledged(folderPath string, report *ChangeReport) error
+
+	// FileHistory returns the hashes a file had across a folder's snapshots
+	FileHistory(folderPath, path string) ([]HashPeriod, error)
 }
 
 // MerkleClient implements the Client interface
 type MerkleClient struct {
-	storageDir string
+	storageDir    string
+	scan          scanOptions
+	fsSnapshots   SnapshotProvider
+	history       historyIndex
+	sets          map[string]FileSet
+	compatChanges bool
+	noRenames     bool
+	severityRules []SeverityRule
+	comparisons   *comparisonCache // nil unless WithComparisonCache
+	incremental   bool
+	fullRehash    bool
+	format        SnapshotFormat
+	sqlite        *sqliteStore // nil when snapshots are kept in files
+	loadMode      LoadMode
+	imageRef      string
 }
 
 // NewClient creates a new Merkle tree client
-func NewClient(storageDir string) Client {
-	return &MerkleClient{
+func NewClient(storageDir string, opts ...Option) Client {
+	c := &MerkleClient{
 		storageDir: storageDir,
+		scan:       defaultScanOptions(),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 // MerkleNode represents a node in the Merkle tree
@@ -78,98 +336,263 @@ type MerkleNode struct {
 	Right    *MerkleNode
 	IsLeaf   bool
 	FileName string
+	Special  bool   // leaf represents a socket, FIFO or device node
+	Err      string // non-empty when the file could not be hashed
+	Unstable bool   // file changed while it was being hashed
+	Size     int64  // size of the file in bytes
+
+	// Digests holds additional per-file digests keyed by algorithm
+	Digests map[string][]byte
+
+	// Chunks holds content-defined chunk hashes when chunk hashing is enabled
+	Chunks *FileChunks
+
+	// Content tells whether the file looked like text or binary data
+	Content ContentKind
+
+	// Attributes holds the Windows file attributes when HasAttributes is set
+	Attributes    FileAttributes
+	HasAttributes bool
 }
 
 // MerkleTree represents the complete Merkle tree
 type MerkleTree struct {
-	Root *MerkleNode
+	Root   *MerkleNode
+	Scheme HashScheme // how the tree was hashed; the zero scheme means SHA-256 throughout
 }
 
 // TreeState represents a snapshot of the Merkle tree at a point in time
 type TreeState struct {
 	Timestamp  time.Time
 	RootHash   []byte
-	FileHashes map[string][]byte // filename -> hash
+	FileHashes map[string][]byte            // filename -> hash
+	FileSizes  map[string]int64             // filename -> size in bytes
+	Errors     map[string]string            // filename -> reason the file could not be hashed
+	Unstable   map[string]bool              // files that kept changing while being hashed
+	Digests    map[string]map[string][]byte // filename -> algorithm -> extra digest
+	Chunks     map[string]*FileChunks       // filename -> chunk hashes
+	Content    map[string]ContentKind       // filename -> text or binary
+	Attributes map[string]FileAttributes    // filename -> Windows file attributes, if recorded
+	Scheme     HashScheme                   // how the hashes were produced
+	Warnings   []string                     // malformed values skipped by a lenient LoadSnapshot
+
+	leaves *leafCache // stat of the files of an incremental scan, recorded by SaveSnapshot
 }
 
 // CreateSnapshot creates a Merkle tree snapshot of the specified folder
 func (c *MerkleClient) CreateSnapshot(folderPath string) (*TreeState, error) {
-	tree, err := c.GetTree(folderPath)
-	if err != nil {
-		return nil, err
-	}
+	return c.createSnapshot(folderPath, c.scan)
+}
 
+// stateFromTree records the leaves of a freshly built tree as a tree state
+func (c *MerkleClient) stateFromTree(tree *MerkleTree) *TreeState {
 	state := &TreeState{
 		Timestamp:  time.Now(),
 		RootHash:   tree.Root.Hash,
 		FileHashes: make(map[string][]byte),
+		FileSizes:  make(map[string]int64),
+		Errors:     make(map[string]string),
+		Unstable:   make(map[string]bool),
+		Digests:    make(map[string]map[string][]byte),
+		Chunks:     make(map[string]*FileChunks),
+		Content:    make(map[string]ContentKind),
+		Attributes: make(map[string]FileAttributes),
+		Scheme:     c.scan.scheme(),
 	}
 
-	collectFileHashes(tree.Root, state.FileHashes)
-	return state, nil
+	collectFileHashes(tree, state)
+	return state
 }
 
 // GetTree returns the Merkle tree for a folder
 func (c *MerkleClient) GetTree(folderPath string) (*MerkleTree, error) {
-	return createMerkleTreeFromFolder(folderPath)
+	tree, _, err := c.scanTree(folderPath, c.scan)
+	return tree, err
+}
+
+// buildTree scans a folder, file set or image with the given options
+func (c *MerkleClient) buildTree(folderPath string, scan scanOptions) (tree *MerkleTree, err error) {
+	if IsSetPath(folderPath) {
+		return c.setTree(folderPath, scan)
+	}
+	if IsImagePath(folderPath) {
+		return c.imageTree(folderPath, scan)
+	}
+
+	if c.fsSnapshots == nil {
+		return createMerkleTreeFromFolder(folderPath, scan)
+	}
+
+	// Scan a point-in-time view of the folder and release it afterwards
+	viewPath, release, err := c.fsSnapshots.Create(folderPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if releaseErr := release(); releaseErr != nil && err == nil {
+			tree, err = nil, releaseErr
+		}
+	}()
+
+	return createMerkleTreeFromFolder(viewPath, scan)
 }
 
 // SaveSnapshot saves a tree state to storage
 func (c *MerkleClient) SaveSnapshot(state *TreeState, folderPath string) error {
-	// Create storage directory if it doesn't exist
-	if err := os.MkdirAll(c.storageDir, 0755); err != nil {
+	// Lock the storage directory, creating it if it doesn't exist
+	unlock, err := c.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Remember where this family's snapshots come from for garbage collection
+	if err := c.registerFolder(folderPath); err != nil {
+		return err
+	}
+
+	db, err := c.sqliteDB()
+	if err != nil {
+		return err
+	}
+	stamp := state.Timestamp.Format(snapshotStampLayout)
+	if db != nil {
+		err = saveSQLiteSnapshot(db, state, filepath.Base(folderPath), stamp)
+	} else {
+		// Generate filename with timestamp
+		filename := fmt.Sprintf("%s/state_%s_%s%s", c.storageDir,
+			filepath.Base(folderPath), stamp, c.format.extension())
+		err = writeSnapshotFile(filename, state, c.format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := c.appendStats(folderPath, state); err != nil {
 		return err
 	}
 
-	// Generate filename with timestamp
-	filename := fmt.Sprintf("%s/state_%s_%s.csv", c.storageDir,
-		filepath.Base(folderPath),
-		state.Timestamp.Format("20060102_150405"))
+	// Let the next incremental scan skip the files that stay unchanged
+	if state.leaves != nil {
+		scan := c.scan
+		scan.cache = state.leaves
+		return c.saveStatCache(folderPath, state, scan)
+	}
+	return nil
+}
 
-	// Create CSV file
-	file, err := os.Create(filename)
+// writeSnapshotFile streams a snapshot into a temporary file and moves it into
+// place once complete, so an interrupted save never leaves a truncated snapshot
+func writeSnapshotFile(filename string, state *TreeState, format SnapshotFormat) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), ".state-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(file.Name())
 	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	// CreateTemp makes the file private; keep snapshots readable like before
+	if err := file.Chmod(0644); err != nil {
+		return err
+	}
+
+	if err := writeSnapshot(file, state, format); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// writeSnapshot encodes a tree state in the given format
+func writeSnapshot(w io.Writer, state *TreeState, format SnapshotFormat) error {
+	switch format {
+	case FormatBinary:
+		return writeBinarySnapshot(w, state)
+	case FormatJSON:
+		return writeJSONSnapshot(w, state)
+	}
+	return writeCSVSnapshot(w, state)
+}
+
+// snapshotHeader is the header row of the current CSV snapshot layout
+var snapshotHeader = []string{"timestamp", "root_hash", "file_path", "file_hash", "status", "digests", "file_size", "chunks", "content", "scheme", "attributes"}
+
+// writeCSVSnapshot encodes a tree state in the current CSV layout
+func writeCSVSnapshot(w io.Writer, state *TreeState) error {
+	writer := csv.NewWriter(w)
 
 	// Write header
-	header := []string{"timestamp", "root_hash", "file_path", "file_hash"}
-	if err := writer.Write(header); err != nil {
+	if err := writer.Write(snapshotHeader); err != nil {
 		return err
 	}
 
-	// Write data rows
+	// Rows are written one at a time in path order; only the sorted list of
+	// paths is held besides the state itself
+	paths := sortedPaths(state)
+
 	timestampStr := state.Timestamp.Format(time.RFC3339)
 	rootHashStr := hex.EncodeToString(state.RootHash)
+	schemeStr := state.Scheme.String()
 
-	for fileName, hash := range state.FileHashes {
-		row := []string{
-			timestampStr,
-			rootHashStr,
-			fileName,
-			hex.EncodeToString(hash),
+	row := make([]string, len(snapshotHeader))
+	for _, fileName := range paths {
+		row[0], row[1], row[2], row[9] = timestampStr, rootHashStr, fileName, schemeStr
+
+		if reason, failed := state.Errors[fileName]; failed {
+			row[3], row[4], row[5], row[6], row[7], row[8], row[10] = "", "error: "+reason, "", "", "", "", ""
+		} else {
+			status := ""
+			if state.Unstable[fileName] {
+				status = "unstable"
+			}
+			row[3] = hex.EncodeToString(state.FileHashes[fileName])
+			row[4] = status
+			row[5] = formatDigests(state.Digests[fileName])
+			row[6] = strconv.FormatInt(state.FileSizes[fileName], 10)
+			row[7] = formatChunks(state.Chunks[fileName])
+			row[8] = formatContentKind(state.Content[fileName])
+			row[10] = formatAttributes(state.Attributes, fileName)
 		}
+
 		if err := writer.Write(row); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	writer.Flush()
+	return writer.Error()
 }
 
-// LoadSnapshot loads a specific snapshot from storage
+// LoadSnapshot loads a specific snapshot from storage. The format (CSV, JSON or
+// binary, optionally gzip-compressed) is detected from the file's content, and
+// snapshots in an SQLite database are loaded from it. Malformed values are
+// handled according to the client's LoadMode.
 func (c *MerkleClient) LoadSnapshot(filename string) (*TreeState, error) {
+	if _, _, ok := splitSQLiteRef(filename); ok {
+		return c.loadSQLiteRef(filename, c.loadMode)
+	}
+
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
+	return decodeSnapshot(file, c.loadMode)
+}
+
+// decodeCSVSnapshot reads a snapshot in the CSV format written by SaveSnapshot.
+// It also accepts every older CSV layout: columns were only ever appended to the
+// original timestamp,root_hash,file_path,file_hash layout, so they are read by
+// position and missing trailing columns are left at their zero values. The
+// timestamp and root hash are repeated on every row; the first row wins.
+// Snapshots with the scheme column are the current layout for LoadAuto; the
+// attributes column that follows it is only filled in on Windows.
+func decodeCSVSnapshot(r io.Reader, mode LoadMode) (*TreeState, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true // everything kept is copied out by the interner
 
 	// Read header
 	header, err := reader.Read()
@@ -179,6 +602,9 @@ func (c *MerkleClient) LoadSnapshot(filename string) (*TreeState, error) {
 
 	// Validate header
 	expectedHeader := []string{"timestamp", "root_hash", "file_path", "file_hash"}
+	if len(header) < len(expectedHeader) {
+		return nil, fmt.Errorf("invalid CSV header")
+	}
 	for i, h := range expectedHeader {
 		if header[i] != h {
 			return nil, fmt.Errorf("invalid CSV header")
@@ -187,9 +613,18 @@ func (c *MerkleClient) LoadSnapshot(filename string) (*TreeState, error) {
 
 	state := &TreeState{
 		FileHashes: make(map[string][]byte),
+		FileSizes:  make(map[string]int64),
+		Errors:     make(map[string]string),
+		Unstable:   make(map[string]bool),
+		Digests:    make(map[string]map[string][]byte),
+		Chunks:     make(map[string]*FileChunks),
+		Content:    make(map[string]ContentKind),
+		Attributes: make(map[string]FileAttributes),
 	}
 
 	// Read data rows
+	in := newSnapshotInterner()
+	rc := &rowChecker{strict: mode.strict(len(header) > 9)}
 	for {
 		row, err := reader.Read()
 		if err == io.EOF {
@@ -198,30 +633,114 @@ func (c *MerkleClient) LoadSnapshot(filename string) (*TreeState, error) {
 		if err != nil {
 			return nil, err
 		}
+		rc.row++
+		path := in.path(row[2])
+
+		// The timestamp and root hash are taken from the first row
+		if rc.row == 1 {
+			if state.Timestamp, err = time.Parse(time.RFC3339, row[0]); err != nil {
+				if err := rc.check("timestamp", err); err != nil {
+					return nil, err
+				}
+			}
+			if state.RootHash, err = hex.DecodeString(row[1]); err != nil {
+				state.RootHash = nil
+				if err := rc.check("root_hash", err); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		// Snapshots without a scheme column predate it and use the legacy scheme
+		if len(row) > 9 && state.Scheme == (HashScheme{}) {
+			if state.Scheme, err = parseHashScheme(row[9]); err != nil {
+				return nil, err
+			}
+		}
 
-		// Parse timestamp
-		if state.Timestamp.IsZero() {
-			state.Timestamp, _ = time.Parse(time.RFC3339, row[0])
+		// Errored entries carry no hash
+		if len(row) > 4 && strings.HasPrefix(row[4], "error: ") {
+			state.Errors[path] = in.string(strings.TrimPrefix(row[4], "error: "))
+			continue
 		}
 
-		// Parse root hash
-		if state.RootHash == nil {
-			state.RootHash, _ = hex.DecodeString(row[1])
+		if len(row) > 4 {
+			if err := rc.check("status", checkStatus(row[4])); err != nil {
+				return nil, err
+			}
+			if row[4] == "unstable" {
+				state.Unstable[path] = true
+			}
+		}
+		if len(row) > 5 {
+			digests, err := in.digests(row[5])
+			if err := rc.check("digests", err); err != nil {
+				return nil, err
+			}
+			if len(digests) > 0 {
+				state.Digests[path] = digests
+			}
+		}
+		if len(row) > 6 {
+			size, err := parseSize(row[6])
+			if err := rc.check("file_size", err); err != nil {
+				return nil, err
+			}
+			if err == nil {
+				state.FileSizes[path] = size
+			}
+		}
+		if len(row) > 7 {
+			chunks, err := parseChunks(row[7])
+			if err := rc.check("chunks", err); err != nil {
+				return nil, err
+			}
+			if chunks != nil {
+				state.Chunks[path] = chunks
+			}
+		}
+		if len(row) > 8 {
+			if err := rc.check("content", checkContent(row[8])); err != nil {
+				return nil, err
+			}
+			if kind := parseContentKind(row[8]); kind != ContentUnknown {
+				state.Content[path] = kind
+			}
+		}
+		if len(row) > 10 && row[10] != "" {
+			attrs, err := parseFileAttributes(row[10])
+			if err := rc.check("attributes", err); err != nil {
+				return nil, err
+			}
+			if err == nil {
+				state.Attributes[path] = attrs
+			}
 		}
 
 		// Parse file hash
-		fileHash, _ := hex.DecodeString(row[3])
-		state.FileHashes[row[2]] = fileHash
+		fileHash, err := in.hexHash(row[3])
+		if err == nil && len(fileHash) == 0 {
+			err = fmt.Errorf("missing hash")
+		}
+		if err := rc.check("file_hash", err); err != nil {
+			return nil, err
+		}
+		state.FileHashes[path] = fileHash
 	}
 
+	state.Warnings = rc.warnings
 	return state, nil
 }
 
-// FindLatestSnapshot finds the most recent snapshot for a folder
+// FindLatestSnapshot finds the most recent snapshot for a folder. With
+// SQLite storage, snapshot files are only searched while the database holds
+// no snapshot of the folder.
 func (c *MerkleClient) FindLatestSnapshot(folderPath string) (string, error) {
 	folderName := filepath.Base(folderPath)
-	pattern := fmt.Sprintf("%s/state_%s_*.csv", c.storageDir, folderName)
-	files, err := filepath.Glob(pattern)
+	if ref, err := c.sqliteSnapshotAt(folderName, ""); ref != "" || err != nil {
+		return ref, err
+	}
+	files, err := c.snapshotFiles(folderName)
 	if err != nil {
 		return "", err
 	}
@@ -230,32 +749,158 @@ func (c *MerkleClient) FindLatestSnapshot(folderPath string) (string, error) {
 		return "", fmt.Errorf("no previous state found for folder: %s", folderName)
 	}
 
-	// Sort files by name (which includes timestamp)
-	sort.Strings(files)
-
 	// Return the most recent file
 	return files[len(files)-1], nil
 }
 
-// CompareSnapshots compares two tree states and returns a change report
-func (c *MerkleClient) CompareSnapshots(oldState, newState *TreeState) *ChangeReport {
+// snapshotFiles lists the snapshot files of a folder name in the storage
+// directory, sorted by name and therefore by time. The glob also matches the
+// files of folders whose name starts with name_, such as app_v2 for app,
+// which are left out as in folderSnapshots.
+func (c *MerkleClient) snapshotFiles(folderName string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(c.storageDir, "state_"+folderName+"_*"))
+	if err != nil {
+		return nil, err
+	}
+	snapshots := files[:0]
+	for _, file := range files {
+		if folder, ok := snapshotFolderName(file); ok && folder == folderName {
+			snapshots = append(snapshots, file)
+		}
+	}
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// FindSnapshotAt finds the newest snapshot for a folder taken at or before t
+func (c *MerkleClient) FindSnapshotAt(folderPath string, t time.Time) (string, error) {
+	folderName := filepath.Base(folderPath)
+	if ref, err := c.sqliteSnapshotAt(folderName, t.In(time.Local).Format(snapshotStampLayout)); ref != "" || err != nil {
+		return ref, err
+	}
+	files, err := c.snapshotFiles(folderName)
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		taken, err := snapshotTime(files[i])
+		if err != nil {
+			continue
+		}
+		if !taken.After(t) {
+			return files[i], nil
+		}
+	}
+
+	return "", fmt.Errorf("no state found for folder %s at or before %s",
+		folderName, t.Format("2006-01-02 15:04:05"))
+}
+
+// ListSnapshots lists the stored snapshots of a folder, oldest first: snapshot
+// files in every format and snapshots in the SQLite database
+func (c *MerkleClient) ListSnapshots(folderPath string) ([]string, error) {
+	return c.folderSnapshots(folderPath)
+}
+
+// ResolveSnapshot finds a stored snapshot from a path to the file, its name in
+// the storage directory, or its ID: the name without compression suffix and
+// extension (state_<folder>_<timestamp>).
+func (c *MerkleClient) ResolveSnapshot(id string) (string, error) {
+	if info, err := os.Stat(id); err == nil && !info.IsDir() {
+		return id, nil
+	}
+	if _, _, ok := splitSQLiteRef(id); ok {
+		return id, c.snapshotExists(id)
+	}
+
+	files, err := c.storedSnapshots("")
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	for _, file := range files {
+		if filepath.Base(file) == id || snapshotStem(file) == id {
+			matches = append(matches, file)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no snapshot found: %s", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("snapshot %s is stored in several formats: %s", id, strings.Join(matches, ", "))
+	}
+}
+
+// snapshotTime extracts the timestamp encoded in a snapshot filename
+func snapshotTime(filename string) (time.Time, error) {
+	const layout = "20060102_150405"
+	name := snapshotStem(filename)
+	if len(name) < len(layout) {
+		return time.Time{}, fmt.Errorf("no timestamp in snapshot filename: %s", filename)
+	}
+	return time.ParseInLocation(layout, name[len(name)-len(layout):], time.Local)
+}
+
+// CompareSnapshots compares two tree states and returns a change report. It
+// refuses to compare states hashed under incompatible schemes, which would
+// otherwise report every file as modified.
+func (c *MerkleClient) CompareSnapshots(oldState, newState *TreeState) (*ChangeReport, error) {
+	if err := oldState.Scheme.Compatible(newState.Scheme); err != nil {
+		return nil, err
+	}
+
 	report := &ChangeReport{
 		OldTimestamp: oldState.Timestamp,
 		NewTimestamp: newState.Timestamp,
 		OldRootHash:  oldState.RootHash,
 		NewRootHash:  newState.RootHash,
 		Changes:      []FileChange{},
+		Errors:       fileErrors(oldState.Errors, newState.Errors),
+	}
+
+	// Files that could not be hashed in either state have no trustworthy
+	// digest to compare against, so they are listed apart from the changes
+	unreadable := func(fileName string) bool {
+		_, oldErr := oldState.Errors[fileName]
+		_, newErr := newState.Errors[fileName]
+		return oldErr || newErr
 	}
 
 	// Find modified files
 	for fileName, newHash := range newState.FileHashes {
 		if oldHash, exists := oldState.FileHashes[fileName]; exists {
 			if !equalHashes(oldHash, newHash) {
-				report.Changes = append(report.Changes, FileChange{
+				change := FileChange{
 					FileName:   fileName,
 					ChangeType: Modified,
 					OldHash:    oldHash,
 					NewHash:    newHash,
+					OldSize:    oldState.FileSizes[fileName],
+					NewSize:    newState.FileSizes[fileName],
+					Content:    newState.Content[fileName],
+				}
+				change.OldAttributes, change.NewAttributes, _ = attributeChange(oldState, newState, fileName)
+				if changed, total, ok := changedChunks(oldState.Chunks[fileName], newState.Chunks[fileName]); ok {
+					change.ChangedChunks = changed
+					change.TotalChunks = total
+				}
+				report.Changes = append(report.Changes, change)
+			} else if oldAttrs, newAttrs, ok := attributeChange(oldState, newState, fileName); ok && oldAttrs != newAttrs {
+				report.Changes = append(report.Changes, FileChange{
+					FileName:      fileName,
+					ChangeType:    AttributesChanged,
+					OldHash:       oldHash,
+					NewHash:       newHash,
+					OldSize:       oldState.FileSizes[fileName],
+					NewSize:       newState.FileSizes[fileName],
+					Content:       newState.Content[fileName],
+					OldAttributes: oldAttrs,
+					NewAttributes: newAttrs,
 				})
 			}
 		}
@@ -263,27 +908,60 @@ func (c *MerkleClient) CompareSnapshots(oldState, newState *TreeState) *ChangeRe
 
 	// Find added files
 	for fileName, hash := range newState.FileHashes {
-		if _, exists := oldState.FileHashes[fileName]; !exists {
+		if _, exists := oldState.FileHashes[fileName]; !exists && !unreadable(fileName) {
 			report.Changes = append(report.Changes, FileChange{
 				FileName:   fileName,
 				ChangeType: Added,
 				NewHash:    hash,
+				NewSize:    newState.FileSizes[fileName],
+				Content:    newState.Content[fileName],
 			})
 		}
 	}
 
 	// Find deleted files
 	for fileName, hash := range oldState.FileHashes {
-		if _, exists := newState.FileHashes[fileName]; !exists {
+		if _, exists := newState.FileHashes[fileName]; !exists && !unreadable(fileName) {
 			report.Changes = append(report.Changes, FileChange{
 				FileName:   fileName,
 				ChangeType: Deleted,
 				OldHash:    hash,
+				OldSize:    oldState.FileSizes[fileName],
+				Content:    oldState.Content[fileName],
 			})
 		}
 	}
 
-	return report
+	// Readme.md -> README.md shows up as a deletion plus an addition, and
+	// so do renames and moves
+	if !c.noRenames || c.scan.foldCase {
+		report.Changes = pairCaseRenames(report.Changes, c.scan.foldCase)
+	}
+	if !c.compatChanges && !c.noRenames {
+		report.Changes = pairMoves(report.Changes)
+	}
+	ClassifyChanges(report, c.severityRules)
+
+	// Maps are walked in random order; reports list changes in path order
+	// so the same two states always give the same report
+	sort.SliceStable(report.Changes, func(i, j int) bool {
+		return report.Changes[i].FileName < report.Changes[j].FileName
+	})
+
+	report.tallySizes()
+
+	return report, nil
+}
+
+// attributeChange returns a file's attributes in both states, and false
+// unless both states recorded them
+func attributeChange(oldState, newState *TreeState, fileName string) (FileAttributes, FileAttributes, bool) {
+	oldAttrs, oldOK := oldState.Attributes[fileName]
+	newAttrs, newOK := newState.Attributes[fileName]
+	if !oldOK || !newOK {
+		return 0, 0, false
+	}
+	return oldAttrs, newAttrs, true
 }
 
 // Helper functions (not exported)
@@ -293,115 +971,373 @@ func hashData(data []byte) []byte {
 	return hash[:]
 }
 
-func hashFile(filePath string) ([]byte, error) {
-	file, err := os.Open(filePath)
+// hashFile hashes the raw bytes of a file. Files are never read in text mode, so
+// line endings are hashed exactly as stored unless a Transformer rewrites them.
+func hashFile(filePath, relPath string, opts scanOptions) (fileDigest, error) {
+	file, err := openRegular(filePath)
 	if err != nil {
-		return nil, err
+		return fileDigest{}, err
 	}
 	defer file.Close()
 
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
-		return nil, err
+	return hashContent(file, filePath, relPath, opts)
+}
+
+// hashContent computes the leaf hash and every other configured digest of a
+// file's content in one read pass. filePath is what the leaf hasher is told.
+func hashContent(r io.Reader, filePath, relPath string, opts scanOptions) (fileDigest, error) {
+	var writers []io.Writer
+	extraHashers := make(map[string]hash.Hash, len(opts.extraDigests))
+	for _, name := range opts.extraDigests {
+		h := hashAlgorithms[name]()
+		extraHashers[name] = h
+		writers = append(writers, h)
 	}
 
-	return hasher.Sum(nil), nil
-}
+	sniffer := &contentSniffer{}
+	writers = append(writers, sniffer)
 
-func buildMerkleTree(nodes []*MerkleNode) *MerkleNode {
-	if len(nodes) == 0 {
-		return nil
+	var chunker *chunkHasher
+	if opts.chunkSize > 0 {
+		chunker = newChunkHasher(opts.chunkSize)
+		writers = append(writers, chunker)
 	}
 
-	if len(nodes) == 1 {
-		return nodes[0]
+	content := r
+	if opts.ctx != nil {
+		content = &contextReader{ctx: opts.ctx, r: content}
+	}
+	for _, transform := range opts.transformsFor(relPath) {
+		content = transform(content)
 	}
 
-	var nextLevel []*MerkleNode
+	// The leaf hasher reads the content while every other digest is fed the
+	// same bytes, so the file is still read only once
+	tee := io.TeeReader(content, io.MultiWriter(writers...))
+	leafHash, err := opts.leafHasher.Hash(filePath, tee)
+	if err != nil {
+		return fileDigest{}, err
+	}
+	if _, err := copyContent(io.Discard, tee); err != nil {
+		return fileDigest{}, err
+	}
 
-	for i := 0; i < len(nodes); i += 2 {
-		var left, right *MerkleNode
-		left = nodes[i]
+	digest := fileDigest{hash: leafHash, kind: sniffer.kind()}
+	if len(extraHashers) > 0 {
+		digest.extra = make(map[string][]byte, len(extraHashers))
+		for name, h := range extraHashers {
+			digest.extra[name] = h.Sum(nil)
+		}
+	}
+	if chunker != nil {
+		digest.chunks = chunker.chunks()
+	}
+	return digest, nil
+}
 
-		if i+1 < len(nodes) {
-			right = nodes[i+1]
-		} else {
-			right = nodes[i]
+// isSpecialFile reports whether mode describes a socket, FIFO, device or other
+// non-regular file that must not be opened for hashing
+func isSpecialFile(mode os.FileMode) bool {
+	return mode&(os.ModeNamedPipe|os.ModeSocket|os.ModeDevice|os.ModeCharDevice|os.ModeIrregular) != 0
+}
+
+// hashSpecialFile derives a stable hash for a special file from its type
+func hashSpecialFile(mode os.FileMode) []byte {
+	return hashData([]byte("special:" + mode.Type().String()))
+}
+
+// errHashTimeout is returned when a file takes longer than the configured deadline to hash
+var errHashTimeout = fmt.Errorf("hashing timed out")
+
+// hashFileWithTimeout hashes a file, giving up after timeout. A read blocked on a
+// dead mount cannot be interrupted, so the abandoned goroutine finishes on its own.
+func hashFileWithTimeout(filePath, relPath string, opts scanOptions) (fileDigest, error) {
+	if opts.hashTimeout <= 0 {
+		return hashFile(filePath, relPath, opts)
+	}
+
+	type result struct {
+		digest fileDigest
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		digest, err := hashFile(filePath, relPath, opts)
+		done <- result{digest, err}
+	}()
+
+	timer := time.NewTimer(opts.hashTimeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.digest, r.err
+	case <-timer.C:
+		return fileDigest{}, errHashTimeout
+	case <-opts.done():
+		return fileDigest{}, opts.canceled()
+	}
+}
+
+// hashStableFile hashes a file and re-reads it while its size or modification
+// time changes underneath the read, up to the configured number of retries.
+// Modification times within the configured slack of each other count as equal.
+// The returned flag reports a file that was still changing after the last attempt.
+func hashStableFile(filePath, relPath string, opts scanOptions) (fileDigest, bool, error) {
+	for attempt := 0; ; attempt++ {
+		before, err := os.Stat(filePath)
+		if err != nil {
+			return fileDigest{}, false, err
 		}
 
-		combinedHash := append(left.Hash, right.Hash...)
-		parentHash := hashData(combinedHash)
+		digest, err := hashFileWithTimeout(filePath, relPath, opts)
+		if err != nil {
+			return fileDigest{}, false, err
+		}
 
-		parent := &MerkleNode{
-			Hash:   parentHash,
-			Left:   left,
-			Right:  right,
-			IsLeaf: false,
+		after, err := os.Stat(filePath)
+		if err != nil {
+			return fileDigest{}, false, err
 		}
 
-		nextLevel = append(nextLevel, parent)
+		digest.size = after.Size()
+		if before.Size() == after.Size() && sameModTime(before.ModTime(), after.ModTime(), opts.mtimeSlack) {
+			return digest, false, nil
+		}
+		if attempt >= opts.stableTries {
+			return digest, true, nil
+		}
 	}
+}
 
-	return buildMerkleTree(nextLevel)
+// fileID identifies the underlying file behind a path
+type fileID struct {
+	dev uint64
+	ino uint64
 }
 
-func createMerkleTreeFromFolder(folderPath string) (*MerkleTree, error) {
-	var leafNodes []*MerkleNode
+func createMerkleTreeFromFolder(folderPath string, opts scanOptions) (*MerkleTree, error) {
+	if err := validateDigests(opts.extraDigests); err != nil {
+		return nil, err
+	}
+
+	// A single regular file is scanned as a one-leaf tree named after the file
+	if info, err := os.Stat(folderPath); err == nil && info.Mode().IsRegular() && opts.fileList == nil {
+		opts.fileList = []string{filepath.Base(folderPath)}
+		folderPath = filepath.Dir(folderPath)
+	}
+
+	// Walk long and oddly named Windows paths without tripping over MAX_PATH
+	if folderPath != "" {
+		extended, err := extendedPath(folderPath)
+		if err != nil {
+			return nil, err
+		}
+		folderPath = extended
+	}
 
-	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
+	// The walk hands the files to hash to workers as it finds them, keeping
+	// only their leaves, which the workers fill in
+	var leafNodes []*MerkleNode
+	var pool *hashPool
+	var aliases []hashJob                     // hardlinks, filled in after the walk
+	firstLeaf := make(map[fileID]*MerkleNode) // the leaf hashing each (device, inode)
+
+	var rootDev uint64
+	var hasRootDev bool
+	if opts.oneFS {
+		rootInfo, err := os.Stat(folderPath)
 		if err != nil {
+			return nil, err
+		}
+		var rootID fileID
+		rootID, hasRootDev = fileIDOf(rootInfo)
+		rootDev = rootID.dev
+	}
+
+	// File sets list their files explicitly and are not filtered
+	var ignored *ignoreMatcher
+	var filtered *statFilter
+	if folderPath != "" {
+		ignored = newIgnoreMatcher(folderPath, opts)
+		var err error
+		if filtered, err = newStatFilter(opts, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	visit := func(path string, info os.FileInfo) error {
+		if err := opts.canceled(); err != nil {
 			return err
 		}
 
+		// Do not cross mount points when restricted to one file system
+		if hasRootDev {
+			if id, ok := fileIDOf(info); ok && id.dev != rootDev {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		relPath := opts.leafOrder.path(canonicalPath(folderPath, path))
+		if !inScope(relPath, opts.scope) {
+			return nil
+		}
+		if isNested(relPath, opts.nested) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if skip, err := ignored.skip(relPath, info.IsDir()); err != nil || skip {
+			if err == nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return err
+		}
 		if !info.IsDir() {
-			fileHash, err := hashFile(path)
-			if err != nil {
+			if skip, err := filtered.skip(info); err != nil || skip {
 				return err
 			}
+		}
 
-			relPath, _ := filepath.Rel(folderPath, path)
+		// A symlink is hashed as its target, which may be a FIFO that
+		// would block the read
+		mode := info.Mode()
+		if mode&os.ModeSymlink != 0 {
+			if target, err := os.Stat(path); err == nil && isSpecialFile(target.Mode()) {
+				mode = target.Mode()
+			}
+		}
+		if isSpecialFile(mode) {
+			if !opts.specialFiles {
+				return nil
+			}
 
-			node := &MerkleNode{
-				Hash:     fileHash,
-				Left:     nil,
-				Right:    nil,
+			leafNodes = append(leafNodes, &MerkleNode{
+				Hash:     hashSpecialFile(mode),
 				IsLeaf:   true,
 				FileName: relPath,
+				Special:  true,
+			})
+			return nil
+		}
+
+		if !info.IsDir() {
+			opts.tracker.found()
+
+			// Files a watcher saw before with the same stat are not read again
+			if node := opts.cache.lookup(relPath, info); node != nil {
+				leafNodes = append(leafNodes, node)
+				opts.tracker.processed(relPath, 0)
+				return nil
 			}
 
-			leafNodes = append(leafNodes, node)
+			job := hashJob{path: path, relPath: relPath, info: info, node: &MerkleNode{IsLeaf: true, FileName: relPath}}
+			leafNodes = append(leafNodes, job.node)
+
+			// Transformed content depends on the path, so it cannot be shared between links
+			if opts.dedupInodes && len(opts.transformsFor(relPath)) == 0 {
+				if id, ok := fileIDOf(info); ok {
+					if first, seen := firstLeaf[id]; seen {
+						job.first = first
+						aliases = append(aliases, job)
+						return nil
+					}
+					firstLeaf[id] = job.node
+				}
+			}
+			return pool.submit(job)
 		}
 
 		return nil
-	})
+	}
+
+	// A scoped scan only walks the directory of its scope
+	walkRoot := folderPath
+	if opts.scope != "" && folderPath != "" {
+		walkRoot = filepath.Join(folderPath, filepath.FromSlash(opts.scope))
+		if _, err := os.Lstat(walkRoot); os.IsNotExist(err) {
+			return nil, fmt.Errorf("path %q not found in folder", opts.scope)
+		}
+	}
 
+	opts.tracker = newProgressTracker(opts.progress)
+	pool = newHashPool(opts)
+	var err error
+	if opts.fileList != nil {
+		err = visitFileList(folderPath, opts.fileList, visit)
+	} else {
+		err = walkFolder(walkRoot, visit)
+	}
+	opts.tracker.walkDone()
+
+	// Wait for the workers even when the walk failed, so none outlives the scan
+	if poolErr := pool.wait(); err == nil {
+		err = poolErr
+	}
+	if err == nil {
+		err = hashAliases(aliases, opts)
+	}
 	if err != nil {
+		opts.cache.discard()
 		return nil, err
 	}
+	opts.cache.commit()
+	opts.tracker.finish()
 
 	if len(leafNodes) == 0 {
 		return nil, fmt.Errorf("no files found in folder")
 	}
 
-	sort.Slice(leafNodes, func(i, j int) bool {
-		return leafNodes[i].FileName < leafNodes[j].FileName
-	})
-
-	root := buildMerkleTree(leafNodes)
+	// Ordering canonical paths independently of locale keeps the tree shape,
+	// and therefore the root hash, identical on every platform
+	sortLeaves(leafNodes, opts.leafOrder)
+	if err := checkNormalizedLeaves(leafNodes, opts.leafOrder); err != nil {
+		return nil, err
+	}
 
-	return &MerkleTree{Root: root}, nil
+	return newMerkleTree(leafNodes, opts.scheme())
 }
 
-func collectFileHashes(node *MerkleNode, fileHashes map[string][]byte) {
-	if node == nil {
-		return
+// canonicalPath returns the path of a file relative to the scanned folder using
+// forward slashes, so snapshots taken on Windows, macOS and Linux agree
+func canonicalPath(folderPath, path string) string {
+	// File sets are scanned without a common folder and keep absolute paths
+	if folderPath == "" {
+		return filepath.ToSlash(path)
 	}
+	relPath, _ := filepath.Rel(folderPath, path)
+	return filepath.ToSlash(relPath)
+}
 
-	if node.IsLeaf {
-		fileHashes[node.FileName] = node.Hash
-	} else {
-		collectFileHashes(node.Left, fileHashes)
-		collectFileHashes(node.Right, fileHashes)
+func collectFileHashes(tree *MerkleTree, state *TreeState) {
+	for it := tree.Leaves(); it.Next(); {
+		node := it.Node()
+		if node.Err != "" {
+			state.Errors[node.FileName] = node.Err
+			continue
+		}
+
+		state.FileHashes[node.FileName] = node.Hash
+		state.FileSizes[node.FileName] = node.Size
+		if node.Unstable {
+			state.Unstable[node.FileName] = true
+		}
+		if len(node.Digests) > 0 {
+			state.Digests[node.FileName] = node.Digests
+		}
+		if node.Chunks != nil {
+			state.Chunks[node.FileName] = node.Chunks
+		}
+		if node.Content != ContentUnknown {
+			state.Content[node.FileName] = node.Content
+		}
+		if node.HasAttributes {
+			state.Attributes[node.FileName] = node.Attributes
+		}
 	}
 }
 
@@ -0,0 +1,180 @@
+package merkle
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// WithComparisonCache makes CompareSnapshotFiles keep the reports of the last
+// entries snapshot pairs it compared, keyed by the digests of both snapshot
+// files, so repeated queries of the same historical diff are served without
+// loading and comparing the snapshots again. Snapshots stored with
+// WithSQLiteStorage are not cached.
+func WithComparisonCache(entries int) Option {
+	return func(c *MerkleClient) {
+		if entries > 0 {
+			c.comparisons = &comparisonCache{
+				max:     entries,
+				entries: make(map[string]*list.Element),
+				order:   list.New(),
+				digests: make(map[string]snapshotDigest),
+			}
+		}
+	}
+}
+
+// CompareSnapshotFiles loads two stored snapshots and compares them as
+// CompareSnapshots does. With WithComparisonCache, a pair compared before is
+// answered from the cache as long as neither file changed since.
+func (c *MerkleClient) CompareSnapshotFiles(oldFile, newFile string) (*ChangeReport, error) {
+	key, cacheable := "", false
+	if c.comparisons != nil {
+		key, cacheable = c.comparisons.key(oldFile, newFile)
+		if cacheable {
+			if report, ok := c.comparisons.get(key); ok {
+				return report, nil
+			}
+		}
+	}
+
+	oldState, err := c.LoadSnapshot(oldFile)
+	if err != nil {
+		return nil, err
+	}
+	newState, err := c.LoadSnapshot(newFile)
+	if err != nil {
+		return nil, err
+	}
+	report, err := c.CompareSnapshots(oldState, newState)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheable {
+		c.comparisons.put(key, report)
+	}
+	return report, nil
+}
+
+// comparisonCache is a least recently used cache of change reports
+type comparisonCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element // key -> element of order
+	order   *list.List               // *cachedReport, most recently used first
+
+	// digests remembers the digest of every snapshot file seen, so a cached
+	// comparison costs two stats; snapshots are never rewritten in place
+	digests map[string]snapshotDigest
+}
+
+// errSQLiteUncached leaves comparisons of snapshots in SQLite storage uncached
+var errSQLiteUncached = errors.New("snapshots in SQLite storage are not cached")
+
+type cachedReport struct {
+	key    string
+	report *ChangeReport
+}
+
+// snapshotDigest is the SHA-256 of a snapshot file as of its size and modification time
+type snapshotDigest struct {
+	size    int64
+	modTime time.Time
+	sum     []byte
+}
+
+// key identifies the comparison of two snapshot files by their digests. It
+// returns false when either file cannot be digested, which leaves the
+// comparison uncached.
+func (cc *comparisonCache) key(oldFile, newFile string) (string, bool) {
+	oldSum, err := cc.digest(oldFile)
+	if err != nil {
+		return "", false
+	}
+	newSum, err := cc.digest(newFile)
+	if err != nil {
+		return "", false
+	}
+	return hex.EncodeToString(oldSum) + ":" + hex.EncodeToString(newSum), true
+}
+
+// digest returns the SHA-256 of a snapshot file, hashing it only when it is
+// new or changed
+func (cc *comparisonCache) digest(filename string) ([]byte, error) {
+	if _, _, ok := splitSQLiteRef(filename); ok {
+		return nil, errSQLiteUncached
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.mu.Lock()
+	known, ok := cc.digests[filename]
+	cc.mu.Unlock()
+	if ok && known.size == info.Size() && known.modTime.Equal(info.ModTime()) {
+		return known.sum, nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, err
+	}
+	sum := hasher.Sum(nil)
+
+	cc.mu.Lock()
+	cc.digests[filename] = snapshotDigest{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	cc.mu.Unlock()
+	return sum, nil
+}
+
+// get returns a copy of the report cached under key
+func (cc *comparisonCache) get(key string) (*ChangeReport, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	element, ok := cc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	cc.order.MoveToFront(element)
+	return copyReport(element.Value.(*cachedReport).report), true
+}
+
+// put caches a copy of a report, evicting the least recently used one when
+// the cache is full
+func (cc *comparisonCache) put(key string, report *ChangeReport) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if element, ok := cc.entries[key]; ok {
+		element.Value.(*cachedReport).report = copyReport(report)
+		cc.order.MoveToFront(element)
+		return
+	}
+	cc.entries[key] = cc.order.PushFront(&cachedReport{key: key, report: copyReport(report)})
+	for cc.order.Len() > cc.max {
+		oldest := cc.order.Back()
+		cc.order.Remove(oldest)
+		delete(cc.entries, oldest.Value.(*cachedReport).key)
+	}
+}
+
+// copyReport copies a report deeply enough that callers setting fields of
+// its changes, such as MarkAcknowledged, leave the cached report alone
+func copyReport(report *ChangeReport) *ChangeReport {
+	copied := *report
+	copied.Changes = append([]FileChange{}, report.Changes...)
+	return &copied
+}
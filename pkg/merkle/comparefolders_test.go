@@ -0,0 +1,109 @@
+package merkle
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Folders over the same paths are compared by walking both trees side by
+// side, which skips identical subtrees and gives the CompareSnapshots report
+func TestCompareFoldersPrunesUnchangedSubtrees(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	files := map[string]string{"a/1.txt": "one", "a/2.txt": "two", "b/1.txt": "three", "b/2.txt": "four"}
+	writeFiles(t, a, files)
+	writeFiles(t, b, files)
+	writeFiles(t, b, map[string]string{"b/1.txt": "three, edited"})
+
+	client := NewClient(t.TempDir()).(*MerkleClient)
+	oldTree, err := client.GetTree(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTree, err := client.GetTree(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := client.CompareSnapshots(client.stateFromTree(oldTree), client.stateFromTree(newTree))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aligned := client.diffAlignedTrees(oldTree, newTree)
+	if aligned == nil {
+		t.Fatal("trees over the same paths were not walked side by side")
+	}
+	if !reflect.DeepEqual(aligned.Changes, full.Changes) || aligned.MaxSeverity() != full.MaxSeverity() {
+		t.Fatalf("aligned changes = %+v, want %+v", aligned.Changes, full.Changes)
+	}
+	if len(aligned.Changes) != 1 || aligned.Changes[0].FileName != "b/1.txt" || aligned.Changes[0].ChangeType != Modified {
+		t.Fatalf("changes = %+v, want b/1.txt modified", aligned.Changes)
+	}
+
+	// A leaf hash the walk would have to visit to notice: its subtree hash is
+	// untouched, so it is only reported if the subtree is not pruned
+	leaves := treeLeaves(newTree)
+	if leaves[0].FileName != "a/1.txt" {
+		t.Fatalf("first leaf is %s, want a/1.txt", leaves[0].FileName)
+	}
+	leaves[0].Hash = []byte("not the content hash")
+	if report := client.diffAlignedTrees(oldTree, newTree); len(report.Changes) != 1 {
+		t.Errorf("changes = %+v, want the unchanged subtree to be skipped", report.Changes)
+	}
+
+	report, err := client.CompareFolders(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Changes, full.Changes) {
+		t.Errorf("CompareFolders changes = %+v, want %+v", report.Changes, full.Changes)
+	}
+
+	// Different paths fall back to CompareSnapshots
+	writeFiles(t, b, map[string]string{"c.txt": "new"})
+	if newTree, err = client.GetTree(b); err != nil {
+		t.Fatal(err)
+	}
+	if client.diffAlignedTrees(oldTree, newTree) != nil {
+		t.Error("trees over different paths were walked side by side")
+	}
+	if report, err = client.CompareFolders(a, b); err != nil || len(report.Changes) != 2 {
+		t.Errorf("CompareFolders changes = %+v (%v), want b/1.txt and c.txt", report.Changes, err)
+	}
+}
+
+// Without rename detection, renames, moves and case renames are reported as
+// deletions plus additions
+func TestRenameDetectionCanBeDisabled(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	writeFiles(t, a, map[string]string{"Readme.md": "doc", "a.txt": "rename me", "src/b.txt": "move me"})
+	writeFiles(t, b, map[string]string{"README.md": "doc", "c.txt": "rename me", "lib/b.txt": "move me"})
+
+	count := func(report *ChangeReport) map[ChangeType]int {
+		counts := make(map[ChangeType]int)
+		for _, change := range report.Changes {
+			counts[change.ChangeType]++
+		}
+		return counts
+	}
+
+	report, err := NewClient(t.TempDir()).CompareFolders(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count(report), (map[ChangeType]int{CaseRenamed: 1, Renamed: 1, Moved: 1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("with rename detection: %v, want %v", got, want)
+	}
+
+	report, err = NewClient(t.TempDir(), WithRenameDetection(false)).CompareFolders(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count(report), (map[ChangeType]int{Deleted: 3, Added: 3}); !reflect.DeepEqual(got, want) {
+		t.Errorf("without rename detection: %v, want %v", got, want)
+	}
+	for _, change := range report.Changes {
+		if change.OldPath != "" || change.NewPath != "" {
+			t.Errorf("%s: paired with %q -> %q", change.FileName, change.OldPath, change.NewPath)
+		}
+	}
+}
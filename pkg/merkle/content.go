@@ -0,0 +1,77 @@
+package merkle
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// ContentKind tells whether a file holds text or binary data
+type ContentKind int
+
+const (
+	ContentUnknown ContentKind = iota
+	ContentText
+	ContentBinary
+)
+
+// sniffLen is how many leading bytes of a file are inspected to classify it
+const sniffLen = 8000
+
+// contentSniffer is an io.Writer that keeps the first sniffLen bytes written to it
+type contentSniffer struct {
+	head []byte
+}
+
+func (s *contentSniffer) Write(p []byte) (int, error) {
+	if room := sniffLen - len(s.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		s.head = append(s.head, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// kind classifies the sniffed content: NUL bytes or invalid UTF-8 mean binary
+func (s *contentSniffer) kind() ContentKind {
+	head := s.head
+	if bytes.IndexByte(head, 0) >= 0 {
+		return ContentBinary
+	}
+
+	// The sniff window may end in the middle of a multi-byte character
+	if len(head) == sniffLen {
+		for trimmed := 0; trimmed < utf8.UTFMax-1 && !utf8.Valid(head); trimmed++ {
+			head = head[:len(head)-1]
+		}
+	}
+
+	if !utf8.Valid(head) {
+		return ContentBinary
+	}
+	return ContentText
+}
+
+// formatContentKind encodes a content kind for snapshot storage
+func formatContentKind(kind ContentKind) string {
+	switch kind {
+	case ContentText:
+		return "text"
+	case ContentBinary:
+		return "binary"
+	default:
+		return ""
+	}
+}
+
+// parseContentKind decodes the output of formatContentKind
+func parseContentKind(s string) ContentKind {
+	switch s {
+	case "text":
+		return ContentText
+	case "binary":
+		return ContentBinary
+	default:
+		return ContentUnknown
+	}
+}
@@ -0,0 +1,55 @@
+package merkle
+
+import (
+	"context"
+	"io"
+)
+
+// CreateSnapshotContext is CreateSnapshot with a context. Cancelling ctx, or
+// reaching its deadline, stops the walk and every file being hashed at their
+// next read, and the error of ctx is returned; nothing is recorded for the
+// files hashed so far.
+func (c *MerkleClient) CreateSnapshotContext(ctx context.Context, folderPath string) (*TreeState, error) {
+	scan := c.scan
+	scan.ctx = ctx
+	return c.createSnapshot(folderPath, scan)
+}
+
+// GetTreeContext is GetTree with a context; see CreateSnapshotContext
+func (c *MerkleClient) GetTreeContext(ctx context.Context, folderPath string) (*MerkleTree, error) {
+	scan := c.scan
+	scan.ctx = ctx
+	tree, _, err := c.scanTree(folderPath, scan)
+	return tree, err
+}
+
+// done returns the channel closed when the scan is cancelled, or nil, which
+// blocks forever, when it cannot be
+func (opts scanOptions) done() <-chan struct{} {
+	if opts.ctx == nil {
+		return nil
+	}
+	return opts.ctx.Done()
+}
+
+// canceled returns the error of the scan's context once it is cancelled
+func (opts scanOptions) canceled() error {
+	if opts.ctx == nil {
+		return nil
+	}
+	return opts.ctx.Err()
+}
+
+// contextReader fails reads once its context is cancelled, so hashing a large
+// file stops within one read
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
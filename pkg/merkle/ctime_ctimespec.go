@@ -0,0 +1,13 @@
+//go:build darwin || freebsd || netbsd
+
+package merkle
+
+import (
+	"syscall"
+	"time"
+)
+
+// statChangeTime returns the inode change time (ctime)
+func statChangeTime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(stat.Ctimespec.Unix())
+}
@@ -0,0 +1,14 @@
+//go:build unix && !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package merkle
+
+import (
+	"syscall"
+	"time"
+)
+
+// statChangeTime reports no change time where stat_t does not expose it
+// portably
+func statChangeTime(stat *syscall.Stat_t) time.Time {
+	return time.Time{}
+}
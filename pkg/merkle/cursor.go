@@ -0,0 +1,64 @@
+package merkle
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ReportCursor remembers, per folder, the timestamp of the newest snapshot
+// whose changes were successfully reported. Keeping it on disk lets a restarted
+// monitor retry a notification that failed or was interrupted from the last
+// reported snapshot, instead of losing that window or reporting it twice.
+type ReportCursor struct {
+	Path string // JSON file holding folder name -> timestamp
+}
+
+// Reported returns the timestamp of the last reported snapshot of a folder, or
+// the zero time if nothing has been reported yet
+func (rc ReportCursor) Reported(folderPath string) (time.Time, error) {
+	cursors, err := rc.load()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cursors[filepath.Base(folderPath)], nil
+}
+
+// Advance records that the changes up to the snapshot taken at t were reported
+func (rc ReportCursor) Advance(folderPath string, t time.Time) error {
+	cursors, err := rc.load()
+	if err != nil {
+		return err
+	}
+	cursors[filepath.Base(folderPath)] = t
+
+	data, err := json.Marshal(cursors)
+	if err != nil {
+		return err
+	}
+
+	// Replace the file atomically so a crash never leaves a truncated cursor
+	tmp := rc.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rc.Path)
+}
+
+// load reads the cursor file, which may not exist yet
+func (rc ReportCursor) load() (map[string]time.Time, error) {
+	cursors := make(map[string]time.Time)
+	data, err := os.ReadFile(rc.Path)
+	if os.IsNotExist(err) {
+		return cursors, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cursors); err != nil {
+		return nil, fmt.Errorf("invalid report cursor: %v", err)
+	}
+	return cursors, nil
+}
@@ -0,0 +1,181 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"path"
+	"time"
+)
+
+// DiffStats aggregates the magnitude of the changes in a report, so drift can
+// be charted by size rather than only by number of files
+type DiffStats struct {
+	AddedBytes    int64 `json:"added_bytes"`    // total size of added files
+	RemovedBytes  int64 `json:"removed_bytes"`  // total size of deleted files
+	ModifiedBytes int64 `json:"modified_bytes"` // total new size of modified files
+
+	// LargestFile is the biggest file among the changes, by its larger size
+	// before or after the change; empty when there are no changes
+	LargestFile      string `json:"largest_file,omitempty"`
+	LargestFileBytes int64  `json:"largest_file_bytes"`
+
+	// BusiestDir is the directory holding the most changed files ("." for the
+	// top level), the first in path order on a tie
+	BusiestDir        string `json:"busiest_dir,omitempty"`
+	BusiestDirChanges int    `json:"busiest_dir_changes"`
+}
+
+// computeDiffStats aggregates the changes of a report
+func computeDiffStats(changes []FileChange) DiffStats {
+	var stats DiffStats
+	perDir := make(map[string]int)
+	for _, change := range changes {
+		switch change.ChangeType {
+		case Added:
+			stats.AddedBytes += change.NewSize
+		case Deleted:
+			stats.RemovedBytes += change.OldSize
+		case Modified:
+			stats.ModifiedBytes += change.NewSize
+		}
+
+		size := change.NewSize
+		if change.OldSize > size {
+			size = change.OldSize
+		}
+		if stats.LargestFile == "" || size > stats.LargestFileBytes {
+			stats.LargestFile, stats.LargestFileBytes = change.FileName, size
+		}
+
+		perDir[path.Dir(change.FileName)]++
+	}
+
+	for dir, count := range perDir {
+		if count > stats.BusiestDirChanges || (count == stats.BusiestDirChanges && dir < stats.BusiestDir) {
+			stats.BusiestDir, stats.BusiestDirChanges = dir, count
+		}
+	}
+	return stats
+}
+
+// jsonReport is the JSON form of a change report
+type jsonReport struct {
+	OldTimestamp time.Time       `json:"old_timestamp"`
+	NewTimestamp time.Time       `json:"new_timestamp"`
+	OldRootHash  string          `json:"old_root_hash"`
+	NewRootHash  string          `json:"new_root_hash"`
+	MaxSeverity  string          `json:"max_severity" enum:"max_severity"`
+	Changes      []jsonChange    `json:"changes"`
+	Errors       []jsonFileError `json:"errors"`
+	BytesAdded   int64           `json:"bytes_added"`
+	BytesRemoved int64           `json:"bytes_removed"`
+	Stats        DiffStats       `json:"stats"`
+}
+
+// jsonFileError is the JSON form of a file that could not be hashed
+type jsonFileError struct {
+	FileName string `json:"file_path"`
+	OldError string `json:"old_error,omitempty"`
+	NewError string `json:"new_error,omitempty"`
+}
+
+// jsonChange is the JSON form of one change
+type jsonChange struct {
+	FileName     string `json:"file_path"`
+	OldPath      string `json:"old_path,omitempty"`
+	NewPath      string `json:"new_path,omitempty"`
+	ChangeType   string `json:"change_type" enum:"change_type"`
+	Severity     string `json:"severity" enum:"severity"`
+	Acknowledged bool   `json:"acknowledged"`
+	OldHash      string `json:"old_hash,omitempty"`
+	NewHash      string `json:"new_hash,omitempty"`
+	OldSize      int64  `json:"old_size"`
+	NewSize      int64  `json:"new_size"`
+	Content      string `json:"content" enum:"content"`
+	Recheck      string `json:"recheck,omitempty" enum:"recheck"`
+}
+
+// WriteJSONReport writes a change report, including its DiffStats, as one
+// indented JSON object for dashboards and other tools
+func WriteJSONReport(w io.Writer, report *ChangeReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(newJSONReport(report))
+}
+
+// MarshalJSON encodes a change report as WriteJSONReport writes it, so reports
+// can be embedded in other JSON documents
+func (r *ChangeReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newJSONReport(r))
+}
+
+// newJSONReport converts a change report to its JSON form
+func newJSONReport(report *ChangeReport) jsonReport {
+	out := jsonReport{
+		OldTimestamp: report.OldTimestamp,
+		NewTimestamp: report.NewTimestamp,
+		OldRootHash:  hex.EncodeToString(report.OldRootHash),
+		NewRootHash:  hex.EncodeToString(report.NewRootHash),
+		MaxSeverity:  maxSeverityName(len(report.Changes), report.MaxSeverity()),
+		Changes:      make([]jsonChange, 0, len(report.Changes)),
+		Errors:       make([]jsonFileError, 0, len(report.Errors)),
+		BytesAdded:   report.BytesAdded,
+		BytesRemoved: report.BytesRemoved,
+		Stats:        report.Stats,
+	}
+	for _, change := range report.Changes {
+		out.Changes = append(out.Changes, newJSONChange(change))
+	}
+	for _, fileErr := range report.Errors {
+		out.Errors = append(out.Errors, jsonFileError(fileErr))
+	}
+	return out
+}
+
+// newJSONChange converts a change to its JSON form
+func newJSONChange(change FileChange) jsonChange {
+	entry := jsonChange{
+		FileName:     change.FileName,
+		OldPath:      change.OldPath,
+		NewPath:      change.NewPath,
+		ChangeType:   GetChangeTypeString(change.ChangeType),
+		Severity:     change.Severity.String(),
+		Acknowledged: change.Acknowledged,
+		OldHash:      hex.EncodeToString(change.OldHash),
+		NewHash:      hex.EncodeToString(change.NewHash),
+		OldSize:      change.OldSize,
+		NewSize:      change.NewSize,
+		Content:      GetContentKindString(change.Content),
+	}
+	if change.Recheck != NotRechecked {
+		entry.Recheck = GetRecheckString(change.Recheck)
+	}
+	return entry
+}
+
+// jsonEvent is the JSON form of a change recorded in the history store
+type jsonEvent struct {
+	Folder     string    `json:"folder"`
+	PreviousAt time.Time `json:"previous_at"`
+	DetectedAt time.Time `json:"detected_at"`
+	jsonChange
+}
+
+// WriteJSONEvents writes change events, as returned by the history queries, as
+// one indented JSON array
+func WriteJSONEvents(w io.Writer, events []ChangeEvent) error {
+	out := make([]jsonEvent, len(events))
+	for i, event := range events {
+		out[i] = jsonEvent{
+			Folder:     event.Folder,
+			PreviousAt: event.PreviousAt,
+			DetectedAt: event.DetectedAt,
+			jsonChange: newJSONChange(event.FileChange),
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
@@ -0,0 +1,233 @@
+package merkle
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DiffTrees compares two Merkle trees directly, for callers that already hold
+// both trees and have no use for snapshots. When both trees cover the same
+// paths, which is the common case of files being edited in place, and have the
+// same tree version, they have the same shape and are walked side by side,
+// skipping every subtree whose hash is unchanged. Otherwise the sorted leaves
+// are merged. Timestamps in the report are left zero, and file attributes are
+// not compared.
+func DiffTrees(a, b *MerkleTree) *ChangeReport {
+	report := &ChangeReport{Changes: []FileChange{}}
+	if a != nil && a.Root != nil {
+		report.OldRootHash = a.Root.Hash
+	}
+	if b != nil && b.Root != nil {
+		report.NewRootHash = b.Root.Hash
+	}
+
+	oldLeaves, newLeaves := treeLeaves(a), treeLeaves(b)
+	report.Errors = fileErrors(leafErrors(oldLeaves), leafErrors(newLeaves))
+
+	if samePaths(oldLeaves, newLeaves) && a.Scheme.TreeVersion == b.Scheme.TreeVersion {
+		// Leaf hashes cover content only, so pruning is sound once the paths match
+		diffAlignedNodes(a.Root, b.Root, report)
+	} else {
+		// The trees may have been built in different leaf orders
+		sortLeaves(oldLeaves, BytewiseOrder)
+		sortLeaves(newLeaves, BytewiseOrder)
+		diffLeaves(oldLeaves, newLeaves, report)
+		report.Changes = pairMoves(pairCaseRenames(report.Changes, false))
+	}
+
+	report.tallySizes()
+	return report
+}
+
+// leafErrors returns why each leaf that could not be hashed failed, by path
+func leafErrors(leaves []*MerkleNode) map[string]string {
+	errs := make(map[string]string)
+	for _, leaf := range leaves {
+		if leaf.Err != "" {
+			errs[leaf.FileName] = leaf.Err
+		}
+	}
+	return errs
+}
+
+// CompareFolders scans two live folders and diffs them without going through
+// saved snapshots, reporting pathB as a change of pathA; e.g. a production
+// copy against staging. Both folders are scanned at the same time with the
+// client's options and compared as by CompareSnapshots, so severity rules,
+// case folding and the rename options apply. Folders holding the same paths
+// are walked side by side as by DiffTrees, skipping identical subtrees.
+// Nothing is stored.
+func (c *MerkleClient) CompareFolders(pathA, pathB string) (*ChangeReport, error) {
+	paths := [2]string{pathA, pathB}
+	var trees [2]*MerkleTree
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			trees[i], errs[i] = c.GetTree(paths[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", paths[i], err)
+		}
+	}
+
+	if report := c.diffAlignedTrees(trees[0], trees[1]); report != nil {
+		return report, nil
+	}
+	return c.CompareSnapshots(c.stateFromTree(trees[0]), c.stateFromTree(trees[1]))
+}
+
+// diffAlignedTrees compares two trees over the same paths by walking them side
+// by side, giving the report CompareSnapshots would. It returns nil when the
+// paths or tree versions differ, or when attributes were recorded, since leaf
+// hashes do not cover them and an unchanged subtree may still hold attribute
+// changes.
+func (c *MerkleClient) diffAlignedTrees(a, b *MerkleTree) *ChangeReport {
+	oldLeaves, newLeaves := treeLeaves(a), treeLeaves(b)
+	if !samePaths(oldLeaves, newLeaves) || a.Scheme.TreeVersion != b.Scheme.TreeVersion || hasAttributes(oldLeaves) || hasAttributes(newLeaves) {
+		return nil
+	}
+
+	now := time.Now()
+	report := &ChangeReport{
+		OldTimestamp: now,
+		NewTimestamp: now,
+		OldRootHash:  a.Root.Hash,
+		NewRootHash:  b.Root.Hash,
+		Changes:      []FileChange{},
+		Errors:       fileErrors(leafErrors(oldLeaves), leafErrors(newLeaves)),
+	}
+	// With the same paths on both sides there is nothing to pair as a rename
+	diffAlignedNodes(a.Root, b.Root, report)
+	sort.SliceStable(report.Changes, func(i, j int) bool {
+		return report.Changes[i].FileName < report.Changes[j].FileName
+	})
+	ClassifyChanges(report, c.severityRules)
+
+	report.tallySizes()
+	return report
+}
+
+// hasAttributes reports whether any leaf recorded file attributes
+func hasAttributes(leaves []*MerkleNode) bool {
+	for _, leaf := range leaves {
+		if leaf.HasAttributes {
+			return true
+		}
+	}
+	return false
+}
+
+// treeLeaves returns the leaves of a tree in tree order
+func treeLeaves(t *MerkleTree) []*MerkleNode {
+	var leaves []*MerkleNode
+	for it := t.Leaves(); it.Next(); {
+		leaves = append(leaves, it.Node())
+	}
+	return leaves
+}
+
+// samePaths reports whether two leaf sequences name the same files
+func samePaths(oldLeaves, newLeaves []*MerkleNode) bool {
+	if len(oldLeaves) == 0 || len(oldLeaves) != len(newLeaves) {
+		return false
+	}
+	for i := range oldLeaves {
+		if oldLeaves[i].FileName != newLeaves[i].FileName {
+			return false
+		}
+	}
+	return true
+}
+
+// diffAlignedNodes walks two trees of identical shape and paths, descending
+// only into subtrees whose hashes differ
+func diffAlignedNodes(oldNode, newNode *MerkleNode, report *ChangeReport) {
+	if equalHashes(oldNode.Hash, newNode.Hash) {
+		return
+	}
+
+	if oldNode.IsLeaf {
+		if oldNode.Err == "" && newNode.Err == "" {
+			report.Changes = append(report.Changes, leafChange(oldNode, newNode))
+		}
+		return
+	}
+
+	diffAlignedNodes(oldNode.Left, newNode.Left, report)
+	if oldNode.Right != nil && oldNode.Right != oldNode.Left {
+		diffAlignedNodes(oldNode.Right, newNode.Right, report)
+	}
+}
+
+// diffLeaves merges two leaf sequences sorted by path
+func diffLeaves(oldLeaves, newLeaves []*MerkleNode, report *ChangeReport) {
+	i, j := 0, 0
+	for i < len(oldLeaves) || j < len(newLeaves) {
+		switch {
+		case j == len(newLeaves) || (i < len(oldLeaves) && oldLeaves[i].FileName < newLeaves[j].FileName):
+			if oldLeaves[i].Err == "" {
+				report.Changes = append(report.Changes, leafChange(oldLeaves[i], nil))
+			}
+			i++
+		case i == len(oldLeaves) || newLeaves[j].FileName < oldLeaves[i].FileName:
+			if newLeaves[j].Err == "" {
+				report.Changes = append(report.Changes, leafChange(nil, newLeaves[j]))
+			}
+			j++
+		default:
+			// Files that could not be hashed on either side are listed in
+			// report.Errors instead, as in CompareSnapshots
+			if oldLeaves[i].Err == "" && newLeaves[j].Err == "" && !equalHashes(oldLeaves[i].Hash, newLeaves[j].Hash) {
+				report.Changes = append(report.Changes, leafChange(oldLeaves[i], newLeaves[j]))
+			}
+			i++
+			j++
+		}
+	}
+}
+
+// leafChange describes the change from oldLeaf to newLeaf; a nil leaf means the
+// file was added or deleted
+func leafChange(oldLeaf, newLeaf *MerkleNode) FileChange {
+	switch {
+	case oldLeaf == nil:
+		return FileChange{
+			FileName:   newLeaf.FileName,
+			ChangeType: Added,
+			NewHash:    newLeaf.Hash,
+			NewSize:    newLeaf.Size,
+			Content:    newLeaf.Content,
+		}
+	case newLeaf == nil:
+		return FileChange{
+			FileName:   oldLeaf.FileName,
+			ChangeType: Deleted,
+			OldHash:    oldLeaf.Hash,
+			OldSize:    oldLeaf.Size,
+			Content:    oldLeaf.Content,
+		}
+	}
+
+	change := FileChange{
+		FileName:   newLeaf.FileName,
+		ChangeType: Modified,
+		OldHash:    oldLeaf.Hash,
+		NewHash:    newLeaf.Hash,
+		OldSize:    oldLeaf.Size,
+		NewSize:    newLeaf.Size,
+		Content:    newLeaf.Content,
+	}
+	if changed, total, ok := changedChunks(oldLeaf.Chunks, newLeaf.Chunks); ok {
+		change.ChangedChunks = changed
+		change.TotalChunks = total
+	}
+	return change
+}
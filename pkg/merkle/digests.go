@@ -0,0 +1,80 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WithExtraDigests computes the named digests, any of HashAlgorithms, in the
+// same read pass as the leaf hash and stores them in the snapshot
+func WithExtraDigests(algorithms ...string) Option {
+	return func(c *MerkleClient) {
+		c.scan.extraDigests = algorithms
+	}
+}
+
+// fileDigest holds the tree hash of a file, any additional digests and the
+// size of the content that was hashed
+type fileDigest struct {
+	hash   []byte
+	extra  map[string][]byte
+	size   int64
+	chunks *FileChunks
+	kind   ContentKind
+}
+
+// validateDigests checks that every requested extra digest is supported
+func validateDigests(algorithms []string) error {
+	for _, name := range algorithms {
+		if _, ok := hashAlgorithms[name]; !ok {
+			return fmt.Errorf("unsupported digest algorithm: %s", name)
+		}
+	}
+	return nil
+}
+
+// formatDigests encodes digests as "algo=hex;algo=hex" in algorithm order
+func formatDigests(digests map[string][]byte) string {
+	names := make([]string, 0, len(digests))
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + hex.EncodeToString(digests[name])
+	}
+	return strings.Join(parts, ";")
+}
+
+// parseDigests decodes the output of formatDigests
+// and returns the digests it could decode along with the first problem found
+func parseDigests(s string) (map[string][]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var firstErr error
+	digests := make(map[string][]byte)
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("malformed digest %q", part)
+			}
+			continue
+		}
+		decoded, err := hex.DecodeString(value)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("invalid %s digest: %v", name, err)
+			}
+			continue
+		}
+		digests[name] = decoded
+	}
+	return digests, firstErr
+}
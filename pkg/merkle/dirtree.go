@@ -0,0 +1,101 @@
+package merkle
+
+import (
+	"sort"
+	"strings"
+)
+
+// DirNode is a node of the directory view of a snapshot. Unlike MerkleNode,
+// which pairs leaves into a balanced binary tree, DirNode mirrors the folder
+// hierarchy so a snapshot can be explored one directory at a time.
+type DirNode struct {
+	Name     string
+	Path     string // path relative to the snapshot root, "" for the root
+	IsDir    bool
+	Hash     []byte // file hash for leaves; see RollupHash for directories
+	Parent   *DirNode
+	children map[string]*DirNode
+	rollup   []byte
+}
+
+// BuildDirTree arranges the files of a snapshot into a directory hierarchy
+func BuildDirTree(state *TreeState) *DirNode {
+	root := &DirNode{IsDir: true, children: make(map[string]*DirNode)}
+
+	for fileName, hash := range state.FileHashes {
+		node := root
+		parts := strings.Split(fileName, "/")
+		for i, part := range parts {
+			child, ok := node.children[part]
+			if !ok {
+				child = &DirNode{
+					Name:   part,
+					Path:   strings.Join(parts[:i+1], "/"),
+					Parent: node,
+				}
+				if i < len(parts)-1 {
+					child.IsDir = true
+					child.children = make(map[string]*DirNode)
+				} else {
+					child.Hash = hash
+				}
+				node.children[part] = child
+			}
+			node = child
+		}
+	}
+
+	return root
+}
+
+// Children returns the entries of a directory, directories first, then by name
+func (n *DirNode) Children() []*DirNode {
+	children := make([]*DirNode, 0, len(n.children))
+	for _, child := range n.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].IsDir != children[j].IsDir {
+			return children[i].IsDir
+		}
+		return children[i].Name < children[j].Name
+	})
+	return children
+}
+
+// Child returns the named entry of a directory
+func (n *DirNode) Child(name string) (*DirNode, bool) {
+	child, ok := n.children[name]
+	return child, ok
+}
+
+// RollupHash returns the hash of a file, or for a directory a hash over the
+// names and rollup hashes of everything below it. Directory hashes are computed
+// on first use, so browsing a large tree only pays for what is viewed.
+func (n *DirNode) RollupHash() []byte {
+	if !n.IsDir {
+		return n.Hash
+	}
+	if n.rollup == nil {
+		var data []byte
+		for _, child := range n.Children() {
+			data = append(data, child.Name...)
+			data = append(data, 0)
+			data = append(data, child.RollupHash()...)
+		}
+		n.rollup = hashData(data)
+	}
+	return n.rollup
+}
+
+// FileCount returns the number of files at or below the node
+func (n *DirNode) FileCount() int {
+	if !n.IsDir {
+		return 1
+	}
+	count := 0
+	for _, child := range n.children {
+		count += child.FileCount()
+	}
+	return count
+}
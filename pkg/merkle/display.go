@@ -2,101 +2,497 @@ package merkle
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// TreeLimits bounds how much of a tree PrintTreeLimited prints
+type TreeLimits struct {
+	MaxDepth    int // nodes at this depth are collapsed into a file count; 0 means unlimited
+	MaxChildren int // leaves listed under one node before the rest are elided; 0 means unlimited
+}
+
 // PrintTree prints the Merkle tree structure
 func PrintTree(node *MerkleNode, depth int) {
+	WriteTree(os.Stdout, node, depth, TreeLimits{})
+}
+
+// PrintTreeLimited prints the Merkle tree structure, collapsing nodes beyond
+// limits.MaxDepth and listing at most limits.MaxChildren leaves under a node
+// followed by an "… and N more" line, so large trees stay readable
+func PrintTreeLimited(node *MerkleNode, depth int, limits TreeLimits) {
+	WriteTree(os.Stdout, node, depth, limits)
+}
+
+// WriteTree writes the Merkle tree structure to w, within the given limits
+func WriteTree(w io.Writer, node *MerkleNode, depth int, limits TreeLimits) {
 	if node == nil {
 		return
 	}
 
-	indent := ""
-	for i := 0; i < depth; i++ {
-		indent += "  "
-	}
+	indent := strings.Repeat("  ", depth)
 
 	if node.IsLeaf {
-		fmt.Printf("%s[FILE] %s: %x\n", indent, node.FileName, node.Hash[:8])
+		writeLeaf(w, indent, node)
+		return
+	}
+
+	fmt.Fprintf(w, "%s[NODE] Hash: %x\n", indent, shortHash(node.Hash, 8))
+
+	leaves := countLeaves(node)
+	if limits.MaxDepth > 0 && depth >= limits.MaxDepth {
+		fmt.Fprintf(w, "%s  … %s files below\n", indent, formatCount(leaves))
+		return
+	}
+
+	if limits.MaxChildren > 0 && leaves > limits.MaxChildren {
+		listed := 0
+		forEachLeaf(node, func(leaf *MerkleNode) bool {
+			if listed == limits.MaxChildren {
+				return false
+			}
+			writeLeaf(w, indent+"  ", leaf)
+			listed++
+			return true
+		})
+		fmt.Fprintf(w, "%s  … and %s more\n", indent, formatCount(leaves-listed))
+		return
+	}
+
+	WriteTree(w, node.Left, depth+1, limits)
+	WriteTree(w, node.Right, depth+1, limits)
+}
+
+// writeLeaf writes a single leaf line of a tree dump
+func writeLeaf(w io.Writer, indent string, node *MerkleNode) {
+	if node.Err != "" {
+		fmt.Fprintf(w, "%s[ERROR] %s: %s\n", indent, node.FileName, node.Err)
+	} else if node.Unstable {
+		fmt.Fprintf(w, "%s[UNSTABLE] %s: %x\n", indent, node.FileName, shortHash(node.Hash, 8))
+	} else if node.Special {
+		fmt.Fprintf(w, "%s[SPECIAL] %s: %x\n", indent, node.FileName, shortHash(node.Hash, 8))
 	} else {
-		fmt.Printf("%s[NODE] Hash: %x\n", indent, node.Hash[:8])
-		PrintTree(node.Left, depth+1)
-		PrintTree(node.Right, depth+1)
+		fmt.Fprintf(w, "%s[FILE] %s: %x\n", indent, node.FileName, shortHash(node.Hash, 8))
+	}
+}
+
+// countLeaves returns the number of leaf lines below a node, as PrintTree would show them
+func countLeaves(node *MerkleNode) int {
+	if node == nil {
+		return 0
+	}
+	if node.IsLeaf {
+		return 1
+	}
+	return countLeaves(node.Left) + countLeaves(node.Right)
+}
+
+// forEachLeaf visits leaves left to right until fn returns false
+func forEachLeaf(node *MerkleNode, fn func(*MerkleNode) bool) bool {
+	if node == nil {
+		return true
+	}
+	if node.IsLeaf {
+		return fn(node)
+	}
+	return forEachLeaf(node.Left, fn) && forEachLeaf(node.Right, fn)
+}
+
+// formatCount formats n with thousands separators, e.g. 4812 as "4,812"
+func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// writeFileErrors lists the files of a report that could not be hashed, which
+// come before every change as any of them may hide one
+func writeFileErrors(w io.Writer, report *ChangeReport) {
+	if len(report.Errors) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n!!! %d file(s) could not be hashed and were not compared !!!\n", len(report.Errors))
+	for _, fileErr := range report.Errors {
+		fmt.Fprintf(w, "  [ERROR] %s: %s\n", fileErr.FileName, fileErr.Reason())
 	}
 }
 
 // PrintChangeReport prints a formatted change report
 func PrintChangeReport(report *ChangeReport) {
-	fmt.Println("\n=== Change Detection Report ===")
-	fmt.Printf("Comparing states from %s to %s\n",
-		report.OldTimestamp.Format("2006-01-02 15:04:05"),
-		report.NewTimestamp.Format("2006-01-02 15:04:05"))
+	WriteChangeReport(os.Stdout, report)
+}
+
+// WriteChangeReport writes a formatted change report to w
+func WriteChangeReport(w io.Writer, report *ChangeReport) {
+	WriteStyledChangeReport(w, report, ReportStyle{})
+}
+
+// WriteStyledChangeReport writes a formatted change report to w, showing
+// times and sizes as style says
+func WriteStyledChangeReport(w io.Writer, report *ChangeReport, style ReportStyle) {
+	fmt.Fprintln(w, "\n=== Change Detection Report ===")
+	fmt.Fprintf(w, "Comparing states from %s to %s\n",
+		style.time(report.OldTimestamp), style.time(report.NewTimestamp))
 
 	// Check root hash
 	if !equalHashes(report.OldRootHash, report.NewRootHash) {
-		fmt.Println("\nRoot hash changed - files have been modified")
-		fmt.Printf("Old root: %x\n", report.OldRootHash[:16])
-		fmt.Printf("New root: %x\n", report.NewRootHash[:16])
-	} else {
-		fmt.Println("\nNo changes detected - root hash is identical")
+		fmt.Fprintln(w, "\nRoot hash changed - files have been modified")
+		fmt.Fprintf(w, "Old root: %x\n", shortHash(report.OldRootHash, 16))
+		fmt.Fprintf(w, "New root: %x\n", shortHash(report.NewRootHash, 16))
+	} else if len(report.Changes) == 0 {
+		fmt.Fprintln(w, "\nNo changes detected - root hash is identical")
+		writeFileErrors(w, report)
 		return
+	} else {
+		// The root hash covers contents and their order, not the names or
+		// attributes of the files
+		fmt.Fprintln(w, "\nRoot hash is identical but file paths or attributes changed")
 	}
 
+	writeFileErrors(w, report)
+
 	// Count changes by type
 	modifiedCount := 0
 	addedCount := 0
 	deletedCount := 0
+	renamedCount := 0
+	movedCount := 0
+	attributesCount := 0
+	truncatedCount := 0
 
 	for _, change := range report.Changes {
 		switch change.ChangeType {
 		case Modified:
 			modifiedCount++
+			if change.SizeChange() == SizeTruncated {
+				truncatedCount++
+			}
 		case Added:
 			addedCount++
 		case Deleted:
 			deletedCount++
+		case CaseRenamed:
+			renamedCount++
+		case Renamed, Moved:
+			movedCount++
+		case AttributesChanged:
+			attributesCount++
+		}
+	}
+
+	// Changes that severity rules flagged come first, most severe first, so a
+	// long report can be triaged from the top
+	counts := report.SeverityCounts()
+	if flagged := counts[SeverityCritical] + counts[SeverityWarning]; flagged > 0 {
+		fmt.Fprintf(w, "\n!!! %d critical, %d warning change(s) !!!\n", counts[SeverityCritical], counts[SeverityWarning])
+		for _, severity := range []Severity{SeverityCritical, SeverityWarning} {
+			for _, change := range report.Changes {
+				if change.Severity != severity {
+					continue
+				}
+				fmt.Fprintf(w, " %s %s %s", severityTag(severity), GetChangeTypeString(change.ChangeType), change.FileName)
+				if change.OldPath != "" {
+					fmt.Fprintf(w, " (was %s)", change.OldPath)
+				}
+				if change.Acknowledged {
+					fmt.Fprint(w, " [ACKNOWLEDGED]")
+				}
+				fmt.Fprintln(w)
+			}
+		}
+	}
+
+	// Files that suddenly became empty are usually incidents, so list them first
+	if truncatedCount > 0 {
+		fmt.Fprintf(w, "\n!!! WARNING: %d file(s) truncated to zero bytes !!!\n", truncatedCount)
+		for _, change := range report.Changes {
+			if change.ChangeType == Modified && change.SizeChange() == SizeTruncated {
+				fmt.Fprintf(w, "  [TRUNCATED] %s (was %s)\n", change.FileName, style.size(change.OldSize))
+			}
 		}
 	}
 
 	// Print modified files
-	fmt.Println("\nModified files:")
+	fmt.Fprintln(w, "\nModified files:")
 	if modifiedCount == 0 {
-		fmt.Println("  None")
+		fmt.Fprintln(w, "  None")
 	} else {
 		for _, change := range report.Changes {
 			if change.ChangeType == Modified {
-				fmt.Printf("  [MODIFIED] %s\n", change.FileName)
-				fmt.Printf("    Old hash: %x\n", change.OldHash[:16])
-				fmt.Printf("    New hash: %x\n", change.NewHash[:16])
+				fmt.Fprintf(w, "  [MODIFIED] %s%s%s\n", change.FileName, contentTag(change.Content), changeTags(change))
+				fmt.Fprintf(w, "    Old hash: %x\n", shortHash(change.OldHash, 16))
+				fmt.Fprintf(w, "    New hash: %x\n", shortHash(change.NewHash, 16))
+				if style.HumanSizes {
+					fmt.Fprintf(w, "    Size: %s -> %s (%s, %s)\n", style.size(change.OldSize), style.size(change.NewSize),
+						style.delta(change.NewSize-change.OldSize), GetSizeChangeString(change.SizeChange()))
+				} else {
+					fmt.Fprintf(w, "    Size: %d -> %d bytes (%+d, %s)\n", change.OldSize, change.NewSize,
+						change.NewSize-change.OldSize, GetSizeChangeString(change.SizeChange()))
+				}
+				if percent, ok := change.ChangedPercent(); ok {
+					fmt.Fprintf(w, "    Changed: ~%.0f%% (%d of %d chunks)\n", percent, change.ChangedChunks, change.TotalChunks)
+				}
+				if change.OldAttributes != change.NewAttributes {
+					fmt.Fprintf(w, "    Attributes: %s -> %s\n", change.OldAttributes, change.NewAttributes)
+				}
+				writeRecheck(w, change)
 			}
 		}
 	}
 
 	// Print added files
-	fmt.Println("\nAdded files:")
+	fmt.Fprintln(w, "\nAdded files:")
 	if addedCount == 0 {
-		fmt.Println("  None")
+		fmt.Fprintln(w, "  None")
 	} else {
 		for _, change := range report.Changes {
 			if change.ChangeType == Added {
-				fmt.Printf("  [ADDED] %s (hash: %x)\n", change.FileName, change.NewHash[:16])
+				fmt.Fprintf(w, "  [ADDED] %s%s (hash: %x, %s)%s\n", change.FileName, contentTag(change.Content), shortHash(change.NewHash, 16), style.size(change.NewSize), changeTags(change))
+				writeRecheck(w, change)
 			}
 		}
 	}
 
 	// Print deleted files
-	fmt.Println("\nDeleted files:")
+	fmt.Fprintln(w, "\nDeleted files:")
 	if deletedCount == 0 {
-		fmt.Println("  None")
+		fmt.Fprintln(w, "  None")
 	} else {
 		for _, change := range report.Changes {
 			if change.ChangeType == Deleted {
-				fmt.Printf("  [DELETED] %s (hash: %x)\n", change.FileName, change.OldHash[:16])
+				fmt.Fprintf(w, "  [DELETED] %s%s (hash: %x, %s)%s\n", change.FileName, contentTag(change.Content), shortHash(change.OldHash, 16), style.size(change.OldSize), changeTags(change))
+				writeRecheck(w, change)
 			}
 		}
 	}
 
-	fmt.Printf("\nSummary: %d modified, %d added, %d deleted\n",
+	// Case-only renames are rare, so the section only appears when there are some
+	if renamedCount > 0 {
+		fmt.Fprintln(w, "\nRenamed files (letter case only):")
+		for _, change := range report.Changes {
+			if change.ChangeType == CaseRenamed {
+				fmt.Fprintf(w, "  [CASE_RENAMED] %s -> %s%s", change.OldPath, change.FileName, contentTag(change.Content))
+				if !equalHashes(change.OldHash, change.NewHash) {
+					fmt.Fprintf(w, " (content changed: %x -> %x)", shortHash(change.OldHash, 16), shortHash(change.NewHash, 16))
+				}
+				fmt.Fprintln(w, changeTags(change))
+			}
+		}
+	}
+
+	// Renames and moves keep their content, so only the paths are shown
+	if movedCount > 0 {
+		fmt.Fprintln(w, "\nRenamed and moved files:")
+		for _, change := range report.Changes {
+			if change.ChangeType == Renamed || change.ChangeType == Moved {
+				fmt.Fprintf(w, "  [%s] %s -> %s%s%s\n", GetChangeTypeString(change.ChangeType),
+					change.OldPath, change.NewPath, contentTag(change.Content), changeTags(change))
+			}
+		}
+	}
+
+	// Attributes are only recorded on Windows, so this section is rare as well
+	if attributesCount > 0 {
+		fmt.Fprintln(w, "\nAttribute changes (content unchanged):")
+		for _, change := range report.Changes {
+			if change.ChangeType == AttributesChanged {
+				fmt.Fprintf(w, "  [ATTRIBUTES] %s: %s -> %s%s\n", change.FileName, change.OldAttributes, change.NewAttributes, changeTags(change))
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\nSummary: %d modified, %d added, %d deleted",
 		modifiedCount, addedCount, deletedCount)
+	if renamedCount > 0 {
+		fmt.Fprintf(w, ", %d renamed (case only)", renamedCount)
+	}
+	if movedCount > 0 {
+		fmt.Fprintf(w, ", %d renamed or moved", movedCount)
+	}
+	if attributesCount > 0 {
+		fmt.Fprintf(w, ", %d attribute change(s)", attributesCount)
+	}
+	fmt.Fprintln(w)
+	if truncatedCount > 0 {
+		fmt.Fprintf(w, "         %d truncated to zero bytes\n", truncatedCount)
+	}
+	if len(report.Errors) > 0 {
+		fmt.Fprintf(w, "Not compared: %d file(s) could not be hashed\n", len(report.Errors))
+	}
+	if counts[SeverityCritical]+counts[SeverityWarning] > 0 {
+		fmt.Fprintf(w, "Severity: %d critical, %d warning, %d info\n",
+			counts[SeverityCritical], counts[SeverityWarning], counts[SeverityInfo])
+	}
+	if acknowledged := report.AcknowledgedCount(); acknowledged > 0 {
+		fmt.Fprintf(w, "Acknowledged: %d of %d change(s) reviewed as expected; %d new\n",
+			acknowledged, len(report.Changes), len(report.Changes)-acknowledged)
+	}
+	if style.HumanSizes {
+		fmt.Fprintf(w, "Size: +%s / -%s (net %s)\n",
+			FormatBytes(report.BytesAdded), FormatBytes(report.BytesRemoved), style.delta(report.NetBytes()))
+	} else {
+		fmt.Fprintf(w, "Size: +%d / -%d bytes (net %+d)\n",
+			report.BytesAdded, report.BytesRemoved, report.NetBytes())
+	}
+	if stats := report.Stats; stats.LargestFile != "" {
+		fmt.Fprintf(w, "Largest changed file: %s (%s)\n", stats.LargestFile, style.size(stats.LargestFileBytes))
+		fmt.Fprintf(w, "Most changes in: %s (%d)\n", stats.BusiestDir, stats.BusiestDirChanges)
+	}
+}
+
+// PrintHistory prints the recorded change events for a file
+func PrintHistory(path string, events []ChangeEvent) {
+	fmt.Printf("=== Change History: %s ===\n", path)
+	if len(events) == 0 {
+		fmt.Println("  No recorded changes")
+		return
+	}
+
+	for _, event := range events {
+		fmt.Printf("  %s  [%s] %s/%s",
+			event.DetectedAt.Format("2006-01-02 15:04:05"),
+			GetChangeTypeString(event.ChangeType), event.Folder, event.FileName)
+		switch event.ChangeType {
+		case Modified:
+			fmt.Printf(" (%x -> %x, %d -> %d bytes)", shortHash(event.OldHash, 8), shortHash(event.NewHash, 8), event.OldSize, event.NewSize)
+		case Added:
+			fmt.Printf(" (%x, %d bytes)", shortHash(event.NewHash, 8), event.NewSize)
+		case Deleted:
+			fmt.Printf(" (%x, %d bytes)", shortHash(event.OldHash, 8), event.OldSize)
+		case CaseRenamed:
+			fmt.Printf(" (renamed by case, %x -> %x)", shortHash(event.OldHash, 8), shortHash(event.NewHash, 8))
+		case AttributesChanged:
+			fmt.Printf(" (attributes only, %x)", shortHash(event.NewHash, 8))
+		case Renamed, Moved:
+			fmt.Printf(" (content unchanged, %x)", shortHash(event.NewHash, 8))
+		}
+		fmt.Println(changeTags(event.FileChange))
+	}
+}
+
+// PrintRuns prints the run summaries returned by RunSummaries, one line each
+func PrintRuns(folder string, runs []RunSummary) {
+	fmt.Printf("=== Runs: %s ===\n", folder)
+	if len(runs) == 0 {
+		fmt.Println("  No recorded runs")
+		return
+	}
+
+	for _, run := range runs {
+		fmt.Printf("  %s  %-8s %8s  %d files, %d bytes",
+			run.Timestamp.Format("2006-01-02 15:04:05"), run.Kind,
+			run.Duration.Round(time.Millisecond), run.Files, run.Bytes)
+		if run.Errors > 0 {
+			fmt.Printf(", %d unreadable", run.Errors)
+		}
+		if run.OldRootHash != nil {
+			fmt.Printf("  +%d ~%d -%d", run.Added, run.Modified, run.Deleted)
+			if run.Other > 0 {
+				fmt.Printf(" (%d other)", run.Other)
+			}
+			if run.Changes() > 0 {
+				fmt.Printf("  %s", run.MaxSeverity)
+			}
+		}
+		fmt.Printf("  %x\n", shortHash(run.NewRootHash, 8))
+	}
+}
+
+// PrintSnapshots prints a page of a snapshot listing returned by
+// QuerySnapshots
+func PrintSnapshots(folder string, page SnapshotPage) {
+	fmt.Printf("=== Snapshots: %s ===\n", folder)
+	if len(page.Snapshots) == 0 {
+		fmt.Println("  No matching snapshots")
+		return
+	}
+
+	for _, file := range page.Snapshots {
+		taken, err := snapshotTime(file)
+		if err != nil {
+			continue
+		}
+		fmt.Printf("  %s  %s", taken.Format("2006-01-02 15:04:05"), filepath.Base(file))
+		if labels := page.Labels[snapshotStem(file)]; len(labels) > 0 {
+			fmt.Printf("  [%s]", strings.Join(labels, ", "))
+		}
+		fmt.Println()
+	}
+	fmt.Printf("  %d of %d matching snapshots shown\n", len(page.Snapshots), page.Total)
+	if page.Next != "" {
+		fmt.Printf("  Next page: --after=%s\n", page.Next)
+	}
+}
+
+// PrintFileHistory prints the timeline returned by FileHistory
+func PrintFileHistory(path string, periods []HashPeriod) {
+	fmt.Printf("=== File History: %s ===\n", path)
+	if len(periods) == 0 {
+		fmt.Println("  Not found in any snapshot")
+		return
+	}
+
+	const layout = "2006-01-02 15:04:05"
+	seen := make(map[string]bool)
+	for _, period := range periods {
+		fmt.Printf("  %s .. %s  ", period.FirstSeen.Format(layout), period.LastSeen.Format(layout))
+		switch {
+		case period.Absent():
+			fmt.Print("(deleted)")
+		case period.Error != "":
+			fmt.Printf("(unreadable: %s)", period.Error)
+		default:
+			fmt.Printf("%x  %d bytes", period.Hash, period.Size)
+			if seen[string(period.Hash)] {
+				fmt.Print("  (earlier content restored)")
+			}
+			seen[string(period.Hash)] = true
+		}
+		fmt.Printf("  [%d snapshot(s)]\n", period.Snapshots)
+	}
+}
+
+// WriteNotification writes a plain-text rendering of a notification
+func WriteNotification(w io.Writer, n *Notification) error {
+	if _, err := fmt.Fprintf(w, "%s\n", n.Subject); err != nil {
+		return err
+	}
+	if len(n.Events) > 0 {
+		fmt.Fprintf(w, "Detected between %s and %s\n\n",
+			n.From.Format("2006-01-02 15:04:05"), n.To.Format("2006-01-02 15:04:05"))
+	}
+
+	for _, event := range n.Events {
+		if _, err := fmt.Fprintf(w, "  %s  [%s] %s/%s%s\n",
+			event.DetectedAt.Format("2006-01-02 15:04:05"),
+			GetChangeTypeString(event.ChangeType), event.Folder, event.FileName, changeTags(event.FileChange)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintQuotaAlerts prints any exceeded quota rules
+func PrintQuotaAlerts(alerts []QuotaAlert) {
+	WriteQuotaAlerts(os.Stdout, alerts)
+}
+
+// WriteQuotaAlerts writes any exceeded quota rules to w
+func WriteQuotaAlerts(w io.Writer, alerts []QuotaAlert) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "\n=== Quota Alerts ===")
+	for _, alert := range alerts {
+		fmt.Fprintf(w, "  [ALERT] %s\n", alert.Message)
+	}
 }
 
 // GetChangeTypeString returns a string representation of the change type
@@ -108,7 +504,92 @@ func GetChangeTypeString(changeType ChangeType) string {
 		return "ADDED"
 	case Deleted:
 		return "DELETED"
+	case CaseRenamed:
+		return "CASE_RENAMED"
+	case AttributesChanged:
+		return "ATTRIBUTES"
+	case Renamed:
+		return "RENAMED"
+	case Moved:
+		return "MOVED"
 	default:
 		return "UNKNOWN"
 	}
 }
+
+// writeRecheck annotates a change with the result of hashing it again
+func writeRecheck(w io.Writer, change FileChange) {
+	if change.Recheck != NotRechecked {
+		fmt.Fprintf(w, "    Re-hash: %s\n", GetRecheckString(change.Recheck))
+	}
+}
+
+// GetRecheckString returns a string representation of a recheck result
+func GetRecheckString(result RecheckResult) string {
+	switch result {
+	case NotRechecked:
+		return "not rechecked"
+	case MismatchPersisted:
+		return "mismatch persisted"
+	case MismatchReverted:
+		return "reverted to the old state (caught mid-write)"
+	case StillChanging:
+		return "still changing (caught mid-write)"
+	default:
+		return "unknown"
+	}
+}
+
+// GetSizeChangeString returns a string representation of a size change
+func GetSizeChangeString(sizeChange SizeChange) string {
+	switch sizeChange {
+	case SizeUnchanged:
+		return "same size"
+	case SizeGrew:
+		return "grew"
+	case SizeShrank:
+		return "shrank"
+	case SizeTruncated:
+		return "TRUNCATED"
+	default:
+		return "unknown"
+	}
+}
+
+// shortHash truncates a hash for display, tolerating hashes shorter than n bytes
+func shortHash(h []byte, n int) []byte {
+	if len(h) < n {
+		return h
+	}
+	return h[:n]
+}
+
+// GetContentKindString returns a string representation of a content kind
+func GetContentKindString(kind ContentKind) string {
+	switch kind {
+	case ContentText:
+		return "text"
+	case ContentBinary:
+		return "binary"
+	default:
+		return "unknown"
+	}
+}
+
+// contentTag formats a content kind for inline display after a file name
+func contentTag(kind ContentKind) string {
+	if kind == ContentUnknown {
+		return ""
+	}
+	return " [" + GetContentKindString(kind) + "]"
+}
+
+// changeTags formats the severity of a change and whether it was
+// acknowledged for inline display at the end of its line
+func changeTags(change FileChange) string {
+	tags := severityTag(change.Severity)
+	if change.Acknowledged {
+		tags += " [ACKNOWLEDGED]"
+	}
+	return tags
+}
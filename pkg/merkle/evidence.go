@@ -0,0 +1,157 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BlobStore provides earlier file contents by hash, so evidence can include
+// the version a file had before it changed
+type BlobStore interface {
+	// Open returns the content whose tree hash is hash, or an error wrapping
+	// os.ErrNotExist if it is not stored
+	Open(hash []byte) (io.ReadCloser, error)
+}
+
+// EvidencePolicy selects which changes CaptureEvidence preserves
+type EvidencePolicy struct {
+	Dir      string    // evidence root; each capture gets its own subdirectory
+	Patterns []string  // sensitive paths, as for ActionHook; empty for every file
+	Blobs    BlobStore // optional; previous versions are captured when set
+}
+
+// evidenceManifest lists what a capture holds
+const evidenceManifest = "MANIFEST.csv"
+
+// CaptureEvidence copies the current content of every added or modified file
+// in a sensitive path into a new timestamped directory below policy.Dir, for
+// forensic review before anyone restores the files. With a BlobStore the
+// previous versions of modified files are copied too. Copies are made
+// read-only, and MANIFEST.csv records each file's change, the hashes from the
+// report, and the SHA-256 of the bytes actually captured, which differs from
+// the tree hash if the file changed again after the scan.
+//
+// It returns the capture directory, or "" when no change was sensitive. A file
+// that cannot be copied is recorded in the manifest instead of aborting the
+// capture.
+func CaptureEvidence(folderPath string, report *ChangeReport, policy EvidencePolicy) (string, error) {
+	var sensitive []FileChange
+	for _, change := range report.Changes {
+		if change.ChangeType != Added && change.ChangeType != Modified {
+			continue
+		}
+		if len(policy.Patterns) == 0 {
+			sensitive = append(sensitive, change)
+			continue
+		}
+		for _, pattern := range policy.Patterns {
+			if matchPathPattern(pattern, change.FileName) {
+				sensitive = append(sensitive, change)
+				break
+			}
+		}
+	}
+	if len(sensitive) == 0 {
+		return "", nil
+	}
+
+	captureDir := filepath.Join(policy.Dir, fmt.Sprintf("%s_%s",
+		filepath.Base(folderPath), report.NewTimestamp.Format("20060102_150405")))
+	if err := os.MkdirAll(captureDir, 0700); err != nil {
+		return "", err
+	}
+
+	manifest, err := os.Create(filepath.Join(captureDir, evidenceManifest))
+	if err != nil {
+		return "", err
+	}
+	defer manifest.Close()
+
+	writer := csv.NewWriter(manifest)
+	writer.Write([]string{"file_path", "change_type", "version", "tree_hash", "captured_sha256", "captured_at", "error"})
+	for _, change := range sensitive {
+		source := fileOnDisk(folderPath, change.FileName)
+		target := filepath.Join(captureDir, "new", filepath.FromSlash(change.FileName))
+		digest, err := copyEvidence(target, func() (io.ReadCloser, error) { return os.Open(source) })
+		writer.Write(evidenceRow(change, "new", change.NewHash, digest, err))
+
+		if change.ChangeType == Modified && policy.Blobs != nil {
+			target := filepath.Join(captureDir, "old", filepath.FromSlash(change.FileName))
+			digest, err := copyEvidence(target, func() (io.ReadCloser, error) { return policy.Blobs.Open(change.OldHash) })
+			writer.Write(evidenceRow(change, "old", change.OldHash, digest, err))
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return captureDir, err
+	}
+	if err := manifest.Close(); err != nil {
+		return captureDir, err
+	}
+	return captureDir, os.Chmod(filepath.Join(captureDir, evidenceManifest), 0400)
+}
+
+// fileOnDisk returns where a reported file is on disk
+func fileOnDisk(folderPath, fileName string) string {
+	if IsSetPath(folderPath) {
+		// File sets record absolute paths
+		return filepath.FromSlash(fileName)
+	}
+	if info, err := os.Stat(folderPath); err == nil && !info.IsDir() {
+		// A single monitored file is reported by its base name
+		return filepath.Join(filepath.Dir(folderPath), filepath.FromSlash(fileName))
+	}
+	return filepath.Join(folderPath, filepath.FromSlash(fileName))
+}
+
+// copyEvidence copies the content from open into a new read-only file at
+// target, returning the SHA-256 of the bytes copied
+func copyEvidence(target string, open func() (io.ReadCloser, error)) ([]byte, error) {
+	src, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return nil, err
+	}
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0400)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, hasher), src); err != nil {
+		dst.Close()
+		return nil, err
+	}
+	if err := dst.Close(); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
+// evidenceRow formats one manifest row
+func evidenceRow(change FileChange, version string, treeHash, digest []byte, err error) []string {
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+	return []string{
+		change.FileName,
+		GetChangeTypeString(change.ChangeType),
+		version,
+		hex.EncodeToString(treeHash),
+		hex.EncodeToString(digest),
+		time.Now().Format(time.RFC3339),
+		errText,
+	}
+}
@@ -0,0 +1,117 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Files that could not be hashed are listed in every report, not dropped, and
+// fail the comparison
+func TestUnhashedFilesFailReports(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "alpha", "stuck.txt": "beta"})
+
+	// Reading stuck.txt blocks until the test ends while stall is set
+	var stall atomic.Bool
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	hasher := LeafHasherFunc(func(path string, r io.Reader) ([]byte, error) {
+		if stall.Load() && strings.HasSuffix(path, "stuck.txt") {
+			<-release
+		}
+		h := sha256.New()
+		_, err := io.Copy(h, r)
+		return h.Sum(nil), err
+	})
+	client := NewClient(t.TempDir(), WithLeafHasher(hasher), WithHashTimeout(50*time.Millisecond))
+
+	oldState, err := client.CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldTree, err := client.GetTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stall.Store(true)
+	newState, err := client.CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTree, err := client.GetTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, failed := newState.Errors["stuck.txt"]; !failed {
+		t.Fatalf("stuck.txt was hashed: %v", newState.Errors)
+	}
+
+	want := []FileError{{FileName: "stuck.txt", NewError: errHashTimeout.Error()}}
+	report, err := client.CompareSnapshots(oldState, newState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Errors, want) || len(report.Changes) != 0 {
+		t.Errorf("CompareSnapshots: errors %v, changes %v; want %v and no changes", report.Errors, report.Changes, want)
+	}
+	if code := report.ExitCode(); code != ExitError {
+		t.Errorf("ExitCode = %d, want %d", code, ExitError)
+	}
+	if status := (FolderVerification{Report: report}).Status(); status != "failed" {
+		t.Errorf("verify-all status = %s, want failed", status)
+	}
+	if diffed := DiffTrees(oldTree, newTree); !reflect.DeepEqual(diffed.Errors, want) {
+		t.Errorf("DiffTrees: errors %v, want %v", diffed.Errors, want)
+	}
+	reversed, err := client.CompareSnapshots(newState, oldState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reversed.Errors) != 1 || reversed.Errors[0].OldError == "" || reversed.ExitCode() != ExitError {
+		t.Errorf("errors of the old state: %v", reversed.Errors)
+	}
+
+	// Mapped snapshots hold the reason as a status
+	mapped := make([]*MappedSnapshot, 2)
+	for i, state := range []*TreeState{oldState, newState} {
+		var buf bytes.Buffer
+		if err := writeBinarySnapshot(&buf, state); err != nil {
+			t.Fatal(err)
+		}
+		if mapped[i], err = writeMappedFixture(t, buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if compared, err := CompareMappedSnapshots(mapped[0], mapped[1]); err != nil || !reflect.DeepEqual(compared.Errors, want) {
+		t.Errorf("CompareMappedSnapshots: errors %v, %v; want %v", compared.Errors, err, want)
+	}
+
+	var text bytes.Buffer
+	WriteChangeReport(&text, report)
+	if !strings.Contains(text.String(), "[ERROR] stuck.txt: hashing timed out") {
+		t.Errorf("text report does not list stuck.txt:\n%s", text.String())
+	}
+	var decoded struct {
+		Errors []struct {
+			FileName string `json:"file_path"`
+			NewError string `json:"new_error"`
+		} `json:"errors"`
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Errors) != 1 || decoded.Errors[0].FileName != "stuck.txt" || decoded.Errors[0].NewError == "" {
+		t.Errorf("JSON errors = %+v", decoded.Errors)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package merkle
+
+import "os"
+
+// fileIDOf reports no identity on platforms without device/inode numbers
+func fileIDOf(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
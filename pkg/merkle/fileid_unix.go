@@ -0,0 +1,17 @@
+//go:build unix
+
+package merkle
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIDOf returns the (device, inode) pair backing a file, if available
+func fileIDOf(info os.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
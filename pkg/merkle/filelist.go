@@ -0,0 +1,77 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WithFileList restricts scans to exactly the listed files instead of walking
+// the folder. Paths are relative to the scanned folder, as printed by
+// "find . -type f" or "git ls-files" run inside it. Directories in the list are
+// ignored and a listed file that does not exist fails the scan.
+func WithFileList(paths []string) Option {
+	return func(c *MerkleClient) {
+		c.scan.fileList = paths
+	}
+}
+
+// ReadFileList reads a list of paths separated by NUL bytes or, when the input
+// contains none, by newlines. Empty entries are dropped.
+func ReadFileList(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	sep := []byte("\n")
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = []byte{0}
+	}
+
+	var paths []string
+	for _, entry := range bytes.Split(data, sep) {
+		path := strings.TrimSuffix(string(entry), "\r")
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// visitFileList calls visit for every listed path inside folderPath, once per
+// distinct file, in the same way filepath.Walk would have
+func visitFileList(folderPath string, paths []string, visit func(string, os.FileInfo) error) error {
+	visited := make(map[string]bool)
+	for _, listed := range paths {
+		rel := filepath.Clean(filepath.FromSlash(listed))
+		if folderPath == "" {
+			// File sets list absolute paths and have no common folder
+			if !filepath.IsAbs(rel) {
+				return fmt.Errorf("listed path %q is not absolute", listed)
+			}
+		} else if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("listed path %q is outside the scanned folder", listed)
+		}
+		if visited[rel] {
+			continue
+		}
+		visited[rel] = true
+
+		path := filepath.Join(folderPath, rel)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+		if err := visit(path, info); err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package merkle
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// setPrefix marks a folder path that names a file set instead of a directory.
+// Snapshots, comparisons and history of "@critical-configs" work exactly like
+// those of a folder.
+const setPrefix = "@"
+
+// FileSet is a named group of files, possibly spread over several roots, that
+// is snapshotted and compared as a unit
+type FileSet struct {
+	Name  string   `json:"name"`
+	Paths []string `json:"paths"` // explicit files
+	Globs []string `json:"globs"` // filepath.Match patterns such as /etc/nginx/*.conf
+}
+
+// Manifest lists the file sets that can be monitored
+type Manifest struct {
+	Sets []FileSet `json:"sets"`
+}
+
+// LoadManifest reads a JSON manifest of file sets
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, set := range m.Sets {
+		if !ValidNamespace(set.Name) {
+			return nil, fmt.Errorf("invalid file set name: %q", set.Name)
+		}
+		if seen[set.Name] {
+			return nil, fmt.Errorf("duplicate file set: %s", set.Name)
+		}
+		seen[set.Name] = true
+	}
+	return &m, nil
+}
+
+// WithManifest makes the file sets of a manifest available under SetPath(name)
+func WithManifest(m *Manifest) Option {
+	return func(c *MerkleClient) {
+		c.sets = make(map[string]FileSet, len(m.Sets))
+		for _, set := range m.Sets {
+			c.sets[set.Name] = set
+		}
+	}
+}
+
+// SetPath returns the folder path under which a file set is snapshotted
+func SetPath(name string) string {
+	return setPrefix + name
+}
+
+// IsSetPath reports whether folderPath names a file set
+func IsSetPath(folderPath string) bool {
+	return strings.HasPrefix(folderPath, setPrefix)
+}
+
+// Files returns the absolute paths of the files currently in the set, sorted.
+// Listed files that do not exist are left out so that they show up as deleted.
+func (s FileSet) Files() ([]string, error) {
+	candidates := append([]string(nil), s.Paths...)
+	for _, pattern := range s.Globs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob in file set %s: %q", s.Name, pattern)
+		}
+		candidates = append(candidates, matches...)
+	}
+
+	seen := make(map[string]bool)
+	var files []string
+	for _, candidate := range candidates {
+		path, err := filepath.Abs(candidate)
+		if err != nil {
+			return nil, err
+		}
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, path)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// setTree builds the Merkle tree of a file set; leaves are named by the
+// absolute, forward-slash path of each file
+func (c *MerkleClient) setTree(folderPath string, opts scanOptions) (*MerkleTree, error) {
+	name := strings.TrimPrefix(folderPath, setPrefix)
+	set, ok := c.sets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown file set: %s", name)
+	}
+
+	files, err := set.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	opts.fileList = files
+	opts.oneFS = false
+	return createMerkleTreeFromFolder("", opts)
+}
@@ -0,0 +1,153 @@
+package merkle
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// FileMetadata is the file system metadata of a file at one point in time.
+// Fields a platform does not provide are left empty.
+type FileMetadata struct {
+	Mode       os.FileMode
+	Size       int64
+	ModTime    time.Time
+	ChangeTime time.Time // inode change time (ctime)
+	UID, GID   string
+	Owner      string // user name of UID, when it can be resolved
+	Group      string // group name of GID, when it can be resolved
+	Device     uint64
+	Inode      uint64
+}
+
+// StatMetadata reads the metadata of a file without following a final
+// symlink, so a replaced link is reported as the link
+func StatMetadata(path string) (FileMetadata, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+
+	meta := FileMetadata{
+		Mode:    info.Mode(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	fillSysMetadata(info, &meta)
+	return meta, nil
+}
+
+// WriteForensicReport writes a change report for incident reports and chain
+// of custody documentation. For every change it lists both tree hashes and
+// sizes from the snapshots, then examines the file as it is now: owner and
+// group, mode, mtime and ctime, device and inode, and SHA-256 and MD5 digests
+// of its current content. All times are UTC. folderPath is the folder the
+// report's new state was taken from.
+func WriteForensicReport(w io.Writer, folderPath string, report *ChangeReport) {
+	const layout = time.RFC3339Nano
+	host, _ := os.Hostname()
+
+	fmt.Fprintln(w, "=== Forensic Change Report ===")
+	fmt.Fprintf(w, "Generated:     %s\n", time.Now().UTC().Format(layout))
+	fmt.Fprintf(w, "Host:          %s\n", host)
+	fmt.Fprintf(w, "Folder:        %s\n", folderPath)
+	fmt.Fprintf(w, "Baseline:      %s  root %x\n", report.OldTimestamp.UTC().Format(layout), report.OldRootHash)
+	fmt.Fprintf(w, "Current:       %s  root %x\n", report.NewTimestamp.UTC().Format(layout), report.NewRootHash)
+	fmt.Fprintf(w, "Changes:       %d\n", len(report.Changes))
+	if len(report.Errors) > 0 {
+		fmt.Fprintf(w, "Not hashed:    %d\n", len(report.Errors))
+	}
+	if counts := report.SeverityCounts(); counts[SeverityCritical]+counts[SeverityWarning] > 0 {
+		fmt.Fprintf(w, "Severity:      %d critical, %d warning, %d info\n",
+			counts[SeverityCritical], counts[SeverityWarning], counts[SeverityInfo])
+	}
+
+	for i, change := range report.Changes {
+		fmt.Fprintf(w, "\n[%d] %s %s%s\n", i+1, GetChangeTypeString(change.ChangeType), change.FileName, changeTags(change))
+		if change.OldPath != "" {
+			fmt.Fprintf(w, "    Previous path: %s\n", change.OldPath)
+		}
+		if change.ChangeType != Added {
+			fmt.Fprintf(w, "    Old tree hash: %x\n", change.OldHash)
+			fmt.Fprintf(w, "    Old size:      %d bytes\n", change.OldSize)
+		}
+		if change.Recheck != NotRechecked {
+			fmt.Fprintf(w, "    Re-hash:       %s\n", GetRecheckString(change.Recheck))
+		}
+		if change.ChangeType == Deleted {
+			fmt.Fprintln(w, "    Current file:  not present")
+			continue
+		}
+		fmt.Fprintf(w, "    New tree hash: %x\n", change.NewHash)
+		fmt.Fprintf(w, "    New size:      %d bytes\n", change.NewSize)
+		if change.OldAttributes != change.NewAttributes {
+			fmt.Fprintf(w, "    Attributes:    %s -> %s\n", change.OldAttributes, change.NewAttributes)
+		}
+
+		path := fileOnDisk(folderPath, change.FileName)
+		meta, err := StatMetadata(path)
+		if err != nil {
+			fmt.Fprintf(w, "    Current file:  unavailable (%v)\n", err)
+			continue
+		}
+		fmt.Fprintf(w, "    Examined:      %s\n", time.Now().UTC().Format(layout))
+		fmt.Fprintf(w, "    Owner:         %s\n", idWithName(meta.UID, meta.Owner))
+		fmt.Fprintf(w, "    Group:         %s\n", idWithName(meta.GID, meta.Group))
+		fmt.Fprintf(w, "    Mode:          %s (%04o)\n", meta.Mode, meta.Mode.Perm())
+		fmt.Fprintf(w, "    Size on disk:  %d bytes\n", meta.Size)
+		fmt.Fprintf(w, "    Modified:      %s\n", meta.ModTime.UTC().Format(layout))
+		if !meta.ChangeTime.IsZero() {
+			fmt.Fprintf(w, "    Changed:       %s\n", meta.ChangeTime.UTC().Format(layout))
+		}
+		if meta.Inode != 0 {
+			fmt.Fprintf(w, "    Device/inode:  %d/%d\n", meta.Device, meta.Inode)
+		}
+		if meta.Mode.IsRegular() {
+			sha, md, err := contentDigests(path)
+			if err != nil {
+				fmt.Fprintf(w, "    Digests:       unavailable (%v)\n", err)
+				continue
+			}
+			fmt.Fprintf(w, "    SHA-256:       %x\n", sha)
+			fmt.Fprintf(w, "    MD5:           %x\n", md)
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		fmt.Fprintln(w, "\nFiles that could not be hashed, so were not compared:")
+		for _, fileErr := range report.Errors {
+			fmt.Fprintf(w, "  %s: %s\n", fileErr.FileName, fileErr.Reason())
+		}
+	}
+}
+
+// idWithName formats a numeric owner or group ID with its name, if known
+func idWithName(id, name string) string {
+	switch {
+	case id == "":
+		return "unknown"
+	case name == "":
+		return id
+	default:
+		return fmt.Sprintf("%s (%s)", name, id)
+	}
+}
+
+// contentDigests returns the SHA-256 and MD5 digests of a file's content,
+// computed in one read pass
+func contentDigests(path string) ([]byte, []byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	sha, md := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(sha, md), file); err != nil {
+		return nil, nil, err
+	}
+	return sha.Sum(nil), md.Sum(nil), nil
+}
@@ -0,0 +1,118 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SnapshotProvider creates a point-in-time view of a folder so that a scan sees
+// a single consistent state even while the folder is being written to
+type SnapshotProvider interface {
+	// Create returns the path of a read-only view of folderPath and a function
+	// that releases the view once the scan has finished
+	Create(folderPath string) (viewPath string, release func() error, err error)
+}
+
+// WithSnapshotProvider scans folders from a filesystem snapshot created by p
+// instead of reading the live folder
+func WithSnapshotProvider(p SnapshotProvider) Option {
+	return func(c *MerkleClient) {
+		c.fsSnapshots = p
+	}
+}
+
+// CommandSnapshotProvider delegates snapshot handling to shell commands, which
+// covers LVM, ZFS and Windows VSS setups. The create command must print the path
+// of the snapshot view on stdout. Both commands receive FCD_FOLDER with the
+// absolute folder path; the release command also receives FCD_VIEW.
+type CommandSnapshotProvider struct {
+	CreateCommand  string
+	ReleaseCommand string
+}
+
+// Create runs the create command and returns the view path it printed
+func (p *CommandSnapshotProvider) Create(folderPath string) (string, func() error, error) {
+	absFolder, err := filepath.Abs(folderPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	out, err := runShell(p.CreateCommand, "FCD_FOLDER="+absFolder)
+	if err != nil {
+		return "", nil, fmt.Errorf("snapshot create command failed: %v", err)
+	}
+
+	viewPath := strings.TrimSpace(out)
+	if viewPath == "" {
+		return "", nil, fmt.Errorf("snapshot create command printed no view path")
+	}
+
+	release := func() error {
+		if p.ReleaseCommand == "" {
+			return nil
+		}
+		if _, err := runShell(p.ReleaseCommand, "FCD_FOLDER="+absFolder, "FCD_VIEW="+viewPath); err != nil {
+			return fmt.Errorf("snapshot release command failed: %v", err)
+		}
+		return nil
+	}
+
+	return viewPath, release, nil
+}
+
+// BtrfsSnapshotProvider takes a read-only Btrfs subvolume snapshot of the folder,
+// which must itself be a subvolume, and deletes it after the scan
+type BtrfsSnapshotProvider struct {
+	// SnapshotDir is where temporary snapshots are created; it must be on the
+	// same Btrfs filesystem as the scanned folder
+	SnapshotDir string
+}
+
+// Create takes a read-only snapshot of folderPath
+func (p *BtrfsSnapshotProvider) Create(folderPath string) (string, func() error, error) {
+	viewPath := filepath.Join(p.SnapshotDir, fmt.Sprintf("fcd_%s_%d",
+		filepath.Base(folderPath), time.Now().UnixNano()))
+
+	cmd := exec.Command("btrfs", "subvolume", "snapshot", "-r", folderPath, viewPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", nil, fmt.Errorf("btrfs snapshot failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	release := func() error {
+		cmd := exec.Command("btrfs", "subvolume", "delete", viewPath)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("btrfs snapshot delete failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	return viewPath, release, nil
+}
+
+// shellCommand prepares command to run through the platform shell
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// runShell runs command through the platform shell with extra environment variables
+func runShell(command string, env ...string) (string, error) {
+	cmd := shellCommand(command)
+	cmd.Env = append(os.Environ(), env...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
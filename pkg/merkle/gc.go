@@ -0,0 +1,239 @@
+package merkle
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// folderIndexFile maps snapshot family names to the folders they were taken from
+const folderIndexFile = "folders.csv"
+
+// GCPolicy controls which snapshot families are collected by CollectGarbage
+type GCPolicy struct {
+	// MaxAge also collects families whose newest snapshot is older than this;
+	// zero only collects families whose source folder no longer exists
+	MaxAge time.Duration
+
+	// ArchiveDir, when set, receives collected snapshots instead of deleting them
+	ArchiveDir string
+
+	// DryRun reports what would be collected without touching any files
+	DryRun bool
+}
+
+// CollectGarbage removes or archives snapshot families whose source folder no
+// longer exists or that have not been scanned within policy.MaxAge. The
+// baseline snapshot of a folder is kept. It returns the snapshot files that
+// were (or, in dry-run mode, would be) collected.
+func (c *MerkleClient) CollectGarbage(policy GCPolicy) ([]string, error) {
+	unlock, err := c.lockStorage()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	index, err := c.readFolderIndex()
+	if err != nil {
+		return nil, err
+	}
+	baselines, err := c.readBaselines()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := c.storedSnapshots("")
+	if err != nil {
+		return nil, err
+	}
+
+	// Group snapshots by the folder name encoded in their filename
+	families := make(map[string][]string)
+	for _, file := range files {
+		if name, ok := snapshotFolderName(file); ok {
+			families[name] = append(families[name], file)
+		}
+	}
+
+	var collected []string
+	for name, snapshots := range families {
+		if !c.isDeadFamily(index[name], snapshots, policy.MaxAge) {
+			continue
+		}
+
+		// A promoted baseline is kept with its entry, as by PruneSnapshots, so
+		// a folder that comes back can still be verified against it
+		baseline, hasBaseline := baselines[name]
+		for _, file := range snapshots {
+			if hasBaseline && snapshotStem(file) == snapshotStem(baseline.Snapshot) {
+				continue
+			}
+			if !policy.DryRun {
+				if err := c.retireSnapshot(file, policy.ArchiveDir); err != nil {
+					return collected, err
+				}
+			}
+			collected = append(collected, file)
+		}
+
+		if !policy.DryRun {
+			if !hasBaseline {
+				delete(index, name)
+			}
+			if err := os.Remove(c.statCacheFile(name)); err != nil && !os.IsNotExist(err) {
+				return collected, err
+			}
+		}
+	}
+
+	if !policy.DryRun {
+		if err := c.writeFolderIndex(index); err != nil {
+			return collected, err
+		}
+	}
+
+	sort.Strings(collected)
+	return collected, nil
+}
+
+// isDeadFamily decides whether a snapshot family should be collected
+func (c *MerkleClient) isDeadFamily(folderPath string, snapshots []string, maxAge time.Duration) bool {
+	// File sets have no folder of their own and only expire by age
+	if folderPath != "" && !IsSetPath(folderPath) {
+		if _, imagePath, ok := splitImagePath(folderPath); ok {
+			folderPath = imagePath
+		}
+		if _, err := os.Stat(folderPath); os.IsNotExist(err) {
+			return true
+		}
+	}
+
+	if maxAge > 0 {
+		newest, err := snapshotTime(snapshots[len(snapshots)-1])
+		if err == nil && time.Since(newest) > maxAge {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retireSnapshot deletes a snapshot or moves it into archiveDir
+func (c *MerkleClient) retireSnapshot(file, archiveDir string) error {
+	if _, _, ok := splitSQLiteRef(file); ok {
+		return c.retireSQLiteSnapshot(file, archiveDir)
+	}
+	if archiveDir == "" {
+		return os.Remove(file)
+	}
+	if err := os.MkdirAll(archiveDir, 0755); err != nil {
+		return err
+	}
+	return os.Rename(file, filepath.Join(archiveDir, filepath.Base(file)))
+}
+
+// snapshotFolderName extracts the folder name from state_<folder>_<timestamp>.<ext>
+func snapshotFolderName(filename string) (string, bool) {
+	const timestampLen = len("_20060102_150405")
+	name := strings.TrimPrefix(snapshotStem(filename), "state_")
+	if len(name) <= timestampLen {
+		return "", false
+	}
+	return name[:len(name)-timestampLen], true
+}
+
+// registerFolder records the absolute source path of a snapshot family
+func (c *MerkleClient) registerFolder(folderPath string) error {
+	absPath := folderPath
+	if prefix, imagePath, ok := splitImagePath(folderPath); ok {
+		abs, err := filepath.Abs(imagePath)
+		if err != nil {
+			return err
+		}
+		absPath = prefix + abs
+	} else if !IsSetPath(folderPath) {
+		var err error
+		if absPath, err = filepath.Abs(folderPath); err != nil {
+			return err
+		}
+	}
+
+	index, err := c.readFolderIndex()
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(folderPath)
+	if index[name] == absPath {
+		return nil
+	}
+	index[name] = absPath
+	return c.writeFolderIndex(index)
+}
+
+// readFolderIndex loads the folder name -> path index, which may not exist yet
+func (c *MerkleClient) readFolderIndex() (map[string]string, error) {
+	index := make(map[string]string)
+
+	file, err := os.Open(filepath.Join(c.storageDir, folderIndexFile))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) != 2 {
+			return nil, fmt.Errorf("invalid folder index row")
+		}
+		if row[0] == "folder_name" {
+			continue
+		}
+		index[row[0]] = row[1]
+	}
+
+	return index, nil
+}
+
+// writeFolderIndex replaces the folder index with the given entries
+func (c *MerkleClient) writeFolderIndex(index map[string]string) error {
+	names := make([]string, 0, len(index))
+	for name := range index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	file, err := os.Create(filepath.Join(c.storageDir, folderIndexFile))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{"folder_name", "folder_path"}); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := writer.Write([]string{name, index[name]}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
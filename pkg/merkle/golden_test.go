@@ -0,0 +1,165 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenCase describes a folder before and after a change. Files are named
+// with forward slashes and created with the separator of the platform, so the
+// golden roots and reports hold on every platform.
+type goldenCase struct {
+	name    string
+	before  map[string]string
+	after   map[string]string
+	options []Option
+}
+
+var goldenCases = []goldenCase{
+	{
+		// "a/x.txt" sorts before "a0.txt" with forward slashes but after it
+		// with backslashes, so the root pins the separator used for ordering
+		name:   "separators",
+		before: map[string]string{"a/x.txt": "x", "a0.txt": "zero", "a/b/c.txt": "c", "a.b/d.txt": "d"},
+		after:  map[string]string{"a/x.txt": "x", "a0.txt": "zero", "a/b/c.txt": "c2", "a.b/d.txt": "d", "a/b/e.txt": "e"},
+	},
+	{
+		name:   "case-rename",
+		before: map[string]string{"Readme.md": "doc", "src/Main.go": "package main"},
+		after:  map[string]string{"README.md": "doc", "src/main.go": "package main // edited"},
+	},
+	{
+		name:    "case-folded",
+		before:  map[string]string{"Readme.md": "doc", "src/Main.go": "package main"},
+		after:   map[string]string{"README.md": "doc", "src/main.go": "package main // edited"},
+		options: []Option{WithCaseInsensitivePaths(true)},
+	},
+	{
+		// Line endings are content: converting a file to LF is a modification
+		name:   "crlf",
+		before: map[string]string{"unix.txt": "a\nb\n", "sub/dos.txt": "a\r\nb\r\n", "sub/nested/mixed.txt": "a\r\nb\n"},
+		after:  map[string]string{"unix.txt": "a\nb\n", "sub/dos.txt": "a\nb\n", "sub/nested/mixed.txt": "a\r\nb\n"},
+	},
+}
+
+// goldenSnapshot scans a folder holding files
+func goldenSnapshot(t *testing.T, client Client, files map[string]string) *TreeState {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, files)
+	state, err := client.CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// renderGolden renders the roots of both states and their report, without
+// the timestamps, as stored in a golden file
+func renderGolden(t *testing.T, before, after *TreeState, report *ChangeReport) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "old root %s\n", hex.EncodeToString(before.RootHash))
+	fmt.Fprintf(&out, "new root %s\n", hex.EncodeToString(after.RootHash))
+
+	report.OldTimestamp, report.NewTimestamp = time.Time{}, time.Time{}
+	if err := WriteJSONReport(&out, report); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// checkGolden compares got with testdata/golden/<name>.golden, rewriting the
+// file instead when the tests run with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run the tests with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n--- got ---\n%s\n--- want ---\n%s", name, got, want)
+	}
+}
+
+func TestGoldenRootsAndReports(t *testing.T) {
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewClient(t.TempDir(), tc.options...)
+			before := goldenSnapshot(t, client, tc.before)
+			after := goldenSnapshot(t, client, tc.after)
+			report, err := client.CompareSnapshots(before, after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, tc.name, renderGolden(t, before, after, report))
+		})
+	}
+}
+
+// A file list read from a file with CRLF line endings selects the same files,
+// and so yields the same root, as walking the folder
+func TestGoldenCRLFFileList(t *testing.T) {
+	list, err := os.Open(filepath.Join("testdata", "golden", "crlf.list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+	paths, err := ReadFileList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var crlf goldenCase
+	for _, tc := range goldenCases {
+		if tc.name == "crlf" {
+			crlf = tc
+		}
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, crlf.before)
+
+	walked, err := NewClient(t.TempDir()).CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed, err := NewClient(t.TempDir(), WithFileList(paths)).CreateSnapshot(dir)
+	if err != nil {
+		t.Fatalf("scanning the listed files: %v", err)
+	}
+	if !bytes.Equal(walked.RootHash, listed.RootHash) {
+		t.Errorf("listed root %x, walked root %x", listed.RootHash, walked.RootHash)
+	}
+	checkGolden(t, "crlf-list", []byte(fmt.Sprintf("root %s\n", hex.EncodeToString(listed.RootHash))))
+}
+
+func TestCanonicalPathUsesForwardSlashes(t *testing.T) {
+	folder := filepath.Join("root", "folder")
+	for _, tc := range []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"file.txt"}, "file.txt"},
+		{[]string{"a", "b", "c.txt"}, "a/b/c.txt"},
+	} {
+		path := filepath.Join(append([]string{folder}, tc.parts...)...)
+		if got := canonicalPath(folder, path); got != tc.want {
+			t.Errorf("canonicalPath(%q, %q) = %q, want %q", folder, path, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+//go:build !readonly
+
+package merkle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	merklev1 "github.com/Ridwan414/file-change-detector/api/merkle/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCService returns the MerkleService of api/merkle/v1 serving the same
+// storage directory, tokens and folders as the HTTP API of s, for
+// registration on a gRPC server with merklev1.RegisterMerkleServiceServer.
+// Callers send their bearer token as "authorization" metadata.
+func (s *Server) GRPCService() merklev1.MerkleServiceServer {
+	return &grpcService{server: s}
+}
+
+// grpcService implements merklev1.MerkleServiceServer on a Server
+type grpcService struct {
+	merklev1.UnimplementedMerkleServiceServer
+	server *Server
+}
+
+// CreateSnapshot scans a folder and saves its snapshot
+func (g *grpcService) CreateSnapshot(ctx context.Context, req *merklev1.CreateSnapshotRequest) (*merklev1.SnapshotInfo, error) {
+	client, err := g.authorize(ctx, PermTriggerScans, req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	code, info, err := g.server.saveSnapshot(ctx, client, req.GetFolder())
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	return newProtoSnapshotInfo(info), nil
+}
+
+// CompareSnapshots compares two stored snapshots by ID
+func (g *grpcService) CompareSnapshots(ctx context.Context, req *merklev1.CompareSnapshotsRequest) (*merklev1.ChangeReport, error) {
+	client, err := g.authorize(ctx, PermReadReports, req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	code, report, err := compareSnapshotIDs(client, req.GetFrom(), req.GetTo())
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	return newProtoReport(report), nil
+}
+
+// GetRootHash describes the latest snapshot of a folder
+func (g *grpcService) GetRootHash(ctx context.Context, req *merklev1.GetRootHashRequest) (*merklev1.SnapshotInfo, error) {
+	client, err := g.authorize(ctx, PermReadReports, req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetFolder() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing folder")
+	}
+	code, info, err := latestSnapshot(client, req.GetFolder())
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	return newProtoSnapshotInfo(info), nil
+}
+
+// StreamChanges watches a folder and sends a report whenever it changes, until
+// the caller cancels. Watching scans the folder, so it must be one that may be
+// scanned, and saving snapshots takes the permission to trigger scans.
+func (g *grpcService) StreamChanges(req *merklev1.StreamChangesRequest, stream merklev1.MerkleService_StreamChangesServer) error {
+	ctx := stream.Context()
+	perm := PermReadReports
+	if req.GetSave() {
+		perm = PermTriggerScans
+	}
+	client, err := g.authorize(ctx, perm, req.GetNamespace())
+	if err != nil {
+		return err
+	}
+	if req.GetIntervalMs() < 0 {
+		return status.Error(codes.InvalidArgument, "interval_ms must not be negative")
+	}
+	folder, code, err := g.server.scannableFolder(client, req.GetFolder())
+	if err != nil {
+		return grpcError(code, err)
+	}
+
+	reports, err := client.Watch(ctx, folder, WatchOptions{
+		Interval: time.Duration(req.GetIntervalMs()) * time.Millisecond,
+		Save:     req.GetSave(),
+	})
+	if err != nil {
+		return grpcError(http.StatusInternalServerError, err)
+	}
+	for report := range reports {
+		if err := stream.Send(newProtoReport(&report)); err != nil {
+			return err
+		}
+	}
+	return status.FromContextError(ctx.Err()).Err()
+}
+
+// authorize returns the client of the namespace a call asked for, if the
+// bearer token in its metadata grants perm there
+func (g *grpcService) authorize(ctx context.Context, perm Permission, namespace string) (*serverClient, error) {
+	var authorization string
+	if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+	principal, ok := g.server.authenticate(authorization)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+	}
+	client, code, err := g.server.authorize(principal, perm, namespace)
+	if err != nil {
+		return nil, grpcError(code, err)
+	}
+	return client, nil
+}
+
+// grpcError converts a failure of the HTTP API, given by its status, to the
+// status of a gRPC call
+func grpcError(httpStatus int, err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	code := codes.Internal
+	switch httpStatus {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	}
+	return status.Error(code, err.Error())
+}
+
+// newProtoSnapshotInfo converts a snapshot description to its protobuf form
+func newProtoSnapshotInfo(info jsonSnapshotInfo) *merklev1.SnapshotInfo {
+	return &merklev1.SnapshotInfo{
+		Id:        info.ID,
+		Folder:    info.Folder,
+		Timestamp: protoTime(info.Timestamp),
+		RootHash:  info.RootHash,
+		Files:     int64(info.Files),
+	}
+}
+
+// newProtoReport converts a change report to its protobuf form, which carries
+// the same fields as its JSON form
+func newProtoReport(report *ChangeReport) *merklev1.ChangeReport {
+	in := newJSONReport(report)
+	out := &merklev1.ChangeReport{
+		OldTimestamp: protoTime(in.OldTimestamp),
+		NewTimestamp: protoTime(in.NewTimestamp),
+		OldRootHash:  in.OldRootHash,
+		NewRootHash:  in.NewRootHash,
+		MaxSeverity:  in.MaxSeverity,
+		Changes:      make([]*merklev1.FileChange, 0, len(in.Changes)),
+		Errors:       make([]*merklev1.FileError, 0, len(in.Errors)),
+		BytesAdded:   in.BytesAdded,
+		BytesRemoved: in.BytesRemoved,
+		Stats: &merklev1.DiffStats{
+			AddedBytes:        in.Stats.AddedBytes,
+			RemovedBytes:      in.Stats.RemovedBytes,
+			ModifiedBytes:     in.Stats.ModifiedBytes,
+			LargestFile:       in.Stats.LargestFile,
+			LargestFileBytes:  in.Stats.LargestFileBytes,
+			BusiestDir:        in.Stats.BusiestDir,
+			BusiestDirChanges: int64(in.Stats.BusiestDirChanges),
+		},
+	}
+	for _, change := range in.Changes {
+		out.Changes = append(out.Changes, &merklev1.FileChange{
+			FilePath:     change.FileName,
+			OldPath:      change.OldPath,
+			NewPath:      change.NewPath,
+			ChangeType:   change.ChangeType,
+			Severity:     change.Severity,
+			Acknowledged: change.Acknowledged,
+			OldHash:      change.OldHash,
+			NewHash:      change.NewHash,
+			OldSize:      change.OldSize,
+			NewSize:      change.NewSize,
+			Content:      change.Content,
+			Recheck:      change.Recheck,
+		})
+	}
+	for _, fileErr := range in.Errors {
+		out.Errors = append(out.Errors, &merklev1.FileError{
+			FilePath: fileErr.FileName,
+			OldError: fileErr.OldError,
+			NewError: fileErr.NewError,
+		})
+	}
+	return out
+}
+
+// protoTime converts a time to a protobuf timestamp, nil when unset
+func protoTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
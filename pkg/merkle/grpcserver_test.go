@@ -0,0 +1,171 @@
+//go:build !readonly
+
+package merkle
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	merklev1 "github.com/Ridwan414/file-change-detector/api/merkle/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcTestClient serves GRPCService of a server over an in-memory connection
+func grpcTestClient(t *testing.T, server *Server) merklev1.MerkleServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	merklev1.RegisterMerkleServiceServer(grpcServer, server.GRPCService())
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return merklev1.NewMerkleServiceClient(conn)
+}
+
+// withToken returns a context sending a bearer token
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestGRPCServiceRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "alpha", "sub/b.txt": "beta"})
+	storage := t.TempDir()
+
+	// A snapshot from a minute ago, so the one taken over gRPC gets its own ID
+	local := NewClient(storage)
+	state, err := local.CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Timestamp = state.Timestamp.Add(-time.Minute)
+	if err := local.SaveSnapshot(state, dir); err != nil {
+		t.Fatal(err)
+	}
+	first, err := local.FindLatestSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := grpcTestClient(t, &Server{
+		StorageDir: storage,
+		Tokens: &TokenAuthorizer{tokens: map[string]Principal{
+			HashToken("operator-token"): {Role: RoleOperator},
+			HashToken("viewer-token"):   {Role: RoleViewer},
+		}},
+	})
+
+	_, err = client.GetRootHash(context.Background(), &merklev1.GetRootHashRequest{Folder: dir})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("call without a token: %v, want Unauthenticated", err)
+	}
+	_, err = client.CreateSnapshot(withToken("viewer-token"), &merklev1.CreateSnapshotRequest{Folder: dir})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("viewer creating a snapshot: %v, want PermissionDenied", err)
+	}
+
+	writeFiles(t, dir, map[string]string{"a.txt": "alpha, edited"})
+	created, err := client.CreateSnapshot(withToken("operator-token"), &merklev1.CreateSnapshotRequest{Folder: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.GetFiles() != 2 || created.GetId() == snapshotStem(first) || created.GetTimestamp() == nil {
+		t.Errorf("created snapshot = %v", created)
+	}
+
+	root, err := client.GetRootHash(withToken("viewer-token"), &merklev1.GetRootHashRequest{Folder: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.GetId() != created.GetId() || root.GetRootHash() != created.GetRootHash() {
+		t.Errorf("root = %v, want the snapshot just created, %v", root, created)
+	}
+
+	report, err := client.CompareSnapshots(withToken("viewer-token"), &merklev1.CompareSnapshotsRequest{From: snapshotStem(first), To: created.GetId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.GetOldRootHash() != hex.EncodeToString(state.RootHash) || report.GetNewRootHash() != created.GetRootHash() {
+		t.Errorf("report root hashes = %s -> %s", report.GetOldRootHash(), report.GetNewRootHash())
+	}
+	if len(report.GetChanges()) != 1 || report.GetChanges()[0].GetFilePath() != "a.txt" || report.GetChanges()[0].GetChangeType() != "MODIFIED" {
+		t.Errorf("changes = %v, want a.txt modified", report.GetChanges())
+	}
+
+	_, err = client.CompareSnapshots(withToken("viewer-token"), &merklev1.CompareSnapshotsRequest{From: "../" + snapshotStem(first), To: created.GetId()})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("comparing a path: %v, want InvalidArgument", err)
+	}
+	_, err = client.GetRootHash(withToken("viewer-token"), &merklev1.GetRootHashRequest{Folder: t.TempDir()})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("root of a folder never scanned: %v, want NotFound", err)
+	}
+}
+
+func TestGRPCServiceStreamsChanges(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "alpha"})
+	client := grpcTestClient(t, &Server{StorageDir: t.TempDir()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := client.StreamChanges(ctx, &merklev1.StreamChangesRequest{Folder: dir, IntervalMs: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep writing until the watcher reports the file, as the first writes
+	// may come before its first scan
+	reports := make(chan *merklev1.ChangeReport)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			report, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case reports <- report:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for i := 0; ; i++ {
+		if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte{byte(i)}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case report := <-reports:
+			for _, change := range report.GetChanges() {
+				if change.GetFilePath() == "b.txt" {
+					cancel()
+					return
+				}
+			}
+		case err := <-errs:
+			t.Fatalf("stream ended: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
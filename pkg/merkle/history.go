@@ -0,0 +1,254 @@
+package merkle
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// historyFile is the append-only log of every recorded change event
+const historyFile = "changes.csv"
+
+var historyHeader = []string{
+	"folder", "previous_timestamp", "detected_timestamp", "file_path",
+	"change_type", "old_hash", "new_hash", "old_size", "new_size",
+}
+
+// ChangeEvent is a FileChange recorded in the history store
+type ChangeEvent struct {
+	FileChange
+	Folder     string    // name of the monitored folder
+	PreviousAt time.Time // timestamp of the older state of the comparison
+	DetectedAt time.Time // timestamp of the newer state of the comparison
+}
+
+// historyIndex keeps the change log in memory, indexed by path and ordered by time
+type historyIndex struct {
+	mu     sync.Mutex
+	loaded bool
+	events []ChangeEvent    // ordered by DetectedAt
+	byPath map[string][]int // file path -> positions in events
+}
+
+// RecordChanges appends every change in report to the history store
+func (c *MerkleClient) RecordChanges(folderPath string, report *ChangeReport) error {
+	if len(report.Changes) == 0 {
+		return nil
+	}
+
+	unlock, err := c.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	filename := filepath.Join(c.storageDir, historyFile)
+	if err := appendEvents(filename, EventsFromReport(folderPath, report)); err != nil {
+		return err
+	}
+
+	// The index is rebuilt from disk on the next query
+	c.history.mu.Lock()
+	c.history.loaded = false
+	c.history.mu.Unlock()
+
+	return nil
+}
+
+// EventsFromReport converts the changes in a report into change events
+func EventsFromReport(folderPath string, report *ChangeReport) []ChangeEvent {
+	events := make([]ChangeEvent, len(report.Changes))
+	for i, change := range report.Changes {
+		events[i] = ChangeEvent{
+			FileChange: change,
+			Folder:     filepath.Base(folderPath),
+			PreviousAt: report.OldTimestamp,
+			DetectedAt: report.NewTimestamp,
+		}
+	}
+	return events
+}
+
+// appendEvents appends events to a change log, creating it with a header if needed
+func appendEvents(filename string, events []ChangeEvent) error {
+	_, statErr := os.Stat(filename)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if os.IsNotExist(statErr) {
+		if err := writer.Write(historyHeader); err != nil {
+			return err
+		}
+	}
+
+	for _, event := range events {
+		if err := writer.Write(eventRow(event)); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// eventRow encodes a change event as a change log row
+func eventRow(event ChangeEvent) []string {
+	return []string{
+		event.Folder,
+		event.PreviousAt.Format(time.RFC3339),
+		event.DetectedAt.Format(time.RFC3339),
+		event.FileName,
+		GetChangeTypeString(event.ChangeType),
+		hex.EncodeToString(event.OldHash),
+		hex.EncodeToString(event.NewHash),
+		strconv.FormatInt(event.OldSize, 10),
+		strconv.FormatInt(event.NewSize, 10),
+	}
+}
+
+// HistoryForPath returns every recorded change to a file, oldest first
+func (c *MerkleClient) HistoryForPath(path string) ([]ChangeEvent, error) {
+	c.history.mu.Lock()
+	defer c.history.mu.Unlock()
+
+	if err := c.loadHistory(); err != nil {
+		return nil, err
+	}
+
+	positions := c.history.byPath[filepath.ToSlash(path)]
+	events := make([]ChangeEvent, len(positions))
+	for i, pos := range positions {
+		events[i] = c.history.events[pos]
+	}
+	return events, nil
+}
+
+// HistoryBetween returns every change detected in [from, to), oldest first
+func (c *MerkleClient) HistoryBetween(from, to time.Time) ([]ChangeEvent, error) {
+	c.history.mu.Lock()
+	defer c.history.mu.Unlock()
+
+	if err := c.loadHistory(); err != nil {
+		return nil, err
+	}
+
+	events := c.history.events
+	start := sort.Search(len(events), func(i int) bool {
+		return !events[i].DetectedAt.Before(from)
+	})
+	end := sort.Search(len(events), func(i int) bool {
+		return !events[i].DetectedAt.Before(to)
+	})
+	return append([]ChangeEvent(nil), events[start:end]...), nil
+}
+
+// loadHistory reads the change log and builds the path index; the caller
+// must hold c.history.mu
+func (c *MerkleClient) loadHistory() error {
+	if c.history.loaded {
+		return nil
+	}
+
+	events, err := readEvents(filepath.Join(c.storageDir, historyFile))
+	if err != nil {
+		return err
+	}
+	acks, err := readAcknowledgements(filepath.Join(c.storageDir, ackFile))
+	if err != nil {
+		return err
+	}
+	acked := indexAcknowledgements(acks)
+	for i := range events {
+		events[i].Acknowledged = acked.covers(events[i].Folder, events[i].FileChange)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].DetectedAt.Before(events[j].DetectedAt)
+	})
+
+	byPath := make(map[string][]int)
+	for i, event := range events {
+		byPath[event.FileName] = append(byPath[event.FileName], i)
+	}
+
+	c.history.events = events
+	c.history.byPath = byPath
+	c.history.loaded = true
+	return nil
+}
+
+// readEvents parses a change log, which may not exist yet
+func readEvents(filename string) ([]ChangeEvent, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = len(historyHeader)
+
+	if _, err := reader.Read(); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var events []ChangeEvent
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		changeType, ok := parseChangeType(row[4])
+		if !ok {
+			return nil, fmt.Errorf("invalid change type in history: %s", row[4])
+		}
+
+		event := ChangeEvent{Folder: row[0]}
+		event.PreviousAt, _ = time.Parse(time.RFC3339, row[1])
+		event.DetectedAt, _ = time.Parse(time.RFC3339, row[2])
+		event.FileName = row[3]
+		event.ChangeType = changeType
+		event.OldHash, _ = hex.DecodeString(row[5])
+		event.NewHash, _ = hex.DecodeString(row[6])
+		event.OldSize, _ = strconv.ParseInt(row[7], 10, 64)
+		event.NewSize, _ = strconv.ParseInt(row[8], 10, 64)
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// parseChangeType is the inverse of GetChangeTypeString
+func parseChangeType(s string) (ChangeType, bool) {
+	for _, t := range []ChangeType{Modified, Added, Deleted, CaseRenamed, AttributesChanged, Renamed, Moved} {
+		if GetChangeTypeString(t) == s {
+			return t, true
+		}
+	}
+	return 0, false
+}
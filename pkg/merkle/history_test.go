@@ -0,0 +1,72 @@
+package merkle
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Recorded changes are found by path and by time, by the client that recorded
+// them and by a new one reading the log from disk
+func TestHistoryByPathAndTime(t *testing.T) {
+	storage := t.TempDir()
+	client := NewClient(storage)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return start.Add(time.Duration(hours) * time.Hour) }
+
+	// A query before anything is recorded must not hide later records
+	if events, err := client.HistoryForPath("a.txt"); err != nil || len(events) != 0 {
+		t.Fatalf("empty history = %v, %v", events, err)
+	}
+
+	for _, report := range []*ChangeReport{
+		{OldTimestamp: at(0), NewTimestamp: at(1), Changes: []FileChange{
+			{FileName: "a.txt", ChangeType: Added, NewHash: []byte{1}, NewSize: 1},
+			{FileName: "dir/b.txt", ChangeType: Added, NewHash: []byte{2}, NewSize: 2},
+		}},
+		{OldTimestamp: at(1), NewTimestamp: at(2), Changes: []FileChange{
+			{FileName: "a.txt", ChangeType: Modified, OldHash: []byte{1}, NewHash: []byte{3}, OldSize: 1, NewSize: 3},
+		}},
+		{OldTimestamp: at(2), NewTimestamp: at(3)},
+		{OldTimestamp: at(3), NewTimestamp: at(4), Changes: []FileChange{
+			{FileName: "a.txt", ChangeType: Deleted, OldHash: []byte{3}, OldSize: 3},
+		}},
+	} {
+		if err := client.RecordChanges("/srv/app", report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, c := range map[string]Client{"recording client": client, "new client": NewClient(storage)} {
+		events, err := c.HistoryForPath("a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []ChangeType{Added, Modified, Deleted}
+		detected := []int{1, 2, 4}
+		if len(events) != len(want) {
+			t.Fatalf("%s: %d events of a.txt, want %d", name, len(events), len(want))
+		}
+		for i, event := range events {
+			hour := detected[i]
+			if event.ChangeType != want[i] || event.Folder != "app" || !event.DetectedAt.Equal(at(hour)) || !event.PreviousAt.Equal(at(hour-1)) {
+				t.Errorf("%s: event %d = %s in %s at %s, want %s in app at %s",
+					name, i, GetChangeTypeString(event.ChangeType), event.Folder, event.DetectedAt, GetChangeTypeString(want[i]), at(hour))
+			}
+		}
+		if !bytes.Equal(events[1].OldHash, []byte{1}) || !bytes.Equal(events[1].NewHash, []byte{3}) || events[1].NewSize != 3 {
+			t.Errorf("%s: modification of a.txt = %+v", name, events[1].FileChange)
+		}
+
+		between, err := c.HistoryBetween(at(1), at(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(between) != 2 || between[0].FileName != "a.txt" || between[1].FileName != "dir/b.txt" {
+			t.Errorf("%s: changes detected in [1h, 2h) = %+v, want those of the first comparison", name, between)
+		}
+		if later, err := c.HistoryBetween(at(2), at(5)); err != nil || len(later) != 2 {
+			t.Errorf("%s: changes detected in [2h, 5h) = %+v, %v; want 2", name, later, err)
+		}
+	}
+}
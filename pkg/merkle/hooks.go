@@ -0,0 +1,126 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ActionHook runs a command when a comparison finds changes of the given
+// types to files matching a pattern, e.g. reloading nginx only when files
+// under conf.d/ are modified
+type ActionHook struct {
+	Types   []ChangeType // types that trigger the hook; empty for every type
+	Pattern string       // empty for every file; see Matches
+	Command string       // run through the platform shell
+}
+
+// ParseActionHook parses a hook written as "<types>[:<pattern>]=<command>",
+// where types is a comma-separated list of change types (modified, added,
+// deleted, case_renamed, attributes, renamed, moved) or "*" for all of them:
+//
+//	modified:conf.d/=systemctl reload nginx
+//	added,deleted:*.md=make docs-index
+func ParseActionHook(spec string) (ActionHook, error) {
+	selector, command, ok := strings.Cut(spec, "=")
+	if !ok || strings.TrimSpace(command) == "" {
+		return ActionHook{}, fmt.Errorf("invalid hook %q: want <types>[:<pattern>]=<command>", spec)
+	}
+
+	types, pattern, err := parseChangeSelector(selector)
+	if err != nil {
+		return ActionHook{}, fmt.Errorf("invalid hook %q: %v", spec, err)
+	}
+	return ActionHook{Types: types, Pattern: pattern, Command: command}, nil
+}
+
+// parseChangeSelector parses the "<types>[:<pattern>]" part of a hook or
+// severity rule, returning no types for "*"
+func parseChangeSelector(selector string) ([]ChangeType, string, error) {
+	names, pattern, _ := strings.Cut(selector, ":")
+	if pattern != "" && !strings.HasSuffix(pattern, "/") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, "", fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if names == "*" {
+		return nil, pattern, nil
+	}
+
+	var types []ChangeType
+	for _, name := range strings.Split(names, ",") {
+		changeType, ok := parseChangeType(strings.ToUpper(strings.TrimSpace(name)))
+		if !ok {
+			return nil, "", fmt.Errorf("unknown change type %q", name)
+		}
+		types = append(types, changeType)
+	}
+	return types, pattern, nil
+}
+
+// Matches reports whether a change triggers the hook; see matchPathPattern
+// for the pattern syntax
+func (h ActionHook) Matches(change FileChange) bool {
+	if len(h.Types) > 0 {
+		found := false
+		for _, t := range h.Types {
+			found = found || t == change.ChangeType
+		}
+		if !found {
+			return false
+		}
+	}
+	return h.Pattern == "" || matchPathPattern(h.Pattern, change.FileName)
+}
+
+// matchPathPattern reports whether a relative path matches a pattern. A
+// pattern ending in "/" matches every file below that directory; other
+// patterns use path.Match syntax against the relative path or the base name,
+// as WithTransformer does.
+func matchPathPattern(pattern, relPath string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(relPath, pattern)
+	}
+	if matched, _ := path.Match(pattern, relPath); matched {
+		return true
+	}
+	matched, _ := path.Match(pattern, path.Base(relPath))
+	return matched
+}
+
+// RunActionHooks runs every hook matched by at least one change in the
+// report, once per report. The matching changes are written to the command's
+// stdin as "<TYPE> <path>" lines, and FCD_FOLDER, FCD_CHANGE_COUNT and
+// FCD_MAX_SEVERITY (info, warning or critical) are set in its environment. All hooks are run even if some fail; the failures are
+// returned together.
+func RunActionHooks(folderPath string, report *ChangeReport, hooks []ActionHook) error {
+	var errs []error
+	for _, hook := range hooks {
+		var input bytes.Buffer
+		count := 0
+		severity := SeverityInfo
+		for _, change := range report.Changes {
+			if hook.Matches(change) {
+				fmt.Fprintf(&input, "%s %s\n", GetChangeTypeString(change.ChangeType), change.FileName)
+				count++
+				severity = max(severity, change.Severity)
+			}
+		}
+		if count == 0 {
+			continue
+		}
+
+		cmd := shellCommand(hook.Command)
+		cmd.Env = append(os.Environ(), "FCD_FOLDER="+folderPath, "FCD_CHANGE_COUNT="+strconv.Itoa(count),
+			"FCD_MAX_SEVERITY="+severity.String())
+		cmd.Stdin = &input
+		if out, err := cmd.CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("hook %q failed: %v: %s", hook.Command, err, bytes.TrimSpace(out)))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,101 @@
+package merkle
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReportStyle chooses how text reports show times and sizes. The zero style
+// shows them exactly, as scripts reading the reports expect.
+type ReportStyle struct {
+	RelativeTimes bool      // follow times with their zone and how long ago they were
+	HumanSizes    bool      // show sizes in KiB, MiB and so on instead of bytes
+	Now           time.Time // what relative times are relative to; zero for the time of writing
+}
+
+// reportTimeLayout is how text reports show times
+const reportTimeLayout = "2006-01-02 15:04:05"
+
+// time formats a time, e.g. "2024-05-02 03:14:00" or, with relative times,
+// "2024-05-02 03:14:00 UTC (6 hours ago)"
+func (s ReportStyle) time(t time.Time) string {
+	if !s.RelativeTimes {
+		return t.Format(reportTimeLayout)
+	}
+	now := s.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return fmt.Sprintf("%s (%s)", t.Format(reportTimeLayout+" MST"), FormatAge(t, now))
+}
+
+// size formats a size, e.g. "1536 bytes" or "1.5 KiB"
+func (s ReportStyle) size(n int64) string {
+	if s.HumanSizes {
+		return FormatBytes(n)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// delta formats a signed size difference, e.g. "+1536" or "+1.5 KiB"
+func (s ReportStyle) delta(n int64) string {
+	if !s.HumanSizes {
+		return fmt.Sprintf("%+d", n)
+	}
+	if n < 0 {
+		return "-" + FormatBytes(-n)
+	}
+	return "+" + FormatBytes(n)
+}
+
+// FormatBytes formats a byte count with a binary unit, e.g. 1536 as "1.5 KiB"
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < 0 {
+		return "-" + FormatBytes(-n)
+	}
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// FormatAge says how long before now t was, in its largest whole unit, e.g.
+// "6 hours ago", "just now" for less than a minute, or "in 5 minutes" for
+// times after now
+func FormatAge(t, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	var n int
+	var unit string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < 48*time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	case d < 60*24*time.Hour:
+		n, unit = int(d/(24*time.Hour)), "day"
+	case d < 2*365*24*time.Hour:
+		n, unit = int(d/(30*24*time.Hour)), "month"
+	default:
+		n, unit = int(d/(365*24*time.Hour)), "year"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	if future {
+		return fmt.Sprintf("in %d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %s ago", n, unit)
+}
@@ -0,0 +1,301 @@
+package merkle
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Names of the ignore files WithIgnoreFiles is usually given
+const (
+	GitIgnoreFile    = ".gitignore"
+	MerkleIgnoreFile = ".merkleignore"
+)
+
+// WithExcludes leaves out files and directories matching any of the patterns,
+// written as lines of a .gitignore file relative to the scanned folder:
+// "node_modules/" skips every directory of that name, "/build" only the one at
+// the top, "*.log" files ending in .log at any depth, "docs/**/*.tmp" uses
+// "**" for any number of directories, and "!keep.log" re-includes what an
+// earlier pattern excluded. Excluded directories are not descended into.
+// These patterns take precedence over those of ignore files.
+func WithExcludes(patterns ...string) Option {
+	return func(c *MerkleClient) {
+		for _, pattern := range patterns {
+			if rule, ok := parseIgnoreRule(pattern, ""); ok {
+				c.scan.excludes = append(c.scan.excludes, rule)
+			}
+		}
+	}
+}
+
+// WithIncludes restricts scans to files matching at least one of the patterns,
+// in the same syntax as WithExcludes. Directories are still descended into,
+// and excluded files stay excluded even if they match.
+func WithIncludes(patterns ...string) Option {
+	return func(c *MerkleClient) {
+		for _, pattern := range patterns {
+			if rule, ok := parseIgnoreRule(pattern, ""); ok && !rule.negate {
+				c.scan.includes = append(c.scan.includes, rule)
+			}
+		}
+	}
+}
+
+// WithIgnoreFiles honors ignore files with the given names, such as
+// GitIgnoreFile and MerkleIgnoreFile, in the scanned folder and every
+// directory below it. As with git, the patterns of a file apply to its own
+// directory and are overridden by those of deeper ones, and the last matching
+// line of a file wins. When .gitignore files are honored, .git itself is
+// left out too. File sets and container images list their files explicitly
+// and ignore files are not read for them.
+func WithIgnoreFiles(names ...string) Option {
+	return func(c *MerkleClient) {
+		c.scan.ignoreFiles = names
+	}
+}
+
+// ignoreRule is one pattern of an exclude list or ignore file
+type ignoreRule struct {
+	base     string // directory of the ignore file, "" for the scanned folder
+	negate   bool   // "!pattern" re-includes what earlier rules excluded
+	dirOnly  bool   // "pattern/" only matches directories
+	anchored bool   // a pattern with a slash matches paths below base, others any name
+	re       *regexp.Regexp
+}
+
+// String describes the rule for filterFingerprint
+func (r ignoreRule) String() string {
+	return fmt.Sprintf("base=%s negate=%t dir=%t anchored=%t %s", r.base, r.negate, r.dirOnly, r.anchored, r.re)
+}
+
+// parseIgnoreRule parses one line of an ignore file found in base. Blank
+// lines, comments and malformed patterns yield false.
+func parseIgnoreRule(line, base string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	re, err := regexp.Compile(globRegexp(line))
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// globRegexp translates a gitignore glob into an anchored regular expression
+func globRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// match reports whether the rule matches a path relative to the scanned folder
+func (r ignoreRule) match(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(relPath, r.base+"/") {
+			return false
+		}
+		relPath = relPath[len(r.base)+1:]
+	}
+	if !r.anchored {
+		relPath = path.Base(relPath)
+	}
+	return r.re.MatchString(relPath)
+}
+
+// ignoreMatcher decides which paths of one scan are left out. Ignore files
+// are read as the scan first reaches their directory.
+type ignoreMatcher struct {
+	root     string // folder to read ignore files from; "" reads none
+	opts     scanOptions
+	rules    map[string][]ignoreRule // rules applying inside each directory
+	excluded map[string]bool         // directories already decided
+}
+
+// newIgnoreMatcher returns the matcher for a scan of root, or nil when the
+// options leave nothing out
+func newIgnoreMatcher(root string, opts scanOptions) *ignoreMatcher {
+	if len(opts.excludes) == 0 && len(opts.includes) == 0 && (root == "" || len(opts.ignoreFiles) == 0) {
+		return nil
+	}
+	return &ignoreMatcher{
+		root:     root,
+		opts:     opts,
+		rules:    make(map[string][]ignoreRule),
+		excluded: make(map[string]bool),
+	}
+}
+
+// skip reports whether a path relative to the scanned folder is left out,
+// because it or a directory above it is excluded or because it is a file no
+// include pattern matches. It is a no-op on a nil matcher.
+func (m *ignoreMatcher) skip(relPath string, isDir bool) (bool, error) {
+	if m == nil || relPath == "." {
+		return false, nil
+	}
+
+	// Files of an excluded directory cannot be re-included, as with git
+	dir := path.Dir(relPath)
+	if dir != "." {
+		excluded, ok := m.excluded[dir]
+		if !ok {
+			var err error
+			if excluded, err = m.skip(dir, true); err != nil {
+				return false, err
+			}
+			m.excluded[dir] = excluded
+		}
+		if excluded {
+			return true, nil
+		}
+	} else {
+		dir = ""
+	}
+
+	rules, err := m.rulesIn(dir)
+	if err != nil {
+		return false, err
+	}
+	skipped := false
+	for _, rule := range rules {
+		if rule.match(relPath, isDir) {
+			skipped = !rule.negate
+		}
+	}
+	if skipped || isDir || len(m.opts.includes) == 0 {
+		return skipped, nil
+	}
+
+	for _, rule := range m.opts.includes {
+		if rule.match(relPath, false) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// rulesIn returns the rules applying to the entries of a directory in order of
+// increasing precedence: ignore files from the top down, then WithExcludes
+func (m *ignoreMatcher) rulesIn(dir string) ([]ignoreRule, error) {
+	if rules, ok := m.rules[dir]; ok {
+		return rules, nil
+	}
+
+	var rules []ignoreRule
+	if dir != "" {
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		inherited, err := m.rulesIn(parent)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, inherited[:len(inherited)-len(m.opts.excludes)]...)
+	} else if m.root != "" {
+		for _, name := range m.opts.ignoreFiles {
+			if name == GitIgnoreFile {
+				rule, _ := parseIgnoreRule(".git", "")
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	if m.root != "" {
+		for _, name := range m.opts.ignoreFiles {
+			own, err := readIgnoreFile(filepath.Join(m.root, filepath.FromSlash(dir), name), dir)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, own...)
+		}
+	}
+
+	rules = append(rules, m.opts.excludes...)
+	m.rules[dir] = rules
+	return rules, nil
+}
+
+// readIgnoreFile parses an ignore file of the directory base, which may not
+// exist
+func readIgnoreFile(file, base string) ([]ignoreRule, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text(), base); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
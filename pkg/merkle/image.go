@@ -0,0 +1,575 @@
+package merkle
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Container images are scanned by prefixing their path with a transport, as
+// skopeo does: "oci:<dir>" for an OCI image layout and "docker-archive:<file>"
+// for a tarball written by docker save. Snapshots, comparisons and history of
+// an image work exactly like those of a folder whose files are the image's
+// flattened file system.
+const (
+	ociLayoutPrefix     = "oci:"
+	dockerArchivePrefix = "docker-archive:"
+)
+
+// ociRefAnnotation names an image in an OCI layout's index
+const ociRefAnnotation = "org.opencontainers.image.ref.name"
+
+// OCILayoutPath returns the folder path under which the OCI image layout in
+// dir is snapshotted
+func OCILayoutPath(dir string) string {
+	return ociLayoutPrefix + dir
+}
+
+// DockerArchivePath returns the folder path under which a docker save tarball
+// is snapshotted
+func DockerArchivePath(file string) string {
+	return dockerArchivePrefix + file
+}
+
+// IsImagePath reports whether folderPath names a container image
+func IsImagePath(folderPath string) bool {
+	_, _, ok := splitImagePath(folderPath)
+	return ok
+}
+
+// splitImagePath splits an image path into its transport prefix and the path
+// of the image on disk
+func splitImagePath(folderPath string) (string, string, bool) {
+	for _, prefix := range []string{ociLayoutPrefix, dockerArchivePrefix} {
+		if rest, ok := strings.CutPrefix(folderPath, prefix); ok {
+			return prefix, rest, true
+		}
+	}
+	return "", "", false
+}
+
+// WithImageRef selects which image of an OCI layout or docker save tarball
+// holding several is scanned: an OCI ref name such as "v1.2", or a docker
+// repository tag such as "app:1.2". Sources holding a single image need no
+// ref.
+func WithImageRef(ref string) Option {
+	return func(c *MerkleClient) {
+		c.imageRef = ref
+	}
+}
+
+// imageSource gives access to the layers of one image
+type imageSource interface {
+	// layers returns the names of the image's layer blobs, bottom layer first
+	layers(ref string) ([]string, error)
+
+	// open returns the uncompressed or compressed content of a layer blob
+	open(layer string) (io.ReadCloser, error)
+
+	Close() error
+}
+
+// openImageSource opens the image named by an image path
+func openImageSource(folderPath string) (imageSource, error) {
+	prefix, imagePath, _ := splitImagePath(folderPath)
+	if prefix == ociLayoutPrefix {
+		if _, err := os.Stat(filepath.Join(imagePath, "oci-layout")); err != nil {
+			return nil, fmt.Errorf("not an OCI image layout: %s", imagePath)
+		}
+		return ociLayout{dir: imagePath}, nil
+	}
+
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	return &dockerArchive{file: file}, nil
+}
+
+// imageTree builds the Merkle tree of a container image by flattening its
+// layers. Files are hashed like those of a folder; symbolic links, which
+// cannot be followed outside the image, are hashed by their target. Empty
+// directories, ownership and permissions are not recorded, as in folders.
+func (c *MerkleClient) imageTree(folderPath string, opts scanOptions) (*MerkleTree, error) {
+	if err := validateDigests(opts.extraDigests); err != nil {
+		return nil, err
+	}
+
+	source, err := openImageSource(folderPath)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	layers, err := source.layers(c.imageRef)
+	if err != nil {
+		return nil, err
+	}
+	files, err := flattenLayers(source, layers)
+	if err != nil {
+		return nil, err
+	}
+	ignored := newIgnoreMatcher("", opts)
+	for name := range files {
+		if skip, _ := ignored.skip(name, false); skip {
+			delete(files, name)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found in image")
+	}
+
+	leafNodes, err := hashImageFiles(source, layers, files, folderPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(leafNodes) == 0 {
+		return nil, fmt.Errorf("no files found in image")
+	}
+
+	for _, leaf := range leafNodes {
+		leaf.FileName = opts.leafOrder.path(leaf.FileName)
+	}
+	sortLeaves(leafNodes, opts.leafOrder)
+	if err := checkNormalizedLeaves(leafNodes, opts.leafOrder); err != nil {
+		return nil, err
+	}
+	return newMerkleTree(leafNodes, opts.scheme())
+}
+
+// imageFile is a file of the flattened image and the layer entry its content
+// comes from
+type imageFile struct {
+	header *tar.Header
+	layer  int // layer holding the content
+	entry  int // position of the content's entry in that layer
+}
+
+// flattenLayers applies the layers in order, as an overlay file system
+// would, and returns the files of the result by path. Whiteout entries
+// (".wh.<name>") delete a path from lower layers and opaque markers
+// (".wh..wh..opq") empty a directory of lower layers' files.
+func flattenLayers(source imageSource, layers []string) (map[string]imageFile, error) {
+	files := make(map[string]imageFile)
+	added := make(map[string]int) // layer that added each path
+
+	removeTree := func(dir string) {
+		for p := range files {
+			if p == dir || strings.HasPrefix(p, dir+"/") {
+				delete(files, p)
+			}
+		}
+	}
+
+	for i, layer := range layers {
+		var whiteouts, opaque []string
+		var entries []imageFile
+		err := readLayer(source, layer, func(n int, header *tar.Header, _ io.Reader) error {
+			name := layerPath(header.Name)
+			if name == "" {
+				return nil
+			}
+			dir, base := path.Split(name)
+			switch {
+			case base == ".wh..wh..opq":
+				opaque = append(opaque, strings.TrimSuffix(dir, "/"))
+			case strings.HasPrefix(base, ".wh."):
+				whiteouts = append(whiteouts, dir+strings.TrimPrefix(base, ".wh."))
+			default:
+				entries = append(entries, imageFile{header: header, layer: i, entry: n})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %v", layer, err)
+		}
+
+		// Whiteouts only hide what lower layers provided
+		for _, dir := range opaque {
+			for p := range files {
+				if dir == "" || strings.HasPrefix(p, dir+"/") {
+					delete(files, p)
+				}
+			}
+		}
+		for _, p := range whiteouts {
+			removeTree(p)
+		}
+
+		for _, entry := range entries {
+			header := entry.header
+			name := layerPath(header.Name)
+			switch header.Typeflag {
+			case tar.TypeDir:
+				// A directory replaces a file of the same name
+				delete(files, name)
+			case tar.TypeLink:
+				// A hard link shares the content its target has at this point
+				target, ok := files[layerPath(header.Linkname)]
+				if !ok {
+					return nil, fmt.Errorf("layer %s: hard link %s to missing %s", layer, name, header.Linkname)
+				}
+				files[name] = target
+				added[name] = i
+			default:
+				files[name] = entry
+				added[name] = i
+			}
+		}
+	}
+
+	// A file that replaced a directory hides the lower layers' files below it
+	for p := range files {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if _, isFile := files[dir]; isFile && added[dir] > added[p] {
+				delete(files, p)
+				break
+			}
+		}
+	}
+	return files, nil
+}
+
+// hashImageFiles hashes the files of a flattened image, reading each layer
+// once and only the entries whose content survived
+func hashImageFiles(source imageSource, layers []string, files map[string]imageFile, folderPath string, opts scanOptions) ([]*MerkleNode, error) {
+	// Paths sharing content through hard links are hashed once
+	type contentKey struct {
+		layer int
+		entry int
+	}
+	paths := make(map[contentKey][]string)
+	var leafNodes []*MerkleNode
+	for name, file := range files {
+		mode := file.header.FileInfo().Mode()
+		switch {
+		case file.header.Typeflag == tar.TypeSymlink:
+			digest, err := hashContent(strings.NewReader(file.header.Linkname), folderPath+":"+name, name, opts)
+			if err != nil {
+				return nil, err
+			}
+			leafNodes = append(leafNodes, imageLeaf(name, digest, int64(len(file.header.Linkname))))
+		case isSpecialFile(mode):
+			if opts.specialFiles {
+				leafNodes = append(leafNodes, &MerkleNode{
+					Hash:     hashSpecialFile(mode),
+					IsLeaf:   true,
+					FileName: name,
+					Special:  true,
+				})
+			}
+		default:
+			key := contentKey{file.layer, file.entry}
+			paths[key] = append(paths[key], name)
+		}
+	}
+
+	for i, layer := range layers {
+		err := readLayer(source, layer, func(n int, header *tar.Header, content io.Reader) error {
+			names := paths[contentKey{i, n}]
+			if len(names) == 0 {
+				return nil
+			}
+			digest, err := hashContent(content, folderPath+":"+names[0], names[0], opts)
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				leafNodes = append(leafNodes, imageLeaf(name, digest, header.Size))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", layer, err)
+		}
+	}
+	return leafNodes, nil
+}
+
+// imageLeaf returns the leaf of a hashed file of an image
+func imageLeaf(name string, digest fileDigest, size int64) *MerkleNode {
+	return &MerkleNode{
+		Hash:     digest.hash,
+		IsLeaf:   true,
+		FileName: name,
+		Size:     size,
+		Digests:  digest.extra,
+		Chunks:   digest.chunks,
+		Content:  digest.kind,
+	}
+}
+
+// layerPath returns the path of a layer entry relative to the image root,
+// or "" for the root itself
+func layerPath(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+// readLayer calls fn with the position, header and content of every entry of
+// a layer, decompressing it if needed
+func readLayer(source imageSource, layer string, fn func(int, *tar.Header, io.Reader) error) error {
+	blob, err := source.open(layer)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	br := bufio.NewReader(blob)
+	var r io.Reader = br
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return fmt.Errorf("zstd-compressed layers are not supported")
+	}
+
+	tr := tar.NewReader(r)
+	for n := 0; ; n++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(n, header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// ociLayout reads an image from an OCI image layout directory
+type ociLayout struct {
+	dir string
+}
+
+// ociDescriptor references a blob of an OCI layout
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform"`
+}
+
+// ociIndex is an OCI image index, including the layout's index.json
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest is an OCI or Docker v2 image manifest
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+const (
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	dockerListMediaType  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociLayoutIndexFile   = "index.json"
+	ociLayoutBlobsFolder = "blobs"
+)
+
+// layers resolves the image manifest selected by ref. A multi-platform image
+// index is resolved to the linux image for the architecture of this program.
+func (l ociLayout) layers(ref string) ([]string, error) {
+	var index ociIndex
+	if err := l.readJSON(filepath.Join(l.dir, ociLayoutIndexFile), &index); err != nil {
+		return nil, err
+	}
+
+	var candidates []ociDescriptor
+	var refs []string
+	for _, manifest := range index.Manifests {
+		name := manifest.Annotations[ociRefAnnotation]
+		refs = append(refs, name)
+		if ref == "" || name == ref {
+			candidates = append(candidates, manifest)
+		}
+	}
+	switch {
+	case len(candidates) == 0 && ref != "":
+		return nil, fmt.Errorf("no image %q in OCI layout %s (found %s)", ref, l.dir, strings.Join(refs, ", "))
+	case len(candidates) == 0:
+		return nil, fmt.Errorf("OCI layout %s holds no images", l.dir)
+	case len(candidates) > 1:
+		return nil, fmt.Errorf("OCI layout %s holds several images, select one of: %s", l.dir, strings.Join(refs, ", "))
+	}
+
+	descriptor := candidates[0]
+	for descriptor.MediaType == ociIndexMediaType || descriptor.MediaType == dockerListMediaType {
+		var nested ociIndex
+		if err := l.readBlobJSON(descriptor.Digest, &nested); err != nil {
+			return nil, err
+		}
+		found := false
+		for _, manifest := range nested.Manifests {
+			if manifest.Platform != nil && manifest.Platform.OS == "linux" && manifest.Platform.Architecture == runtime.GOARCH {
+				descriptor, found = manifest, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("image index %s has no linux/%s image", descriptor.Digest, runtime.GOARCH)
+		}
+	}
+
+	var manifest ociManifest
+	if err := l.readBlobJSON(descriptor.Digest, &manifest); err != nil {
+		return nil, err
+	}
+	layers := make([]string, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		layers[i] = layer.Digest
+	}
+	return layers, nil
+}
+
+// open opens a layer blob by digest
+func (l ociLayout) open(digest string) (io.ReadCloser, error) {
+	blobPath, err := l.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(blobPath)
+}
+
+// blobPath returns where a blob is stored, refusing digests that would
+// escape the blobs directory
+func (l ociLayout) blobPath(digest string) (string, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	valid := ok && algorithm != "" && encoded != ""
+	for _, r := range algorithm + encoded {
+		valid = valid && (r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '+' || r == '.' || r == '_' || r == '-')
+	}
+	if !valid {
+		return "", fmt.Errorf("invalid blob digest %q", digest)
+	}
+	return filepath.Join(l.dir, ociLayoutBlobsFolder, algorithm, encoded), nil
+}
+
+func (l ociLayout) readBlobJSON(digest string, v any) error {
+	blobPath, err := l.blobPath(digest)
+	if err != nil {
+		return err
+	}
+	return l.readJSON(blobPath, v)
+}
+
+func (l ociLayout) readJSON(file string, v any) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid %s: %v", file, err)
+	}
+	return nil
+}
+
+func (l ociLayout) Close() error {
+	return nil
+}
+
+// dockerArchive reads an image from a docker save tarball. Its entries are
+// found by reading the tar headers; the file is seekable, so the contents of
+// other entries are skipped rather than read.
+type dockerArchive struct {
+	file *os.File
+}
+
+// dockerManifestEntry is one image of a docker save manifest.json
+type dockerManifestEntry struct {
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// layers reads manifest.json and returns the layers of the image tagged ref
+func (a *dockerArchive) layers(ref string) ([]string, error) {
+	r, err := a.entry("manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest []dockerManifestEntry
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest.json in %s: %v", a.file.Name(), err)
+	}
+
+	var candidates []dockerManifestEntry
+	var tags []string
+	for _, image := range manifest {
+		tags = append(tags, image.RepoTags...)
+		matches := ref == ""
+		for _, tag := range image.RepoTags {
+			matches = matches || tag == ref
+		}
+		if matches {
+			candidates = append(candidates, image)
+		}
+	}
+	switch {
+	case len(candidates) == 0 && ref != "":
+		return nil, fmt.Errorf("no image %q in %s (found %s)", ref, a.file.Name(), strings.Join(tags, ", "))
+	case len(candidates) == 0:
+		return nil, fmt.Errorf("%s holds no images", a.file.Name())
+	case len(candidates) > 1:
+		return nil, fmt.Errorf("%s holds several images, select one of: %s", a.file.Name(), strings.Join(tags, ", "))
+	}
+	return candidates[0].Layers, nil
+}
+
+// open returns the content of a layer entry. Entries share the archive's
+// file offset, so only one can be read at a time.
+func (a *dockerArchive) open(layer string) (io.ReadCloser, error) {
+	r, err := a.entry(layer)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(r), nil
+}
+
+// entry returns a reader of the archive entry called name
+func (a *dockerArchive) entry(name string) (io.Reader, error) {
+	if _, err := a.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(a.file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s has no %s", a.file.Name(), name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if layerPath(header.Name) == layerPath(name) {
+			return tr, nil
+		}
+	}
+}
+
+func (a *dockerArchive) Close() error {
+	return a.file.Close()
+}
@@ -0,0 +1,56 @@
+package merkle
+
+// WithIncrementalSnapshots makes GetTree and CreateSnapshot of a folder reuse
+// the hashes of the latest snapshot for every file whose size, modification
+// time, change time and inode are unchanged since, and only hash the others.
+// SaveSnapshot records the stat of the files of a snapshot created this way
+// next to it, for the next run; until a folder has such a snapshot, its scans
+// hash every file. File sets and container images are always hashed in full.
+func WithIncrementalSnapshots(enabled bool) Option {
+	return func(c *MerkleClient) {
+		c.incremental = enabled
+	}
+}
+
+// WithFullRehash makes incremental snapshots hash every file again while
+// still recording their stat, for when a file may have changed without its
+// stat changing, such as after restoring a backup with its times preserved.
+func WithFullRehash(enabled bool) Option {
+	return func(c *MerkleClient) {
+		c.fullRehash = enabled
+	}
+}
+
+// scanTree builds the tree of a folder, incrementally when enabled. It
+// returns the leaf cache of the scan, to be recorded when its snapshot is
+// saved, or nil when the scan was not incremental.
+func (c *MerkleClient) scanTree(folderPath string, scan scanOptions) (*MerkleTree, *leafCache, error) {
+	if !c.incremental || IsSetPath(folderPath) || IsImagePath(folderPath) {
+		tree, err := c.buildTree(folderPath, scan)
+		return tree, nil, err
+	}
+
+	scan.cache = &leafCache{}
+	if !c.fullRehash {
+		// Without a usable stat cache the leaf cache stays empty and every
+		// file is hashed
+		c.warmStart(folderPath, scan)
+	}
+	tree, err := c.buildTree(folderPath, scan)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tree, scan.cache, nil
+}
+
+// createSnapshot scans a folder into a tree state that remembers the leaf
+// cache of an incremental scan for SaveSnapshot
+func (c *MerkleClient) createSnapshot(folderPath string, scan scanOptions) (*TreeState, error) {
+	tree, leaves, err := c.scanTree(folderPath, scan)
+	if err != nil {
+		return nil, err
+	}
+	state := c.stateFromTree(tree)
+	state.leaves = leaves
+	return state, nil
+}
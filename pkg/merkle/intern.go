@@ -0,0 +1,91 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// hashSlabSize is how many bytes of hashes are allocated at once
+const hashSlabSize = 64 << 10
+
+// snapshotInterner reduces the memory a loaded snapshot holds on to. Every
+// path is copied out of the row it was read from, since CSV fields are slices
+// of one string per row that would otherwise keep the whole row (hashes,
+// digests and chunk lists included) alive as a map key. Repeated strings such
+// as error reasons and digest names share one copy, and hashes are packed
+// into shared slabs instead of being allocated one by one.
+//
+// Hash slices are capped at their length, so appending to one never writes
+// into its neighbours.
+type snapshotInterner struct {
+	strings map[string]string
+	slab    []byte
+	src     []byte
+	scratch []byte
+}
+
+func newSnapshotInterner() *snapshotInterner {
+	return &snapshotInterner{
+		strings: make(map[string]string),
+	}
+}
+
+// path returns a compact copy of a file path
+func (in *snapshotInterner) path(s string) string {
+	return strings.Clone(s)
+}
+
+// string returns the shared copy of a frequently repeated string
+func (in *snapshotInterner) string(s string) string {
+	if shared, ok := in.strings[s]; ok {
+		return shared
+	}
+	s = strings.Clone(s)
+	in.strings[s] = s
+	return s
+}
+
+// hash copies a hash into the current slab
+func (in *snapshotInterner) hash(h []byte) []byte {
+	if len(h) == 0 {
+		return nil
+	}
+
+	if len(h) > cap(in.slab)-len(in.slab) {
+		in.slab = make([]byte, 0, max(hashSlabSize, len(h)))
+	}
+	start := len(in.slab)
+	in.slab = append(in.slab, h...)
+	return in.slab[start:len(in.slab):len(in.slab)]
+}
+
+// hexHash decodes a hex-encoded hash into the current slab
+func (in *snapshotInterner) hexHash(s string) ([]byte, error) {
+	in.src = append(in.src[:0], s...)
+	if cap(in.scratch) < hex.DecodedLen(len(s)) {
+		in.scratch = make([]byte, hex.DecodedLen(len(s)))
+	}
+	n, err := hex.Decode(in.scratch[:hex.DecodedLen(len(s))], in.src)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		// Keep the distinction between an empty and a missing hash
+		return []byte{}, nil
+	}
+	return in.hash(in.scratch[:n]), nil
+}
+
+// digests decodes a digests column with shared names and hashes, like
+// parseDigests
+func (in *snapshotInterner) digests(s string) (map[string][]byte, error) {
+	digests, err := parseDigests(s)
+	if len(digests) == 0 {
+		return nil, err
+	}
+	shared := make(map[string][]byte, len(digests))
+	for name, value := range digests {
+		shared[in.string(name)] = in.hash(value)
+	}
+	return shared, err
+}
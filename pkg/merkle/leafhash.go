@@ -0,0 +1,199 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"lukechampine.com/blake3"
+)
+
+// LeafHasher computes the fingerprint of a single file, which becomes the hash
+// of its leaf in the Merkle tree. path is the file's location on disk, or
+// "<image>:<path>" for files inside a scanned container image, and r
+// yields its (possibly transformed) content. Implementations that fingerprint
+// by other means, such as delegating to an external tool, need not consume r.
+// Implementations may also provide an Algorithm() string method naming the
+// digest; it is recorded in snapshots so that incompatible ones are not
+// compared. Unnamed hashers are recorded as "custom".
+type LeafHasher interface {
+	Hash(path string, r io.Reader) ([]byte, error)
+}
+
+// LeafHasherFunc adapts an ordinary function to the LeafHasher interface
+type LeafHasherFunc func(path string, r io.Reader) ([]byte, error)
+
+// Hash calls f(path, r)
+func (f LeafHasherFunc) Hash(path string, r io.Reader) ([]byte, error) {
+	return f(path, r)
+}
+
+// copyBuffers recycles the buffers file content is read through, which a scan
+// of many small files would otherwise allocate, and collect, once per file
+var copyBuffers = sync.Pool{New: func() any {
+	buf := make([]byte, 32*1024)
+	return &buf
+}}
+
+// copyContent is io.Copy through a recycled buffer
+func copyContent(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// SHA256LeafHasher hashes file content with SHA-256; it is the default
+type SHA256LeafHasher struct{}
+
+// Hash returns the SHA-256 digest of everything read from r
+func (SHA256LeafHasher) Hash(path string, r io.Reader) ([]byte, error) {
+	hasher := sha256.New()
+	if _, err := copyContent(hasher, r); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
+// Algorithm names the digest for the snapshot's hash scheme
+func (SHA256LeafHasher) Algorithm() string {
+	return "sha256"
+}
+
+// hashAlgorithms is the registry of digests known by name: those
+// WithHashAlgorithm fingerprints files and combines tree nodes with, and those
+// WithExtraDigests stores alongside. md5, sha1 and xxhash are not collision
+// resistant: they detect accidental changes only.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake3": func() hash.Hash { return blake3.New(32, nil) },
+	"xxhash": func() hash.Hash { return xxhash.New() },
+}
+
+// nodeHasher returns the function that hashes the concatenated hashes of two
+// children into the hash of their parent under a node algorithm of the
+// registry; "" is SHA-256, as used before the algorithm was recorded
+func nodeHasher(algorithm string) (func(data []byte) []byte, error) {
+	if algorithm == "" || algorithm == "sha256" {
+		return hashData, nil
+	}
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported node hash algorithm: %s", algorithm)
+	}
+	return func(data []byte) []byte {
+		hasher := newHash()
+		hasher.Write(data)
+		return hasher.Sum(nil)
+	}, nil
+}
+
+// HashAlgorithms returns the names WithHashAlgorithm and WithExtraDigests
+// accept, sorted
+func HashAlgorithms() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithHashAlgorithm fingerprints files, and combines the interior nodes of the
+// tree, with one of HashAlgorithms instead of SHA-256. The name is recorded in
+// the snapshot's hash scheme, so snapshots taken with different algorithms are
+// never compared. Scans fail if the name is not supported.
+func WithHashAlgorithm(name string) Option {
+	if name == "sha256" {
+		return WithLeafHasher(SHA256LeafHasher{})
+	}
+	return WithLeafHasher(digestLeafHasher{name: name, newHash: hashAlgorithms[name]})
+}
+
+// digestLeafHasher hashes file content with a standard hash function
+type digestLeafHasher struct {
+	name    string
+	newHash func() hash.Hash // nil for unsupported names
+}
+
+// Hash returns the digest of everything read from r
+func (h digestLeafHasher) Hash(path string, r io.Reader) ([]byte, error) {
+	if h.newHash == nil {
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", h.name)
+	}
+	hasher := h.newHash()
+	if _, err := copyContent(hasher, r); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
+// Algorithm names the digest for the snapshot's hash scheme
+func (h digestLeafHasher) Algorithm() string {
+	return h.name
+}
+
+// CommandLeafHasher delegates file fingerprints to an external command, such
+// as a FIPS-certified module or organization-mandated tool, run through the
+// platform shell for every file. The command gets the file's path in FCD_FILE
+// and its (possibly transformed) content on stdin, and must print the digest
+// in hex as the first word of its output, so "sha256sum" style output works.
+// Files inside container images only exist on stdin.
+type CommandLeafHasher struct {
+	Command string
+	Name    string // digest name recorded in the hash scheme; "external" if empty
+}
+
+// Hash runs the command for one file and decodes the digest it prints
+func (h CommandLeafHasher) Hash(path string, r io.Reader) ([]byte, error) {
+	cmd := shellCommand(h.Command)
+	cmd.Env = append(os.Environ(), "FCD_FILE="+path)
+	cmd.Stdin = r
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("hasher command failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	fields := strings.Fields(stdout.String())
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("hasher command printed no digest")
+	}
+	digest, err := hex.DecodeString(fields[0])
+	if err != nil || len(digest) == 0 {
+		return nil, fmt.Errorf("hasher command printed an invalid digest: %q", fields[0])
+	}
+	return digest, nil
+}
+
+// Algorithm names the digest for the snapshot's hash scheme
+func (h CommandLeafHasher) Algorithm() string {
+	if h.Name == "" {
+		return "external"
+	}
+	return h.Name
+}
+
+// WithLeafHasher replaces the SHA-256 file fingerprint with a custom LeafHasher.
+// A hasher whose Algorithm is one of HashAlgorithms also combines interior
+// nodes with that algorithm; for any other the nodes are combined with SHA-256.
+func WithLeafHasher(h LeafHasher) Option {
+	return func(c *MerkleClient) {
+		c.scan.leafHasher = h
+	}
+}
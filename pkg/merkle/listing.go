@@ -0,0 +1,295 @@
+package merkle
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+)
+
+// labelsFile records the labels of snapshots, one row per snapshot and label
+const labelsFile = "labels.csv"
+
+// validLabel matches labels, which are used in file names and URLs alike
+var validLabel = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// SnapshotQuery selects a page of the stored snapshots of a folder. Filters
+// left empty select every snapshot.
+type SnapshotQuery struct {
+	Since  time.Time // only snapshots taken at or after Since
+	Until  time.Time // only snapshots taken at or before Until
+	Labels []string  // only snapshots carrying every one of these labels
+	Newest bool      // list the newest snapshots first instead of the oldest
+
+	// After continues a listing after the snapshot of this ID, the Next of
+	// the previous page. Unlike Offset, it stays on the same snapshots while
+	// new ones are taken and old ones are pruned.
+	After  string
+	Offset int // snapshots to skip, after After
+	Limit  int // snapshots to return at most; 0 for all
+}
+
+// SnapshotPage is a page of a snapshot listing
+type SnapshotPage struct {
+	Snapshots []string            // as returned by ListSnapshots
+	Labels    map[string][]string // labels of the listed snapshots by snapshot ID
+	Total     int                 // snapshots matching the filters, on every page
+	Next      string              // After of the next page, or "" on the last page
+}
+
+// QuerySnapshots lists the stored snapshots of a folder that match the
+// filters of a query, a page at a time. Times are read from the snapshot
+// names, so no snapshot is loaded.
+func (c *MerkleClient) QuerySnapshots(folderPath string, query SnapshotQuery) (SnapshotPage, error) {
+	var page SnapshotPage
+	for _, label := range query.Labels {
+		if !validLabel.MatchString(label) {
+			return page, fmt.Errorf("invalid label %q", label)
+		}
+	}
+	if query.Offset < 0 || query.Limit < 0 {
+		return page, fmt.Errorf("offset and limit must not be negative")
+	}
+
+	files, err := c.folderSnapshots(folderPath)
+	if err != nil {
+		return page, err
+	}
+	labels, err := c.SnapshotLabels()
+	if err != nil {
+		return page, err
+	}
+	if query.Newest {
+		slices.Reverse(files)
+	}
+
+	var matching []string
+	for _, file := range files {
+		taken, err := snapshotTime(file)
+		if err != nil {
+			continue
+		}
+		if (!query.Since.IsZero() && taken.Before(query.Since)) || (!query.Until.IsZero() && taken.After(query.Until)) {
+			continue
+		}
+		if !hasLabels(labels[snapshotStem(file)], query.Labels) {
+			continue
+		}
+		matching = append(matching, file)
+	}
+	page.Total = len(matching)
+
+	// Names sort by time, so the cursor is found by comparing them, even when
+	// its snapshot is gone
+	if query.After != "" {
+		start := sort.Search(len(matching), func(i int) bool {
+			stem := snapshotStem(matching[i])
+			if query.Newest {
+				return stem < query.After
+			}
+			return stem > query.After
+		})
+		matching = matching[start:]
+	}
+	matching = matching[min(query.Offset, len(matching)):]
+
+	// A snapshot stored in several formats is never split across pages
+	end := len(matching)
+	if query.Limit > 0 && query.Limit < end {
+		end = query.Limit
+		for end < len(matching) && snapshotStem(matching[end]) == snapshotStem(matching[end-1]) {
+			end++
+		}
+	}
+	page.Snapshots = matching[:end]
+	if end < len(matching) {
+		page.Next = snapshotStem(matching[end-1])
+	}
+
+	page.Labels = make(map[string][]string)
+	for _, file := range page.Snapshots {
+		if stem := snapshotStem(file); labels[stem] != nil {
+			page.Labels[stem] = labels[stem]
+		}
+	}
+	return page, nil
+}
+
+// hasLabels reports whether a snapshot's labels include all wanted ones
+func hasLabels(labels, wanted []string) bool {
+	for _, label := range wanted {
+		if !slices.Contains(labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// LabelSnapshot attaches labels, such as "release-2.1" or "pre-upgrade", to a
+// stored snapshot, found as by ResolveSnapshot
+func (c *MerkleClient) LabelSnapshot(id string, labels ...string) error {
+	return c.updateLabels(id, labels, func(current []string, label string) []string {
+		if slices.Contains(current, label) {
+			return current
+		}
+		return append(current, label)
+	})
+}
+
+// UnlabelSnapshot removes labels from a stored snapshot
+func (c *MerkleClient) UnlabelSnapshot(id string, labels ...string) error {
+	return c.updateLabels(id, labels, func(current []string, label string) []string {
+		return slices.DeleteFunc(current, func(l string) bool { return l == label })
+	})
+}
+
+// updateLabels applies change for every label to the labels of a snapshot
+func (c *MerkleClient) updateLabels(id string, labels []string, change func([]string, string) []string) error {
+	for _, label := range labels {
+		if !validLabel.MatchString(label) {
+			return fmt.Errorf("invalid label %q: use letters, digits, '.', '_' and '-'", label)
+		}
+	}
+	file, err := c.ResolveSnapshot(id)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := c.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	all, err := c.SnapshotLabels()
+	if err != nil {
+		return err
+	}
+	stem := snapshotStem(file)
+	for _, label := range labels {
+		all[stem] = change(all[stem], label)
+	}
+	if len(all[stem]) == 0 {
+		delete(all, stem)
+	}
+	return c.writeLabels(all)
+}
+
+// SnapshotLabels returns the labels of the stored snapshots by snapshot ID.
+// Labels of pruned snapshots are kept, so a snapshot restored from an
+// archive gets them back.
+func (c *MerkleClient) SnapshotLabels() (map[string][]string, error) {
+	labels := make(map[string][]string)
+
+	file, err := os.Open(filepath.Join(c.storageDir, labelsFile))
+	if os.IsNotExist(err) {
+		return labels, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	if _, err := reader.Read(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		labels[row[0]] = append(labels[row[0]], row[1])
+	}
+	return labels, nil
+}
+
+// writeLabels replaces the label table with the given labels
+func (c *MerkleClient) writeLabels(labels map[string][]string) error {
+	ids := make([]string, 0, len(labels))
+	for id := range labels {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	file, err := os.Create(filepath.Join(c.storageDir, labelsFile))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{"snapshot", "label"}); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		sorted := slices.Clone(labels[id])
+		sort.Strings(sorted)
+		for _, label := range sorted {
+			if err := writer.Write([]string{id, label}); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// jsonSnapshotInfo describes a stored snapshot
+type jsonSnapshotInfo struct {
+	ID        string    `json:"id"`
+	Folder    string    `json:"folder"`
+	Timestamp time.Time `json:"timestamp"`
+	RootHash  string    `json:"root_hash,omitempty"`
+	Files     int       `json:"files,omitempty"`
+	Labels    []string  `json:"labels,omitempty"`
+}
+
+// snapshotInfo describes a stored snapshot, with its root hash and file
+// count when it was loaded
+func snapshotInfo(file, folder string, state *TreeState) jsonSnapshotInfo {
+	info := jsonSnapshotInfo{ID: snapshotStem(file), Folder: filepath.Base(folder)}
+	if taken, err := snapshotTime(file); err == nil {
+		info.Timestamp = taken
+	}
+	if state != nil {
+		info.Timestamp = state.Timestamp
+		info.RootHash = hex.EncodeToString(state.RootHash)
+		info.Files = len(state.FileHashes) + len(state.Errors)
+	}
+	return info
+}
+
+// newJSONSnapshotList converts a page of a snapshot listing to its JSON form
+func newJSONSnapshotList(folderPath string, page SnapshotPage) []jsonSnapshotInfo {
+	list := make([]jsonSnapshotInfo, 0, len(page.Snapshots))
+	for _, file := range page.Snapshots {
+		info := snapshotInfo(file, folderPath, nil)
+		info.Labels = page.Labels[info.ID]
+		list = append(list, info)
+	}
+	return list
+}
+
+// WriteJSONSnapshots writes a page of a snapshot listing as an indented JSON
+// array, as GET /snapshots of Server returns it
+func WriteJSONSnapshots(w io.Writer, folderPath string, page SnapshotPage) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(newJSONSnapshotList(folderPath, page))
+}
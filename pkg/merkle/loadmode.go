@@ -0,0 +1,106 @@
+package merkle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LoadMode controls what LoadSnapshot does with values it cannot parse
+type LoadMode int
+
+const (
+	// LoadAuto is strict for snapshots in the current formats and lenient for
+	// CSV snapshots from before the scheme column, which older versions read
+	// without any validation
+	LoadAuto LoadMode = iota
+	// LoadStrict fails on the first malformed value with a RowError
+	LoadStrict
+	// LoadLenient keeps loading, leaves malformed values at their zero value
+	// and records each problem in TreeState.Warnings
+	LoadLenient
+)
+
+// WithLoadMode selects how snapshots with malformed values are loaded
+func WithLoadMode(mode LoadMode) Option {
+	return func(c *MerkleClient) {
+		c.loadMode = mode
+	}
+}
+
+// strict resolves the mode for a snapshot; current is whether the snapshot is
+// in a current format
+func (m LoadMode) strict(current bool) bool {
+	switch m {
+	case LoadStrict:
+		return true
+	case LoadLenient:
+		return false
+	default:
+		return current
+	}
+}
+
+// RowError describes a malformed value in a stored snapshot
+type RowError struct {
+	Row    int    // data row (CSV and JSON) or record (binary), counted from 1
+	Column string // column the value belongs to, as in the CSV header
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: invalid %s: %v", e.Row, e.Column, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// rowChecker applies a load mode to the problems found while decoding
+type rowChecker struct {
+	strict   bool
+	row      int
+	warnings []string
+}
+
+// check returns err as a RowError in strict mode and records it as a warning
+// otherwise. A nil err is ignored.
+func (rc *rowChecker) check(column string, err error) error {
+	if err == nil {
+		return nil
+	}
+	rowErr := &RowError{Row: rc.row, Column: column, Err: err}
+	if rc.strict {
+		return rowErr
+	}
+	rc.warnings = append(rc.warnings, rowErr.Error())
+	return nil
+}
+
+// checkStatus validates a status column value
+func checkStatus(status string) error {
+	if status == "" || status == "unstable" || strings.HasPrefix(status, "error: ") {
+		return nil
+	}
+	return fmt.Errorf("unknown status %q", status)
+}
+
+// checkContent validates a content column value
+func checkContent(content string) error {
+	if content == "" || parseContentKind(content) != ContentUnknown {
+		return nil
+	}
+	return fmt.Errorf("unknown content kind %q", content)
+}
+
+// parseSize parses a file_size column value
+func parseSize(s string) (int64, error) {
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size < 0 {
+		return 0, fmt.Errorf("negative size %d", size)
+	}
+	return size, nil
+}
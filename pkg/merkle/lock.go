@@ -0,0 +1,29 @@
+package merkle
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrReadOnly is returned by every operation that would modify the storage
+// directory in builds with the readonly tag
+var ErrReadOnly = errors.New("storage is read-only in this build")
+
+// lockFile is held while the storage directory is being modified
+const lockFile = ".lock"
+
+// lockStorage takes an exclusive, OS-level lock on the storage directory so
+// that a daemon and ad-hoc CLI runs sharing it cannot interleave writes. It
+// waits for other holders and returns a function that releases the lock.
+// Every write to the storage directory goes through it, so read-only builds
+// refuse them here.
+func (c *MerkleClient) lockStorage() (func() error, error) {
+	if readOnly {
+		return nil, ErrReadOnly
+	}
+	if err := os.MkdirAll(c.storageDir, 0755); err != nil {
+		return nil, err
+	}
+	return lockPath(filepath.Join(c.storageDir, lockFile))
+}
@@ -0,0 +1,32 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package merkle
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockTimeout bounds how long lockPath waits for another holder
+const lockTimeout = 30 * time.Second
+
+// lockPath holds a lock by exclusively creating path. Unlike flock(2) the lock
+// outlives a crashed holder, so waiting gives up after lockTimeout.
+func lockPath(path string) (func() error, error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			file.Close()
+			return func() error { return os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("storage is locked by another process (remove %s if it is stale)", path)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package merkle
+
+// extendedPath returns the path unchanged; only Windows needs a special form
+// for long paths and reserved names
+func extendedPath(path string) (string, error) {
+	return path, nil
+}
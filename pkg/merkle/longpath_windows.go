@@ -0,0 +1,26 @@
+//go:build windows
+
+package merkle
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// extendedPath returns the absolute \\?\ form of a path. Paths in this form are
+// not limited to MAX_PATH and skip Win32 name parsing, so files named after
+// reserved devices (CON, NUL, ...) or ending in dots or spaces can be opened.
+func extendedPath(path string) (string, error) {
+	if strings.HasPrefix(path, `\\?\`) {
+		return path, nil
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(absPath, `\\`) {
+		return `\\?\UNC\` + absPath[2:], nil
+	}
+	return `\\?\` + absPath, nil
+}
@@ -0,0 +1,271 @@
+package merkle
+
+import (
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// MappedSnapshot gives read-only access to a binary snapshot that is mapped
+// into memory instead of loaded. Lookups and comparisons work directly on the
+// mapped file, so snapshots far larger than the available RAM can be diffed.
+// Hash slices returned by Entry and Lookup are only valid until Close.
+//
+// Offsets are validated as for loaded snapshots, so a corrupt file yields an
+// error wrapping ErrCorruptSnapshot. A file truncated by another program while
+// mapped would fault on access; such faults are turned into the same error.
+type MappedSnapshot struct {
+	snapshot *binarySnapshot
+	unmap    func() error
+}
+
+// OpenMappedSnapshot maps a snapshot saved with WithSnapshotFormat(FormatBinary)
+func OpenMappedSnapshot(filename string) (*MappedSnapshot, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	data, unmap, err := mapFile(file, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := parseBinarySnapshot(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	return &MappedSnapshot{snapshot: snapshot, unmap: unmap}, nil
+}
+
+// Close unmaps the snapshot
+func (m *MappedSnapshot) Close() error {
+	return m.unmap()
+}
+
+// Len returns the number of files in the snapshot
+func (m *MappedSnapshot) Len() int {
+	return m.snapshot.count
+}
+
+// Timestamp returns when the snapshot was taken
+func (m *MappedSnapshot) Timestamp() time.Time {
+	return m.snapshot.timestamp
+}
+
+// RootHash returns the root hash of the snapshot
+func (m *MappedSnapshot) RootHash() []byte {
+	return append([]byte(nil), m.snapshot.rootHash...)
+}
+
+// Scheme returns how the snapshot's hashes were produced
+func (m *MappedSnapshot) Scheme() HashScheme {
+	return m.snapshot.scheme
+}
+
+// Entry returns the i-th file in path order. Paths are prefix-compressed, so
+// this decodes forward from the nearest restart point.
+func (m *MappedSnapshot) Entry(i int) (entry SnapshotEntry, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer recoverMappingFault(&err)
+	return m.snapshot.entry(i)
+}
+
+// Lookup finds a file by path in O(log n) reads of the mapped file
+func (m *MappedSnapshot) Lookup(path string) (entry SnapshotEntry, found bool, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer recoverMappingFault(&err)
+	return m.snapshot.lookup(path)
+}
+
+// recoverMappingFault turns a fault on the mapped memory, which a panic on
+// fault makes recoverable, into an ErrCorruptSnapshot. Other panics go on.
+func recoverMappingFault(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if fault, ok := r.(interface{ Addr() uintptr }); ok {
+		*err = corruptf("mapped snapshot changed while being read (fault at %#x)", fault.Addr())
+		return
+	}
+	panic(r)
+}
+
+// CompareMappedSnapshots compares two mapped snapshots in a single sequential
+// pass over both, holding only the changes in memory. The result matches
+// CompareSnapshots on the same snapshots.
+func CompareMappedSnapshots(oldSnapshot, newSnapshot *MappedSnapshot) (_ *ChangeReport, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer recoverMappingFault(&err)
+
+	if err := oldSnapshot.Scheme().Compatible(newSnapshot.Scheme()); err != nil {
+		return nil, err
+	}
+
+	report := &ChangeReport{
+		OldTimestamp: oldSnapshot.Timestamp(),
+		NewTimestamp: newSnapshot.Timestamp(),
+		OldRootHash:  oldSnapshot.RootHash(),
+		NewRootHash:  newSnapshot.RootHash(),
+		Changes:      []FileChange{},
+	}
+
+	// Entries are decoded one at a time as each side advances
+	oldCursor, err := oldSnapshot.snapshot.cursor(0)
+	if err != nil {
+		return nil, err
+	}
+	newCursor, err := newSnapshot.snapshot.cursor(0)
+	if err != nil {
+		return nil, err
+	}
+	var oldEntry, newEntry SnapshotEntry
+	load := func(c *binaryCursor, entry *SnapshotEntry) error {
+		if c.i >= c.s.count {
+			return nil
+		}
+		var err error
+		*entry, err = c.next()
+		return err
+	}
+	if err := load(oldCursor, &oldEntry); err != nil {
+		return nil, err
+	}
+	if err := load(newCursor, &newEntry); err != nil {
+		return nil, err
+	}
+
+	oldErrors, newErrors := make(map[string]string), make(map[string]string)
+	i, j := 0, 0
+	for i < oldSnapshot.Len() || j < newSnapshot.Len() {
+		advanceOld, advanceNew := false, false
+		switch {
+		case j == newSnapshot.Len() || (i < oldSnapshot.Len() && oldEntry.Path < newEntry.Path):
+			if oldEntry.failed() {
+				oldErrors[oldEntry.Path] = oldEntry.failure()
+			} else {
+				report.Changes = append(report.Changes, entryChange(&oldEntry, nil))
+			}
+			advanceOld = true
+		case i == oldSnapshot.Len() || newEntry.Path < oldEntry.Path:
+			if newEntry.failed() {
+				newErrors[newEntry.Path] = newEntry.failure()
+			} else {
+				report.Changes = append(report.Changes, entryChange(nil, &newEntry))
+			}
+			advanceNew = true
+		default:
+			// Files that could not be hashed on either side are listed in
+			// report.Errors instead, as in CompareSnapshots
+			if oldEntry.failed() {
+				oldErrors[oldEntry.Path] = oldEntry.failure()
+			}
+			if newEntry.failed() {
+				newErrors[newEntry.Path] = newEntry.failure()
+			}
+			if !oldEntry.failed() && !newEntry.failed() {
+				if !equalHashes(oldEntry.Hash, newEntry.Hash) {
+					report.Changes = append(report.Changes, entryChange(&oldEntry, &newEntry))
+				} else if oldAttrs, newAttrs, ok := entryAttributes(&oldEntry, &newEntry); ok && oldAttrs != newAttrs {
+					change := entryChange(&oldEntry, &newEntry)
+					change.ChangeType = AttributesChanged
+					change.ChangedChunks, change.TotalChunks = 0, 0
+					report.Changes = append(report.Changes, change)
+				}
+			}
+			advanceOld, advanceNew = true, true
+		}
+
+		if advanceOld {
+			i++
+			if err := load(oldCursor, &oldEntry); err != nil {
+				return nil, err
+			}
+		}
+		if advanceNew {
+			j++
+			if err := load(newCursor, &newEntry); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	report.Errors = fileErrors(oldErrors, newErrors)
+	report.Changes = pairMoves(pairCaseRenames(report.Changes, false))
+	report.tallySizes()
+	return report, nil
+}
+
+// failed reports whether the file could not be hashed
+func (e *SnapshotEntry) failed() bool {
+	return strings.HasPrefix(e.Status, "error: ")
+}
+
+// failure returns why the file could not be hashed
+func (e *SnapshotEntry) failure() string {
+	return strings.TrimPrefix(e.Status, "error: ")
+}
+
+// entryChange describes the change between two stored entries; a nil entry
+// means the file was added or deleted. Hashes are copied out of the mapping.
+func entryChange(oldEntry, newEntry *SnapshotEntry) FileChange {
+	switch {
+	case oldEntry == nil:
+		return FileChange{
+			FileName:   newEntry.Path,
+			ChangeType: Added,
+			NewHash:    append([]byte(nil), newEntry.Hash...),
+			NewSize:    newEntry.Size,
+			Content:    newEntry.Content,
+		}
+	case newEntry == nil:
+		return FileChange{
+			FileName:   oldEntry.Path,
+			ChangeType: Deleted,
+			OldHash:    append([]byte(nil), oldEntry.Hash...),
+			OldSize:    oldEntry.Size,
+			Content:    oldEntry.Content,
+		}
+	}
+
+	change := FileChange{
+		FileName:   newEntry.Path,
+		ChangeType: Modified,
+		OldHash:    append([]byte(nil), oldEntry.Hash...),
+		NewHash:    append([]byte(nil), newEntry.Hash...),
+		OldSize:    oldEntry.Size,
+		NewSize:    newEntry.Size,
+		Content:    newEntry.Content,
+	}
+	change.OldAttributes, change.NewAttributes, _ = entryAttributes(oldEntry, newEntry)
+	oldChunks, _ := parseChunks(oldEntry.chunks)
+	newChunks, _ := parseChunks(newEntry.chunks)
+	if changed, total, ok := changedChunks(oldChunks, newChunks); ok {
+		change.ChangedChunks = changed
+		change.TotalChunks = total
+	}
+	return change
+}
+
+// entryAttributes returns the attributes of two stored entries, and false
+// unless both recorded valid attributes
+func entryAttributes(oldEntry, newEntry *SnapshotEntry) (FileAttributes, FileAttributes, bool) {
+	if oldEntry.attributes == "" || newEntry.attributes == "" {
+		return 0, 0, false
+	}
+	oldAttrs, oldErr := parseFileAttributes(oldEntry.attributes)
+	newAttrs, newErr := parseFileAttributes(newEntry.attributes)
+	if oldErr != nil || newErr != nil {
+		return 0, 0, false
+	}
+	return oldAttrs, newAttrs, true
+}
@@ -0,0 +1,37 @@
+package merkle
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Truncating a mapped file makes its pages fault on access instead of
+// returning corrupt data
+func TestCompareMappedSnapshotsSurvivesTruncation(t *testing.T) {
+	intact, err := writeMappedFixture(t, binaryFixture(t, 40))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "state_truncated.fcds")
+	if err := os.WriteFile(path, binaryFixture(t, 40), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	truncated, err := OpenMappedSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer truncated.Close()
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CompareMappedSnapshots(intact, truncated); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("CompareMappedSnapshots = %v, want ErrCorruptSnapshot", err)
+	}
+	if _, _, err := truncated.Lookup("dir0/sub/file000.txt"); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("Lookup = %v, want ErrCorruptSnapshot", err)
+	}
+}
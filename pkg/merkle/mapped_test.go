@@ -0,0 +1,57 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeMappedFixture writes data to a snapshot file and maps it
+func writeMappedFixture(t *testing.T, data []byte) (*MappedSnapshot, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "state_fixture.fcds")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := OpenMappedSnapshot(path)
+	if err == nil {
+		t.Cleanup(func() { mapped.Close() })
+	}
+	return mapped, err
+}
+
+func TestCompareMappedSnapshotsRejectsCorruptRecords(t *testing.T) {
+	intact, err := writeMappedFixture(t, binaryFixture(t, 40))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is valid, but the second record claims to share more of the
+	// previous path than it has
+	corrupt, err := writeMappedFixture(t, rawSnapshot(16, [][]byte{rawRecord(0, "a"), rawRecord(1<<63, "b")}, nil, 0))
+	if err != nil {
+		t.Fatalf("the index should still open: %v", err)
+	}
+	if _, err := CompareMappedSnapshots(intact, corrupt); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("CompareMappedSnapshots = %v, want ErrCorruptSnapshot", err)
+	}
+	if _, err := corrupt.Entry(1); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("Entry = %v, want ErrCorruptSnapshot", err)
+	}
+	if _, _, err := corrupt.Lookup("b"); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("Lookup = %v, want ErrCorruptSnapshot", err)
+	}
+}
+
+func TestOpenMappedSnapshotRejectsCorruptIndex(t *testing.T) {
+	data := binaryFixture(t, 40)
+	// Point the first restart offset far past the end of the file
+	indexOffset := binary.BigEndian.Uint64(data[len(data)-binaryFooterLen:])
+	binary.BigEndian.PutUint64(data[indexOffset:], 1<<63)
+
+	if _, err := writeMappedFixture(t, data); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("OpenMappedSnapshot = %v, want ErrCorruptSnapshot", err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package merkle
+
+import "os"
+
+// fillSysMetadata has nothing to add where there is no stat(2)
+func fillSysMetadata(info os.FileInfo, meta *FileMetadata) {}
+
+// ownerOf reports that files have no user and group IDs here
+func ownerOf(info os.FileInfo) (uid, gid string, ok bool) {
+	return "", "", false
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package merkle
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// fillSysMetadata adds ownership, inode and change time from stat(2)
+func fillSysMetadata(info os.FileInfo, meta *FileMetadata) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+
+	meta.UID = strconv.FormatUint(uint64(stat.Uid), 10)
+	meta.GID = strconv.FormatUint(uint64(stat.Gid), 10)
+	if u, err := user.LookupId(meta.UID); err == nil {
+		meta.Owner = u.Username
+	}
+	if g, err := user.LookupGroupId(meta.GID); err == nil {
+		meta.Group = g.Name
+	}
+	meta.Device = uint64(stat.Dev)
+	meta.Inode = uint64(stat.Ino)
+	meta.ChangeTime = statChangeTime(stat)
+}
+
+// ownerOf returns the numeric user and group IDs owning a file
+func ownerOf(info os.FileInfo) (uid, gid string, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", "", false
+	}
+	return strconv.FormatUint(uint64(stat.Uid), 10), strconv.FormatUint(uint64(stat.Gid), 10), true
+}
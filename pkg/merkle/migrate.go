@@ -0,0 +1,130 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MigrateSnapshots rewrites every stored snapshot that is not in the format the
+// client saves (the current CSV layout unless WithSnapshotFormat says
+// otherwise), such as CSV written before newer columns existed or gzip and JSON
+// snapshots. Snapshots keep their name apart from the extension. With SQLite
+// storage every snapshot file is moved into the database instead. It returns
+// the files that were (or, in dry-run mode, would be) migrated.
+func (c *MerkleClient) MigrateSnapshots(dryRun bool) ([]string, error) {
+	unlock, err := c.lockStorage()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	files, err := filepath.Glob(filepath.Join(c.storageDir, "state_*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var migrated []string
+	for _, file := range files {
+		// With SQLite storage every snapshot file is moved into the database
+		if c.sqlite == nil {
+			current, err := isCurrentSnapshot(file, c.format)
+			if err != nil {
+				return migrated, fmt.Errorf("%s: %v", file, err)
+			}
+			if current {
+				continue
+			}
+		}
+
+		if !dryRun {
+			if err := c.migrateSnapshot(file); err != nil {
+				return migrated, fmt.Errorf("%s: %v", file, err)
+			}
+		}
+		migrated = append(migrated, file)
+	}
+
+	return migrated, nil
+}
+
+// migrateSnapshot rewrites one snapshot in the client's format
+func (c *MerkleClient) migrateSnapshot(file string) error {
+	state, err := c.LoadSnapshot(file)
+	if err != nil {
+		return err
+	}
+
+	// Snapshots without a recorded scheme were all produced by the legacy one
+	if state.Scheme == (HashScheme{}) {
+		state.Scheme = legacyScheme
+	}
+
+	db, err := c.sqliteDB()
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		folder, ok := snapshotFolderName(file)
+		if !ok {
+			return fmt.Errorf("no folder name and timestamp in snapshot name")
+		}
+		stem := snapshotStem(file)
+		if err := saveSQLiteSnapshot(db, state, folder, stem[len(stem)-len(snapshotStampLayout):]); err != nil {
+			return err
+		}
+		return os.Remove(file)
+	}
+
+	target := filepath.Join(filepath.Dir(file), snapshotStem(file)+c.format.extension())
+	if err := writeSnapshotFile(target, state, c.format); err != nil {
+		return err
+	}
+
+	if file != target {
+		return os.Remove(file)
+	}
+	return nil
+}
+
+// isCurrentSnapshot reports whether a snapshot is already stored in format: an
+// uncompressed CSV file with the current header, or a binary or JSON snapshot
+// of the current version
+func isCurrentSnapshot(file string, format SnapshotFormat) (bool, error) {
+	if !strings.HasSuffix(file, format.extension()) {
+		return false, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	switch format {
+	case FormatBinary:
+		magic := make([]byte, len(binaryMagic))
+		_, err := io.ReadFull(f, magic)
+		return err == nil && bytes.Equal(magic, binaryMagic), nil
+	case FormatJSON:
+		var snapshot jsonSnapshot
+		err := json.NewDecoder(f).Decode(&snapshot)
+		return err == nil && snapshot.FormatVersion == jsonFormatVersion, nil
+	}
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		// Not CSV at all; LoadSnapshot decides whether it can be migrated
+		return false, nil
+	}
+	return strings.Join(header, ",") == strings.Join(snapshotHeader, ","), nil
+}
@@ -0,0 +1,97 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"path"
+)
+
+// WithCompatChangeTypes reports renamed and moved files as a deletion of the
+// old path and an addition of the new one, as before Renamed and Moved
+// existed, for consumers that only know the older change types. Case-only
+// renames are still reported as CaseRenamed.
+func WithCompatChangeTypes(enabled bool) Option {
+	return func(c *MerkleClient) {
+		c.compatChanges = enabled
+	}
+}
+
+// WithRenameDetection controls whether a deleted file and an added file are
+// ever merged into one change. It is on by default; with false, renames, moves
+// and case-only renames are all reported as a deletion plus an addition, for
+// callers that must not act on a guess. Paths folded by
+// WithCaseInsensitivePaths are the same file, not a rename, so they are
+// still merged.
+func WithRenameDetection(enabled bool) Option {
+	return func(c *MerkleClient) {
+		c.noRenames = !enabled
+	}
+}
+
+// pairMoves merges each deleted file with an added file of the same content
+// into a single change: Renamed when both paths are in the same directory,
+// Moved otherwise. Content is only a safe match when it identifies one file on
+// each side, so empty files and content shared by several deleted or several
+// added files are left as they are.
+func pairMoves(changes []FileChange) []FileChange {
+	deleted := make(map[string]int)
+	added := make(map[string]int)
+	for i, change := range changes {
+		var index map[string]int
+		var hash []byte
+		switch change.ChangeType {
+		case Deleted:
+			index, hash = deleted, change.OldHash
+		case Added:
+			index, hash = added, change.NewHash
+		default:
+			continue
+		}
+		if len(hash) == 0 || max(change.OldSize, change.NewSize) == 0 {
+			continue
+		}
+		key := hex.EncodeToString(hash)
+		if _, dup := index[key]; dup {
+			// Several files share the content; pairing would be a guess
+			index[key] = -1
+			continue
+		}
+		index[key] = i
+	}
+
+	paired := make(map[int]bool)
+	for i, change := range changes {
+		if change.ChangeType != Added {
+			continue
+		}
+		key := hex.EncodeToString(change.NewHash)
+		j, ok := deleted[key]
+		if k, unique := added[key]; !unique || k != i || !ok || j < 0 {
+			continue
+		}
+		old := changes[j]
+		paired[i], paired[j] = true, true
+
+		moved := change
+		moved.ChangeType = Moved
+		if path.Dir(old.FileName) == path.Dir(change.FileName) {
+			moved.ChangeType = Renamed
+		}
+		moved.OldPath = old.FileName
+		moved.NewPath = change.FileName
+		moved.OldHash = old.OldHash
+		moved.OldSize = old.OldSize
+		changes = append(changes, moved)
+	}
+
+	if len(paired) == 0 {
+		return changes
+	}
+
+	merged := changes[:0]
+	for i, change := range changes {
+		if !paired[i] {
+			merged = append(merged, change)
+		}
+	}
+	return merged
+}
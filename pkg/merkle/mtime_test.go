@@ -0,0 +1,58 @@
+package merkle
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Changes are detected from content: a file whose only difference is its
+// modification time, as after a backup restore or touch, is never reported
+func TestMtimeOnlyChangesAreNotReported(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"full scan", nil},
+		{"incremental", []Option{WithIncrementalSnapshots(true)}},
+		{"incremental full rehash", []Option{WithIncrementalSnapshots(true), WithFullRehash(true)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.txt": "a", "sub/b.txt": "b", "sub/deep/c.bin": "\x00c"})
+			client := NewClient(t.TempDir(), tc.opts...)
+
+			before, err := client.CreateSnapshot(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := client.SaveSnapshot(before, dir); err != nil {
+				t.Fatal(err)
+			}
+
+			restored := time.Now().Add(-72 * time.Hour)
+			for _, name := range []string{"a.txt", "sub/b.txt", "sub/deep/c.bin"} {
+				if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(name)), restored, restored); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			after, err := client.CreateSnapshot(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err := client.CompareSnapshots(before, after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Changes) != 0 {
+				t.Errorf("changes = %v, want none", report.Changes)
+			}
+			if !bytes.Equal(before.RootHash, after.RootHash) {
+				t.Errorf("root changed from %x to %x", before.RootHash, after.RootHash)
+			}
+		})
+	}
+}
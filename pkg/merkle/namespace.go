@@ -0,0 +1,59 @@
+package merkle
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// namespacesDir holds one storage directory per namespace
+const namespacesDir = "namespaces"
+
+// namespacePattern restricts namespace names to safe directory names
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+
+// ValidNamespace reports whether name can be used as a namespace
+func ValidNamespace(name string) bool {
+	return namespacePattern.MatchString(name) && name != "." && name != ".."
+}
+
+// NewNamespacedClient creates a client whose snapshots, history and other state
+// live in a private area of storageDir, so that several tenants can share one
+// storage directory without seeing each other's data
+func NewNamespacedClient(storageDir, namespace string, opts ...Option) (Client, error) {
+	dir, err := NamespaceDir(storageDir, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(dir, opts...), nil
+}
+
+// NamespaceDir returns the private storage directory of a namespace
+func NamespaceDir(storageDir, namespace string) (string, error) {
+	if !ValidNamespace(namespace) {
+		return "", fmt.Errorf("invalid namespace: %q", namespace)
+	}
+	return filepath.Join(storageDir, namespacesDir, namespace), nil
+}
+
+// ListNamespaces returns the namespaces that have data in storageDir
+func ListNamespaces(storageDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(storageDir, namespacesDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var namespaces []string
+	for _, entry := range entries {
+		if entry.IsDir() && ValidNamespace(entry.Name()) {
+			namespaces = append(namespaces, entry.Name())
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
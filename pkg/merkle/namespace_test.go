@@ -0,0 +1,61 @@
+package merkle
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Namespaces keep their snapshots apart in one storage directory
+func TestNamespacesKeepSnapshotsApart(t *testing.T) {
+	folder := filepath.Join(t.TempDir(), "app")
+	writeFiles(t, folder, map[string]string{"a.txt": "alpha"})
+	storage := t.TempDir()
+	clients := make(map[string]Client)
+	for _, namespace := range []string{"team-a", "team-b"} {
+		client, err := NewNamespacedClient(storage, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients[namespace] = client
+	}
+
+	state, err := clients["team-a"].CreateSnapshot(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := clients["team-a"].SaveSnapshot(state, folder); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clients["team-a"].FindLatestSnapshot(folder); err != nil {
+		t.Errorf("team-a does not find its own snapshot: %v", err)
+	}
+	if file, err := clients["team-b"].FindLatestSnapshot(folder); err == nil {
+		t.Errorf("team-b found a snapshot of team-a: %s", file)
+	}
+	if file, err := NewClient(storage).FindLatestSnapshot(folder); err == nil {
+		t.Errorf("the storage directory itself holds a snapshot of team-a: %s", file)
+	}
+
+	if namespaces, err := ListNamespaces(storage); err != nil || !reflect.DeepEqual(namespaces, []string{"team-a"}) {
+		t.Errorf("ListNamespaces = %v, %v; want [team-a]", namespaces, err)
+	}
+}
+
+// Namespace names cannot leave the namespaces directory
+func TestNamespaceNames(t *testing.T) {
+	for _, name := range []string{"team-a", "Team_1", "a.b", "0"} {
+		if !ValidNamespace(name) {
+			t.Errorf("%q is refused", name)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "../team-a", "a/b", `a\b`, ".hidden", "-flag", strings.Repeat("a", 64)} {
+		if ValidNamespace(name) {
+			t.Errorf("%q is accepted", name)
+		}
+		if _, err := NewNamespacedClient(t.TempDir(), name); err == nil {
+			t.Errorf("a client was created for %q", name)
+		}
+	}
+}
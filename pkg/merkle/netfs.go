@@ -0,0 +1,72 @@
+package merkle
+
+import (
+	"fmt"
+	"time"
+)
+
+// Settings of the network file system profile
+const (
+	networkHashTimeout = 10 * time.Minute
+	networkStableTries = 4
+	networkStaleTries  = 3
+	networkMtimeSlack  = 2 * time.Second
+	networkWorkers     = 4
+
+	// staleRetryDelay is the pause before the first retry of a stale handle;
+	// it doubles with every further attempt
+	staleRetryDelay = 500 * time.Millisecond
+)
+
+// errStaleHandle is recorded for files whose handle stayed stale after every retry
+var errStaleHandle = fmt.Errorf("stale file handle")
+
+// WithNetworkProfile tunes scanning for folders on NFS or SMB shares, where
+// reads are slow, handles go stale when the server drops them, and file times
+// are stored more coarsely than on local disks:
+//
+//   - a file that takes longer than 10 minutes to hash is recorded as errored
+//     instead of hanging the scan on a dead mount
+//   - hashing a file whose handle went stale (ESTALE, or a dropped SMB
+//     connection on Windows) is retried 3 times with increasing pauses, and
+//     the file is recorded as errored instead of failing the scan if it is
+//     still stale
+//   - modification times within 2 seconds of each other are considered equal
+//     by the stability check, and files are re-read up to 4 times while they
+//     change
+//   - at most 4 files are hashed at the same time, fewer when the share does
+//     not keep up, so a scan does not flood it with reads (besides reads
+//     abandoned by the timeout)
+//
+// Options given after WithNetworkProfile override its individual settings.
+func WithNetworkProfile() Option {
+	return func(c *MerkleClient) {
+		c.scan.hashTimeout = networkHashTimeout
+		c.scan.stableTries = networkStableTries
+		c.scan.staleTries = networkStaleTries
+		c.scan.mtimeSlack = networkMtimeSlack
+		c.scan.workers = networkWorkers
+	}
+}
+
+// hashRetryingStale hashes a file like hashStableFile, retrying while its
+// handle is stale. A handle that is still stale after the configured retries
+// yields errStaleHandle.
+func hashRetryingStale(filePath, relPath string, opts scanOptions) (fileDigest, bool, error) {
+	for attempt := 0; ; attempt++ {
+		digest, unstable, err := hashStableFile(filePath, relPath, opts)
+		if err == nil || opts.staleTries == 0 || !isStaleHandle(err) {
+			return digest, unstable, err
+		}
+		if attempt >= opts.staleTries {
+			return fileDigest{}, false, errStaleHandle
+		}
+		time.Sleep(staleRetryDelay << attempt)
+	}
+}
+
+// sameModTime reports whether two modification times agree within slack
+func sameModTime(a, b time.Time, slack time.Duration) bool {
+	d := a.Sub(b)
+	return d <= slack && d >= -slack
+}
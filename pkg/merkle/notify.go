@@ -0,0 +1,168 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notification is a batch of change events delivered to a Notifier
+type Notification struct {
+	Subject string
+	From    time.Time // earliest detection time covered
+	To      time.Time // latest detection time covered
+	Events  []ChangeEvent
+
+	// Urgent notifications, such as those of high priority folders or with
+	// critical changes, are sent on at once instead of waiting for a digest
+	Urgent bool
+}
+
+// Notifier delivers change notifications, e.g. by email or chat
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// NewNotification builds a notification for the changes in a single report.
+// It is urgent when any of the changes is critical.
+func NewNotification(folderPath string, report *ChangeReport) *Notification {
+	events := EventsFromReport(folderPath, report)
+	subject := fmt.Sprintf("%d change(s) detected", len(events))
+	critical := report.SeverityCounts()[SeverityCritical]
+	if critical > 0 {
+		subject += fmt.Sprintf(", %d critical", critical)
+	}
+	n := newNotification(subject, events)
+	n.Urgent = critical > 0
+	return n
+}
+
+func newNotification(subject string, events []ChangeEvent) *Notification {
+	n := &Notification{Subject: subject, Events: events}
+	for i, event := range events {
+		if i == 0 || event.DetectedAt.Before(n.From) {
+			n.From = event.DetectedAt
+		}
+		if event.DetectedAt.After(n.To) {
+			n.To = event.DetectedAt
+		}
+	}
+	return n
+}
+
+// WriterNotifier writes notifications as plain text to an io.Writer
+type WriterNotifier struct {
+	W io.Writer
+}
+
+// Notify writes n to the underlying writer
+func (wn *WriterNotifier) Notify(n *Notification) error {
+	return WriteNotification(wn.W, n)
+}
+
+// CommandNotifier pipes notifications as plain text into a shell command,
+// e.g. `mail -s "file changes" ops@example.com`. The subject is also passed
+// in the FCD_SUBJECT environment variable.
+type CommandNotifier struct {
+	Command string
+}
+
+// Notify runs the command with n on stdin
+func (cn *CommandNotifier) Notify(n *Notification) error {
+	var body bytes.Buffer
+	if err := WriteNotification(&body, n); err != nil {
+		return err
+	}
+
+	cmd := shellCommand(cn.Command)
+	cmd.Env = append(os.Environ(), "FCD_SUBJECT="+n.Subject)
+	cmd.Stdin = &body
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("notify command failed: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// DigestNotifier batches events and forwards them to Next as a single summary
+// once per Window, instead of once per comparison. When StatePath is set,
+// pending events are kept in that file so batches survive process restarts;
+// this lets short-lived CLI runs build up an hourly or daily digest.
+type DigestNotifier struct {
+	Next      Notifier
+	Window    time.Duration
+	StatePath string
+
+	mu      sync.Mutex
+	pending []ChangeEvent
+}
+
+// Notify queues the events of n and sends a digest if the window has elapsed.
+// Urgent notifications are passed to Next right away.
+func (d *DigestNotifier) Notify(n *Notification) error {
+	if n.Urgent {
+		return d.Next.Notify(n)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.StatePath != "" {
+		if err := appendEvents(d.StatePath, n.Events); err != nil {
+			return err
+		}
+	} else {
+		d.pending = append(d.pending, n.Events...)
+	}
+
+	return d.flushIfDue(false)
+}
+
+// Poll sends the pending digest if its window has elapsed. Call it
+// periodically so digests go out even when no new changes arrive.
+func (d *DigestNotifier) Poll() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flushIfDue(false)
+}
+
+// Flush sends any pending events immediately
+func (d *DigestNotifier) Flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flushIfDue(true)
+}
+
+// flushIfDue sends the pending digest when forced or when the oldest pending
+// event is at least one window old; the caller must hold d.mu
+func (d *DigestNotifier) flushIfDue(force bool) error {
+	pending := d.pending
+	if d.StatePath != "" {
+		var err error
+		if pending, err = readEvents(d.StatePath); err != nil {
+			return err
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	digest := newNotification(fmt.Sprintf("Digest: %d change(s)", len(pending)), pending)
+	if !force && time.Since(digest.From) < d.Window {
+		return nil
+	}
+
+	if err := d.Next.Notify(digest); err != nil {
+		return err
+	}
+
+	d.pending = nil
+	if d.StatePath != "" {
+		if err := os.Remove(d.StatePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
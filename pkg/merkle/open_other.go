@@ -0,0 +1,27 @@
+//go:build !unix
+
+package merkle
+
+import (
+	"fmt"
+	"os"
+)
+
+// openRegular opens a file for hashing and fails unless the opened file is a
+// regular file
+func openRegular(path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a regular file (%s)", path, info.Mode().Type())
+	}
+	return file, nil
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package merkle
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// openRegular opens a file for hashing. It opens without blocking, so a FIFO
+// or device reached through a symlink or swapped in after the walk cannot
+// hang the scan, and fails unless the opened file is a regular file.
+func openRegular(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a regular file (%s)", path, info.Mode().Type())
+	}
+	return file, nil
+}
@@ -0,0 +1,105 @@
+package merkle
+
+import (
+	"context"
+	"time"
+)
+
+// Option configures optional behaviour of a MerkleClient
+type Option func(*MerkleClient)
+
+// scanOptions controls how a folder is walked and hashed
+type scanOptions struct {
+	dedupInodes   bool
+	specialFiles  bool
+	oneFS         bool
+	hashTimeout   time.Duration
+	stableTries   int
+	staleTries    int           // retries of stale file handles; see WithNetworkProfile
+	mtimeSlack    time.Duration // modification times this close count as unchanged
+	extraDigests  []string
+	chunkSize     int
+	transforms    []contentTransform
+	leafHasher    LeafHasher
+	leafOrder     LeafOrder
+	fileList      []string
+	excludes      []ignoreRule
+	includes      []ignoreRule
+	ignoreFiles   []string      // names of ignore files to honor; see WithIgnoreFiles
+	owners        []string      // owner specs files must match; see WithOwners
+	excludeOwners []string      // owner specs of files to leave out
+	newerThan     time.Duration // scan only files modified less than this long ago
+	olderThan     time.Duration // scan only files modified more than this long ago
+	cache         *leafCache    // leaves of the previous scan, kept by Watch
+	workers       int           // files hashed at the same time; the most when adaptive
+	adaptive      bool          // tune the workers while scanning; see WithAdaptiveHashWorkers
+	budget        *WorkerBudget // workers shared with the scans of other folders
+	budgetQueue   string        // queue of the budget the files of this scan wait in
+	priority      Priority      // order in which the budget serves this scan
+	scope         string        // path prefix the scan is restricted to; see WithPathScope
+	nested        []string      // folders left to watches of their own; see WatchOptions.Nested
+	foldCase      bool          // paths differing only in letter case are the same file
+
+	// progress is called as files are found and hashed, see WithProgress;
+	// tracker counts the files of the running scan for it
+	progress func(ScanProgress)
+	tracker  *progressTracker
+
+	// ctx cancels the walk and hashing, or is nil when the scan cannot be
+	// cancelled; see GetTreeContext
+	ctx context.Context
+}
+
+// defaultScanOptions returns the scan options used when no Option overrides them
+func defaultScanOptions() scanOptions {
+	return scanOptions{
+		dedupInodes: true,
+		stableTries: 2,
+		leafHasher:  SHA256LeafHasher{},
+		workers:     defaultAdaptiveWorkers(),
+		adaptive:    true,
+	}
+}
+
+// WithInodeDedup enables or disables hashing each (device, inode) pair only once.
+// When enabled, hardlinks and bind-mounted duplicates reuse the first digest computed.
+func WithInodeDedup(enabled bool) Option {
+	return func(c *MerkleClient) {
+		c.scan.dedupInodes = enabled
+	}
+}
+
+// WithSpecialFiles controls whether sockets, FIFOs and device nodes are recorded.
+// Special files are never opened; when included they are recorded as "special"
+// entries whose hash is derived from their file type. They are skipped by default.
+func WithSpecialFiles(include bool) Option {
+	return func(c *MerkleClient) {
+		c.scan.specialFiles = include
+	}
+}
+
+// WithOneFileSystem keeps the walk on the device of the scanned folder, skipping
+// any directory or file that lives on a different mount point.
+func WithOneFileSystem(enabled bool) Option {
+	return func(c *MerkleClient) {
+		c.scan.oneFS = enabled
+	}
+}
+
+// WithHashTimeout bounds how long hashing a single file may take. Files that do
+// not finish in time are recorded as errored entries and hashed again on the next
+// scan. A zero duration disables the deadline.
+func WithHashTimeout(d time.Duration) Option {
+	return func(c *MerkleClient) {
+		c.scan.hashTimeout = d
+	}
+}
+
+// WithStabilityRetries sets how many times a file is re-read when its size or
+// modification time changes while it is being hashed. Files that are still
+// changing after the last retry are recorded as unstable.
+func WithStabilityRetries(retries int) Option {
+	return func(c *MerkleClient) {
+		c.scan.stableTries = retries
+	}
+}
@@ -0,0 +1,125 @@
+package merkle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// LeafOrder is the order of the leaves a Merkle tree is built over. The order
+// decides the tree's shape and therefore its root hash, so each order has its
+// own tree versions, recorded in the hash scheme of every snapshot. Both orders
+// compare canonical paths (relative, with forward slashes) and never depend on
+// the locale or platform.
+type LeafOrder int
+
+const (
+	// BytewiseOrder sorts paths by their UTF-8 bytes, so "a.txt" sorts between
+	// "a" and "a/b", as "." precedes "/". It is the default and tree version 3,
+	// or 1 for trees built before leaves and nodes were hashed apart.
+	BytewiseOrder LeafOrder = iota
+
+	// HierarchicalOrder compares paths one component at a time by Unicode code
+	// point, so the files of a directory are contiguous and a directory's
+	// entries sort as a sorted directory listing would. Paths are normalized
+	// to NFC first, so a name stored decomposed (NFD), as older macOS file
+	// systems return it, is the same path as its composed form. It is tree
+	// version 4, or 2 for trees built before leaves and nodes were hashed
+	// apart.
+	HierarchicalOrder
+)
+
+// leafOrderNames are the names ParseLeafOrder accepts, by order
+var leafOrderNames = map[LeafOrder]string{
+	BytewiseOrder:     "bytewise",
+	HierarchicalOrder: "hierarchical",
+}
+
+// String returns the name of the order
+func (o LeafOrder) String() string {
+	if name, ok := leafOrderNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("LeafOrder(%d)", int(o))
+}
+
+// ParseLeafOrder parses an order name as returned by LeafOrder.String
+func ParseLeafOrder(name string) (LeafOrder, error) {
+	for order, orderName := range leafOrderNames {
+		if orderName == name {
+			return order, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown leaf order %q (use bytewise or hierarchical)", name)
+}
+
+// treeVersion returns the tree version trees built in this order are recorded as
+func (o LeafOrder) treeVersion() int {
+	return int(o) + 3
+}
+
+// normalizes reports whether paths are normalized to NFC in this order
+func (o LeafOrder) normalizes() bool {
+	return o == HierarchicalOrder
+}
+
+// path returns the form of a canonical path that is ordered and stored
+func (o LeafOrder) path(relPath string) string {
+	if o.normalizes() {
+		return norm.NFC.String(relPath)
+	}
+	return relPath
+}
+
+// less reports whether path a sorts before path b
+func (o LeafOrder) less(a, b string) bool {
+	if o != HierarchicalOrder {
+		return a < b
+	}
+
+	for {
+		aHead, aRest, aMore := strings.Cut(a, "/")
+		bHead, bRest, bMore := strings.Cut(b, "/")
+		if aHead != bHead {
+			// UTF-8 byte order is code point order
+			return aHead < bHead
+		}
+		if !aMore || !bMore {
+			// A file sorts before the contents of a directory of the same name
+			return !aMore && bMore
+		}
+		a, b = aRest, bRest
+	}
+}
+
+// sortLeaves puts leaves into the order the tree is built over
+func sortLeaves(leaves []*MerkleNode, order LeafOrder) {
+	sort.Slice(leaves, func(i, j int) bool {
+		return order.less(leaves[i].FileName, leaves[j].FileName)
+	})
+}
+
+// checkNormalizedLeaves fails when two files of a sorted tree normalized to
+// the same path, as a name stored in both NFC and NFD would
+func checkNormalizedLeaves(leaves []*MerkleNode, order LeafOrder) error {
+	if !order.normalizes() {
+		return nil
+	}
+	for i := 1; i < len(leaves); i++ {
+		if leaves[i].FileName == leaves[i-1].FileName {
+			return fmt.Errorf("two files normalize to the same path %q", leaves[i].FileName)
+		}
+	}
+	return nil
+}
+
+// WithLeafOrder selects the order leaves are sorted in before the tree is
+// built. Snapshots taken in different orders have different tree versions and
+// are not compared.
+func WithLeafOrder(order LeafOrder) Option {
+	return func(c *MerkleClient) {
+		c.scan.leafOrder = order
+	}
+}
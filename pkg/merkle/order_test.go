@@ -0,0 +1,88 @@
+package merkle
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// A tree whose names are stored decomposed has the same paths and root as the
+// same tree stored composed, once ordered hierarchically
+func TestHierarchicalOrderNormalizesPaths(t *testing.T) {
+	names := []string{"café/menü.txt", "café.txt", "cafe/plain.txt", "Ångström/ÿ.txt"}
+	tree := func(form norm.Form) string {
+		dir := t.TempDir()
+		files := make(map[string]string)
+		for _, name := range names {
+			files[form.String(name)] = name
+		}
+		writeFiles(t, dir, files)
+		return dir
+	}
+	nfc, nfd := tree(norm.NFC), tree(norm.NFD)
+	if !hasEntry(t, nfd, norm.NFD.String("café.txt")) {
+		t.Skip("the file system normalizes names")
+	}
+
+	snapshot := func(dir string, order LeafOrder) *TreeState {
+		t.Helper()
+		state, err := NewClient(t.TempDir(), WithLeafOrder(order)).CreateSnapshot(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+
+	composed, decomposed := snapshot(nfc, HierarchicalOrder), snapshot(nfd, HierarchicalOrder)
+	if !bytes.Equal(composed.RootHash, decomposed.RootHash) {
+		t.Errorf("NFC root %x, NFD root %x", composed.RootHash, decomposed.RootHash)
+	}
+	for _, name := range names {
+		if _, ok := decomposed.FileHashes[norm.NFC.String(name)]; !ok {
+			t.Errorf("%q is not stored in NFC", name)
+		}
+	}
+	report, err := NewClient(t.TempDir(), WithLeafOrder(HierarchicalOrder)).CompareSnapshots(composed, decomposed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 0 {
+		t.Errorf("changes = %v, want none", report.Changes)
+	}
+	if got := decomposed.Scheme.PathEncoding; got != "slash,nfc" {
+		t.Errorf("path encoding = %q, want slash,nfc", got)
+	}
+
+	// Names are compared as stored in the default order
+	if bytes.Equal(snapshot(nfc, BytewiseOrder).RootHash, snapshot(nfd, BytewiseOrder).RootHash) {
+		t.Error("bytewise roots of NFC and NFD names agree")
+	}
+}
+
+// hasEntry reports whether a directory lists a name exactly as given
+func hasEntry(t *testing.T, dir, name string) bool {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHierarchicalOrderRejectsNamesInBothForms(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{norm.NFC.String("é.txt"): "composed", norm.NFD.String("é.txt"): "decomposed"})
+	if !hasEntry(t, dir, norm.NFD.String("é.txt")) || !hasEntry(t, dir, norm.NFC.String("é.txt")) {
+		t.Skip("the file system normalizes names")
+	}
+	if _, err := NewClient(t.TempDir(), WithLeafOrder(HierarchicalOrder)).CreateSnapshot(dir); err == nil {
+		t.Error("two files with the same normalized name were scanned")
+	}
+}
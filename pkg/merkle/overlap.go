@@ -0,0 +1,56 @@
+package merkle
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// NestedFolders returns the folders among others that lie inside folder, as
+// slash separated paths relative to it, for WatchOptions.Nested. Paths are
+// compared after making them absolute and resolving symbolic links, so two
+// spellings of the same folder are recognized; folder itself, file sets and
+// container images are never nested.
+func NestedFolders(folder string, others []string) []string {
+	root := resolvedFolder(folder)
+	var nested []string
+	for _, other := range others {
+		if IsSetPath(other) || IsImagePath(other) {
+			continue
+		}
+		rel, err := filepath.Rel(root, resolvedFolder(other))
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		nested = append(nested, filepath.ToSlash(rel))
+	}
+	return nested
+}
+
+// SameFolder reports whether two paths name the same folder, after making
+// them absolute and resolving symbolic links
+func SameFolder(a, b string) bool {
+	return resolvedFolder(a) == resolvedFolder(b)
+}
+
+// resolvedFolder returns the absolute path of a folder with symbolic links
+// resolved, or as far as it can be resolved
+func resolvedFolder(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
+
+// isNested reports whether a path of a scan is one of the nested folders
+// left out of it
+func isNested(relPath string, nested []string) bool {
+	for _, folder := range nested {
+		if relPath == folder {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,287 @@
+package merkle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Layout of a patch bundle
+const (
+	patchFilesDir     = "files/"
+	patchDeletionList = "DELETED.json" // JSON array of paths, which may hold any character
+)
+
+// ErrPatchMismatch is returned when a file no longer has the content a change
+// report recorded for it, e.g. because it changed while being exported
+var ErrPatchMismatch = errors.New("file changed since it was compared")
+
+// ExportPatch packages every added and modified file from a change report into a
+// tar.gz bundle, along with a manifest of deleted files. Files are read from
+// folderPath, which should be the folder the report's new state was taken from.
+// Exported content is checked against the report with the default SHA-256
+// leaf hashing; use Client.ExportPatch for reports hashed with other options.
+func ExportPatch(report *ChangeReport, folderPath, bundlePath string) error {
+	return (&MerkleClient{scan: defaultScanOptions()}).ExportPatch(report, folderPath, bundlePath)
+}
+
+// ExportPatch packages every added and modified file from a change report into
+// a tar.gz bundle, along with a manifest of deleted files. Each file is hashed
+// with the client's options as it is copied into the bundle, and the export
+// fails with ErrPatchMismatch, leaving no bundle behind, if a file no longer
+// matches the hash of the report.
+func (c *MerkleClient) ExportPatch(report *ChangeReport, folderPath, bundlePath string) (err error) {
+	file, err := os.Create(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(bundlePath)
+		}
+	}()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	// Deletions come first so that a case-only rename applied to a
+	// case-insensitive target removes the old name before writing the new one,
+	// and renamed and moved files are removed from their old path
+	var deleted []string
+	for _, change := range report.Changes {
+		switch change.ChangeType {
+		case Deleted:
+			deleted = append(deleted, filepath.ToSlash(change.FileName))
+		case CaseRenamed, Renamed, Moved:
+			deleted = append(deleted, filepath.ToSlash(change.OldPath))
+		}
+	}
+
+	if deleted == nil {
+		deleted = []string{}
+	}
+	manifest, err := json.Marshal(deleted)
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Name: patchDeletionList,
+		Mode: 0644,
+		Size: int64(len(manifest)),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	// Only the content is hashed; extra digests and chunks are not needed
+	scan := c.scan
+	scan.extraDigests, scan.chunkSize = nil, 0
+	for _, change := range report.Changes {
+		switch change.ChangeType {
+		case Added, Modified, CaseRenamed, Renamed, Moved:
+			if err := addFileToPatch(tw, folderPath, change, scan); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// ApplyPatch writes the files from a patch bundle into targetDir and removes the
+// files listed in its deletion manifest
+func ApplyPatch(bundlePath, targetDir string) error {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case header.Name == patchDeletionList:
+			var deleted []string
+			if err := json.NewDecoder(tr).Decode(&deleted); err != nil {
+				return fmt.Errorf("reading deletion manifest: %w", err)
+			}
+			if err := applyDeletions(deleted, targetDir); err != nil {
+				return err
+			}
+		case strings.HasPrefix(header.Name, patchFilesDir):
+			relPath := strings.TrimPrefix(header.Name, patchFilesDir)
+			if err := extractPatchFile(tr, header, targetDir, relPath); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected entry in patch bundle: %s", header.Name)
+		}
+	}
+
+	return nil
+}
+
+// addFileToPatch copies the file of a change into the bundle, hashing exactly
+// the bytes written so that a file modified since the comparison is caught
+func addFileToPatch(tw *tar.Writer, folderPath string, change FileChange, scan scanOptions) error {
+	fullPath := filepath.Join(folderPath, change.FileName)
+	src, err := openRegular(fullPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = patchFilesDir + filepath.ToSlash(change.FileName)
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	// The tar entry receives what the hasher reads, up to the size in its header
+	counted := &countingWriter{w: tw}
+	content := io.TeeReader(io.LimitReader(src, info.Size()), counted)
+	digest, err := hashContent(content, fullPath, change.FileName, scan)
+	if err != nil {
+		return err
+	}
+	if counted.n != info.Size() || !bytes.Equal(digest.hash, change.NewHash) {
+		return fmt.Errorf("%s: %w", change.FileName, ErrPatchMismatch)
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func extractPatchFile(r io.Reader, header *tar.Header, targetDir, relPath string) error {
+	dest, err := patchTarget(targetDir, relPath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	// Written aside and renamed into place, so an interrupted patch never
+	// leaves a file half written and a symlink at dest is replaced, not followed
+	out, err := os.CreateTemp(filepath.Dir(dest), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Chmod(os.FileMode(header.Mode).Perm()); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dest)
+}
+
+// applyDeletions removes the files of a deletion manifest from targetDir
+func applyDeletions(deleted []string, targetDir string) error {
+	for _, relPath := range deleted {
+		if relPath == "" {
+			continue
+		}
+
+		dest, err := patchTarget(targetDir, relPath)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchTarget resolves a bundle path inside targetDir, rejecting paths that
+// would escape it, whether by "..", a volume name or a symlinked directory
+func patchTarget(targetDir, relPath string) (string, error) {
+	clean := path.Clean(relPath)
+	local := filepath.FromSlash(clean)
+	if clean == "." || path.IsAbs(clean) || !filepath.IsLocal(local) || hasVolumeName(clean) {
+		return "", fmt.Errorf("unsafe path in patch bundle: %s", relPath)
+	}
+
+	// Every directory on the way must be a real one; those not yet there are
+	// created by extractPatchFile
+	components := strings.Split(clean, "/")
+	for i := 1; i < len(components); i++ {
+		info, err := os.Lstat(filepath.Join(targetDir, filepath.FromSlash(path.Join(components[:i]...))))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("unsafe path in patch bundle: %s: %s is a symlink", relPath, path.Join(components[:i]...))
+		}
+	}
+	return filepath.Join(targetDir, local), nil
+}
+
+// hasVolumeName reports whether a bundle path starts with a drive letter, as
+// C:/Windows does. Such paths leave targetDir on Windows, so they are refused
+// on every system alike.
+func hasVolumeName(p string) bool {
+	return len(p) >= 2 && p[1] == ':' && ('a' <= p[0] && p[0] <= 'z' || 'A' <= p[0] && p[0] <= 'Z')
+}
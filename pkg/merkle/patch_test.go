@@ -0,0 +1,214 @@
+package merkle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeFiles creates files with the given contents under dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// patchReport snapshots dir, applies change to it and compares
+func patchReport(t *testing.T, client Client, dir string, change func()) *ChangeReport {
+	t.Helper()
+	before, err := client.CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change()
+	after, err := client.CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := client.CompareSnapshots(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestExportPatchRoundTrip(t *testing.T) {
+	source, target := t.TempDir(), t.TempDir()
+	deletedName := "old.txt"
+	if runtime.GOOS != "windows" {
+		deletedName = "line\nbreak.txt"
+	}
+	files := map[string]string{"keep.txt": "same", "edit.txt": "v1", deletedName: "gone"}
+	writeFiles(t, source, files)
+	writeFiles(t, target, files)
+
+	client := NewClient(t.TempDir())
+	report := patchReport(t, client, source, func() {
+		writeFiles(t, source, map[string]string{"edit.txt": "v2", "dir/new.txt": "new"})
+		if err := os.Remove(filepath.Join(source, deletedName)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	bundle := filepath.Join(t.TempDir(), "patch.tar.gz")
+	if err := client.ExportPatch(report, source, bundle); err != nil {
+		t.Fatalf("ExportPatch: %v", err)
+	}
+	if err := ApplyPatch(bundle, target); err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+
+	for name, want := range map[string]string{"keep.txt": "same", "edit.txt": "v2", "dir/new.txt": "new"} {
+		got, err := os.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q (%v), want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(target, deletedName)); !os.IsNotExist(err) {
+		t.Errorf("%q was not deleted: %v", deletedName, err)
+	}
+}
+
+func TestExportPatchRejectsFileChangedSinceComparison(t *testing.T) {
+	source := t.TempDir()
+	writeFiles(t, source, map[string]string{"edit.txt": "v1"})
+
+	client := NewClient(t.TempDir())
+	report := patchReport(t, client, source, func() {
+		writeFiles(t, source, map[string]string{"edit.txt": "v2"})
+	})
+	// Same size, different content: only the hash can tell
+	writeFiles(t, source, map[string]string{"edit.txt": "v3"})
+
+	bundle := filepath.Join(t.TempDir(), "patch.tar.gz")
+	err := client.ExportPatch(report, source, bundle)
+	if !errors.Is(err, ErrPatchMismatch) {
+		t.Fatalf("ExportPatch = %v, want ErrPatchMismatch", err)
+	}
+	if _, err := os.Stat(bundle); !os.IsNotExist(err) {
+		t.Errorf("a bundle was left behind: %v", err)
+	}
+}
+
+// writeBundle writes a patch bundle holding the given files and deletion
+// manifest, as a hostile exporter could
+func writeBundle(t *testing.T, files map[string]string, deleted []string) string {
+	t.Helper()
+	bundle := filepath.Join(t.TempDir(), "patch.tar.gz")
+	file, err := os.Create(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range files {
+		add(patchFilesDir+name, []byte(content))
+	}
+	if deleted != nil {
+		data, err := json.Marshal(deleted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		add(patchDeletionList, data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+// Bundles cannot write or delete outside the target, whether by "..", a
+// volume name or a directory symlinked out of it
+func TestApplyPatchStaysInTarget(t *testing.T) {
+	outside, target := t.TempDir(), t.TempDir()
+	writeFiles(t, outside, map[string]string{"victim.txt": "keep"})
+	symlinks := runtime.GOOS != "windows"
+	if symlinks {
+		if err := os.Symlink(outside, filepath.Join(target, "link")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		files   map[string]string
+		deleted []string
+		links   bool
+	}{
+		{name: "dot dot", files: map[string]string{"../escaped.txt": "x"}},
+		{name: "volume name", files: map[string]string{"C:/escaped.txt": "x"}},
+		{name: "deleted dot dot", deleted: []string{"../victim.txt"}},
+		{name: "through symlink", files: map[string]string{"link/victim.txt": "overwritten"}, links: true},
+		{name: "nested through symlink", files: map[string]string{"link/sub/new.txt": "x"}, links: true},
+		{name: "deleted through symlink", deleted: []string{"link/victim.txt"}, links: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.links && !symlinks {
+				t.Skip("symlinks need privileges on Windows")
+			}
+			if err := ApplyPatch(writeBundle(t, tc.files, tc.deleted), target); err == nil {
+				t.Error("the bundle was applied")
+			}
+			if got, err := os.ReadFile(filepath.Join(outside, "victim.txt")); err != nil || string(got) != "keep" {
+				t.Errorf("victim.txt = %q, %v", got, err)
+			}
+			entries, _ := os.ReadDir(outside)
+			if len(entries) != 1 {
+				t.Errorf("files outside the target: %v", entries)
+			}
+		})
+	}
+}
+
+// A symlink where a patched file goes is replaced, not written through
+func TestApplyPatchReplacesSymlinkedFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	outside, target := t.TempDir(), t.TempDir()
+	writeFiles(t, outside, map[string]string{"victim.txt": "keep"})
+	if err := os.Symlink(filepath.Join(outside, "victim.txt"), filepath.Join(target, "file.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ApplyPatch(writeBundle(t, map[string]string{"file.txt": "patched"}, nil), target); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(outside, "victim.txt")); err != nil || string(got) != "keep" {
+		t.Errorf("victim.txt = %q, %v", got, err)
+	}
+	info, err := os.Lstat(filepath.Join(target, "file.txt"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("file.txt is not a regular file: %v, %v", info, err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(target, "file.txt")); string(got) != "patched" {
+		t.Errorf("file.txt = %q", got)
+	}
+	if entries, _ := os.ReadDir(target); len(entries) != 1 {
+		t.Errorf("temporary files were left behind: %v", entries)
+	}
+}
@@ -0,0 +1,74 @@
+package merkle
+
+import (
+	"fmt"
+	"time"
+)
+
+// Priority ranks the folders of a watch. High priority folders, typically
+// security critical ones such as /etc, are scanned more often, their files are
+// hashed before those of other folders sharing a WorkerBudget, and their
+// notifications skip digests. The zero value is PriorityNormal.
+type Priority int
+
+const (
+	// PriorityLow is for bulky folders where detection may lag, such as data
+	// directories
+	PriorityLow Priority = -1
+	// PriorityNormal is the default
+	PriorityNormal Priority = 0
+	// PriorityHigh is for small, security critical folders
+	PriorityHigh Priority = 1
+)
+
+// priorityIntervalFactor is how much more often a high priority folder, and
+// how much less often a low priority one, is scanned than a normal one
+const priorityIntervalFactor = 4
+
+// String returns the name of the priority as ParsePriority accepts it
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// ParsePriority parses "high", "normal" or "low"
+func ParsePriority(name string) (Priority, error) {
+	switch name {
+	case "low":
+		return PriorityLow, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority: %q (want high, normal or low)", name)
+}
+
+// interval scales the interval of a normal priority watch to this priority
+func (p Priority) interval(normal time.Duration) time.Duration {
+	switch p {
+	case PriorityHigh:
+		return normal / priorityIntervalFactor
+	case PriorityLow:
+		return normal * priorityIntervalFactor
+	}
+	return normal
+}
+
+// class returns the index of the priority's line in a WorkerBudget
+func (p Priority) class() int {
+	switch {
+	case p < PriorityNormal:
+		return 0
+	case p > PriorityNormal:
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,102 @@
+package merkle
+
+import (
+	"sync"
+	"time"
+)
+
+// ScanProgress is how far a scan has come
+type ScanProgress struct {
+	Found     int    // regular files found by the walk so far
+	Processed int    // files hashed, failed or reused from the previous scan
+	Bytes     int64  // bytes read to hash files; reused files are not read
+	Path      string // the file processed last, relative to the folder
+	WalkDone  bool   // the walk is over, so Found is the total
+}
+
+// Done reports whether the scan has processed every file
+func (p ScanProgress) Done() bool {
+	return p.WalkDone && p.Processed >= p.Found
+}
+
+// progressInterval is how often a scan reports its progress at most
+const progressInterval = 100 * time.Millisecond
+
+// WithProgress calls fn with the progress of every scan of a folder or file
+// set, at most every 100ms and once more when the files are processed, so a
+// scan of millions of files shows it is alive. The walk and the hashing run
+// at the same time, so Found keeps growing until WalkDone. fn is called from
+// the scanning goroutines, one call at a time, and should return quickly;
+// to receive the progress on a channel, send to it without blocking.
+func WithProgress(fn func(ScanProgress)) Option {
+	return func(c *MerkleClient) {
+		c.scan.progress = fn
+	}
+}
+
+// progressTracker counts the files of a scan and reports the counts to the
+// callback of WithProgress. Its methods are no-ops on a nil tracker.
+type progressTracker struct {
+	fn func(ScanProgress)
+
+	mu       sync.Mutex
+	progress ScanProgress
+	reported time.Time
+}
+
+// newProgressTracker returns a tracker reporting to fn, or nil without one
+func newProgressTracker(fn func(ScanProgress)) *progressTracker {
+	if fn == nil {
+		return nil
+	}
+	return &progressTracker{fn: fn}
+}
+
+// found counts a file found by the walk
+func (t *progressTracker) found() {
+	if t == nil {
+		return
+	}
+	t.update(false, func(p *ScanProgress) { p.Found++ })
+}
+
+// processed counts a file that was hashed, failed or reused, of which size
+// bytes were read
+func (t *progressTracker) processed(relPath string, size int64) {
+	if t == nil {
+		return
+	}
+	t.update(false, func(p *ScanProgress) {
+		p.Processed++
+		p.Bytes += size
+		p.Path = relPath
+	})
+}
+
+// walkDone records that the walk is over
+func (t *progressTracker) walkDone() {
+	if t == nil {
+		return
+	}
+	t.update(false, func(p *ScanProgress) { p.WalkDone = true })
+}
+
+// finish reports the final counts once every file is processed
+func (t *progressTracker) finish() {
+	if t == nil {
+		return
+	}
+	t.update(true, func(p *ScanProgress) {})
+}
+
+// update changes the counts and reports them when the last report is old
+// enough, or when force is set
+func (t *progressTracker) update(force bool, change func(*ScanProgress)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change(&t.progress)
+	if now := time.Now(); force || now.Sub(t.reported) >= progressInterval {
+		t.reported = now
+		t.fn(t.progress)
+	}
+}
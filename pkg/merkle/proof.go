@@ -0,0 +1,177 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Proof shows that a file is a leaf of a tree with a given root hash, using
+// one sibling hash per level instead of the whole file list. Each leaf hashes
+// the file's path with its hash, and the root hashes the number of leaves, so
+// a proof binds the name and content of the file to its position in the tree.
+// Only trees of version 3 and later can be proven; see treeHasher.
+type Proof struct {
+	FileName  string     // path of the file relative to the folder
+	Index     int        // position of the leaf in leaf order
+	LeafCount int        // number of leaves in the tree
+	Scheme    HashScheme // how the leaf hash is computed and nodes are combined
+	Siblings  [][]byte   // sibling hashes from the leaf's level up to the root
+}
+
+// GenerateProof returns a proof that a file is a leaf of the tree. Files that
+// could not be hashed are leaves too; their proofs cover the error marker
+// rather than any content.
+func (t *MerkleTree) GenerateProof(fileName string) (*Proof, error) {
+	if !t.Scheme.separatesDomains() {
+		return nil, fmt.Errorf("trees of version %d cannot be proven; take a new snapshot", t.Scheme.TreeVersion)
+	}
+	index, count := -1, 0
+	var leaf *MerkleNode
+	for it := t.Leaves(); it.Next(); count++ {
+		if it.Node().FileName == fileName {
+			index, leaf = count, it.Node()
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("file not in tree: %s", fileName)
+	}
+
+	h, err := newTreeHasher(t.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	// The root's only child is the top of the tree
+	_, siblings := proofPath(t.Root.Left, leaf, h)
+	proof := &Proof{FileName: fileName, Index: index, LeafCount: count, Scheme: t.Scheme, Siblings: siblings}
+	if !VerifyProof(t.Root.Hash, fileName, leaf.Hash, proof) {
+		return nil, fmt.Errorf("tree is not built over its leaves in leaf order; cannot prove %s", fileName)
+	}
+	return proof, nil
+}
+
+// proofPath returns whether leaf is below node and, if so, the values of the
+// siblings on the way up from it to node
+func proofPath(node, leaf *MerkleNode, h treeHasher) (bool, [][]byte) {
+	if node.IsLeaf {
+		return node == leaf, nil
+	}
+	if found, siblings := proofPath(node.Left, leaf, h); found {
+		return true, append(siblings, h.value(node.Right))
+	}
+	if found, siblings := proofPath(node.Right, leaf, h); found {
+		return true, append(siblings, h.value(node.Left))
+	}
+	return false, nil
+}
+
+// GenerateProof returns a proof that a file is part of the snapshot. The tree
+// is rebuilt from the recorded hashes in the snapshot's leaf order, and must
+// reproduce the recorded root hash.
+func (s *TreeState) GenerateProof(fileName string) (*Proof, error) {
+	if !s.Scheme.separatesDomains() {
+		return nil, fmt.Errorf("snapshots with tree version %d cannot be proven; take a new snapshot", s.Scheme.TreeVersion)
+	}
+	leaves := make([]*MerkleNode, 0, len(s.FileHashes)+len(s.Errors))
+	for name, hash := range s.FileHashes {
+		leaves = append(leaves, &MerkleNode{Hash: hash, IsLeaf: true, FileName: name})
+	}
+	for name := range s.Errors {
+		leaves = append(leaves, &MerkleNode{Hash: hashData([]byte("error:" + name)), IsLeaf: true, FileName: name})
+	}
+	if len(leaves) == 0 {
+		return nil, fmt.Errorf("snapshot has no files")
+	}
+
+	sortLeaves(leaves, s.Scheme.leafOrder())
+	tree, err := newMerkleTree(leaves, s.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	if !equalHashes(tree.Root.Hash, s.RootHash) {
+		return nil, fmt.Errorf("snapshot hashes do not reproduce its root hash %x", s.RootHash)
+	}
+	return tree.GenerateProof(fileName)
+}
+
+// VerifyProof reports whether a proof shows that a file with the given path
+// and leaf hash is part of the tree with the given root hash. The leaf hash is
+// the digest of the file's content under the proof's scheme, e.g. the SHA-256
+// of the file for the default scheme, and nodes are combined with the scheme's
+// node algorithm. Proofs of trees before version 3 never verify.
+func VerifyProof(rootHash []byte, fileName string, fileHash []byte, proof *Proof) bool {
+	if proof == nil || proof.FileName != fileName || proof.Index < 0 || proof.Index >= proof.LeafCount {
+		return false
+	}
+	h, err := newTreeHasher(proof.Scheme)
+	if err != nil || !h.separated {
+		return false
+	}
+
+	hash := h.leaf(fileName, fileHash)
+	siblings := proof.Siblings
+	for index, count := proof.Index, proof.LeafCount; count > 1; index, count = index/2, (count+1)/2 {
+		if index == count-1 && count%2 == 1 {
+			// The last node of an odd level moves up unchanged
+			continue
+		}
+		if len(siblings) == 0 {
+			return false
+		}
+		if index%2 == 1 {
+			hash = h.node(siblings[0], hash)
+		} else {
+			hash = h.node(hash, siblings[0])
+		}
+		siblings = siblings[1:]
+	}
+	return len(siblings) == 0 && bytes.Equal(h.root(proof.LeafCount, hash), rootHash)
+}
+
+// proofJSON is the wire form of a Proof, with hashes in hex as elsewhere
+type proofJSON struct {
+	FileName  string   `json:"file_path"`
+	Index     int      `json:"index"`
+	LeafCount int      `json:"leaf_count"`
+	Scheme    string   `json:"scheme,omitempty"`
+	Siblings  []string `json:"siblings"`
+}
+
+// MarshalJSON encodes the proof for shipping to a verifier
+func (p *Proof) MarshalJSON() ([]byte, error) {
+	out := proofJSON{FileName: p.FileName, Index: p.Index, LeafCount: p.LeafCount, Siblings: make([]string, len(p.Siblings))}
+	if p.Scheme != (HashScheme{}) {
+		out.Scheme = p.Scheme.String()
+	}
+	for i, sibling := range p.Siblings {
+		out.Siblings[i] = hex.EncodeToString(sibling)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON is the inverse of MarshalJSON
+func (p *Proof) UnmarshalJSON(data []byte) error {
+	var in proofJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	proof := Proof{FileName: in.FileName, Index: in.Index, LeafCount: in.LeafCount, Siblings: make([][]byte, len(in.Siblings))}
+	if in.Scheme != "" {
+		scheme, err := parseHashScheme(in.Scheme)
+		if err != nil {
+			return fmt.Errorf("invalid proof scheme: %v", err)
+		}
+		proof.Scheme = scheme
+	}
+	for i, sibling := range in.Siblings {
+		hash, err := hex.DecodeString(sibling)
+		if err != nil {
+			return fmt.Errorf("invalid proof sibling %d: %v", i, err)
+		}
+		proof.Siblings[i] = hash
+	}
+	*p = proof
+	return nil
+}
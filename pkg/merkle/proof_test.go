@@ -0,0 +1,148 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// Every file of trees of every size proves, in both leaf orders
+func TestProofsOfEveryLeaf(t *testing.T) {
+	for _, order := range []LeafOrder{BytewiseOrder, HierarchicalOrder} {
+		for n := 1; n <= 9; n++ {
+			dir := t.TempDir()
+			files := make(map[string]string)
+			for i := 0; i < n; i++ {
+				files[fmt.Sprintf("d%d/f%d.txt", i%3, i)] = fmt.Sprint(i)
+			}
+			writeFiles(t, dir, files)
+			state, err := NewClient(t.TempDir(), WithLeafOrder(order)).CreateSnapshot(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name := range files {
+				proof, err := state.GenerateProof(name)
+				if err != nil {
+					t.Fatalf("%s, %d files: %v", order, n, err)
+				}
+				if !VerifyProof(state.RootHash, name, state.FileHashes[name], proof) {
+					t.Errorf("%s, %d files: proof of %s does not verify", order, n, name)
+				}
+			}
+		}
+	}
+}
+
+// Proofs of files that are not in the tree do not verify, whatever leaf,
+// position or leaf count they claim
+func TestForgedProofsDoNotVerify(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "alpha", "b": "beta", "c": "gamma"})
+	state, err := NewClient(t.TempDir()).CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := newTreeHasher(state.Scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := func(name string) []byte { return h.leaf(name, state.FileHashes[name]) }
+	proofOf := func(name string) *Proof {
+		t.Helper()
+		proof, err := state.GenerateProof(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return proof
+	}
+
+	for _, tc := range []struct {
+		name     string
+		fileName string
+		fileHash []byte
+		proof    *Proof
+	}{
+		{
+			// An interior node passed off as a leaf over its children
+			name:     "node as leaf",
+			fileName: "evil",
+			fileHash: append(leaf("a"), leaf("b")...),
+			proof:    &Proof{FileName: "evil", Index: 0, LeafCount: 2, Scheme: state.Scheme, Siblings: [][]byte{leaf("c")}},
+		},
+		{
+			// The last leaf of an odd level claimed a second time past the end
+			name:     "duplicated last leaf",
+			fileName: "d",
+			fileHash: state.FileHashes["c"],
+			proof:    &Proof{FileName: "d", Index: 3, LeafCount: 4, Scheme: state.Scheme, Siblings: [][]byte{leaf("c"), h.node(leaf("a"), leaf("b"))}},
+		},
+		{
+			// The content of a file under another name
+			name:     "renamed leaf",
+			fileName: "d",
+			fileHash: state.FileHashes["c"],
+			proof:    &Proof{FileName: "d", Index: 2, LeafCount: 3, Scheme: state.Scheme, Siblings: proofOf("c").Siblings},
+		},
+		{
+			name:     "other leaf count",
+			fileName: "c",
+			fileHash: state.FileHashes["c"],
+			proof:    &Proof{FileName: "c", Index: 2, LeafCount: 4, Scheme: state.Scheme, Siblings: proofOf("c").Siblings},
+		},
+		{
+			name:     "other content",
+			fileName: "a",
+			fileHash: state.FileHashes["b"],
+			proof:    proofOf("a"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if VerifyProof(state.RootHash, tc.fileName, tc.fileHash, tc.proof) {
+				t.Error("forged proof verifies")
+			}
+		})
+	}
+}
+
+// Trees built before leaves and nodes were hashed apart cannot be proven, as
+// their proofs could be forged
+func TestLegacyTreesCannotBeProven(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "alpha", "b": "beta", "c": "gamma"})
+	state, err := NewClient(t.TempDir()).CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := *state
+	legacy.Scheme.TreeVersion = 1
+	if _, err := legacy.GenerateProof("a"); err == nil {
+		t.Error("a proof of a version 1 snapshot was generated")
+	}
+
+	proof, err := state.GenerateProof("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof.Scheme.TreeVersion = 1
+	if VerifyProof(state.RootHash, "a", state.FileHashes["a"], proof) {
+		t.Error("a proof of a version 1 tree verifies")
+	}
+
+	// The legacy layout, where [a b c] and [a b c c] share a root
+	leaves := func(names ...string) []*MerkleNode {
+		nodes := make([]*MerkleNode, len(names))
+		for i, name := range names {
+			nodes[i] = &MerkleNode{Hash: hashData([]byte(name)), IsLeaf: true, FileName: name}
+		}
+		return nodes
+	}
+	h := treeHasher{combine: hashData}
+	if !bytes.Equal(h.build(leaves("a", "b", "c")).Hash, h.build(leaves("a", "b", "c", "c")).Hash) {
+		t.Error("version 1 trees are not built as before")
+	}
+	h.separated = true
+	if bytes.Equal(h.build(leaves("a", "b", "c")).Hash, h.build(leaves("a", "b", "c", "c")).Hash) {
+		t.Error("a repeated last leaf keeps the root hash")
+	}
+}
@@ -0,0 +1,126 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// HashPeriod is a run of consecutive snapshots in which a file looked the same:
+// it had one hash, could not be hashed, or was absent
+type HashPeriod struct {
+	Hash      []byte // nil when the file was absent or could not be hashed
+	Size      int64
+	Error     string // why the file could not be hashed, if it could not
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Snapshots int
+}
+
+// Absent reports whether the file was missing from the period's snapshots
+func (p HashPeriod) Absent() bool {
+	return p.Hash == nil && p.Error == ""
+}
+
+// observation is what one snapshot taken at a point in time recorded for a file
+type observation struct {
+	taken  time.Time
+	period HashPeriod
+}
+
+// FileHistory reads every stored snapshot of a folder, oldest first, and
+// returns the timeline of one file in it: each hash it had and when it was
+// observed, including periods in which it was deleted. Unlike HistoryForPath
+// it does not depend on the change log, so it also covers snapshots that were
+// never compared. Binary snapshots are searched through a memory mapping
+// instead of being loaded, and snapshots in an SQLite database are all
+// searched with one query.
+func (c *MerkleClient) FileHistory(folderPath, path string) ([]HashPeriod, error) {
+	folderName := filepath.Base(folderPath)
+	files, err := c.snapshotFiles(folderName)
+	if err != nil {
+		return nil, err
+	}
+
+	var observations []observation
+	for _, file := range files {
+		taken, err := snapshotTime(file)
+		if err != nil {
+			continue
+		}
+		observed, err := c.observeFile(file, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		observations = append(observations, observation{taken: taken, period: observed})
+	}
+
+	db, err := c.sqliteDB()
+	if err != nil {
+		return nil, err
+	}
+	if db != nil {
+		stored, err := sqliteObservations(db, folderName, path)
+		if err != nil {
+			return nil, err
+		}
+		observations = append(observations, stored...)
+		sort.SliceStable(observations, func(i, j int) bool {
+			return observations[i].taken.Before(observations[j].taken)
+		})
+	}
+
+	var periods []HashPeriod
+	for _, o := range observations {
+		taken, observed := o.taken, o.period
+
+		// Leading snapshots from before the file existed are not part of it
+		if len(periods) == 0 && observed.Absent() {
+			continue
+		}
+
+		if n := len(periods); n > 0 && samePeriod(periods[n-1], observed) {
+			periods[n-1].LastSeen = taken
+			periods[n-1].Snapshots++
+			continue
+		}
+		observed.FirstSeen, observed.LastSeen, observed.Snapshots = taken, taken, 1
+		periods = append(periods, observed)
+	}
+
+	return periods, nil
+}
+
+// observeFile returns what one snapshot recorded for a file
+func (c *MerkleClient) observeFile(file, path string) (HashPeriod, error) {
+	if mapped, err := OpenMappedSnapshot(file); err == nil {
+		defer mapped.Close()
+		entry, found, err := mapped.Lookup(path)
+		if err != nil || !found {
+			return HashPeriod{}, err
+		}
+		if entry.failed() {
+			return HashPeriod{Error: entry.Status[len("error: "):]}, nil
+		}
+		return HashPeriod{Hash: append([]byte(nil), entry.Hash...), Size: entry.Size}, nil
+	}
+
+	state, err := c.LoadSnapshot(file)
+	if err != nil {
+		return HashPeriod{}, err
+	}
+	if reason, failed := state.Errors[path]; failed {
+		return HashPeriod{Error: reason}, nil
+	}
+	if hash, ok := state.FileHashes[path]; ok {
+		return HashPeriod{Hash: hash, Size: state.FileSizes[path]}, nil
+	}
+	return HashPeriod{}, nil
+}
+
+// samePeriod reports whether an observation continues a period
+func samePeriod(period, observed HashPeriod) bool {
+	return bytes.Equal(period.Hash, observed.Hash) && period.Error == observed.Error
+}
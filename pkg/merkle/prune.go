@@ -0,0 +1,152 @@
+package merkle
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// PrunePolicy decides which snapshots of a folder PruneSnapshots keeps. A
+// snapshot is kept when any of the keep rules selects it, or when no keep rule
+// is set; MaxAge then drops the kept snapshots that are too old. The newest
+// snapshot and the baseline of the folder are always kept.
+type PrunePolicy struct {
+	// KeepLast keeps the newest snapshots
+	KeepLast int
+
+	// KeepDaily keeps the newest snapshot of each of the last days that have
+	// snapshots, in local time
+	KeepDaily int
+
+	// KeepWeekly keeps the newest snapshot of each of the last ISO weeks that
+	// have snapshots
+	KeepWeekly int
+
+	// MaxAge drops snapshots taken longer ago than this; zero for no limit
+	MaxAge time.Duration
+
+	// ArchiveDir, when set, receives pruned snapshots instead of deleting them
+	ArchiveDir string
+
+	// DryRun reports what would be pruned without touching any files
+	DryRun bool
+}
+
+// PruneSnapshots removes or archives the snapshots of a folder that policy
+// does not keep, and returns them, oldest first
+func (c *MerkleClient) PruneSnapshots(folderPath string, policy PrunePolicy) ([]string, error) {
+	if policy.KeepLast < 0 || policy.KeepDaily < 0 || policy.KeepWeekly < 0 || policy.MaxAge < 0 {
+		return nil, fmt.Errorf("invalid prune policy: counts and age must not be negative")
+	}
+
+	unlock, err := c.lockStorage()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	snapshots, err := c.folderSnapshots(folderPath)
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	baseline, err := c.Baseline(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := policy.keep(snapshots, time.Now())
+	keep[snapshots[len(snapshots)-1]] = true
+	if baseline != nil {
+		for _, file := range snapshots {
+			if snapshotStem(file) == snapshotStem(baseline.Snapshot) {
+				keep[file] = true
+			}
+		}
+	}
+
+	var pruned []string
+	for _, file := range snapshots {
+		if keep[file] {
+			continue
+		}
+		if !policy.DryRun {
+			if err := c.retireSnapshot(file, policy.ArchiveDir); err != nil {
+				return pruned, err
+			}
+		}
+		pruned = append(pruned, file)
+	}
+	return pruned, nil
+}
+
+// keep selects the snapshots the policy keeps from a folder's snapshots,
+// oldest first. Snapshots without a timestamp in their name are kept.
+func (p PrunePolicy) keep(snapshots []string, now time.Time) map[string]bool {
+	keep := make(map[string]bool)
+	times := make(map[string]time.Time, len(snapshots))
+	for _, file := range snapshots {
+		taken, err := snapshotTime(file)
+		if err != nil {
+			keep[file] = true
+			continue
+		}
+		times[file] = taken
+	}
+
+	if p.KeepLast == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 {
+		for file := range times {
+			keep[file] = true
+		}
+	}
+
+	// Walk from the newest, keeping the first snapshot of each new period
+	last, days, weeks := 0, 0, 0
+	var lastDay, lastWeek string
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		file := snapshots[i]
+		taken, ok := times[file]
+		if !ok {
+			continue
+		}
+		if last < p.KeepLast {
+			keep[file] = true
+			last++
+		}
+		if day := taken.Format("2006-01-02"); day != lastDay && days < p.KeepDaily {
+			keep[file] = true
+			lastDay = day
+			days++
+		}
+		year, week := taken.ISOWeek()
+		if key := fmt.Sprintf("%d-W%02d", year, week); key != lastWeek && weeks < p.KeepWeekly {
+			keep[file] = true
+			lastWeek = key
+			weeks++
+		}
+	}
+
+	if p.MaxAge > 0 {
+		for file, taken := range times {
+			if now.Sub(taken) > p.MaxAge {
+				delete(keep, file)
+			}
+		}
+	}
+	return keep
+}
+
+// WritePruneReport lists the snapshots PruneSnapshots returned under policy and
+// counts them; a dry run says what would be pruned, as nothing was
+func WritePruneReport(w io.Writer, pruned []string, policy PrunePolicy) {
+	action, summary := "Removed", "pruned"
+	switch {
+	case policy.DryRun:
+		action, summary = "Would prune", "would be pruned"
+	case policy.ArchiveDir != "":
+		action, summary = "Archived", "archived"
+	}
+	for _, file := range pruned {
+		fmt.Fprintf(w, "%s: %s\n", action, file)
+	}
+	fmt.Fprintf(w, "\n%d snapshot(s) %s\n", len(pruned), summary)
+}
@@ -0,0 +1,66 @@
+package merkle
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A dry run removes nothing and says what would be pruned, never that
+// anything was
+func TestPruneDryRunSaysWouldPrune(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "alpha"})
+	client := NewClient(t.TempDir())
+	for age := 3; age > 0; age-- {
+		state, err := client.CreateSnapshot(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.Timestamp = state.Timestamp.Add(-time.Duration(age) * time.Hour)
+		if err := client.SaveSnapshot(state, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policy := PrunePolicy{KeepLast: 1, DryRun: true}
+	pruned, err := client.PruneSnapshots(dir, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 2 {
+		t.Fatalf("pruned = %v, want the 2 older snapshots", pruned)
+	}
+	for _, file := range pruned {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("dry run removed %s: %v", file, err)
+		}
+	}
+
+	var out bytes.Buffer
+	WritePruneReport(&out, pruned, policy)
+	text := out.String()
+	if strings.Count(text, "Would prune: ") != 2 || !strings.Contains(text, "2 snapshot(s) would be pruned\n") {
+		t.Errorf("dry run report:\n%s", text)
+	}
+	if strings.Contains(text, "Removed") || strings.Contains(text, "snapshot(s) pruned") {
+		t.Errorf("dry run report claims snapshots were pruned:\n%s", text)
+	}
+
+	policy.DryRun = false
+	if pruned, err = client.PruneSnapshots(dir, policy); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range pruned {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after pruning", file)
+		}
+	}
+	out.Reset()
+	WritePruneReport(&out, pruned, policy)
+	if strings.Count(out.String(), "Removed: ") != 2 || !strings.Contains(out.String(), "2 snapshot(s) pruned\n") {
+		t.Errorf("report:\n%s", out.String())
+	}
+}
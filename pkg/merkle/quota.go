@@ -0,0 +1,64 @@
+package merkle
+
+import "fmt"
+
+// QuotaRules describes limits on the size and growth of a scanned folder.
+// A zero value disables the corresponding rule.
+type QuotaRules struct {
+	MaxFiles      int   // total number of files in the new state
+	MaxBytes      int64 // total size of the new state in bytes
+	MaxFileGrowth int   // files added minus files removed between the states
+	MaxByteGrowth int64 // bytes added minus bytes removed between the states
+}
+
+// QuotaAlert describes a quota rule that was exceeded
+type QuotaAlert struct {
+	Rule    string
+	Limit   int64
+	Actual  int64
+	Message string
+}
+
+// FileCount returns the number of files recorded in the state
+func (s *TreeState) FileCount() int {
+	return len(s.FileHashes)
+}
+
+// TotalBytes returns the combined size of all files recorded in the state
+func (s *TreeState) TotalBytes() int64 {
+	var total int64
+	for _, size := range s.FileSizes {
+		total += size
+	}
+	return total
+}
+
+// CheckQuotas evaluates rules against a new state and its growth since the old
+// state, returning an alert for every rule that was exceeded. oldState may be
+// nil, in which case only the absolute limits are checked.
+func CheckQuotas(oldState, newState *TreeState, rules QuotaRules) []QuotaAlert {
+	var alerts []QuotaAlert
+
+	check := func(rule string, limit, actual int64, unit string) {
+		if limit > 0 && actual > limit {
+			alerts = append(alerts, QuotaAlert{
+				Rule:    rule,
+				Limit:   limit,
+				Actual:  actual,
+				Message: fmt.Sprintf("%s exceeded: %d %s (limit %d)", rule, actual, unit, limit),
+			})
+		}
+	}
+
+	check("max-files", int64(rules.MaxFiles), int64(newState.FileCount()), "files")
+	check("max-bytes", rules.MaxBytes, newState.TotalBytes(), "bytes")
+
+	if oldState != nil {
+		check("max-file-growth", int64(rules.MaxFileGrowth),
+			int64(newState.FileCount()-oldState.FileCount()), "files")
+		check("max-byte-growth", rules.MaxByteGrowth,
+			newState.TotalBytes()-oldState.TotalBytes(), "bytes")
+	}
+
+	return alerts
+}
@@ -0,0 +1,172 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RateLimitNotifier protects Next from floods of notifications, such as a
+// flapping file paging the on-call every few minutes. A zero duration disables
+// the corresponding rule. When StatePath is set the limiter's memory is kept in
+// that file so limits also hold across separate CLI runs.
+type RateLimitNotifier struct {
+	Next Notifier
+
+	// MinInterval is the minimum time between two notifications; notifications
+	// arriving sooner are dropped and counted in the next one that is sent
+	MinInterval time.Duration
+
+	// SuppressFor drops a notification identical to the previous one when it
+	// arrives within this duration
+	SuppressFor time.Duration
+
+	// PathCooldown drops events about a path that was already reported within
+	// this duration
+	PathCooldown time.Duration
+
+	StatePath string
+
+	mu    sync.Mutex
+	state rateLimitState
+}
+
+// rateLimitState is what a RateLimitNotifier remembers between notifications
+type rateLimitState struct {
+	LastSent        time.Time            `json:"last_sent"`
+	LastFingerprint string               `json:"last_fingerprint"`
+	PathsSent       map[string]time.Time `json:"paths_sent"`
+	Dropped         int                  `json:"dropped"`
+}
+
+// Notify forwards n to Next unless a rate limit or deduplication rule applies
+func (rl *RateLimitNotifier) Notify(n *Notification) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if err := rl.loadState(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	fingerprint := notificationFingerprint(n)
+
+	// Identical to the last notification
+	if rl.SuppressFor > 0 && fingerprint == rl.state.LastFingerprint &&
+		now.Sub(rl.state.LastSent) < rl.SuppressFor {
+		rl.state.Dropped++
+		return rl.saveState()
+	}
+
+	// Too soon after the last notification
+	if rl.MinInterval > 0 && now.Sub(rl.state.LastSent) < rl.MinInterval {
+		rl.state.Dropped++
+		return rl.saveState()
+	}
+
+	// Collapse events about paths that were reported recently
+	var events []ChangeEvent
+	collapsed := 0
+	for _, event := range n.Events {
+		key := event.Folder + "/" + event.FileName
+		if rl.PathCooldown > 0 && now.Sub(rl.state.PathsSent[key]) < rl.PathCooldown {
+			collapsed++
+			continue
+		}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		rl.state.Dropped++
+		return rl.saveState()
+	}
+
+	out := *n
+	out.Events = events
+	if collapsed > 0 || rl.state.Dropped > 0 {
+		out.Subject = fmt.Sprintf("%s (%d repeated change(s) and %d notification(s) suppressed)",
+			n.Subject, collapsed, rl.state.Dropped)
+	}
+
+	if err := rl.Next.Notify(&out); err != nil {
+		return err
+	}
+
+	rl.state.LastSent = now
+	rl.state.LastFingerprint = fingerprint
+	rl.state.Dropped = 0
+	for _, event := range events {
+		rl.state.PathsSent[event.Folder+"/"+event.FileName] = now
+	}
+	rl.forgetOldPaths(now)
+	return rl.saveState()
+}
+
+// forgetOldPaths drops path entries whose cooldown has expired
+func (rl *RateLimitNotifier) forgetOldPaths(now time.Time) {
+	for key, sent := range rl.state.PathsSent {
+		if now.Sub(sent) >= rl.PathCooldown {
+			delete(rl.state.PathsSent, key)
+		}
+	}
+}
+
+// loadState reads the persisted state once; the caller must hold rl.mu
+func (rl *RateLimitNotifier) loadState() error {
+	if rl.state.PathsSent != nil {
+		return nil
+	}
+	rl.state.PathsSent = make(map[string]time.Time)
+
+	if rl.StatePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(rl.StatePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &rl.state); err != nil {
+		return fmt.Errorf("invalid rate limit state: %v", err)
+	}
+	if rl.state.PathsSent == nil {
+		rl.state.PathsSent = make(map[string]time.Time)
+	}
+	return nil
+}
+
+// saveState persists the state if a StatePath is configured; the caller must hold rl.mu
+func (rl *RateLimitNotifier) saveState() error {
+	if rl.StatePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(&rl.state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(rl.StatePath, data, 0644)
+}
+
+// notificationFingerprint identifies the content of a notification regardless
+// of when it was sent
+func notificationFingerprint(n *Notification) string {
+	keys := make([]string, len(n.Events))
+	for i, event := range n.Events {
+		keys[i] = fmt.Sprintf("%s/%s:%s:%x", event.Folder, event.FileName,
+			GetChangeTypeString(event.ChangeType), event.NewHash)
+	}
+	sort.Strings(keys)
+
+	hasher := sha256.New()
+	for _, key := range keys {
+		hasher.Write([]byte(key))
+		hasher.Write([]byte{0})
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
@@ -0,0 +1,193 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Role is a set of permissions granted to an API caller
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleOperator
+	RoleAdmin
+)
+
+// Permission is an action guarded by role-based access control
+type Permission int
+
+const (
+	PermReadReports  Permission = iota // view snapshots, reports and history
+	PermTriggerScans                   // create snapshots and run comparisons
+	PermDelete                         // prune, collect or delete stored data
+)
+
+// Allows reports whether the role grants a permission. Roles are cumulative:
+// operators can do everything viewers can, and admins everything operators can.
+func (r Role) Allows(p Permission) bool {
+	switch p {
+	case PermReadReports:
+		return r >= RoleViewer
+	case PermTriggerScans:
+		return r >= RoleOperator
+	case PermDelete:
+		return r >= RoleAdmin
+	default:
+		return false
+	}
+}
+
+// String returns the role's name
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// ParseRole is the inverse of Role.String
+func ParseRole(s string) (Role, error) {
+	for _, r := range []Role{RoleViewer, RoleOperator, RoleAdmin} {
+		if r.String() == s {
+			return r, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role: %q", s)
+}
+
+// Principal is an authenticated caller
+type Principal struct {
+	Role      Role
+	Namespace string // namespace the caller is confined to; empty for all
+}
+
+// CanAccess reports whether the principal may perform p in namespace
+func (p Principal) CanAccess(perm Permission, namespace string) bool {
+	if p.Namespace != "" && p.Namespace != namespace {
+		return false
+	}
+	return p.Role.Allows(perm)
+}
+
+// TokenAuthorizer maps bearer tokens to principals. Only SHA-256 hashes of
+// tokens are kept, so the token file does not contain usable secrets.
+type TokenAuthorizer struct {
+	tokens map[string]Principal // hex SHA-256 of token -> principal
+}
+
+// HashToken returns the hex SHA-256 of a token, as stored in token files
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadTokenFile reads a CSV token file with rows of
+// "token_sha256,role[,namespace]"; lines starting with # are ignored
+func LoadTokenFile(filename string) (*TokenAuthorizer, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+
+	auth := &TokenAuthorizer{tokens: make(map[string]Principal)}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) < 2 || len(row) > 3 {
+			return nil, fmt.Errorf("invalid token file row: expected token_sha256,role[,namespace]")
+		}
+
+		role, err := ParseRole(row[1])
+		if err != nil {
+			return nil, err
+		}
+		principal := Principal{Role: role}
+		if len(row) == 3 && row[2] != "" {
+			if !ValidNamespace(row[2]) {
+				return nil, fmt.Errorf("invalid namespace in token file: %q", row[2])
+			}
+			principal.Namespace = row[2]
+		}
+		auth.tokens[row[0]] = principal
+	}
+
+	return auth, nil
+}
+
+// Authenticate returns the principal for a bearer token
+func (a *TokenAuthorizer) Authenticate(token string) (Principal, bool) {
+	hashed := HashToken(token)
+	for stored, principal := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hashed)) == 1 {
+			return principal, true
+		}
+	}
+	return Principal{}, false
+}
+
+// ClaimsRoleMapper derives roles from the claims of an already verified OIDC
+// ID token, e.g. mapping membership of a "groups" claim to roles
+type ClaimsRoleMapper struct {
+	Claim          string          // claim holding a string or list of strings
+	Roles          map[string]Role // claim value -> role
+	NamespaceClaim string          // optional claim confining the caller to a namespace
+}
+
+// Principal returns the strongest role granted by the claims
+func (m *ClaimsRoleMapper) Principal(claims map[string]interface{}) (Principal, bool) {
+	var values []string
+	switch v := claims[m.Claim].(type) {
+	case string:
+		values = []string{v}
+	case []string:
+		values = v
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	principal := Principal{}
+	for _, value := range values {
+		if role := m.Roles[value]; role > principal.Role {
+			principal.Role = role
+		}
+	}
+	if principal.Role == RoleNone {
+		return Principal{}, false
+	}
+
+	if m.NamespaceClaim != "" {
+		namespace, _ := claims[m.NamespaceClaim].(string)
+		if !ValidNamespace(namespace) {
+			return Principal{}, false
+		}
+		principal.Namespace = namespace
+	}
+	return principal, true
+}
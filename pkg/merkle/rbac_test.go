@@ -0,0 +1,100 @@
+package merkle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Each role grants its own permissions and those of the roles below it
+func TestRolesAreCumulative(t *testing.T) {
+	for _, tc := range []struct {
+		role                  Role
+		read, trigger, remove bool
+	}{
+		{RoleNone, false, false, false},
+		{RoleViewer, true, false, false},
+		{RoleOperator, true, true, false},
+		{RoleAdmin, true, true, true},
+	} {
+		for perm, want := range map[Permission]bool{PermReadReports: tc.read, PermTriggerScans: tc.trigger, PermDelete: tc.remove} {
+			if got := tc.role.Allows(perm); got != want {
+				t.Errorf("%s allows %d = %v, want %v", tc.role, perm, got, want)
+			}
+		}
+	}
+
+	confined := Principal{Role: RoleAdmin, Namespace: "team-a"}
+	if !confined.CanAccess(PermDelete, "team-a") || confined.CanAccess(PermReadReports, "team-b") || confined.CanAccess(PermReadReports, "") {
+		t.Error("a principal confined to team-a reaches beyond it")
+	}
+	if global := (Principal{Role: RoleViewer}); !global.CanAccess(PermReadReports, "team-b") || global.CanAccess(PermTriggerScans, "team-b") {
+		t.Error("an unconfined viewer is not a viewer of every namespace")
+	}
+}
+
+// Token files hold hashes of tokens, mapped to a role and a namespace
+func TestTokenFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tokens.csv")
+	content := "# token_sha256,role,namespace\n" +
+		HashToken("view-all") + ",viewer\n" +
+		HashToken("ops-a") + ",operator,team-a\n"
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := LoadTokenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for token, want := range map[string]Principal{
+		"view-all": {Role: RoleViewer},
+		"ops-a":    {Role: RoleOperator, Namespace: "team-a"},
+	} {
+		if got, ok := auth.Authenticate(token); !ok || got != want {
+			t.Errorf("%s = %+v, %v; want %+v", token, got, ok, want)
+		}
+	}
+	for _, token := range []string{"", "ops-b", HashToken("ops-a")} {
+		if got, ok := auth.Authenticate(token); ok {
+			t.Errorf("%q authenticates as %+v", token, got)
+		}
+	}
+
+	for _, bad := range []string{
+		HashToken("x") + ",superuser\n",
+		HashToken("x") + ",viewer,../team-a\n",
+		HashToken("x") + "\n",
+	} {
+		if err := os.WriteFile(filename, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadTokenFile(filename); err == nil {
+			t.Errorf("token file %q was loaded", bad)
+		}
+	}
+}
+
+// Claims grant the strongest role they map to, in the namespace they name
+func TestClaimsRoleMapper(t *testing.T) {
+	mapper := &ClaimsRoleMapper{
+		Claim:          "groups",
+		Roles:          map[string]Role{"devs": RoleViewer, "ops": RoleAdmin},
+		NamespaceClaim: "tenant",
+	}
+	for _, tc := range []struct {
+		claims map[string]interface{}
+		want   Principal
+		ok     bool
+	}{
+		{map[string]interface{}{"groups": []interface{}{"devs", "ops"}, "tenant": "team-a"}, Principal{Role: RoleAdmin, Namespace: "team-a"}, true},
+		{map[string]interface{}{"groups": "devs", "tenant": "team-a"}, Principal{Role: RoleViewer, Namespace: "team-a"}, true},
+		{map[string]interface{}{"groups": []interface{}{"guests"}, "tenant": "team-a"}, Principal{}, false},
+		{map[string]interface{}{"groups": "ops"}, Principal{}, false},
+		{map[string]interface{}{"groups": "ops", "tenant": "../team-a"}, Principal{}, false},
+	} {
+		if got, ok := mapper.Principal(tc.claims); got != tc.want || ok != tc.ok {
+			t.Errorf("claims %v = %+v, %v; want %+v, %v", tc.claims, got, ok, tc.want, tc.ok)
+		}
+	}
+}
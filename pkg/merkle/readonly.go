@@ -0,0 +1,8 @@
+//go:build readonly
+
+package merkle
+
+// readOnly is set by the readonly build tag. Such builds leave out the HTTP
+// and gRPC servers, webhooks and HTTPUploader, and every write to a storage directory fails with
+// ErrReadOnly, for verifiers embedded in hardened images.
+const readOnly = true
@@ -0,0 +1,6 @@
+//go:build !readonly
+
+package merkle
+
+// readOnly is false unless built with the readonly tag; see readonly.go
+const readOnly = false
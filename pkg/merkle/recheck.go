@@ -0,0 +1,77 @@
+package merkle
+
+import (
+	"os"
+	"time"
+)
+
+// RecheckResult is what a second hash of a changed file found
+type RecheckResult int
+
+const (
+	NotRechecked      RecheckResult = iota
+	MismatchPersisted               // the file still has the state the comparison found
+	MismatchReverted                // the file is back in its old state
+	StillChanging                   // the file differs from both states
+)
+
+// RecheckChanges waits for delay, then hashes every modified, added and
+// deleted file of a report a second time and records in its Recheck field
+// whether the change persisted. A file that was caught mid-write usually
+// shows up as reverted or still changing, while tampering persists. Files
+// that cannot be re-read, and files inside container images, are left as
+// NotRechecked.
+func (c *MerkleClient) RecheckChanges(folderPath string, report *ChangeReport, delay time.Duration) error {
+	if IsImagePath(folderPath) {
+		return nil
+	}
+	time.Sleep(delay)
+
+	for i := range report.Changes {
+		change := &report.Changes[i]
+		if change.ChangeType != Modified && change.ChangeType != Added && change.ChangeType != Deleted {
+			continue
+		}
+
+		present, hash, ok := c.currentHash(folderPath, change.FileName)
+		if !ok {
+			continue
+		}
+		newPresent := change.ChangeType != Deleted
+		oldPresent := change.ChangeType != Added
+		switch {
+		case present == newPresent && (!present || equalHashes(hash, change.NewHash)):
+			change.Recheck = MismatchPersisted
+		case present == oldPresent && (!present || equalHashes(hash, change.OldHash)):
+			change.Recheck = MismatchReverted
+		default:
+			change.Recheck = StillChanging
+		}
+	}
+	return nil
+}
+
+// currentHash hashes a file of a folder as it is now. It reports whether the
+// file exists and false if it could not be examined.
+func (c *MerkleClient) currentHash(folderPath, fileName string) (bool, []byte, bool) {
+	path := fileOnDisk(folderPath, fileName)
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil, true
+	}
+	if err != nil {
+		return false, nil, false
+	}
+	if isSpecialFile(info.Mode()) {
+		return true, hashSpecialFile(info.Mode()), true
+	}
+	if !info.Mode().IsRegular() {
+		return false, nil, false
+	}
+
+	digest, err := hashFileWithTimeout(path, fileName, c.scan)
+	if err != nil {
+		return false, nil, false
+	}
+	return true, digest.hash, true
+}
@@ -0,0 +1,74 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Case renames, renames and moves all record the previous path in OldPath,
+// which the text and JSON reports show
+func TestRenamesRecordOldPath(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"Readme.md": "doc", "a.txt": "rename me", "src/b.txt": "move me"})
+	report := patchReport(t, NewClient(t.TempDir()), dir, func() {
+		for from, to := range map[string]string{"Readme.md": "README.md", "a.txt": "c.txt", "src/b.txt": "lib/b.txt"} {
+			target := filepath.Join(dir, filepath.FromSlash(to))
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(filepath.Join(dir, filepath.FromSlash(from)), target); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	want := map[string]struct {
+		changeType ChangeType
+		oldPath    string
+	}{
+		"README.md": {CaseRenamed, "Readme.md"},
+		"c.txt":     {Renamed, "a.txt"},
+		"lib/b.txt": {Moved, "src/b.txt"},
+	}
+	if len(report.Changes) != len(want) {
+		t.Fatalf("changes = %+v, want %d", report.Changes, len(want))
+	}
+	for _, change := range report.Changes {
+		w, ok := want[change.FileName]
+		if !ok || change.ChangeType != w.changeType || change.OldPath != w.oldPath || change.NewPath != change.FileName {
+			t.Errorf("%s: %s from %q to %q, want %s from %q", change.FileName, GetChangeTypeString(change.ChangeType),
+				change.OldPath, change.NewPath, GetChangeTypeString(w.changeType), w.oldPath)
+		}
+	}
+
+	var text bytes.Buffer
+	WriteChangeReport(&text, report)
+	for _, line := range []string{"Readme.md -> README.md", "a.txt -> c.txt", "src/b.txt -> lib/b.txt"} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("text report lacks %q:\n%s", line, text.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := WriteJSONReport(&out, report); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Changes []struct {
+			FileName string `json:"file_path"`
+			OldPath  string `json:"old_path"`
+		} `json:"changes"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || len(decoded.Changes) != len(want) {
+		t.Fatalf("JSON report holds %d changes (%v), want %d", len(decoded.Changes), err, len(want))
+	}
+	for _, change := range decoded.Changes {
+		if change.OldPath != want[change.FileName].oldPath {
+			t.Errorf("JSON %s: old_path %q, want %q", change.FileName, change.OldPath, want[change.FileName].oldPath)
+		}
+	}
+}
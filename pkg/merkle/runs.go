@@ -0,0 +1,237 @@
+package merkle
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// runsFile logs a summary of every run, one row each. Unlike snapshots it is
+// never pruned or collected, so trends outlive any retention policy.
+const runsFile = "runs.csv"
+
+var runsHeader = []string{
+	"folder", "kind", "timestamp", "duration_ms", "old_root_hash", "new_root_hash",
+	"files", "bytes", "errors", "added", "modified", "deleted", "other", "max_severity",
+}
+
+// RunSummary is the compact record of one scan of a folder and of its
+// comparison, if any
+type RunSummary struct {
+	Folder      string        // name of the folder, as in snapshot file names
+	Kind        string        // what the run did, e.g. "snapshot", "compare", "verify" or "watch"
+	Timestamp   time.Time     // when the scanned state was taken
+	Duration    time.Duration // time spent scanning and comparing; 0 when unknown
+	OldRootHash []byte        // root hash compared against; nil without a comparison
+	NewRootHash []byte
+	Files       int   // files in the scanned state, including unreadable ones
+	Bytes       int64 // total size of the hashed files
+	Errors      int   // files that could not be hashed
+
+	// Changes found by the comparison, by type; renames, moves and
+	// attribute changes count as Other
+	Added, Modified, Deleted, Other int
+	MaxSeverity                     Severity
+}
+
+// NewRunSummary summarizes a run that scanned state and, unless report is
+// nil, compared it
+func NewRunSummary(folderPath, kind string, state *TreeState, report *ChangeReport, duration time.Duration) RunSummary {
+	stats := state.Stats()
+	summary := RunSummary{
+		Folder:      filepath.Base(folderPath),
+		Kind:        kind,
+		Timestamp:   state.Timestamp,
+		Duration:    duration,
+		NewRootHash: state.RootHash,
+		Files:       stats.Leaves,
+		Bytes:       stats.Bytes,
+		Errors:      len(state.Errors),
+	}
+	if report == nil {
+		return summary
+	}
+
+	summary.OldRootHash = report.OldRootHash
+	summary.MaxSeverity = report.MaxSeverity()
+	for _, change := range report.Changes {
+		switch change.ChangeType {
+		case Added:
+			summary.Added++
+		case Modified:
+			summary.Modified++
+		case Deleted:
+			summary.Deleted++
+		default:
+			summary.Other++
+		}
+	}
+	return summary
+}
+
+// Changes returns the number of changes the run found
+func (s RunSummary) Changes() int {
+	return s.Added + s.Modified + s.Deleted + s.Other
+}
+
+// RecordRun appends a run summary to the run log
+func (c *MerkleClient) RecordRun(summary RunSummary) error {
+	unlock, err := c.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	filename := filepath.Join(c.storageDir, runsFile)
+	_, statErr := os.Stat(filename)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if os.IsNotExist(statErr) {
+		if err := writer.Write(runsHeader); err != nil {
+			return err
+		}
+	}
+	if err := writer.Write([]string{
+		summary.Folder,
+		summary.Kind,
+		summary.Timestamp.Format(time.RFC3339),
+		strconv.FormatInt(summary.Duration.Milliseconds(), 10),
+		hex.EncodeToString(summary.OldRootHash),
+		hex.EncodeToString(summary.NewRootHash),
+		strconv.Itoa(summary.Files),
+		strconv.FormatInt(summary.Bytes, 10),
+		strconv.Itoa(summary.Errors),
+		strconv.Itoa(summary.Added),
+		strconv.Itoa(summary.Modified),
+		strconv.Itoa(summary.Deleted),
+		strconv.Itoa(summary.Other),
+		summary.MaxSeverity.String(),
+	}); err != nil {
+		return err
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// RunSummaries returns the recorded runs of a folder taken at or after since,
+// oldest first; a zero since returns all of them
+func (c *MerkleClient) RunSummaries(folderPath string, since time.Time) ([]RunSummary, error) {
+	file, err := os.Open(filepath.Join(c.storageDir, runsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = len(runsHeader)
+	if _, err := reader.Read(); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	folder := filepath.Base(folderPath)
+	var runs []RunSummary
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row[0] != folder {
+			continue
+		}
+
+		run := RunSummary{Folder: row[0], Kind: row[1]}
+		if run.Timestamp, err = time.Parse(time.RFC3339, row[2]); err != nil {
+			return nil, fmt.Errorf("invalid run timestamp: %v", err)
+		}
+		if run.Timestamp.Before(since) {
+			continue
+		}
+		ms, _ := strconv.ParseInt(row[3], 10, 64)
+		run.Duration = time.Duration(ms) * time.Millisecond
+		run.OldRootHash, _ = hex.DecodeString(row[4])
+		run.NewRootHash, _ = hex.DecodeString(row[5])
+		run.Files, _ = strconv.Atoi(row[6])
+		run.Bytes, _ = strconv.ParseInt(row[7], 10, 64)
+		run.Errors, _ = strconv.Atoi(row[8])
+		run.Added, _ = strconv.Atoi(row[9])
+		run.Modified, _ = strconv.Atoi(row[10])
+		run.Deleted, _ = strconv.Atoi(row[11])
+		run.Other, _ = strconv.Atoi(row[12])
+		run.MaxSeverity, _ = ParseSeverity(row[13])
+		if len(run.OldRootHash) == 0 {
+			run.OldRootHash = nil
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// jsonRun is the JSON form of a run summary
+type jsonRun struct {
+	Folder      string    `json:"folder"`
+	Kind        string    `json:"kind"`
+	Timestamp   time.Time `json:"timestamp"`
+	DurationMS  int64     `json:"duration_ms"`
+	OldRootHash string    `json:"old_root_hash,omitempty"`
+	NewRootHash string    `json:"new_root_hash"`
+	Files       int       `json:"files"`
+	Bytes       int64     `json:"bytes"`
+	Errors      int       `json:"errors"`
+	Added       int       `json:"added"`
+	Modified    int       `json:"modified"`
+	Deleted     int       `json:"deleted"`
+	Other       int       `json:"other"`
+	MaxSeverity string    `json:"max_severity" enum:"max_severity"`
+}
+
+// WriteJSONRuns writes run summaries as an indented JSON array for trend
+// dashboards
+func WriteJSONRuns(w io.Writer, runs []RunSummary) error {
+	out := make([]jsonRun, len(runs))
+	for i, run := range runs {
+		out[i] = jsonRun{
+			Folder:      run.Folder,
+			Kind:        run.Kind,
+			Timestamp:   run.Timestamp,
+			DurationMS:  run.Duration.Milliseconds(),
+			OldRootHash: hex.EncodeToString(run.OldRootHash),
+			NewRootHash: hex.EncodeToString(run.NewRootHash),
+			Files:       run.Files,
+			Bytes:       run.Bytes,
+			Errors:      run.Errors,
+			Added:       run.Added,
+			Modified:    run.Modified,
+			Deleted:     run.Deleted,
+			Other:       run.Other,
+			MaxSeverity: maxSeverityName(run.Added+run.Modified+run.Deleted+run.Other, run.MaxSeverity),
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
@@ -0,0 +1,208 @@
+package merkle
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// jsonSchemaDraft is the JSON Schema dialect the published schemas use
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonPayload is a JSON output with a published schema
+type jsonPayload struct {
+	goType      reflect.Type
+	title       string
+	description string
+	array       bool // the output is an array of goType
+}
+
+// jsonPayloads lists every JSON output by schema name. The schemas are
+// generated from the same types the outputs are encoded from, so they cannot
+// drift apart. The outputs of serve are added by schema_server.go.
+var jsonPayloads = map[string]jsonPayload{
+	"snapshot": {
+		goType:      reflect.TypeOf(jsonSnapshot{}),
+		title:       "Snapshot",
+		description: "A snapshot saved with --snapshot-format=json. Per-file fields use the encodings of the CSV columns of the same name.",
+	},
+	"report": {
+		goType:      reflect.TypeOf(jsonReport{}),
+		title:       "Change report",
+		description: "A comparison of two states, as written by --format=json and WriteJSONReport.",
+	},
+	"events": {
+		goType:      reflect.TypeOf(jsonEvent{}),
+		title:       "Change events",
+		description: "Changes recorded in the history store, as written by history --format=json and WriteJSONEvents.",
+		array:       true,
+	},
+	"runs": {
+		goType:      reflect.TypeOf(jsonRun{}),
+		title:       "Run summaries",
+		description: "Summaries of the runs of a folder, oldest first, as written by runs --format=json and WriteJSONRuns.",
+		array:       true,
+	},
+	"snapshot-info": {
+		goType:      reflect.TypeOf(jsonSnapshotInfo{}),
+		title:       "Snapshot info",
+		description: "A stored snapshot, as returned by POST /snapshots and GET /tree/root of serve.",
+	},
+	"snapshot-list": {
+		goType:      reflect.TypeOf(jsonSnapshotInfo{}),
+		title:       "Snapshot list",
+		description: "A page of the stored snapshots of a folder, as returned by GET /snapshots of serve and written by snapshots --format=json, or the snapshots removed by POST /prune and POST /gc of serve. Root hashes and file counts are left out.",
+		array:       true,
+	},
+	"verification": {
+		goType:      reflect.TypeOf(jsonVerification{}),
+		title:       "Verification",
+		description: "The outcome of verifying several folders against their baselines, as written by verify-all --format=json and WriteJSONVerification.",
+	},
+	"proof": {
+		goType:      reflect.TypeOf(proofJSON{}),
+		title:       "Inclusion proof",
+		description: "A Merkle inclusion proof, as written by prove and Proof.MarshalJSON.",
+	},
+}
+
+// schemaEnums lists the values of string fields tagged enum:"<name>", taken
+// from the functions that produce them
+var schemaEnums = map[string]func() []string{
+	"change_type": func() []string {
+		var values []string
+		for changeType := Modified; changeType <= Moved; changeType++ {
+			values = append(values, GetChangeTypeString(changeType))
+		}
+		return values
+	},
+	"severity": func() []string {
+		var values []string
+		for severity := SeverityInfo; severity <= SeverityCritical; severity++ {
+			values = append(values, severity.String())
+		}
+		return values
+	},
+	"max_severity": func() []string {
+		var values []string
+		for severity := SeverityInfo; severity <= SeverityCritical; severity++ {
+			values = append(values, severity.String())
+		}
+		return append(values, noSeverity)
+	},
+	"content": func() []string {
+		return []string{
+			GetContentKindString(ContentUnknown),
+			GetContentKindString(ContentText),
+			GetContentKindString(ContentBinary),
+		}
+	},
+	"verification": func() []string {
+		return []string{"passed", "failed", "error"}
+	},
+	"recheck": func() []string {
+		return []string{
+			GetRecheckString(MismatchPersisted),
+			GetRecheckString(MismatchReverted),
+			GetRecheckString(StillChanging),
+		}
+	},
+}
+
+// SchemaNames returns the names of the published JSON schemas, sorted
+func SchemaNames() []string {
+	names := make([]string, 0, len(jsonPayloads))
+	for name := range jsonPayloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// JSONSchema returns the JSON Schema of a JSON output by name, as listed by
+// SchemaNames. Fields that are always written are required; new optional
+// fields may be added, so validators should allow unknown properties.
+func JSONSchema(name string) ([]byte, error) {
+	payload, ok := jsonPayloads[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %q (available: %s)", name, strings.Join(SchemaNames(), ", "))
+	}
+
+	schema := typeSchema(payload.goType)
+	if payload.array {
+		schema = map[string]any{"type": "array", "items": schema}
+	}
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = payload.title
+	schema["description"] = payload.description
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// typeSchema returns the schema of the JSON encoding of a Go type
+func typeSchema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		required := []string{}
+		addStructFields(t, properties, &required)
+		return map[string]any{"type": "object", "properties": properties, "required": required}
+	}
+	panic(fmt.Sprintf("no JSON schema for %s", t))
+}
+
+// addStructFields adds the fields of a struct as encoding/json encodes them,
+// with embedded structs flattened into their parent
+func addStructFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			addStructFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := typeSchema(field.Type)
+		if enum := field.Tag.Get("enum"); enum != "" {
+			schema["enum"] = schemaEnums[enum]()
+		}
+		properties[name] = schema
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
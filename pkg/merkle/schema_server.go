@@ -0,0 +1,27 @@
+//go:build !readonly
+
+package merkle
+
+import "reflect"
+
+// The outputs of Server, which read-only builds leave out
+func init() {
+	jsonPayloads["webhook-result"] = jsonPayload{
+		goType:      reflect.TypeOf(jsonWebhookResult{}),
+		title:       "Webhook result",
+		description: "The outcome of a webhook call, as returned by POST /hooks/<name> of serve. The report is left out when there was no earlier snapshot or baseline to compare with.",
+	}
+	jsonPayloads["upload-manifest"] = jsonPayload{
+		goType:      reflect.TypeOf(jsonUploadManifest{}),
+		title:       "Upload manifest",
+		description: "The manifest of a chunked snapshot upload, as sent to PUT /uploads/<id> of serve. Digests are hex SHA-256, one per chunk of chunk_size bytes.",
+	}
+	jsonPayloads["upload-status"] = jsonPayload{
+		goType:      reflect.TypeOf(jsonUploadStatus{}),
+		title:       "Upload status",
+		description: "The chunks of an upload the server holds, as returned by the /uploads endpoints of serve. The snapshot is set once the upload is committed.",
+	}
+	schemaEnums["webhook_action"] = func() []string {
+		return []string{WebhookSnapshot.String(), WebhookVerify.String()}
+	}
+}
@@ -0,0 +1,179 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HashScheme describes how the hashes in a snapshot were produced. Snapshots
+// taken under different schemes cannot be compared file by file.
+type HashScheme struct {
+	Algorithm     string // leaf digest algorithm, e.g. "sha256"
+	NodeAlgorithm string // digest combining child hashes into interior nodes
+	LeafEncoding  string // "raw", or the patterns and names of content transforms applied before hashing
+	TreeVersion   int    // layout of the tree built over the leaves; see LeafOrder
+	PathEncoding  string // "slash", with ",nfc" when paths are normalized to NFC and ",casefold" when compared ignoring letter case
+	Filter        string // fingerprint of the exclude, include and ignore file rules; "" when none apply
+}
+
+// schemeVersion is the version of the encoding written by HashScheme.String.
+// Version 1 schemes had no version or node algorithm, as interior nodes were
+// always combined with SHA-256. Versions 1 and 2 had no filter; such schemes
+// are read as taken with no rules, which is what they were produced with.
+const schemeVersion = 3
+
+// legacyScheme is assumed for snapshots saved before schemes were recorded
+var legacyScheme = HashScheme{Algorithm: "sha256", NodeAlgorithm: "sha256", LeafEncoding: "raw", TreeVersion: 1, PathEncoding: "slash"}
+
+// String encodes the scheme as stored in the snapshot's scheme column. The
+// leaf encoding comes last as it holds free-form patterns and names.
+func (s HashScheme) String() string {
+	return fmt.Sprintf("v=%d;alg=%s;node=%s;tree=%d;path=%s;filter=%s;leaf=%s",
+		schemeVersion, s.Algorithm, s.NodeAlgorithm, s.TreeVersion, s.PathEncoding, s.Filter, s.LeafEncoding)
+}
+
+// parseHashScheme is the inverse of HashScheme.String. It also reads version 1
+// schemes, "alg=...;tree=...;path=...;leaf=...", and version 2 schemes, which
+// lack "filter=".
+func parseHashScheme(value string) (HashScheme, error) {
+	invalid := fmt.Errorf("invalid hash scheme: %q", value)
+	if !strings.HasPrefix(value, "v=") {
+		parts := strings.SplitN(value, ";", 2)
+		if len(parts) != 2 {
+			return HashScheme{}, invalid
+		}
+		value = strings.Join([]string{"v=1", parts[0], "node=sha256", parts[1]}, ";")
+	}
+	if strings.HasPrefix(value, "v=1;") || strings.HasPrefix(value, "v=2;") {
+		parts := strings.SplitN(value, ";", 6)
+		if len(parts) != 6 {
+			return HashScheme{}, invalid
+		}
+		value = strings.Join(append(parts[:5:5], "filter=", parts[5]), ";")
+	}
+
+	parts := strings.SplitN(value, ";", 7)
+	if len(parts) != 7 {
+		return HashScheme{}, invalid
+	}
+	fields := make([]string, len(parts))
+	for i, key := range []string{"v=", "alg=", "node=", "tree=", "path=", "filter=", "leaf="} {
+		field, ok := strings.CutPrefix(parts[i], key)
+		if !ok {
+			return HashScheme{}, invalid
+		}
+		fields[i] = field
+	}
+	version, errVersion := strconv.Atoi(fields[0])
+	tree, errTree := strconv.Atoi(fields[3])
+	if errVersion != nil || errTree != nil {
+		return HashScheme{}, invalid
+	}
+	if version > schemeVersion {
+		return HashScheme{}, fmt.Errorf("hash scheme version %d is newer than this program supports (%d)", version, schemeVersion)
+	}
+
+	return HashScheme{
+		Algorithm:     fields[1],
+		NodeAlgorithm: fields[2],
+		TreeVersion:   tree,
+		PathEncoding:  fields[4],
+		Filter:        fields[5],
+		LeafEncoding:  fields[6],
+	}, nil
+}
+
+// Compatible returns an error explaining why snapshots taken under s and other
+// cannot be compared, or nil if they can
+func (s HashScheme) Compatible(other HashScheme) error {
+	if s == (HashScheme{}) {
+		s = legacyScheme
+	}
+	if other == (HashScheme{}) {
+		other = legacyScheme
+	}
+
+	switch {
+	case s.Algorithm != other.Algorithm:
+		return fmt.Errorf("incompatible snapshots: hashed with %s and %s; take a new baseline", s.Algorithm, other.Algorithm)
+	case s.NodeAlgorithm != other.NodeAlgorithm:
+		return fmt.Errorf("incompatible snapshots: tree nodes combined with %s and %s; take a new baseline", s.NodeAlgorithm, other.NodeAlgorithm)
+	case s.LeafEncoding != other.LeafEncoding:
+		return fmt.Errorf("incompatible snapshots: leaf encoding %q and %q; take a new baseline", s.LeafEncoding, other.LeafEncoding)
+	case s.leafOrder() != other.leafOrder():
+		// Files compare alike however the nodes above them are hashed
+		return fmt.Errorf("incompatible snapshots: tree version %d and %d; take a new baseline", s.TreeVersion, other.TreeVersion)
+	case s.PathEncoding != other.PathEncoding:
+		return fmt.Errorf("incompatible snapshots: path encoding %q and %q; take a new baseline", s.PathEncoding, other.PathEncoding)
+	case s.Filter != other.Filter:
+		return fmt.Errorf("incompatible snapshots: taken with different exclude, include or ignore file rules; take a new baseline")
+	}
+	return nil
+}
+
+// scheme returns the hash scheme that scans with these options produce
+func (opts scanOptions) scheme() HashScheme {
+	algorithm := "custom"
+	if named, ok := opts.leafHasher.(interface{ Algorithm() string }); ok {
+		algorithm = named.Algorithm()
+	}
+
+	encoding := "raw"
+	if len(opts.transforms) > 0 {
+		transforms := make([]string, len(opts.transforms))
+		for i, t := range opts.transforms {
+			transforms[i] = fmt.Sprintf("%q=%q", t.pattern, t.name)
+		}
+		encoding = "transform:" + strings.Join(transforms, ",")
+	}
+
+	// Custom and external hashers have no digest to combine nodes with
+	node := "sha256"
+	if _, ok := hashAlgorithms[algorithm]; ok {
+		node = algorithm
+	}
+
+	paths := "slash"
+	if opts.leafOrder.normalizes() {
+		paths += ",nfc"
+	}
+	if opts.foldCase {
+		paths += ",casefold"
+	}
+
+	return HashScheme{
+		Algorithm:     algorithm,
+		NodeAlgorithm: node,
+		LeafEncoding:  encoding,
+		TreeVersion:   opts.leafOrder.treeVersion(),
+		PathEncoding:  paths,
+		Filter:        opts.filterFingerprint(),
+	}
+}
+
+// filterFingerprint identifies the rules deciding which files a scan sees, so
+// snapshots that saw different files are not compared as if files had been
+// added or deleted. It is empty when no rules apply.
+func (opts scanOptions) filterFingerprint() string {
+	if len(opts.excludes) == 0 && len(opts.includes) == 0 && len(opts.ignoreFiles) == 0 {
+		return ""
+	}
+
+	var rules []string
+	for _, rule := range opts.excludes {
+		rules = append(rules, "exclude "+rule.String())
+	}
+	for _, rule := range opts.includes {
+		rules = append(rules, "include "+rule.String())
+	}
+	names := append([]string(nil), opts.ignoreFiles...)
+	sort.Strings(names)
+	rules = append(rules, "ignore-files "+strings.Join(names, ","))
+
+	sum := sha256.Sum256([]byte(strings.Join(rules, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
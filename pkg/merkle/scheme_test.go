@@ -0,0 +1,252 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHashSchemeRoundTrip(t *testing.T) {
+	scheme := HashScheme{
+		Algorithm:     "blake3",
+		NodeAlgorithm: "blake3",
+		LeafEncoding:  `transform:"*.txt"="eol","gen/*"="strip ;x"`,
+		TreeVersion:   2,
+		PathEncoding:  "slash,casefold",
+		Filter:        "0123456789abcdef",
+	}
+	parsed, err := parseHashScheme(scheme.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != scheme {
+		t.Errorf("parsed %+v, want %+v", parsed, scheme)
+	}
+}
+
+func TestHashSchemeVersions(t *testing.T) {
+	current := defaultScanOptions().scheme()
+	for _, tc := range []struct {
+		name       string
+		stored     string
+		compatible bool
+	}{
+		{"version 1 default", "alg=sha256;tree=1;path=slash;leaf=raw", true},
+		{"version 2 default", "v=2;alg=sha256;node=sha256;tree=1;path=slash;leaf=raw", true},
+		{"version 3 default", current.String(), true},
+		{"version 1 other algorithm", "alg=blake3;tree=1;path=slash;leaf=raw", false},
+		{"other node algorithm", "v=3;alg=sha256;node=blake3;tree=1;path=slash;filter=;leaf=raw", false},
+		{"case folded", "v=3;alg=sha256;node=sha256;tree=1;path=slash,casefold;filter=;leaf=raw", false},
+		{"filtered", "v=3;alg=sha256;node=sha256;tree=1;path=slash;filter=0123456789abcdef;leaf=raw", false},
+		{"other leaf order", "v=3;alg=sha256;node=sha256;tree=2;path=slash;filter=;leaf=raw", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stored, err := parseHashScheme(tc.stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := stored.Compatible(current); (err == nil) != tc.compatible {
+				t.Errorf("Compatible = %v, want compatible %v", err, tc.compatible)
+			}
+		})
+	}
+
+	// Interior nodes were combined with SHA-256 before version 2
+	if stored, err := parseHashScheme("alg=blake3;tree=1;path=slash;leaf=raw"); err != nil || stored.NodeAlgorithm != "sha256" {
+		t.Errorf("version 1 scheme read as %+v, %v; want node algorithm sha256", stored, err)
+	}
+	// Filters were not recorded before version 3
+	if stored, err := parseHashScheme("v=2;alg=sha256;node=sha256;tree=1;path=slash;leaf=raw"); err != nil || stored.Filter != "" {
+		t.Errorf("version 2 scheme read as %+v, %v; want no filter", stored, err)
+	}
+	if _, err := parseHashScheme("v=4;alg=sha256;node=sha256;tree=1;path=slash;filter=;leaf=raw"); err == nil {
+		t.Error("a scheme from a newer version was accepted")
+	}
+}
+
+// A snapshot saved with a version 1 scheme still compares with a new scan
+func TestVersion1SnapshotStillCompares(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b/c.txt": "c"})
+	storageDir := t.TempDir()
+	client := NewClient(storageDir)
+	state, err := client.CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SaveSnapshot(state, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := client.FindLatestSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(storageDir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := strings.ReplaceAll(string(data), state.Scheme.String(), "alg=sha256;tree=1;path=slash;leaf=raw")
+	if v1 == string(data) {
+		t.Fatal("the saved snapshot does not hold the scheme")
+	}
+	if err := os.WriteFile(path, []byte(v1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := client.LoadSnapshot(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := client.CompareSnapshots(old, state)
+	if err != nil {
+		t.Fatalf("CompareSnapshots: %v", err)
+	}
+	if len(report.Changes) != 0 {
+		t.Errorf("changes = %v, want none", report.Changes)
+	}
+}
+
+// Comparing snapshots taken with and without case folding, or with different
+// filters, would report files as renamed, added or deleted, so they are refused
+func TestPathTransformsMustMatch(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b.log": "b"})
+	snapshot := func(opts ...Option) *TreeState {
+		t.Helper()
+		state, err := NewClient(t.TempDir(), opts...).CreateSnapshot(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+	plain := snapshot()
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"case folding", []Option{WithCaseInsensitivePaths(true)}},
+		{"excludes", []Option{WithExcludes("*.log")}},
+		{"includes", []Option{WithIncludes("*.txt")}},
+		{"ignore files", []Option{WithIgnoreFiles(MerkleIgnoreFile)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			other := snapshot(tc.opts...)
+			if _, err := NewClient(t.TempDir(), tc.opts...).CompareSnapshots(plain, other); err == nil {
+				t.Error("snapshots with different path transforms were compared")
+			}
+			if _, err := NewClient(t.TempDir(), tc.opts...).CompareSnapshots(other, snapshot(tc.opts...)); err != nil {
+				t.Errorf("snapshots with the same path transforms were refused: %v", err)
+			}
+		})
+	}
+}
+
+// Swapping the transformer of a pattern changes the hash scheme, so the
+// snapshots are refused rather than reported as modified, and hashes cached
+// under the old transformer are not reused
+func TestContentTransformersMustMatch(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "keep\r\n# stamp\r\n"})
+	eol := WithTransformer("eol", "*.txt", NormalizeLineEndings())
+	strip := WithTransformer("strip ^#", "*.txt", StripLinesMatching(regexp.MustCompile("^#")))
+
+	storageDir := t.TempDir()
+	client := NewClient(storageDir, eol, WithIncrementalSnapshots(true))
+	before, err := client.CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SaveSnapshot(before, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	swapped := NewClient(storageDir, strip, WithIncrementalSnapshots(true))
+	after, err := swapped.CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Scheme == after.Scheme {
+		t.Fatalf("both transformers give scheme %s", before.Scheme)
+	}
+	if _, err := swapped.CompareSnapshots(before, after); err == nil {
+		t.Error("snapshots with different transformers on the same pattern were compared")
+	}
+	if want := hashData([]byte("keep\r\n")); !bytes.Equal(after.FileHashes["a.txt"], want) {
+		t.Errorf("a.txt hashed as %x, want %x after stripping the comment", after.FileHashes["a.txt"], want)
+	}
+
+	same, err := NewClient(t.TempDir(), eol).CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CompareSnapshots(before, same); err != nil {
+		t.Errorf("snapshots with the same transformer were refused: %v", err)
+	}
+}
+
+// Every algorithm of the registry fingerprints files, combines the interior
+// nodes of the tree and can be stored as an extra digest
+func TestHashAlgorithmRegistry(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b/c.txt": "c", "b/d.txt": "d"})
+	for _, name := range HashAlgorithms() {
+		t.Run(name, func(t *testing.T) {
+			state, err := NewClient(t.TempDir(), WithHashAlgorithm(name), WithExtraDigests(name)).CreateSnapshot(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.Scheme.Algorithm != name || state.Scheme.NodeAlgorithm != name {
+				t.Errorf("scheme %+v, want leaf and node algorithm %s", state.Scheme, name)
+			}
+
+			digest := func(data []byte) []byte {
+				hasher := hashAlgorithms[name]()
+				hasher.Write(data)
+				return hasher.Sum(nil)
+			}
+			var leaves [][]byte
+			for _, file := range []struct{ name, content string }{{"a.txt", "a"}, {"b/c.txt", "c"}, {"b/d.txt", "d"}} {
+				want := digest([]byte(file.content))
+				if !bytes.Equal(state.FileHashes[file.name], want) || !bytes.Equal(state.Digests[file.name][name], want) {
+					t.Errorf("%s: hash %x, digest %x, want %x", file.name, state.FileHashes[file.name], state.Digests[file.name][name], want)
+				}
+				leaves = append(leaves, want)
+			}
+			// Three leaves: the last moves up a level, and the root covers
+			// the count
+			join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+			leaf := func(name string, hash []byte) []byte { return digest(join([]byte{0}, []byte(name), []byte{0}, hash)) }
+			top := digest(join([]byte{1}, digest(join([]byte{1}, leaf("a.txt", leaves[0]), leaf("b/c.txt", leaves[1]))), leaf("b/d.txt", leaves[2])))
+			root := digest(join([]byte{2, 0, 0, 0, 0, 0, 0, 0, 3}, top))
+			if !bytes.Equal(state.RootHash, root) {
+				t.Errorf("root %x, want %x combined with %s", state.RootHash, root, name)
+			}
+
+			proof, err := state.GenerateProof("b/c.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(proof)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded Proof
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyProof(state.RootHash, "b/c.txt", leaves[1], &decoded) {
+				t.Error("proof does not verify")
+			}
+		})
+	}
+}
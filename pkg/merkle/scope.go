@@ -0,0 +1,142 @@
+package merkle
+
+import (
+	"bytes"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WithPathScope restricts scans to the files at or below prefix, a path
+// relative to the scanned folder such as "services/billing". Only that
+// directory is walked, and files keep their paths relative to the folder, so
+// a scoped scan can be compared with the same part of a full snapshot through
+// CompareScoped. An empty prefix scans the whole folder.
+func WithPathScope(prefix string) Option {
+	return func(c *MerkleClient) {
+		c.scan.scope = cleanScope(prefix)
+	}
+}
+
+// cleanScope normalizes a path prefix to the form of snapshot paths, with ""
+// for the whole folder
+func cleanScope(prefix string) string {
+	prefix = path.Clean(filepath.ToSlash(prefix))
+	if prefix == "." || prefix == "/" {
+		return ""
+	}
+	return strings.TrimPrefix(prefix, "/")
+}
+
+// inScope reports whether a snapshot path is at or below prefix
+func inScope(fileName, prefix string) bool {
+	return prefix == "" || fileName == prefix || strings.HasPrefix(fileName, prefix+"/")
+}
+
+// Scope returns the part of a state at or below prefix. Its root hash is the
+// hash of that subtree, computed like DirNode.RollupHash, so the scopes of two
+// states have equal root hashes exactly when the files below prefix are the
+// same. An empty prefix returns the state itself.
+func (s *TreeState) Scope(prefix string) *TreeState {
+	prefix = cleanScope(prefix)
+	if prefix == "" {
+		return s
+	}
+
+	scoped := &TreeState{
+		Timestamp:  s.Timestamp,
+		FileHashes: make(map[string][]byte),
+		FileSizes:  make(map[string]int64),
+		Errors:     make(map[string]string),
+		Unstable:   make(map[string]bool),
+		Digests:    make(map[string]map[string][]byte),
+		Chunks:     make(map[string]*FileChunks),
+		Content:    make(map[string]ContentKind),
+		Attributes: make(map[string]FileAttributes),
+		Scheme:     s.Scheme,
+		Warnings:   s.Warnings,
+	}
+	for fileName, hash := range s.FileHashes {
+		if !inScope(fileName, prefix) {
+			continue
+		}
+		scoped.FileHashes[fileName] = hash
+		scoped.FileSizes[fileName] = s.FileSizes[fileName]
+		if s.Unstable[fileName] {
+			scoped.Unstable[fileName] = true
+		}
+		if digests, ok := s.Digests[fileName]; ok {
+			scoped.Digests[fileName] = digests
+		}
+		if chunks, ok := s.Chunks[fileName]; ok {
+			scoped.Chunks[fileName] = chunks
+		}
+		if kind, ok := s.Content[fileName]; ok {
+			scoped.Content[fileName] = kind
+		}
+		if attrs, ok := s.Attributes[fileName]; ok {
+			scoped.Attributes[fileName] = attrs
+		}
+	}
+	for fileName, reason := range s.Errors {
+		if inScope(fileName, prefix) {
+			scoped.Errors[fileName] = reason
+		}
+	}
+
+	scoped.RootHash = subtreeHash(BuildDirTree(scoped), prefix)
+	return scoped
+}
+
+// subtreeHash returns the rollup hash of the node at prefix, or the hash of an
+// empty directory if there is none
+func subtreeHash(root *DirNode, prefix string) []byte {
+	node := root
+	for _, name := range strings.Split(prefix, "/") {
+		child, ok := node.Child(name)
+		if !ok {
+			return hashData(nil)
+		}
+		node = child
+	}
+	return node.RollupHash()
+}
+
+// CompareScoped compares only the files at or below prefix of two states,
+// such as a full snapshot and a scan made WithPathScope. When the hashes of
+// the two subtrees match and neither has errored files or differing
+// attributes, the files are not compared one by one. An empty prefix compares
+// the whole states, like CompareSnapshots.
+func (c *MerkleClient) CompareScoped(oldState, newState *TreeState, prefix string) (*ChangeReport, error) {
+	oldScope, newScope := oldState.Scope(prefix), newState.Scope(prefix)
+	if cleanScope(prefix) == "" || !bytes.Equal(oldScope.RootHash, newScope.RootHash) ||
+		len(oldScope.Errors) > 0 || len(newScope.Errors) > 0 || !sameAttributes(oldScope, newScope) {
+		return c.CompareSnapshots(oldScope, newScope)
+	}
+
+	if err := oldScope.Scheme.Compatible(newScope.Scheme); err != nil {
+		return nil, err
+	}
+	report := &ChangeReport{
+		OldTimestamp: oldScope.Timestamp,
+		NewTimestamp: newScope.Timestamp,
+		OldRootHash:  oldScope.RootHash,
+		NewRootHash:  newScope.RootHash,
+		Changes:      []FileChange{},
+	}
+	report.tallySizes()
+	return report, nil
+}
+
+// sameAttributes reports whether two states record the same file attributes
+func sameAttributes(a, b *TreeState) bool {
+	if len(a.Attributes) != len(b.Attributes) {
+		return false
+	}
+	for fileName, attrs := range a.Attributes {
+		if other, ok := b.Attributes[fileName]; !ok || other != attrs {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,464 @@
+//go:build !readonly
+
+package merkle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server serves the snapshots of a storage directory over HTTP:
+//
+//	POST /snapshots?folder=<path>   scan a folder and save a snapshot (operator)
+//	GET  /snapshots?folder=<path>   list the stored snapshots of a folder (viewer),
+//	                                a page at a time with limit=, offset= and
+//	                                after=, filtered by since=, until= and label=
+//	GET  /diff?from=<id>&to=<id>    compare two stored snapshots (viewer)
+//	GET  /tree/root?folder=<path>   the latest snapshot of a folder (viewer)
+//	GET  /uploads/<id>              the chunks held of a snapshot upload (operator)
+//	PUT  /uploads/<id>              start or resume an upload with its manifest
+//	PUT  /uploads/<id>/chunks/<n>   store one chunk of an upload
+//	POST /uploads/<id>/commit       save the uploaded snapshot
+//	POST /prune?folder=<path>       remove the snapshots of a folder a retention
+//	                                policy does not keep (admin)
+//	POST /gc                        remove the snapshots of folders that are
+//	                                gone or stale (admin)
+//	POST /hooks/<name>              scan the folder of a webhook (webhook secret)
+//
+// Responses are JSON: reports as written by WriteJSONReport, snapshots as
+// described by the "snapshot-info" and "snapshot-list" schemas, webhook calls
+// by the "webhook-result" schema, uploads by the "upload-status" schema, and
+// failures as {"error": "..."}. Callers authenticate with "Authorization:
+// Bearer <token>", where the token is one of Tokens or an OIDC ID token
+// accepted by VerifyIDToken. Every request works in the storage directory of
+// the namespace given by ?namespace=, by default the caller's own namespace,
+// or the storage directory itself for callers not confined to one.
+// GRPCService serves the same storage over gRPC.
+type Server struct {
+	StorageDir string
+	Options    []Option         // options of the clients that scan and compare
+	Tokens     *TokenAuthorizer // nil lets every caller act as admin
+	Folders    []string         // the only folders that may be scanned outside namespaces; any when empty
+	Webhooks   []Webhook        // folders scanned when their webhook is called
+
+	// NamespaceFolders lists the only folders that may be scanned in each
+	// namespace. Once it is set, a namespace missing from it may scan none,
+	// so a tenant cannot scan the folders of another; while it is empty,
+	// namespaces may scan Folders like the shared storage directory.
+	NamespaceFolders map[string][]string
+
+	// VerifyIDToken, when set, authenticates bearer tokens missing from Tokens
+	// as OIDC ID tokens: it checks the signature, issuer, audience and expiry
+	// of a token and returns its claims, which ClaimRoles maps to a role and
+	// namespace. Tokens it rejects, or whose claims grant no role, are refused.
+	VerifyIDToken func(token string) (map[string]interface{}, error)
+	ClaimRoles    *ClaimsRoleMapper
+
+	// OnWebhook, when set, is called with the outcome of every authenticated
+	// webhook call, also when the caller no longer waits for it
+	OnWebhook func(WebhookResult)
+
+	mu      sync.Mutex
+	clients map[string]*serverClient // namespace -> client
+}
+
+// serverClient is the client of a namespace and its storage directory
+type serverClient struct {
+	Client
+	namespace string
+	dir       string
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Webhooks authenticate with their own secrets rather than bearer tokens
+	if strings.HasPrefix(r.URL.Path, "/hooks/") {
+		s.serveWebhook(w, r)
+		return
+	}
+
+	var perm Permission
+	var handle func(*serverClient, *http.Request) (int, any, error)
+	switch {
+	case r.URL.Path == "/snapshots" && r.Method == http.MethodPost:
+		perm, handle = PermTriggerScans, s.createSnapshot
+	case r.URL.Path == "/snapshots" && r.Method == http.MethodGet:
+		perm = PermReadReports
+		handle = func(client *serverClient, r *http.Request) (int, any, error) {
+			return s.listSnapshots(w.Header(), client, r)
+		}
+	case r.URL.Path == "/diff" && r.Method == http.MethodGet:
+		perm, handle = PermReadReports, s.diff
+	case r.URL.Path == "/tree/root" && r.Method == http.MethodGet:
+		perm, handle = PermReadReports, s.treeRoot
+	case strings.HasPrefix(r.URL.Path, "/uploads/"):
+		perm, handle = PermTriggerScans, s.upload
+	case r.URL.Path == "/prune" && r.Method == http.MethodPost:
+		perm, handle = PermDelete, s.prune
+	case r.URL.Path == "/gc" && r.Method == http.MethodPost:
+		perm, handle = PermDelete, s.collectGarbage
+	case r.URL.Path == "/snapshots":
+		w.Header().Set("Allow", "GET, POST")
+		writeServerError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	case r.URL.Path == "/diff" || r.URL.Path == "/tree/root":
+		w.Header().Set("Allow", "GET")
+		writeServerError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	case r.URL.Path == "/prune" || r.URL.Path == "/gc":
+		w.Header().Set("Allow", "POST")
+		writeServerError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	default:
+		writeServerError(w, http.StatusNotFound, fmt.Errorf("no such endpoint: %s", r.URL.Path))
+		return
+	}
+
+	principal, ok := s.authenticate(r.Header.Get("Authorization"))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeServerError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+		return
+	}
+	client, status, err := s.authorize(principal, perm, r.URL.Query().Get("namespace"))
+	if err != nil {
+		writeServerError(w, status, err)
+		return
+	}
+	status, body, err := handle(client, r)
+	if err != nil {
+		writeServerError(w, status, err)
+		return
+	}
+	writeServerJSON(w, status, body)
+}
+
+// authenticate returns the principal of the bearer token of an
+// Authorization header
+func (s *Server) authenticate(authorization string) (Principal, bool) {
+	if s.Tokens == nil && s.VerifyIDToken == nil {
+		return Principal{Role: RoleAdmin}, true
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return Principal{}, false
+	}
+	if s.Tokens != nil {
+		if principal, ok := s.Tokens.Authenticate(token); ok {
+			return principal, true
+		}
+	}
+	if s.VerifyIDToken == nil || s.ClaimRoles == nil {
+		return Principal{}, false
+	}
+	claims, err := s.VerifyIDToken(token)
+	if err != nil {
+		return Principal{}, false
+	}
+	return s.ClaimRoles.Principal(claims)
+}
+
+// authorize returns the client of the namespace a caller asked for, by
+// default their own, if the caller has perm in it; otherwise the HTTP status
+// of the failure
+func (s *Server) authorize(principal Principal, perm Permission, namespace string) (*serverClient, int, error) {
+	if namespace == "" {
+		namespace = principal.Namespace
+	}
+	if !principal.CanAccess(perm, namespace) {
+		err := fmt.Errorf("not allowed for role %s", principal.Role)
+		if namespace != "" {
+			err = fmt.Errorf("not allowed for role %s in namespace %s", principal.Role, namespace)
+		}
+		return nil, http.StatusForbidden, err
+	}
+
+	client, err := s.client(namespace)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return client, http.StatusOK, nil
+}
+
+// client returns the client of a namespace, created on first use so its
+// comparison cache, if any, is shared by all requests
+func (s *Server) client(namespace string) (*serverClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if client, ok := s.clients[namespace]; ok {
+		return client, nil
+	}
+
+	dir := s.StorageDir
+	if namespace != "" {
+		var err error
+		if dir, err = NamespaceDir(s.StorageDir, namespace); err != nil {
+			return nil, err
+		}
+	}
+	opts := s.Options
+	if HasSQLiteStorage(dir) {
+		opts = append(opts[:len(opts):len(opts)], WithSQLiteStorage())
+	}
+	client := &serverClient{Client: NewClient(dir, opts...), namespace: namespace, dir: dir}
+
+	if s.clients == nil {
+		s.clients = make(map[string]*serverClient)
+	}
+	s.clients[namespace] = client
+	return client, nil
+}
+
+// createSnapshot scans a folder and saves its snapshot
+func (s *Server) createSnapshot(client *serverClient, r *http.Request) (int, any, error) {
+	folder, err := requiredParam(r, "folder")
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	// A caller that goes away cancels the scan
+	status, info, err := s.saveSnapshot(r.Context(), client, folder)
+	return status, info, err
+}
+
+// saveSnapshot scans a folder that may be scanned and saves its snapshot
+func (s *Server) saveSnapshot(ctx context.Context, client *serverClient, folder string) (int, jsonSnapshotInfo, error) {
+	folder, status, err := s.scannableFolder(client, folder)
+	if err != nil {
+		return status, jsonSnapshotInfo{}, err
+	}
+	state, err := client.CreateSnapshotContext(ctx, folder)
+	if err != nil {
+		return http.StatusInternalServerError, jsonSnapshotInfo{}, err
+	}
+	if err := client.SaveSnapshot(state, folder); err != nil {
+		return http.StatusInternalServerError, jsonSnapshotInfo{}, err
+	}
+	file, err := client.FindLatestSnapshot(folder)
+	if err != nil {
+		return http.StatusInternalServerError, jsonSnapshotInfo{}, err
+	}
+	return http.StatusCreated, snapshotInfo(file, folder, state), nil
+}
+
+// scannableFolder returns the absolute path of a folder if it exists and may
+// be scanned in the namespace of client; otherwise the HTTP status of the
+// failure
+func (s *Server) scannableFolder(client *serverClient, folder string) (string, int, error) {
+	if folder == "" {
+		return "", http.StatusBadRequest, errors.New("missing folder")
+	}
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	if !s.mayScan(client.namespace, folder) {
+		return "", http.StatusForbidden, fmt.Errorf("folder %s may not be scanned", folder)
+	}
+	if _, err := os.Stat(folder); err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("folder %s does not exist", folder)
+	}
+	return folder, http.StatusOK, nil
+}
+
+// mayScan reports whether a folder is one of the folders that may be scanned
+// in a namespace
+func (s *Server) mayScan(namespace, folder string) bool {
+	folders := s.Folders
+	if namespace != "" && len(s.NamespaceFolders) > 0 {
+		if folders = s.NamespaceFolders[namespace]; len(folders) == 0 {
+			return false
+		}
+	}
+	if len(folders) == 0 {
+		return true
+	}
+	for _, allowed := range folders {
+		if abs, err := filepath.Abs(allowed); err == nil && abs == folder {
+			return true
+		}
+	}
+	return false
+}
+
+// listSnapshots lists the stored snapshots of a folder, oldest first unless
+// order=newest. The body stays a plain array; the number of matching
+// snapshots is sent as X-Total-Count and the next page, if any, as a Link
+// header with rel="next".
+func (s *Server) listSnapshots(header http.Header, client *serverClient, r *http.Request) (int, any, error) {
+	folder, err := requiredParam(r, "folder")
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	query, err := snapshotQueryParams(r.URL.Query())
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	page, err := client.QuerySnapshots(folder, query)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+
+	header.Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.Next != "" {
+		next := *r.URL
+		params := next.Query()
+		params.Set("after", page.Next)
+		params.Del("offset")
+		next.RawQuery = params.Encode()
+		header.Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+	}
+	return http.StatusOK, newJSONSnapshotList(folder, page), nil
+}
+
+// snapshotQueryParams reads the filters and paging of a snapshot listing
+func snapshotQueryParams(params url.Values) (SnapshotQuery, error) {
+	query := SnapshotQuery{After: params.Get("after"), Labels: params["label"]}
+	for name, value := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		if params.Get(name) == "" {
+			continue
+		}
+		n, err := strconv.Atoi(params.Get(name))
+		if err != nil || n < 0 {
+			return query, fmt.Errorf("%s must be a number of snapshots", name)
+		}
+		*value = n
+	}
+	for name, value := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if params.Get(name) == "" {
+			continue
+		}
+		t, err := parseQueryTime(params.Get(name))
+		if err != nil {
+			return query, fmt.Errorf("%s must be an RFC 3339 time or a date: %v", name, err)
+		}
+		*value = t
+	}
+	switch params.Get("order") {
+	case "", "oldest":
+	case "newest":
+		query.Newest = true
+	default:
+		return query, fmt.Errorf("order must be oldest or newest")
+	}
+	return query, nil
+}
+
+// parseQueryTime parses an RFC 3339 time, or a date taken as local midnight
+func parseQueryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// diff compares two stored snapshots of the same folder
+func (s *Server) diff(client *serverClient, r *http.Request) (int, any, error) {
+	var ids [2]string
+	for i, name := range []string{"from", "to"} {
+		id, err := requiredParam(r, name)
+		if err != nil {
+			return http.StatusBadRequest, nil, err
+		}
+		ids[i] = id
+	}
+	status, report, err := compareSnapshotIDs(client, ids[0], ids[1])
+	if err != nil {
+		return status, nil, err
+	}
+	return status, newJSONReport(report), nil
+}
+
+// compareSnapshotIDs compares two snapshots of the same folder in a namespace
+// by ID
+func compareSnapshotIDs(client *serverClient, from, to string) (int, *ChangeReport, error) {
+	var files [2]string
+	for i, param := range [2]struct{ name, id string }{{"from", from}, {"to", to}} {
+		name, id := param.name, param.id
+		if id == "" {
+			return http.StatusBadRequest, nil, fmt.Errorf("missing snapshot ID %q", name)
+		}
+		// Only snapshots of the namespace may be compared, not any file
+		if filepath.Base(id) != id {
+			return http.StatusBadRequest, nil, fmt.Errorf("%s must be a snapshot ID, not a path", name)
+		}
+		file, err := client.ResolveSnapshot(id)
+		if err == nil && !strings.HasPrefix(file, client.dir+string(filepath.Separator)) {
+			err = fmt.Errorf("no snapshot found: %s", id)
+		}
+		if err != nil {
+			return http.StatusNotFound, nil, err
+		}
+		files[i] = file
+	}
+
+	// Snapshots of two folders would report one folder as changes of the
+	// other, letting a caller read a folder it was never allowed to scan
+	// through the folder it was
+	oldFolder, _ := snapshotFolderName(files[0])
+	newFolder, _ := snapshotFolderName(files[1])
+	if oldFolder != newFolder {
+		return http.StatusBadRequest, nil, fmt.Errorf("snapshots %s and %s are of different folders", from, to)
+	}
+
+	report, err := client.CompareSnapshotFiles(files[0], files[1])
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	return http.StatusOK, report, nil
+}
+
+// treeRoot describes the latest snapshot of a folder
+func (s *Server) treeRoot(client *serverClient, r *http.Request) (int, any, error) {
+	folder, err := requiredParam(r, "folder")
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	status, info, err := latestSnapshot(client, folder)
+	return status, info, err
+}
+
+// latestSnapshot describes the latest snapshot of a folder
+func latestSnapshot(client *serverClient, folder string) (int, jsonSnapshotInfo, error) {
+	file, err := client.FindLatestSnapshot(folder)
+	if err != nil {
+		return http.StatusNotFound, jsonSnapshotInfo{}, err
+	}
+	state, err := client.LoadSnapshot(file)
+	if err != nil {
+		return http.StatusInternalServerError, jsonSnapshotInfo{}, err
+	}
+	return http.StatusOK, snapshotInfo(file, folder, state), nil
+}
+
+// requiredParam returns a query parameter that must not be empty
+func requiredParam(r *http.Request, name string) (string, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return "", fmt.Errorf("missing query parameter %q", name)
+	}
+	return value, nil
+}
+
+// writeServerJSON writes a JSON response
+func writeServerJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(body)
+}
+
+// writeServerError writes a JSON error response
+func writeServerError(w http.ResponseWriter, status int, err error) {
+	writeServerJSON(w, status, map[string]string{"error": err.Error()})
+}
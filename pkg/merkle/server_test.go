@@ -0,0 +1,234 @@
+//go:build !readonly
+
+package merkle
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serveRequest sends a request with a bearer token, if any, to a server and
+// decodes its JSON response into out, if not nil
+func serveRequest(t *testing.T, server *Server, method, target, token string, out any) int {
+	t.Helper()
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if out != nil && w.Code < 300 {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v\n%s", method, target, err, w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+// Tenants may only scan the folders of their namespace, not those of another
+func TestNamespacesConfineScans(t *testing.T) {
+	root := t.TempDir()
+	appA, appB := filepath.Join(root, "a", "app"), filepath.Join(root, "b", "app")
+	writeFiles(t, appA, map[string]string{"a.txt": "alpha"})
+	writeFiles(t, appB, map[string]string{"b.txt": "beta"})
+	server := &Server{
+		StorageDir: t.TempDir(),
+		Tokens: &TokenAuthorizer{tokens: map[string]Principal{
+			HashToken("team-a"): {Role: RoleOperator, Namespace: "team-a"},
+			HashToken("team-b"): {Role: RoleOperator, Namespace: "team-b"},
+			HashToken("team-c"): {Role: RoleOperator, Namespace: "team-c"},
+			HashToken("admin"):  {Role: RoleAdmin},
+		}},
+		Folders:          []string{appA, appB},
+		NamespaceFolders: map[string][]string{"team-a": {appA}, "team-b": {appB}},
+	}
+
+	for _, tc := range []struct {
+		token, folder, namespace string
+		want                     int
+	}{
+		{"team-a", appA, "", http.StatusCreated},
+		{"team-a", appB, "", http.StatusForbidden},
+		{"team-b", appB, "", http.StatusCreated},
+		{"team-b", appA, "", http.StatusForbidden},
+		{"team-a", appB, "team-b", http.StatusForbidden},
+		// A namespace without folders of its own may scan none
+		{"team-c", appA, "", http.StatusForbidden},
+		{"admin", appA, "", http.StatusCreated},
+		{"admin", appB, "team-a", http.StatusForbidden},
+	} {
+		target := "/snapshots?folder=" + tc.folder
+		if tc.namespace != "" {
+			target += "&namespace=" + tc.namespace
+		}
+		if code := serveRequest(t, server, http.MethodPost, target, tc.token, nil); code != tc.want {
+			t.Errorf("%s scanning %s in %q: %d, want %d", tc.token, tc.folder, tc.namespace, code, tc.want)
+		}
+	}
+}
+
+// Snapshots of two folders are not compared, so a folder cannot be read
+// through the snapshots of another
+func TestDiffRefusesSnapshotsOfDifferentFolders(t *testing.T) {
+	root := t.TempDir()
+	app, secrets := filepath.Join(root, "app"), filepath.Join(root, "secrets")
+	writeFiles(t, app, map[string]string{"a.txt": "alpha"})
+	writeFiles(t, secrets, map[string]string{"key": "hunter2"})
+	server := &Server{StorageDir: t.TempDir()}
+
+	var ids [2]string
+	for i, folder := range []string{app, secrets} {
+		var info struct {
+			ID string `json:"id"`
+		}
+		if code := serveRequest(t, server, http.MethodPost, "/snapshots?folder="+folder, "", &info); code != http.StatusCreated {
+			t.Fatalf("scanning %s: %d", folder, code)
+		}
+		ids[i] = info.ID
+	}
+
+	if code := serveRequest(t, server, http.MethodGet, "/diff?from="+ids[0]+"&to="+ids[1], "", nil); code != http.StatusBadRequest {
+		t.Errorf("diff of two folders: %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := serveRequest(t, server, http.MethodGet, "/diff?from="+ids[0]+"&to="+ids[0], "", nil); code != http.StatusOK {
+		t.Errorf("diff of one folder: %d, want %d", code, http.StatusOK)
+	}
+}
+
+// savedSnapshots saves n snapshots of a folder a minute apart, the newest now,
+// and returns their IDs, oldest first
+func savedSnapshots(t *testing.T, client Client, folder string, n int) []string {
+	t.Helper()
+	state, err := client.CreateSnapshot(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, n)
+	now := state.Timestamp
+	for i := range ids {
+		state.Timestamp = now.Add(time.Duration(i-n+1) * time.Minute)
+		if err := client.SaveSnapshot(state, folder); err != nil {
+			t.Fatal(err)
+		}
+		file, err := client.FindLatestSnapshot(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = snapshotStem(file)
+	}
+	return ids
+}
+
+// Pruning and collecting take the admin role in the namespace they act on
+func TestAdminEndpointsNeedAdminInTheirNamespace(t *testing.T) {
+	folder := filepath.Join(t.TempDir(), "app")
+	writeFiles(t, folder, map[string]string{"a.txt": "alpha"})
+	storage := t.TempDir()
+	client, err := NewNamespacedClient(storage, "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := savedSnapshots(t, client, folder, 3)
+	server := &Server{
+		StorageDir: storage,
+		Tokens: &TokenAuthorizer{tokens: map[string]Principal{
+			HashToken("admin-a"):    {Role: RoleAdmin, Namespace: "team-a"},
+			HashToken("operator-a"): {Role: RoleOperator, Namespace: "team-a"},
+			HashToken("admin-b"):    {Role: RoleAdmin, Namespace: "team-b"},
+		}},
+	}
+	prune := "/prune?keep_last=1&folder=" + folder
+
+	for _, tc := range []struct {
+		token, target string
+		want          int
+	}{
+		{"operator-a", prune, http.StatusForbidden},
+		{"operator-a", "/gc", http.StatusForbidden},
+		{"admin-b", prune + "&namespace=team-a", http.StatusForbidden},
+		{"admin-b", "/gc?namespace=team-a", http.StatusForbidden},
+		{"admin-a", prune + "&keep_daily=-1", http.StatusBadRequest},
+		{"admin-a", "/gc?max_age=soon", http.StatusBadRequest},
+		{"", prune, http.StatusUnauthorized},
+	} {
+		if code := serveRequest(t, server, http.MethodPost, tc.target, tc.token, nil); code != tc.want {
+			t.Errorf("%s: POST %s = %d, want %d", tc.token, tc.target, code, tc.want)
+		}
+	}
+	if code := serveRequest(t, server, http.MethodGet, prune, "admin-a", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /prune = %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+
+	// Another namespace has nothing of team-a's to prune
+	var pruned []jsonSnapshotInfo
+	if code := serveRequest(t, server, http.MethodPost, prune, "admin-b", &pruned); code != http.StatusOK || len(pruned) != 0 {
+		t.Errorf("admin-b pruned %v (%d), want nothing", pruned, code)
+	}
+
+	if code := serveRequest(t, server, http.MethodPost, prune+"&dry_run=true", "admin-a", &pruned); code != http.StatusOK || len(pruned) != 2 {
+		t.Fatalf("dry run pruned %v (%d), want 2 snapshots", pruned, code)
+	}
+	if snapshots, err := client.ListSnapshots(folder); err != nil || len(snapshots) != 3 {
+		t.Fatalf("after a dry run %d snapshots are left (%v), want 3", len(snapshots), err)
+	}
+	if code := serveRequest(t, server, http.MethodPost, prune, "admin-a", &pruned); code != http.StatusOK || len(pruned) != 2 {
+		t.Fatalf("pruned %v (%d), want 2 snapshots", pruned, code)
+	}
+	if pruned[0].ID != ids[0] || pruned[1].ID != ids[1] || pruned[0].Folder != "app" {
+		t.Errorf("pruned %v, want %v", pruned, ids[:2])
+	}
+	if snapshots, err := client.ListSnapshots(folder); err != nil || len(snapshots) != 1 || snapshotStem(snapshots[0]) != ids[2] {
+		t.Errorf("left %v (%v), want %s", snapshots, err, ids[2])
+	}
+}
+
+// OIDC ID tokens are mapped to roles and namespaces by their claims
+func TestIDTokensAuthenticateByClaims(t *testing.T) {
+	folder := filepath.Join(t.TempDir(), "app")
+	writeFiles(t, folder, map[string]string{"a.txt": "alpha"})
+	server := &Server{
+		StorageDir: t.TempDir(),
+		Tokens: &TokenAuthorizer{tokens: map[string]Principal{
+			HashToken("static-viewer"): {Role: RoleViewer},
+		}},
+		VerifyIDToken: func(token string) (map[string]interface{}, error) {
+			switch token {
+			case "dev-token":
+				return map[string]interface{}{"groups": []interface{}{"devs"}, "tenant": "team-a"}, nil
+			case "ops-token":
+				return map[string]interface{}{"groups": []interface{}{"devs", "ops"}, "tenant": "team-a"}, nil
+			case "guest-token":
+				return map[string]interface{}{"groups": []interface{}{"guests"}, "tenant": "team-a"}, nil
+			}
+			return nil, errors.New("invalid signature")
+		},
+		ClaimRoles: &ClaimsRoleMapper{
+			Claim:          "groups",
+			Roles:          map[string]Role{"devs": RoleViewer, "ops": RoleAdmin},
+			NamespaceClaim: "tenant",
+		},
+	}
+
+	for _, tc := range []struct {
+		token, method, target string
+		want                  int
+	}{
+		{"static-viewer", http.MethodGet, "/snapshots?folder=" + folder, http.StatusOK},
+		{"dev-token", http.MethodGet, "/snapshots?folder=" + folder, http.StatusOK},
+		{"dev-token", http.MethodPost, "/snapshots?folder=" + folder, http.StatusForbidden},
+		{"dev-token", http.MethodPost, "/gc", http.StatusForbidden},
+		{"ops-token", http.MethodPost, "/gc", http.StatusOK},
+		{"ops-token", http.MethodPost, "/gc?namespace=team-b", http.StatusForbidden},
+		{"guest-token", http.MethodGet, "/snapshots?folder=" + folder, http.StatusUnauthorized},
+		{"forged-token", http.MethodGet, "/snapshots?folder=" + folder, http.StatusUnauthorized},
+	} {
+		if code := serveRequest(t, server, tc.method, tc.target, tc.token, nil); code != tc.want {
+			t.Errorf("%s: %s %s = %d, want %d", tc.token, tc.method, tc.target, code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package merkle
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Severity ranks a change for triage, so a report with hundreds of changes
+// shows the few that matter first. Severities are assigned by SeverityRules;
+// the zero value is SeverityInfo.
+type Severity int
+
+const (
+	// SeverityInfo is for routine changes and changes no rule matched
+	SeverityInfo Severity = iota
+	// SeverityWarning is for changes worth a look
+	SeverityWarning
+	// SeverityCritical is for changes that need attention now, such as
+	// edits to /etc/passwd
+	SeverityCritical
+)
+
+// String returns the name of the severity as ParseSeverity accepts it
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// ParseSeverity parses "info", "warning" or "critical"
+func ParseSeverity(name string) (Severity, error) {
+	switch name {
+	case "info":
+		return SeverityInfo, nil
+	case "warning":
+		return SeverityWarning, nil
+	case "critical":
+		return SeverityCritical, nil
+	}
+	return SeverityInfo, fmt.Errorf("unknown severity: %q (want info, warning or critical)", name)
+}
+
+// SeverityRule assigns a severity to changes of the given types to files
+// matching a pattern
+type SeverityRule struct {
+	Types    []ChangeType // types the rule applies to; empty for every type
+	Pattern  string       // empty for every file; see ActionHook.Matches
+	Severity Severity
+}
+
+// ParseSeverityRule parses a rule written as "<types>[:<pattern>]=<severity>",
+// with types and pattern as for ParseActionHook:
+//
+//	*:etc/passwd=critical
+//	deleted=warning
+//	*:*.log=info
+func ParseSeverityRule(spec string) (SeverityRule, error) {
+	// Patterns may contain "=", severities cannot
+	i := strings.LastIndex(spec, "=")
+	if i < 0 {
+		return SeverityRule{}, fmt.Errorf("invalid severity rule %q: want <types>[:<pattern>]=<severity>", spec)
+	}
+	selector, name := spec[:i], spec[i+1:]
+	severity, err := ParseSeverity(strings.TrimSpace(name))
+	if err != nil {
+		return SeverityRule{}, fmt.Errorf("invalid severity rule %q: %v", spec, err)
+	}
+	types, pattern, err := parseChangeSelector(selector)
+	if err != nil {
+		return SeverityRule{}, fmt.Errorf("invalid severity rule %q: %v", spec, err)
+	}
+	return SeverityRule{Types: types, Pattern: pattern, Severity: severity}, nil
+}
+
+// ReadSeverityRules reads severity rules from a file, one per line as for
+// ParseSeverityRule. Blank lines and lines starting with # are skipped.
+func ReadSeverityRules(filename string) ([]SeverityRule, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []SeverityRule
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := ParseSeverityRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// Matches reports whether the rule applies to a change
+func (r SeverityRule) Matches(change FileChange) bool {
+	return ActionHook{Types: r.Types, Pattern: r.Pattern}.Matches(change)
+}
+
+// WithSeverityRules makes CompareSnapshots assign severities to the changes it
+// reports; see ClassifyChanges
+func WithSeverityRules(rules ...SeverityRule) Option {
+	return func(c *MerkleClient) {
+		c.severityRules = append(c.severityRules, rules...)
+	}
+}
+
+// ClassifyChanges sets the severity of every change in a report. As in a
+// .gitignore file the last matching rule wins, so a broad rule can be
+// followed by exceptions ("*=warning" then "*:*.log=info"). Changes no rule
+// matches are SeverityInfo. Renamed and moved files are matched by their new
+// path or their old one, whichever gives the higher severity.
+func ClassifyChanges(report *ChangeReport, rules []SeverityRule) {
+	for i := range report.Changes {
+		change := &report.Changes[i]
+		change.Severity = classifyChange(*change, rules)
+		if change.OldPath != "" && change.OldPath != change.FileName {
+			old := *change
+			old.FileName = change.OldPath
+			change.Severity = max(change.Severity, classifyChange(old, rules))
+		}
+	}
+}
+
+// classifyChange returns the severity of the last rule matching a change
+func classifyChange(change FileChange, rules []SeverityRule) Severity {
+	severity := SeverityInfo
+	for _, rule := range rules {
+		if rule.Matches(change) {
+			severity = rule.Severity
+		}
+	}
+	return severity
+}
+
+// MaxSeverity returns the highest severity of the changes in a report
+func (r *ChangeReport) MaxSeverity() Severity {
+	severity := SeverityInfo
+	for _, change := range r.Changes {
+		severity = max(severity, change.Severity)
+	}
+	return severity
+}
+
+// noSeverity is the highest severity of no changes at all in JSON output
+const noSeverity = "none"
+
+// maxSeverityName names the highest severity of a number of changes for JSON
+// output, "none" when there are none, so an empty report does not claim info
+// drift
+func maxSeverityName(changes int, severity Severity) string {
+	if changes == 0 {
+		return noSeverity
+	}
+	return severity.String()
+}
+
+// ExitError is the exit status of a run that could not compare at all, such as
+// a verify without a baseline. It is above every status ExitCode returns, so a
+// CI job cannot take a failure for drift it tolerates.
+const ExitError = 4
+
+// ExitCode returns the exit status that tells a CI job what a comparison
+// found: 0 when there are no changes or all were acknowledged, and otherwise
+// 1, 2 or 3 when the highest severity of the other changes is info, warning
+// or critical. Files that could not be hashed may hide any change, so a
+// report listing Errors returns ExitError whatever its changes.
+func (r *ChangeReport) ExitCode() int {
+	if len(r.Errors) > 0 {
+		return ExitError
+	}
+	code := 0
+	for _, change := range r.Changes {
+		if !change.Acknowledged {
+			code = max(code, int(change.Severity)+1)
+		}
+	}
+	return code
+}
+
+// SeverityCounts returns the number of changes of each severity, indexed by
+// Severity
+func (r *ChangeReport) SeverityCounts() [SeverityCritical + 1]int {
+	var counts [SeverityCritical + 1]int
+	for _, change := range r.Changes {
+		if change.Severity >= SeverityInfo && change.Severity <= SeverityCritical {
+			counts[change.Severity]++
+		}
+	}
+	return counts
+}
+
+// severityTag returns " [WARNING]" or " [CRITICAL]" for the text report, and
+// nothing for routine changes
+func severityTag(severity Severity) string {
+	if severity == SeverityInfo {
+		return ""
+	}
+	return " [" + strings.ToUpper(severity.String()) + "]"
+}
@@ -0,0 +1,100 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// The last matching rule sets the severity of a change, and renames are
+// judged by the worse of their two paths
+func TestClassifyChanges(t *testing.T) {
+	var rules []SeverityRule
+	for _, spec := range []string{"*=warning", "*:*.log=info", "modified,deleted,renamed:etc/passwd=critical", "added:secrets/=critical"} {
+		rule, err := ParseSeverityRule(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	for _, spec := range []string{"*", "*=urgent", "touched=info", "*:[=info"} {
+		if _, err := ParseSeverityRule(spec); err == nil {
+			t.Errorf("rule %q was parsed", spec)
+		}
+	}
+
+	report := &ChangeReport{Changes: []FileChange{
+		{FileName: "app/main.go", ChangeType: Modified},
+		{FileName: "app/debug.log", ChangeType: Modified},
+		{FileName: "etc/passwd", ChangeType: Modified},
+		{FileName: "etc/passwd", ChangeType: Added},
+		{FileName: "secrets/key.pem", ChangeType: Added},
+		{FileName: "old.log", ChangeType: Renamed, OldPath: "etc/passwd"},
+	}}
+	ClassifyChanges(report, rules)
+	want := []Severity{SeverityWarning, SeverityInfo, SeverityCritical, SeverityWarning, SeverityCritical, SeverityCritical}
+	for i, change := range report.Changes {
+		if change.Severity != want[i] {
+			t.Errorf("%s %s = %s, want %s", GetChangeTypeString(change.ChangeType), change.FileName, change.Severity, want[i])
+		}
+	}
+	if counts := report.SeverityCounts(); counts != [3]int{1, 2, 3} {
+		t.Errorf("SeverityCounts = %v, want [1 2 3]", counts)
+	}
+	if highest := report.MaxSeverity(); highest != SeverityCritical {
+		t.Errorf("MaxSeverity = %s, want critical", highest)
+	}
+	if highest := (&ChangeReport{}).MaxSeverity(); highest != SeverityInfo {
+		t.Errorf("MaxSeverity of no changes = %s, want info", highest)
+	}
+}
+
+// Clients with rules classify what they compare, and reports show it
+func TestSeveritiesInReports(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"etc/passwd": "root", "notes.txt": "v1"})
+	rule, err := ParseSeverityRule("*:etc/passwd=critical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(t.TempDir(), WithSeverityRules(rule))
+	report := patchReport(t, client, dir, func() {
+		writeFiles(t, dir, map[string]string{"etc/passwd": "root:x", "notes.txt": "v2"})
+	})
+	severities := make(map[string]Severity)
+	for _, change := range report.Changes {
+		severities[change.FileName] = change.Severity
+	}
+	if severities["etc/passwd"] != SeverityCritical || severities["notes.txt"] != SeverityInfo {
+		t.Fatalf("severities = %v, want etc/passwd critical and notes.txt info", severities)
+	}
+
+	var text bytes.Buffer
+	WriteChangeReport(&text, report)
+	if !strings.Contains(text.String(), "[CRITICAL]") {
+		t.Errorf("text report does not tag the critical change:\n%s", text.String())
+	}
+	var decoded struct {
+		MaxSeverity string `json:"max_severity"`
+		Changes     []struct {
+			FileName string `json:"file_path"`
+			Severity string `json:"severity"`
+		} `json:"changes"`
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.MaxSeverity != "critical" {
+		t.Errorf("JSON max_severity = %q, want critical", decoded.MaxSeverity)
+	}
+	for _, change := range decoded.Changes {
+		if want := severities[change.FileName].String(); change.Severity != want {
+			t.Errorf("JSON severity of %s = %q, want %q", change.FileName, change.Severity, want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package merkle
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decodeSnapshot detects the format of a stored snapshot from its first bytes
+// and decodes it. Gzip-compressed snapshots are decompressed first, so any
+// mix of formats written by different tool versions can be loaded.
+func decodeSnapshot(r io.Reader, mode LoadMode) (*TreeState, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	head, _ := br.Peek(512)
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	switch {
+	case isBinarySnapshot(head):
+		// The index sits at the end, so binary snapshots are read whole
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		return decodeBinarySnapshot(data, mode)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return decodeJSONSnapshot(br, mode)
+	case bytes.HasPrefix(head, []byte("timestamp,")):
+		return decodeCSVSnapshot(br, mode)
+	case len(head) == 0:
+		return nil, fmt.Errorf("empty snapshot file")
+	default:
+		return nil, fmt.Errorf("unrecognized snapshot format")
+	}
+}
+
+// jsonFormatVersion is the version of the JSON snapshots SaveSnapshot writes.
+// Snapshots without a format_version predate it and are read as version 0.
+const jsonFormatVersion = 1
+
+// jsonSnapshot is the JSON form of a snapshot. Per-file fields use the same
+// encodings as the CSV columns of the same name.
+type jsonSnapshot struct {
+	FormatVersion int               `json:"format_version,omitempty"`
+	Timestamp     time.Time         `json:"timestamp"`
+	RootHash      string            `json:"root_hash"`
+	Scheme        string            `json:"scheme,omitempty"`
+	Files         []jsonSnapshotRow `json:"files"`
+}
+
+type jsonSnapshotRow struct {
+	Path    string `json:"file_path"`
+	Hash    string `json:"file_hash,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Digests string `json:"digests,omitempty"`
+	Size    int64  `json:"file_size"`
+	Chunks  string `json:"chunks,omitempty"`
+	Content string `json:"content,omitempty"`
+	Attrs   string `json:"attributes,omitempty"`
+}
+
+// decodeJSONSnapshot reads a snapshot in the JSON format. Snapshots with a
+// scheme are the current layout for LoadAuto.
+func decodeJSONSnapshot(r io.Reader, mode LoadMode) (*TreeState, error) {
+	var snapshot jsonSnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("invalid JSON snapshot: %v", err)
+	}
+	if snapshot.FormatVersion > jsonFormatVersion {
+		return nil, fmt.Errorf("JSON snapshot format version %d is newer than this program supports (%d)",
+			snapshot.FormatVersion, jsonFormatVersion)
+	}
+
+	state := &TreeState{
+		Timestamp:  snapshot.Timestamp,
+		FileHashes: make(map[string][]byte),
+		FileSizes:  make(map[string]int64),
+		Errors:     make(map[string]string),
+		Unstable:   make(map[string]bool),
+		Digests:    make(map[string]map[string][]byte),
+		Chunks:     make(map[string]*FileChunks),
+		Content:    make(map[string]ContentKind),
+		Attributes: make(map[string]FileAttributes),
+	}
+
+	var err error
+	if state.RootHash, err = hex.DecodeString(snapshot.RootHash); err != nil {
+		return nil, fmt.Errorf("invalid root hash in JSON snapshot: %v", err)
+	}
+	if snapshot.Scheme != "" {
+		if state.Scheme, err = parseHashScheme(snapshot.Scheme); err != nil {
+			return nil, err
+		}
+	}
+
+	in := newSnapshotInterner()
+	rc := &rowChecker{strict: mode.strict(snapshot.Scheme != "")}
+	for i, row := range snapshot.Files {
+		rc.row = i + 1
+		if reason, ok := strings.CutPrefix(row.Status, "error: "); ok {
+			state.Errors[row.Path] = in.string(reason)
+			continue
+		}
+
+		hash, err := in.hexHash(row.Hash)
+		if err == nil && len(hash) == 0 {
+			err = fmt.Errorf("missing hash")
+		}
+		if err := rc.check("file_hash", err); err != nil {
+			return nil, err
+		}
+		state.FileHashes[row.Path] = hash
+
+		var sizeErr error
+		if row.Size < 0 {
+			sizeErr = fmt.Errorf("negative size %d", row.Size)
+		}
+		digests, digestsErr := in.digests(row.Digests)
+		chunks, chunksErr := parseChunks(row.Chunks)
+		var attrs FileAttributes
+		var attrsErr error
+		if row.Attrs != "" {
+			attrs, attrsErr = parseFileAttributes(row.Attrs)
+		}
+		for _, check := range []struct {
+			column string
+			err    error
+		}{
+			{"status", checkStatus(row.Status)},
+			{"digests", digestsErr},
+			{"file_size", sizeErr},
+			{"chunks", chunksErr},
+			{"content", checkContent(row.Content)},
+			{"attributes", attrsErr},
+		} {
+			if err := rc.check(check.column, check.err); err != nil {
+				return nil, err
+			}
+		}
+
+		if sizeErr == nil {
+			state.FileSizes[row.Path] = row.Size
+		}
+		if row.Status == "unstable" {
+			state.Unstable[row.Path] = true
+		}
+		if len(digests) > 0 {
+			state.Digests[row.Path] = digests
+		}
+		if chunks != nil {
+			state.Chunks[row.Path] = chunks
+		}
+		if kind := parseContentKind(row.Content); kind != ContentUnknown {
+			state.Content[row.Path] = kind
+		}
+		if row.Attrs != "" && attrsErr == nil {
+			state.Attributes[row.Path] = attrs
+		}
+	}
+
+	state.Warnings = rc.warnings
+	return state, nil
+}
+
+// writeJSONSnapshot encodes a tree state as a JSON snapshot of the current
+// format version. Files are written one at a time in path order, like CSV
+// rows, and the document is indented one file per line.
+func writeJSONSnapshot(w io.Writer, state *TreeState) error {
+	header, err := json.Marshal(jsonSnapshot{
+		FormatVersion: jsonFormatVersion,
+		Timestamp:     state.Timestamp,
+		RootHash:      hex.EncodeToString(state.RootHash),
+		Scheme:        state.Scheme.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The header ends in "files":null}; splice the rows in its place
+	bw := bufio.NewWriter(w)
+	bw.Write(bytes.TrimSuffix(header, []byte("null}")))
+	bw.WriteString("[")
+	for i, fileName := range sortedPaths(state) {
+		row := jsonSnapshotRow{Path: fileName}
+		if reason, failed := state.Errors[fileName]; failed {
+			row.Status = "error: " + reason
+		} else {
+			if state.Unstable[fileName] {
+				row.Status = "unstable"
+			}
+			row.Hash = hex.EncodeToString(state.FileHashes[fileName])
+			row.Digests = formatDigests(state.Digests[fileName])
+			row.Size = state.FileSizes[fileName]
+			row.Chunks = formatChunks(state.Chunks[fileName])
+			row.Content = formatContentKind(state.Content[fileName])
+			row.Attrs = formatAttributes(state.Attributes, fileName)
+		}
+
+		data, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		bw.WriteString("\n  ")
+		bw.Write(data)
+	}
+	bw.WriteString("\n]}\n")
+	return bw.Flush()
+}
+
+// snapshotStem returns a snapshot's file name without directory, compression
+// suffix or format extension: state_<folder>_<timestamp>
+func snapshotStem(filename string) string {
+	name := strings.TrimSuffix(filepath.Base(filename), ".gz")
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
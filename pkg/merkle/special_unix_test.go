@@ -0,0 +1,115 @@
+//go:build unix
+
+package merkle
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fifoFolder creates a folder holding a regular file, a FIFO and a symlink
+// to the FIFO
+func fifoFolder(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "regular.txt"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(dir, "pipe"), 0o644); err != nil {
+		t.Skipf("cannot create a FIFO: %v", err)
+	}
+	if err := os.Symlink("pipe", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// scanWithin scans a folder, failing the test if the scan blocks
+func scanWithin(t *testing.T, dir string, opts ...Option) *MerkleTree {
+	t.Helper()
+	client := NewClient(t.TempDir(), opts...)
+
+	type result struct {
+		tree *MerkleTree
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		tree, err := client.GetTree(dir)
+		done <- result{tree, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("scan failed: %v", r.err)
+		}
+		return r.tree
+	case <-time.After(10 * time.Second):
+		t.Fatal("scan blocked on a FIFO")
+		return nil
+	}
+}
+
+// leafKinds maps the leaves of a tree to whether they are special files
+func leafKinds(tree *MerkleTree) map[string]bool {
+	special := make(map[string]bool)
+	var walk func(node *MerkleNode)
+	walk = func(node *MerkleNode) {
+		if node == nil {
+			return
+		}
+		if node.IsLeaf {
+			special[node.FileName] = node.Special
+			return
+		}
+		walk(node.Left)
+		walk(node.Right)
+	}
+	walk(tree.Root)
+	return special
+}
+
+func TestSymlinkToFIFOIsSkipped(t *testing.T) {
+	leaves := leafKinds(scanWithin(t, fifoFolder(t)))
+	if len(leaves) != 1 || leaves["regular.txt"] {
+		t.Errorf("leaves = %v, want only regular.txt", leaves)
+	}
+}
+
+func TestSymlinkToFIFOIsRecordedAsSpecial(t *testing.T) {
+	leaves := leafKinds(scanWithin(t, fifoFolder(t), WithSpecialFiles(true)))
+	for name, want := range map[string]bool{"regular.txt": false, "pipe": true, "link": true} {
+		got, ok := leaves[name]
+		if !ok {
+			t.Errorf("%s missing from the tree", name)
+		} else if got != want {
+			t.Errorf("%s: special = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestOpenRegularRefusesFIFO(t *testing.T) {
+	dir := fifoFolder(t)
+
+	done := make(chan error, 1)
+	go func() {
+		file, err := openRegular(filepath.Join(dir, "link"))
+		if err == nil {
+			file.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("openRegular opened a FIFO")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("openRegular blocked on a FIFO")
+	}
+}
@@ -0,0 +1,462 @@
+package merkle
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteFile is the database WithSQLiteStorage keeps snapshots in
+const sqliteFile = "snapshots.db"
+
+// sqliteRefExt ends the names snapshots in the database are known by. A
+// snapshot is referred to as if it were a file inside the database, such as
+// merkle_states/snapshots.db/state_app_20240101_120000.sqlite, so snapshot IDs,
+// baselines and the CLI work the same for both kinds of storage.
+const sqliteRefExt = ".sqlite"
+
+// sqliteSchema creates the tables of a snapshot database. Snapshots are found
+// by folder and time, and the files of a snapshot by path; the path index
+// serves the timeline of one file across all snapshots.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS snapshots (
+	id        INTEGER PRIMARY KEY,
+	name      TEXT NOT NULL UNIQUE,
+	folder    TEXT NOT NULL,
+	stamp     TEXT NOT NULL,
+	timestamp TEXT NOT NULL,
+	root_hash BLOB,
+	scheme    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS snapshots_folder ON snapshots (folder, stamp);
+CREATE TABLE IF NOT EXISTS file_hashes (
+	snapshot_id INTEGER NOT NULL REFERENCES snapshots (id),
+	path        TEXT NOT NULL,
+	hash        BLOB,
+	size        INTEGER NOT NULL,
+	status      TEXT NOT NULL,
+	content     INTEGER NOT NULL,
+	digests     TEXT NOT NULL,
+	chunks      TEXT NOT NULL,
+	attributes  TEXT NOT NULL,
+	PRIMARY KEY (snapshot_id, path)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS file_hashes_path ON file_hashes (path, snapshot_id);
+`
+
+// snapshotStampLayout is how snapshot names encode when they were taken
+const snapshotStampLayout = "20060102_150405"
+
+// WithSQLiteStorage saves snapshots in one SQLite database in the storage
+// directory instead of one file per snapshot, which keeps finding the latest
+// snapshot of a folder and the history of a file fast with hundreds of
+// snapshots of dozens of folders. Snapshot files saved before are still found
+// and loaded; MigrateSnapshots moves them into the database. Change history,
+// baselines and the other bookkeeping files stay as they are.
+func WithSQLiteStorage() Option {
+	return func(c *MerkleClient) {
+		c.sqlite = &sqliteStore{}
+	}
+}
+
+// HasSQLiteStorage reports whether a storage directory holds the database of
+// WithSQLiteStorage, so tools can keep using it once it was created
+func HasSQLiteStorage(storageDir string) bool {
+	info, err := os.Stat(filepath.Join(storageDir, sqliteFile))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// sqliteStore is the database of a client, opened on first use
+type sqliteStore struct {
+	once sync.Once
+	db   *sql.DB
+	err  error
+}
+
+// open returns the database of the storage directory, creating it if needed.
+// The caller creates the directory first when it may not exist.
+func (s *sqliteStore) open(storageDir string) (*sql.DB, error) {
+	s.once.Do(func() {
+		s.db, s.err = openSQLite(filepath.Join(storageDir, sqliteFile), true)
+	})
+	return s.db, s.err
+}
+
+// openSQLite opens a snapshot database; without create it must already exist.
+// Read-only builds open it read-only and never create it.
+func openSQLite(path string, create bool) (*sql.DB, error) {
+	if !create || readOnly {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+	if readOnly {
+		return sql.Open("sqlite3", "file:"+path+"?mode=ro&_busy_timeout=10000")
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=10000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// sqliteRef returns the name a snapshot in the database of storageDir is
+// known by
+func sqliteRef(storageDir, name string) string {
+	return filepath.Join(storageDir, sqliteFile, name+sqliteRefExt)
+}
+
+// splitSQLiteRef returns the database and the snapshot name of a snapshot in
+// a database, and false for snapshot files
+func splitSQLiteRef(ref string) (string, string, bool) {
+	db, file := filepath.Split(ref)
+	db = filepath.Clean(db)
+	name, ok := strings.CutSuffix(file, sqliteRefExt)
+	if !ok || filepath.Base(db) != sqliteFile {
+		return "", "", false
+	}
+	return db, name, true
+}
+
+// sqliteDB returns the client's database, or nil when snapshots are kept in
+// files
+func (c *MerkleClient) sqliteDB() (*sql.DB, error) {
+	if c.sqlite == nil {
+		return nil, nil
+	}
+	if readOnly {
+		return c.sqlite.open(c.storageDir)
+	}
+	if err := os.MkdirAll(c.storageDir, 0755); err != nil {
+		return nil, err
+	}
+	return c.sqlite.open(c.storageDir)
+}
+
+// sqliteRefDB returns the database a snapshot reference points into: the
+// client's own, or another storage directory's, which the caller closes
+func (c *MerkleClient) sqliteRefDB(ref string) (*sql.DB, func() error, error) {
+	dbPath, _, _ := splitSQLiteRef(ref)
+	if c.sqlite != nil && dbPath == filepath.Clean(filepath.Join(c.storageDir, sqliteFile)) {
+		db, err := c.sqliteDB()
+		return db, func() error { return nil }, err
+	}
+	db, err := openSQLite(dbPath, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, db.Close, nil
+}
+
+// loadSQLiteRef loads the snapshot a reference names
+func (c *MerkleClient) loadSQLiteRef(ref string, mode LoadMode) (*TreeState, error) {
+	db, done, err := c.sqliteRefDB(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	_, name, _ := splitSQLiteRef(ref)
+	return loadSQLiteSnapshot(db, name, mode)
+}
+
+// snapshotExists returns an error unless a snapshot file or a snapshot in a
+// database exists
+func (c *MerkleClient) snapshotExists(file string) error {
+	if _, name, ok := splitSQLiteRef(file); ok {
+		db, done, err := c.sqliteRefDB(file)
+		if err != nil {
+			return err
+		}
+		defer done()
+		var found int
+		err = db.QueryRow(`SELECT 1 FROM snapshots WHERE name = ?`, name).Scan(&found)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no snapshot found: %s", file)
+		}
+		return err
+	}
+	_, err := os.Stat(file)
+	return err
+}
+
+// sqliteSnapshotAt returns the newest snapshot of a folder in the client's
+// database taken at or before a snapshot stamp, or the newest at all for an
+// empty stamp. It returns "" when there is none or no database.
+func (c *MerkleClient) sqliteSnapshotAt(folder, stamp string) (string, error) {
+	db, err := c.sqliteDB()
+	if db == nil || err != nil {
+		return "", err
+	}
+	name, err := sqliteSnapshotAt(db, folder, stamp)
+	if name == "" || err != nil {
+		return "", err
+	}
+	return sqliteRef(c.storageDir, name), nil
+}
+
+// storedSnapshots lists the snapshots of a folder, or of every folder for "",
+// both snapshot files and snapshots in the client's database, ordered by name
+// and therefore by folder and time
+func (c *MerkleClient) storedSnapshots(folder string) ([]string, error) {
+	pattern := "state_*"
+	if folder != "" {
+		pattern = "state_" + folder + "_*"
+	}
+	snapshots, err := filepath.Glob(filepath.Join(c.storageDir, pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := c.sqliteDB()
+	if err != nil {
+		return nil, err
+	}
+	if db != nil {
+		names, err := sqliteSnapshotNames(db, folder)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			snapshots = append(snapshots, sqliteRef(c.storageDir, name))
+		}
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshotStem(snapshots[i]) < snapshotStem(snapshots[j])
+	})
+	return snapshots, nil
+}
+
+// saveSQLiteSnapshot stores a snapshot of a folder, named after the folder and
+// stamp like a snapshot file, in one transaction. A snapshot of the same name
+// is replaced, as a snapshot file would be.
+func saveSQLiteSnapshot(db *sql.DB, state *TreeState, folder, stamp string) error {
+	name := fmt.Sprintf("state_%s_%s", folder, stamp)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteSQLiteSnapshot(tx, name); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`INSERT INTO snapshots (name, folder, stamp, timestamp, root_hash, scheme) VALUES (?, ?, ?, ?, ?, ?)`,
+		name, folder, stamp, state.Timestamp.Format(time.RFC3339Nano), state.RootHash, state.Scheme.String())
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	insert, err := tx.Prepare(`INSERT INTO file_hashes (snapshot_id, path, hash, size, status, content, digests, chunks, attributes) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, path := range sortedPaths(state) {
+		entry := stateEntry(state, path)
+		if _, err := insert.Exec(id, entry.Path, entry.Hash, entry.Size, entry.Status, int(entry.Content),
+			entry.digests, entry.chunks, entry.attributes); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteSQLiteSnapshot removes a snapshot and its files, if it exists
+func deleteSQLiteSnapshot(tx *sql.Tx, name string) error {
+	if _, err := tx.Exec(`DELETE FROM file_hashes WHERE snapshot_id IN (SELECT id FROM snapshots WHERE name = ?)`, name); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM snapshots WHERE name = ?`, name)
+	return err
+}
+
+// loadSQLiteSnapshot loads a snapshot from a database. Malformed values are
+// handled as in binary snapshots; the database is always a current format.
+func loadSQLiteSnapshot(db *sql.DB, name string, mode LoadMode) (*TreeState, error) {
+	var id int64
+	var timestamp, scheme string
+	state := &TreeState{
+		FileHashes: make(map[string][]byte),
+		FileSizes:  make(map[string]int64),
+		Errors:     make(map[string]string),
+		Unstable:   make(map[string]bool),
+		Digests:    make(map[string]map[string][]byte),
+		Chunks:     make(map[string]*FileChunks),
+		Content:    make(map[string]ContentKind),
+		Attributes: make(map[string]FileAttributes),
+	}
+	err := db.QueryRow(`SELECT id, timestamp, root_hash, scheme FROM snapshots WHERE name = ?`, name).
+		Scan(&id, &timestamp, &state.RootHash, &scheme)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no snapshot found: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if state.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+		return nil, err
+	}
+	if state.Scheme, err = parseHashScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT path, hash, size, status, content, digests, chunks, attributes FROM file_hashes WHERE snapshot_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	in := newSnapshotInterner()
+	rc := &rowChecker{strict: mode.strict(true)}
+	for rows.Next() {
+		var entry SnapshotEntry
+		var content int
+		if err := rows.Scan(&entry.Path, &entry.Hash, &entry.Size, &entry.Status, &content,
+			&entry.digests, &entry.chunks, &entry.attributes); err != nil {
+			return nil, err
+		}
+		entry.Content = ContentKind(content)
+		rc.row++
+		if err := addEntry(state, entry, in, rc); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	state.Warnings = rc.warnings
+	return state, nil
+}
+
+// sqliteSnapshotAt returns the name of the newest snapshot of a folder taken
+// at or before a snapshot stamp, or of the newest at all for an empty stamp,
+// and "" if there is none
+func sqliteSnapshotAt(db *sql.DB, folder, stamp string) (string, error) {
+	query := `SELECT name FROM snapshots WHERE folder = ? ORDER BY stamp DESC LIMIT 1`
+	args := []any{folder}
+	if stamp != "" {
+		query = `SELECT name FROM snapshots WHERE folder = ? AND stamp <= ? ORDER BY stamp DESC LIMIT 1`
+		args = append(args, stamp)
+	}
+
+	var name string
+	err := db.QueryRow(query, args...).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return name, err
+}
+
+// sqliteSnapshotNames returns the names of the snapshots of a folder, or of
+// every folder for "", ordered by folder and time
+func sqliteSnapshotNames(db *sql.DB, folder string) ([]string, error) {
+	query := `SELECT name FROM snapshots ORDER BY folder, stamp`
+	var args []any
+	if folder != "" {
+		query = `SELECT name FROM snapshots WHERE folder = ? ORDER BY stamp`
+		args = append(args, folder)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// sqliteObservations returns what every snapshot of a folder in the database
+// recorded for one file, oldest first, in a single query
+func sqliteObservations(db *sql.DB, folder, path string) ([]observation, error) {
+	rows, err := db.Query(`SELECT s.stamp, f.hash, f.size, f.status FROM snapshots s
+		LEFT JOIN file_hashes f ON f.snapshot_id = s.id AND f.path = ?
+		WHERE s.folder = ? ORDER BY s.stamp`, path, folder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var observations []observation
+	for rows.Next() {
+		var stamp string
+		var hash []byte
+		var size sql.NullInt64
+		var status sql.NullString
+		if err := rows.Scan(&stamp, &hash, &size, &status); err != nil {
+			return nil, err
+		}
+		taken, err := time.ParseInLocation(snapshotStampLayout, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+
+		var period HashPeriod
+		if reason, failed := strings.CutPrefix(status.String, "error: "); failed {
+			period.Error = reason
+		} else if status.Valid {
+			period.Hash, period.Size = hash, size.Int64
+		}
+		observations = append(observations, observation{taken: taken, period: period})
+	}
+	return observations, rows.Err()
+}
+
+// retireSQLiteSnapshot deletes a snapshot from its database, first saving it
+// as a CSV snapshot file in archiveDir if set
+func (c *MerkleClient) retireSQLiteSnapshot(ref, archiveDir string) error {
+	db, err := c.sqliteDB()
+	if err != nil {
+		return err
+	}
+	_, name, _ := splitSQLiteRef(ref)
+	if archiveDir != "" {
+		state, err := loadSQLiteSnapshot(db, name, LoadStrict)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(archiveDir, 0755); err != nil {
+			return err
+		}
+		if err := writeSnapshotFile(filepath.Join(archiveDir, name+FormatCSV.extension()), state, FormatCSV); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := deleteSQLiteSnapshot(tx, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
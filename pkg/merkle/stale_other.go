@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package merkle
+
+// isStaleHandle reports stale file handles; they cannot be told apart from
+// other errors on this platform
+func isStaleHandle(err error) bool {
+	return false
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package merkle
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isStaleHandle reports whether an error means an NFS server no longer
+// recognizes the file handle
+func isStaleHandle(err error) bool {
+	return errors.Is(err, syscall.ESTALE)
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package merkle
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Win32 errors of SMB connections that were dropped underneath an open file
+const (
+	errorUnexpNetErr    = syscall.Errno(59) // ERROR_UNEXP_NET_ERR
+	errorNetnameDeleted = syscall.Errno(64) // ERROR_NETNAME_DELETED
+)
+
+// isStaleHandle reports whether an error means the share dropped the file
+// handle, which reopening the file usually recovers from
+func isStaleHandle(err error) bool {
+	return errors.Is(err, errorUnexpNetErr) || errors.Is(err, errorNetnameDeleted)
+}
@@ -0,0 +1,170 @@
+package merkle
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithOwners restricts scans to files owned as one of the specs says, written
+// as for chown: "root" matches files owned by the user root, ":wheel" files
+// of the group wheel and "root:wheel" both at once. Users and groups may be
+// given by name or numeric ID. Directories are still descended into, and
+// files excluded by WithExcludeOwners stay excluded. Scans fail where file
+// owners are not available, such as on Windows, or when a name is unknown.
+func WithOwners(specs ...string) Option {
+	return func(c *MerkleClient) {
+		c.scan.owners = append(c.scan.owners, specs...)
+	}
+}
+
+// WithExcludeOwners leaves out files owned as any of the specs says, in the
+// syntax of WithOwners
+func WithExcludeOwners(specs ...string) Option {
+	return func(c *MerkleClient) {
+		c.scan.excludeOwners = append(c.scan.excludeOwners, specs...)
+	}
+}
+
+// WithNewerThan restricts scans to files modified less than age before the
+// scan started. A file that has not been modified since drops out of later
+// scans and is reported as deleted, so this suits scans that are compared
+// with a baseline less than age old, or where only recent activity matters.
+func WithNewerThan(age time.Duration) Option {
+	return func(c *MerkleClient) {
+		c.scan.newerThan = age
+	}
+}
+
+// WithOlderThan leaves out files modified less than age before the scan
+// started, such as files still being written. A file modified during the
+// scan window drops out of the scan and is reported as deleted until it is
+// age old.
+func WithOlderThan(age time.Duration) Option {
+	return func(c *MerkleClient) {
+		c.scan.olderThan = age
+	}
+}
+
+// errNoOwners fails scans filtered by owner on platforms without file owners
+var errNoOwners = errors.New("file owners are not available on this platform")
+
+// ownerSpec matches the owner of a file; an empty ID matches any
+type ownerSpec struct {
+	uid, gid string
+}
+
+// parseOwnerSpec resolves a "user", ":group" or "user:group" spec to IDs
+func parseOwnerSpec(spec string) (ownerSpec, error) {
+	name, group, _ := strings.Cut(spec, ":")
+	if name == "" && group == "" {
+		return ownerSpec{}, fmt.Errorf("invalid owner %q", spec)
+	}
+
+	var owner ownerSpec
+	if name != "" {
+		owner.uid = name
+		if _, err := strconv.ParseUint(name, 10, 32); err != nil {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return ownerSpec{}, fmt.Errorf("unknown user %q in owner %q", name, spec)
+			}
+			owner.uid = u.Uid
+		}
+	}
+	if group != "" {
+		owner.gid = group
+		if _, err := strconv.ParseUint(group, 10, 32); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return ownerSpec{}, fmt.Errorf("unknown group %q in owner %q", group, spec)
+			}
+			owner.gid = g.Gid
+		}
+	}
+	return owner, nil
+}
+
+// matches reports whether a file with the given owner and group matches
+func (s ownerSpec) matches(uid, gid string) bool {
+	return (s.uid == "" || s.uid == uid) && (s.gid == "" || s.gid == gid)
+}
+
+// statFilter skips files by their owner and modification time
+type statFilter struct {
+	owners        []ownerSpec
+	excludeOwners []ownerSpec
+	newerThan     time.Time // files modified before are skipped; zero for none
+	olderThan     time.Time // files modified after are skipped; zero for none
+}
+
+// newStatFilter resolves the owner and age filters of a scan starting at now,
+// or returns nil when there are none
+func newStatFilter(opts scanOptions, now time.Time) (*statFilter, error) {
+	if len(opts.owners) == 0 && len(opts.excludeOwners) == 0 && opts.newerThan <= 0 && opts.olderThan <= 0 {
+		return nil, nil
+	}
+
+	f := &statFilter{}
+	for _, spec := range opts.owners {
+		owner, err := parseOwnerSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		f.owners = append(f.owners, owner)
+	}
+	for _, spec := range opts.excludeOwners {
+		owner, err := parseOwnerSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		f.excludeOwners = append(f.excludeOwners, owner)
+	}
+	if opts.newerThan > 0 {
+		f.newerThan = now.Add(-opts.newerThan)
+	}
+	if opts.olderThan > 0 {
+		f.olderThan = now.Add(-opts.olderThan)
+	}
+	return f, nil
+}
+
+// skip reports whether a file is left out of the scan. It is a no-op on a
+// nil filter.
+func (f *statFilter) skip(info os.FileInfo) (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+	if !f.newerThan.IsZero() && info.ModTime().Before(f.newerThan) {
+		return true, nil
+	}
+	if !f.olderThan.IsZero() && info.ModTime().After(f.olderThan) {
+		return true, nil
+	}
+	if len(f.owners) == 0 && len(f.excludeOwners) == 0 {
+		return false, nil
+	}
+
+	uid, gid, ok := ownerOf(info)
+	if !ok {
+		return false, errNoOwners
+	}
+	for _, owner := range f.excludeOwners {
+		if owner.matches(uid, gid) {
+			return true, nil
+		}
+	}
+	if len(f.owners) == 0 {
+		return false, nil
+	}
+	for _, owner := range f.owners {
+		if owner.matches(uid, gid) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
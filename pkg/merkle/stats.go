@@ -0,0 +1,101 @@
+package merkle
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// statsFile logs the statistics of every saved snapshot, one row each, so that
+// tree growth can be tracked without reading the snapshots themselves
+const statsFile = "stats.csv"
+
+var statsHeader = []string{"folder", "timestamp", "root_hash", "leaf_count", "tree_depth", "total_bytes"}
+
+// TreeStats summarizes the size of a tree
+type TreeStats struct {
+	Leaves int   // number of leaves, including files that could not be hashed
+	Depth  int   // levels below the root; 0 for a single leaf
+	Bytes  int64 // total size of the hashed files
+}
+
+// Stats returns the statistics of the tree
+func (t *MerkleTree) Stats() TreeStats {
+	var stats TreeStats
+	t.Walk(func(node *MerkleNode, depth int) error {
+		if depth > stats.Depth {
+			stats.Depth = depth
+		}
+		if node.IsLeaf {
+			stats.Leaves++
+			stats.Bytes += node.Size
+		}
+		return nil
+	})
+	return stats
+}
+
+// Stats returns the statistics of the tree the state was taken from
+func (s *TreeState) Stats() TreeStats {
+	stats := TreeStats{Leaves: len(s.FileHashes) + len(s.Errors)}
+	for _, size := range s.FileSizes {
+		stats.Bytes += size
+	}
+	stats.Depth = treeDepth(stats.Leaves, s.Scheme)
+	return stats
+}
+
+// treeDepth returns the depth of the tree of a scheme over n leaves; every
+// level halves the number of nodes, rounding up, and trees of version 3 and
+// later have a root above their top
+func treeDepth(n int, scheme HashScheme) int {
+	depth := 0
+	if n > 1 {
+		depth = bits.Len(uint(n - 1))
+	}
+	if scheme.separatesDomains() && n > 0 {
+		depth++
+	}
+	return depth
+}
+
+// appendStats logs the statistics of a saved snapshot; the caller must hold
+// the storage lock
+func (c *MerkleClient) appendStats(folderPath string, state *TreeState) error {
+	filename := filepath.Join(c.storageDir, statsFile)
+	_, statErr := os.Stat(filename)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if os.IsNotExist(statErr) {
+		if err := writer.Write(statsHeader); err != nil {
+			return err
+		}
+	}
+
+	stats := state.Stats()
+	if err := writer.Write([]string{
+		filepath.Base(folderPath),
+		state.Timestamp.Format(time.RFC3339),
+		hex.EncodeToString(state.RootHash),
+		strconv.Itoa(stats.Leaves),
+		strconv.Itoa(stats.Depth),
+		strconv.FormatInt(stats.Bytes, 10),
+	}); err != nil {
+		return err
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
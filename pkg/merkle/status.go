@@ -0,0 +1,153 @@
+package merkle
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// StorageStatus summarizes the contents and health of a storage directory
+type StorageStatus struct {
+	Dir        string
+	TotalBytes int64 // size of every file in the directory, snapshots or not
+	Folders    []FolderStatus
+	Problems   []string // corrupt snapshots and unreadable logs; empty when healthy
+}
+
+// FolderStatus describes the snapshots kept for one monitored folder
+type FolderStatus struct {
+	Name      string    // folder name used in snapshot file names
+	Path      string    // where the folder was scanned from; "" if not recorded
+	Missing   bool      // Path no longer exists, so gc would collect the folder
+	Snapshots int       // number of snapshot files
+	Bytes     int64     // size of the snapshot files
+	Oldest    time.Time // timestamp of the oldest snapshot
+	Newest    time.Time // timestamp of the newest snapshot
+	Corrupt   []string  // snapshot files that cannot be loaded
+	Baseline  *Baseline // snapshot verify compares against; nil if none
+	Named     []string  // names of the folder's named baselines
+}
+
+// StorageStatus reads the whole storage directory and reports, per monitored
+// folder, how many snapshots are kept, how much space they use and the time
+// range they cover and which snapshot is the baseline. Every snapshot is loaded
+// strictly to find corrupt ones, and the folder index, baselines and change log
+// are checked as well; what fails is listed in Problems rather than returned as
+// an error.
+func (c *MerkleClient) StorageStatus() (*StorageStatus, error) {
+	status := &StorageStatus{Dir: c.storageDir}
+
+	entries, err := os.ReadDir(c.storageDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			status.TotalBytes += info.Size()
+		}
+	}
+
+	index, err := c.readFolderIndex()
+	if err != nil {
+		status.Problems = append(status.Problems, fmt.Sprintf("%s: %v", folderIndexFile, err))
+	}
+	baselines, err := c.readBaselines()
+	if err != nil {
+		status.Problems = append(status.Problems, fmt.Sprintf("%s: %v", baselineFile, err))
+	}
+	if _, err := readEvents(filepath.Join(c.storageDir, historyFile)); err != nil {
+		status.Problems = append(status.Problems, fmt.Sprintf("%s: %v", historyFile, err))
+	}
+
+	files, err := c.storedSnapshots("")
+	if err != nil {
+		return nil, err
+	}
+
+	folders := make(map[string]*FolderStatus)
+	for _, file := range files {
+		name, ok := snapshotFolderName(file)
+		if !ok {
+			continue
+		}
+		folder := folders[name]
+		if folder == nil {
+			folder = &FolderStatus{Name: name, Path: index[name]}
+			if folder.Path != "" && !IsSetPath(folder.Path) {
+				path := folder.Path
+				if _, imagePath, ok := splitImagePath(path); ok {
+					path = imagePath
+				}
+				_, err := os.Stat(path)
+				folder.Missing = os.IsNotExist(err)
+			}
+			folders[name] = folder
+		}
+
+		folder.Snapshots++
+		if info, err := os.Stat(file); err == nil {
+			folder.Bytes += info.Size()
+		}
+		if taken, err := snapshotTime(file); err == nil {
+			if folder.Oldest.IsZero() || taken.Before(folder.Oldest) {
+				folder.Oldest = taken
+			}
+			if taken.After(folder.Newest) {
+				folder.Newest = taken
+			}
+		}
+
+		if err := c.checkSnapshot(file); err != nil {
+			folder.Corrupt = append(folder.Corrupt, file)
+			status.Problems = append(status.Problems, fmt.Sprintf("%s: %v", file, err))
+		}
+	}
+
+	for name, baseline := range baselines {
+		if err := c.snapshotExists(baseline.Snapshot); err != nil {
+			status.Problems = append(status.Problems, fmt.Sprintf("baseline of %s: %v", name, err))
+		}
+		if folder := folders[name]; folder != nil {
+			baseline := baseline
+			folder.Baseline = &baseline
+		}
+	}
+
+	for name, folder := range folders {
+		named, err := c.NamedBaselines(name)
+		if err != nil {
+			status.Problems = append(status.Problems, fmt.Sprintf("named baselines of %s: %v", name, err))
+		}
+		for baselineName, file := range named {
+			folder.Named = append(folder.Named, baselineName)
+			if err := c.checkSnapshot(file); err != nil {
+				status.Problems = append(status.Problems, fmt.Sprintf("%s: %v", file, err))
+			}
+		}
+		sort.Strings(folder.Named)
+		status.Folders = append(status.Folders, *folder)
+	}
+	sort.Slice(status.Folders, func(i, j int) bool {
+		return status.Folders[i].Name < status.Folders[j].Name
+	})
+	return status, nil
+}
+
+// checkSnapshot loads a snapshot strictly, failing on any malformed value
+func (c *MerkleClient) checkSnapshot(file string) error {
+	if _, _, ok := splitSQLiteRef(file); ok {
+		_, err := c.loadSQLiteRef(file, LoadStrict)
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = decodeSnapshot(f, LoadStrict)
+	return err
+}
@@ -0,0 +1,179 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ChangeGroup is a set of changes sharing a key, as returned by the groupByDir
+// and groupByType template functions
+type ChangeGroup struct {
+	Key     string
+	Changes []FileChange
+}
+
+// ReportFuncs returns the helper functions available to report templates:
+//
+//	hex <hash>              full hex encoding of a hash
+//	shortHash <hash>        first 16 bytes in hex, as in the text report
+//	changeType <change>     MODIFIED, ADDED, DELETED, CASE_RENAMED, ATTRIBUTES,
+//	                        RENAMED or MOVED
+//	sizeChange <change>     grew, shrank, same size or TRUNCATED
+//	contentKind <change>    text, binary or unknown
+//	recheck <change>        what hashing the file again found, after a verify
+//	severity <change>       INFO, WARNING or CRITICAL
+//	unacknowledged <changes>
+//	                        the changes that were not acknowledged as expected
+//	atLeast <severity> <changes>
+//	                        the changes of that severity ("warning", ...) or higher
+//	ofType <type> <changes> the changes of one type ("modified", "added", ...)
+//	count <type> <changes>  how many changes are of that type
+//	groupByDir <changes>    changes grouped by parent directory, in path order
+//	groupByType <changes>   changes grouped by change type
+//	formatCount <n>         n with thousands separators
+//	sizeDelta <change>      the signed change in bytes
+//	humanBytes <n>          a byte count in KiB, MiB and so on, e.g. "1.5 KiB"
+//	ago <time>              how long ago a time was, e.g. "6 hours ago"
+//	upper, lower, join      the strings functions of the same name
+func ReportFuncs() template.FuncMap {
+	return template.FuncMap{
+		"hex": hex.EncodeToString,
+		"shortHash": func(h []byte) string {
+			return hex.EncodeToString(shortHash(h, 16))
+		},
+		"changeType": func(c FileChange) string {
+			return GetChangeTypeString(c.ChangeType)
+		},
+		"sizeChange": func(c FileChange) string {
+			return GetSizeChangeString(c.SizeChange())
+		},
+		"contentKind": func(c FileChange) string {
+			return GetContentKindString(c.Content)
+		},
+		"recheck": func(c FileChange) string {
+			return GetRecheckString(c.Recheck)
+		},
+		"severity": func(c FileChange) string {
+			return strings.ToUpper(c.Severity.String())
+		},
+		"atLeast": changesAtLeast,
+		"unacknowledged": func(changes []FileChange) []FileChange {
+			var unacknowledged []FileChange
+			for _, change := range changes {
+				if !change.Acknowledged {
+					unacknowledged = append(unacknowledged, change)
+				}
+			}
+			return unacknowledged
+		},
+		"ofType": changesOfType,
+		"count": func(name string, changes []FileChange) (int, error) {
+			matching, err := changesOfType(name, changes)
+			return len(matching), err
+		},
+		"groupByDir": func(changes []FileChange) []ChangeGroup {
+			return groupChanges(changes, func(c FileChange) string {
+				return path.Dir(c.FileName)
+			})
+		},
+		"groupByType": func(changes []FileChange) []ChangeGroup {
+			return groupChanges(changes, func(c FileChange) string {
+				return GetChangeTypeString(c.ChangeType)
+			})
+		},
+		"formatCount": formatCount,
+		"sizeDelta": func(c FileChange) int64 {
+			return c.NewSize - c.OldSize
+		},
+		"humanBytes": FormatBytes,
+		"ago": func(t time.Time) string {
+			return FormatAge(t, time.Now())
+		},
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"join":  strings.Join,
+	}
+}
+
+// ParseReportTemplate parses a text/template for rendering change reports,
+// with ReportFuncs available. The template is executed with the
+// *ChangeReport as its data.
+func ParseReportTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(ReportFuncs()).Parse(text)
+}
+
+// LoadReportTemplate reads and parses a report template file
+func LoadReportTemplate(filename string) (*template.Template, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseReportTemplate(filepath.Base(filename), string(data))
+}
+
+// WriteTemplateReport renders a change report through a template to w
+func WriteTemplateReport(w io.Writer, tmpl *template.Template, report *ChangeReport) error {
+	return tmpl.Execute(w, report)
+}
+
+// changesOfType returns the changes of the type named as in
+// GetChangeTypeString, in any letter case
+func changesOfType(name string, changes []FileChange) ([]FileChange, error) {
+	changeType, ok := parseChangeType(strings.ToUpper(name))
+	if !ok {
+		return nil, fmt.Errorf("unknown change type %q", name)
+	}
+
+	var matching []FileChange
+	for _, change := range changes {
+		if change.ChangeType == changeType {
+			matching = append(matching, change)
+		}
+	}
+	return matching, nil
+}
+
+// changesAtLeast returns the changes of the named severity or higher
+func changesAtLeast(name string, changes []FileChange) ([]FileChange, error) {
+	severity, err := ParseSeverity(strings.ToLower(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []FileChange
+	for _, change := range changes {
+		if change.Severity >= severity {
+			matching = append(matching, change)
+		}
+	}
+	return matching, nil
+}
+
+// groupChanges groups changes by key, ordering groups by key and keeping the
+// order of changes within each group
+func groupChanges(changes []FileChange, key func(FileChange) string) []ChangeGroup {
+	index := make(map[string]int)
+	var groups []ChangeGroup
+	for _, change := range changes {
+		k := key(change)
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, ChangeGroup{Key: k})
+		}
+		groups[i].Changes = append(groups[i].Changes, change)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Key < groups[j].Key
+	})
+	return groups
+}
@@ -0,0 +1,113 @@
+package merkle
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path"
+	"regexp"
+)
+
+// Transformer rewrites file content before it is hashed, so that cosmetically
+// different but semantically identical files produce the same hash
+type Transformer func(r io.Reader) io.Reader
+
+// contentTransform applies a Transformer to files matching a pattern
+type contentTransform struct {
+	name      string
+	pattern   string
+	transform Transformer
+}
+
+// WithTransformer applies t to the content of every file whose relative path,
+// or base name, matches pattern (path.Match syntax, e.g. "*.txt" or
+// "gen/*.go") before hashing. Transformers run in the order they were added.
+//
+// The name identifies what t does, e.g. "eol" or "strip ^// Generated at ",
+// and is recorded with the pattern in the hash scheme, so snapshots whose
+// files were transformed differently are not compared; a transformer that
+// changes what it does needs a new name.
+func WithTransformer(name, pattern string, t Transformer) Option {
+	return func(c *MerkleClient) {
+		c.scan.transforms = append(c.scan.transforms, contentTransform{name, pattern, t})
+	}
+}
+
+// NormalizeLineEndings converts CRLF line endings to LF
+func NormalizeLineEndings() Transformer {
+	return LineTransformer(func(line []byte) []byte {
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			return append(line[:len(line)-2], '\n')
+		}
+		return line
+	})
+}
+
+// StripLinesMatching drops every line matching re, such as a volatile
+// "generated at" header in generated files
+func StripLinesMatching(re *regexp.Regexp) Transformer {
+	return LineTransformer(func(line []byte) []byte {
+		if re.Match(bytes.TrimRight(line, "\r\n")) {
+			return nil
+		}
+		return line
+	})
+}
+
+// LineTransformer builds a Transformer from a function applied to every line,
+// including its line ending. Returning nil drops the line. Lines longer than
+// maxLineLength, as found in binary files, are passed through unchanged.
+func LineTransformer(fn func(line []byte) []byte) Transformer {
+	return func(r io.Reader) io.Reader {
+		return &lineReader{src: bufio.NewReaderSize(r, maxLineLength), fn: fn}
+	}
+}
+
+// maxLineLength is the longest line a LineTransformer function is given, so a
+// file without line breaks is never read into memory whole
+const maxLineLength = 64 << 10
+
+// lineReader streams the result of applying fn to each line of src
+type lineReader struct {
+	src  *bufio.Reader
+	fn   func(line []byte) []byte
+	buf  []byte
+	long bool // the rest of the current line is too long for fn
+	err  error
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		var line []byte
+		line, r.err = r.src.ReadSlice('\n')
+		switch {
+		case r.err == bufio.ErrBufferFull:
+			r.err = nil
+			r.buf, r.long = line, true
+		case r.long:
+			r.buf, r.long = line, false
+		case len(line) > 0:
+			r.buf = r.fn(append([]byte(nil), line...))
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// transformsFor returns the transformers that apply to a file
+func (opts scanOptions) transformsFor(relPath string) []Transformer {
+	var transforms []Transformer
+	for _, t := range opts.transforms {
+		if matched, _ := path.Match(t.pattern, relPath); matched {
+			transforms = append(transforms, t.transform)
+		} else if matched, _ := path.Match(t.pattern, path.Base(relPath)); matched {
+			transforms = append(transforms, t.transform)
+		}
+	}
+	return transforms
+}
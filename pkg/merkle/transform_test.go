@@ -0,0 +1,53 @@
+package merkle
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLineTransformers(t *testing.T) {
+	in := "keep\r\n# stamp\r\nlast"
+	for _, tc := range []struct {
+		name      string
+		transform Transformer
+		want      string
+	}{
+		{"eol", NormalizeLineEndings(), "keep\n# stamp\nlast"},
+		{"strip", StripLinesMatching(regexp.MustCompile("^#")), "keep\r\nlast"},
+	} {
+		out, err := io.ReadAll(tc.transform(strings.NewReader(in)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tc.want {
+			t.Errorf("%s: %q, want %q", tc.name, out, tc.want)
+		}
+	}
+}
+
+// A line longer than maxLineLength, such as a binary file without line
+// breaks, is passed through unchanged instead of being held whole
+func TestLineTransformerPassesLongLinesThrough(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 3*maxLineLength+5)
+	in := append(append([]byte("short\r\n"), long...), "\r\nend\r\n"...)
+
+	var longest int
+	transform := LineTransformer(func(line []byte) []byte {
+		longest = max(longest, len(line))
+		return bytes.ReplaceAll(line, []byte("\r\n"), []byte("\n"))
+	})
+	out, err := io.ReadAll(transform(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if longest > maxLineLength {
+		t.Errorf("fn was given a line of %d bytes, over %d", longest, maxLineLength)
+	}
+	want := append(append([]byte("short\n"), long...), "\r\nend\n"...)
+	if !bytes.Equal(out, want) {
+		t.Errorf("output of %d bytes differs from the %d expected", len(out), len(want))
+	}
+}
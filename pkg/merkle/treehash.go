@@ -0,0 +1,157 @@
+package merkle
+
+import "encoding/binary"
+
+// Domain prefixes of the hashes in trees of version 3 and later, so a leaf can
+// never pass for an interior node or the root, and the other way around
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+	rootPrefix = 0x02
+)
+
+// treeHasher computes the hashes of the nodes of a tree under a hash scheme.
+//
+// Trees of version 3 and later hash a leaf as its path and file hash under the
+// leaf prefix and an interior node as its children under the node prefix. The
+// last node of an odd level moves up a level unchanged, and the root hashes
+// the number of leaves with the top of the tree, so every leaf count and
+// position gives a different root. Versions 1 and 2 used the file hash as the
+// leaf, concatenated children without a prefix and paired the last node of an
+// odd level with itself; such trees are still built to check stored root
+// hashes, but a tree over [a b c] has the root of one over [a b c c], so they
+// cannot back inclusion proofs.
+type treeHasher struct {
+	combine   func([]byte) []byte
+	separated bool
+}
+
+// newTreeHasher returns the hasher of trees of a scheme
+func newTreeHasher(scheme HashScheme) (treeHasher, error) {
+	combine, err := nodeHasher(scheme.NodeAlgorithm)
+	if err != nil {
+		return treeHasher{}, err
+	}
+	return treeHasher{combine: combine, separated: scheme.separatesDomains()}, nil
+}
+
+// leaf returns the value a leaf with the given path and file hash contributes
+// to its parent
+func (h treeHasher) leaf(fileName string, hash []byte) []byte {
+	if !h.separated {
+		return hash
+	}
+	data := make([]byte, 0, 2+len(fileName)+len(hash))
+	data = append(data, leafPrefix)
+	data = append(data, fileName...)
+	data = append(data, 0)
+	return h.combine(append(data, hash...))
+}
+
+// value returns the value a node contributes to its parent
+func (h treeHasher) value(node *MerkleNode) []byte {
+	if node.IsLeaf {
+		return h.leaf(node.FileName, node.Hash)
+	}
+	return node.Hash
+}
+
+// node returns the hash of an interior node over the values of its children
+func (h treeHasher) node(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	if h.separated {
+		data = append(data, nodePrefix)
+	}
+	data = append(data, left...)
+	return h.combine(append(data, right...))
+}
+
+// root returns the root hash of a tree of count leaves whose top node has the
+// given value
+func (h treeHasher) root(count int, top []byte) []byte {
+	data := make([]byte, 9, 9+len(top))
+	data[0] = rootPrefix
+	binary.BigEndian.PutUint64(data[1:], uint64(count))
+	return h.combine(append(data, top...))
+}
+
+// build pairs up nodes level by level into a tree and returns its root. In
+// trees of version 3 and later the root is a node of its own above the top of
+// the tree, with the top as its only child.
+func (h treeHasher) build(nodes []*MerkleNode) *MerkleNode {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	// Allocate every interior node up front; each level halves, rounding up
+	interior := 0
+	for n := len(nodes); n > 1; n = (n + 1) / 2 {
+		if h.separated {
+			interior += n / 2
+		} else {
+			interior += (n + 1) / 2
+		}
+	}
+	if h.separated {
+		interior++
+	}
+	parents := make([]MerkleNode, interior)
+
+	level := nodes
+	for len(level) > 1 {
+		nextLevel := make([]*MerkleNode, (len(level)+1)/2)
+		for i := range nextLevel {
+			left := level[2*i]
+			var right *MerkleNode
+			switch {
+			case 2*i+1 < len(level):
+				right = level[2*i+1]
+			case h.separated:
+				nextLevel[i] = left
+				continue
+			default:
+				right = left // the last node of an odd level is paired with itself
+			}
+
+			parent := &parents[0]
+			parents = parents[1:]
+			parent.Hash = h.node(h.value(left), h.value(right))
+			parent.Left = left
+			parent.Right = right
+			nextLevel[i] = parent
+		}
+		level = nextLevel
+	}
+
+	if !h.separated {
+		return level[0]
+	}
+	root := &parents[0]
+	root.Hash = h.root(len(nodes), h.value(level[0]))
+	root.Left = level[0]
+	return root
+}
+
+// separatesDomains reports whether trees of the scheme are built as described
+// by treeHasher for version 3 and later
+func (s HashScheme) separatesDomains() bool {
+	return s.TreeVersion >= 3
+}
+
+// leafOrder returns the order the leaves of trees of the scheme are sorted in
+func (s HashScheme) leafOrder() LeafOrder {
+	if s.TreeVersion <= 0 {
+		return BytewiseOrder
+	}
+	return LeafOrder((s.TreeVersion - 1) % 2)
+}
+
+// newMerkleTree builds the tree of a scheme over leaves sorted in its leaf
+// order
+func newMerkleTree(leaves []*MerkleNode, scheme HashScheme) (*MerkleTree, error) {
+	h, err := newTreeHasher(scheme)
+	if err != nil {
+		return nil, err
+	}
+	return &MerkleTree{Root: h.build(leaves), Scheme: scheme}, nil
+}
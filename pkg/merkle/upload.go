@@ -0,0 +1,523 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultUploadChunkSize is the chunk size UploadSnapshot uses when none is given
+const DefaultUploadChunkSize = 4 << 20
+
+// uploadRetries is how often a chunk is sent again before the upload gives up
+const uploadRetries = 3
+
+// maxUploadChunkSize is the largest chunk a StorageUploader accepts
+const maxUploadChunkSize = 64 << 20
+
+// uploadsDir is the directory of a storage directory that holds the chunks of
+// uploads in progress, one directory per upload
+const uploadsDir = "uploads"
+
+// ErrUploadMismatch is returned when a chunk, a manifest or an assembled
+// snapshot does not match what the manifest of its upload describes
+var ErrUploadMismatch = errors.New("upload does not match its manifest")
+
+// UploadManifest describes a snapshot split into chunks for upload. The ID
+// includes the digest of the whole file, so resuming the upload of a
+// snapshot that changed starts a new upload instead of mixing versions.
+type UploadManifest struct {
+	ID        string   // state_<folder>_<timestamp>-<first 16 hex digits of Digest>
+	Name      string   // base name of the snapshot file
+	Size      int64    // bytes in the snapshot
+	ChunkSize int64    // bytes per chunk; the last chunk may be shorter
+	Digest    []byte   // SHA-256 of the whole snapshot
+	Chunks    [][]byte // SHA-256 of each chunk, in order
+}
+
+// ChunkUploader is the server side of a chunked snapshot upload
+type ChunkUploader interface {
+	// Received returns the digests of the chunks of an upload the server
+	// already holds, by chunk index; an unknown upload has none
+	Received(uploadID string) (map[int][]byte, error)
+
+	// PutChunk stores one chunk; the server should check it with VerifyChunk
+	PutChunk(manifest *UploadManifest, index int, data []byte) error
+
+	// Complete assembles the chunks once all are stored; the server should
+	// check the result against manifest.Digest
+	Complete(manifest *UploadManifest) error
+}
+
+// NewUploadManifest reads a snapshot file and computes the digests of its
+// chunks. A chunkSize of zero or less selects DefaultUploadChunkSize.
+func NewUploadManifest(file string, chunkSize int64) (*UploadManifest, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultUploadChunkSize
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	manifest := &UploadManifest{Name: filepath.Base(file), ChunkSize: chunkSize}
+	whole := sha256.New()
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			sum := sha256.Sum256(buf[:n])
+			manifest.Chunks = append(manifest.Chunks, sum[:])
+			whole.Write(buf[:n])
+			manifest.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	manifest.Digest = whole.Sum(nil)
+	manifest.ID = fmt.Sprintf("%s-%x", snapshotStem(file), manifest.Digest[:8])
+	return manifest, nil
+}
+
+// VerifyChunk checks a received chunk against the manifest, for servers
+// implementing PutChunk
+func (m *UploadManifest) VerifyChunk(index int, data []byte) error {
+	if index < 0 || index >= len(m.Chunks) {
+		return fmt.Errorf("%w: upload %s: chunk %d out of range", ErrUploadMismatch, m.ID, index)
+	}
+	if want := m.chunkLen(index); int64(len(data)) != want {
+		return fmt.Errorf("%w: upload %s: chunk %d is %d bytes, want %d", ErrUploadMismatch, m.ID, index, len(data), want)
+	}
+	if sum := sha256.Sum256(data); !bytes.Equal(sum[:], m.Chunks[index]) {
+		return fmt.Errorf("%w: upload %s: chunk %d digest mismatch: got %s, want %s",
+			ErrUploadMismatch, m.ID, index, hex.EncodeToString(sum[:]), hex.EncodeToString(m.Chunks[index]))
+	}
+	return nil
+}
+
+// validate checks a manifest received from a client, so that its ID and name
+// are safe to use as file names and its chunks add up to its size
+func (m *UploadManifest) validate() error {
+	chunks := int64(0)
+	if m.ChunkSize > 0 {
+		chunks = m.Size / m.ChunkSize
+		if m.Size%m.ChunkSize != 0 {
+			chunks++
+		}
+	}
+	switch {
+	case !validUploadID(m.ID):
+		return fmt.Errorf("%w: invalid upload ID %q", ErrUploadMismatch, m.ID)
+	case !isSnapshotName(m.Name):
+		return fmt.Errorf("%w: upload %s: %q is not the name of a snapshot file", ErrUploadMismatch, m.ID, m.Name)
+	case m.ChunkSize <= 0 || m.ChunkSize > maxUploadChunkSize:
+		return fmt.Errorf("%w: upload %s: chunk size %d not between 1 and %d", ErrUploadMismatch, m.ID, m.ChunkSize, maxUploadChunkSize)
+	case m.Size < 0 || int64(len(m.Chunks)) != chunks:
+		return fmt.Errorf("%w: upload %s: %d chunks for %d bytes", ErrUploadMismatch, m.ID, len(m.Chunks), m.Size)
+	case len(m.Digest) != sha256.Size:
+		return fmt.Errorf("%w: upload %s: invalid digest", ErrUploadMismatch, m.ID)
+	case m.ID != fmt.Sprintf("%s-%x", snapshotStem(m.Name), m.Digest[:8]):
+		return fmt.Errorf("%w: upload %s does not match snapshot %s", ErrUploadMismatch, m.ID, m.Name)
+	}
+	for i, digest := range m.Chunks {
+		if len(digest) != sha256.Size {
+			return fmt.Errorf("%w: upload %s: invalid digest of chunk %d", ErrUploadMismatch, m.ID, i)
+		}
+	}
+	return nil
+}
+
+// validUploadID reports whether an upload ID is a plain name
+func validUploadID(id string) bool {
+	return id != "" && !strings.HasPrefix(id, ".") && !strings.ContainsAny(id, `/\:`)
+}
+
+// isSnapshotName reports whether name is the base name of a snapshot file
+func isSnapshotName(name string) bool {
+	if !validUploadID(name) || !strings.HasPrefix(name, "state_") {
+		return false
+	}
+	switch filepath.Ext(strings.TrimSuffix(name, ".gz")) {
+	case ".csv", ".json", ".fcds":
+		return true
+	}
+	return false
+}
+
+// jsonUploadManifest is the JSON form of an UploadManifest, as sent to
+// PUT /uploads/<id> of serve
+type jsonUploadManifest struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Size      int64    `json:"size"`
+	ChunkSize int64    `json:"chunk_size"`
+	Digest    string   `json:"digest"`
+	Chunks    []string `json:"chunks"`
+}
+
+// MarshalJSON encodes the manifest with hex digests
+func (m *UploadManifest) MarshalJSON() ([]byte, error) {
+	out := jsonUploadManifest{
+		ID:        m.ID,
+		Name:      m.Name,
+		Size:      m.Size,
+		ChunkSize: m.ChunkSize,
+		Digest:    hex.EncodeToString(m.Digest),
+		Chunks:    make([]string, len(m.Chunks)),
+	}
+	for i, digest := range m.Chunks {
+		out.Chunks[i] = hex.EncodeToString(digest)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON is the inverse of MarshalJSON
+func (m *UploadManifest) UnmarshalJSON(data []byte) error {
+	var in jsonUploadManifest
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	digest, err := hex.DecodeString(in.Digest)
+	if err != nil {
+		return fmt.Errorf("upload manifest digest: %v", err)
+	}
+	*m = UploadManifest{ID: in.ID, Name: in.Name, Size: in.Size, ChunkSize: in.ChunkSize, Digest: digest, Chunks: make([][]byte, len(in.Chunks))}
+	for i, chunk := range in.Chunks {
+		if m.Chunks[i], err = hex.DecodeString(chunk); err != nil {
+			return fmt.Errorf("upload manifest chunk %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// jsonUploadStatus is the JSON form of the chunks of an upload a server
+// holds, as returned by the /uploads endpoints of serve
+type jsonUploadStatus struct {
+	ID       string            `json:"id"`
+	Chunks   []jsonUploadChunk `json:"chunks"`
+	Snapshot string            `json:"snapshot,omitempty"` // ID of the snapshot saved by a commit
+}
+
+// jsonUploadChunk is one chunk a server holds
+type jsonUploadChunk struct {
+	Index  int    `json:"index"`
+	Digest string `json:"digest"`
+}
+
+// newJSONUploadStatus converts the chunks of an upload to their JSON form, in
+// chunk order
+func newJSONUploadStatus(id string, received map[int][]byte) jsonUploadStatus {
+	status := jsonUploadStatus{ID: id, Chunks: make([]jsonUploadChunk, 0, len(received))}
+	for index, digest := range received {
+		status.Chunks = append(status.Chunks, jsonUploadChunk{Index: index, Digest: hex.EncodeToString(digest)})
+	}
+	sort.Slice(status.Chunks, func(i, j int) bool {
+		return status.Chunks[i].Index < status.Chunks[j].Index
+	})
+	return status
+}
+
+// chunkLen returns the length of a chunk
+func (m *UploadManifest) chunkLen(index int) int64 {
+	if index == len(m.Chunks)-1 {
+		return m.Size - int64(index)*m.ChunkSize
+	}
+	return m.ChunkSize
+}
+
+// UploadSnapshot sends a snapshot file to a server in chunks of chunkSize
+// bytes. Chunks the server already holds with the right digest are skipped,
+// so calling it again after an interrupted upload only sends what is
+// missing. Each chunk is read back from the file, checked against the
+// manifest and sent up to uploadRetries times before the upload fails.
+func UploadSnapshot(file string, uploader ChunkUploader, chunkSize int64) (*UploadManifest, error) {
+	manifest, err := NewUploadManifest(file, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	received, err := uploader.Received(manifest.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, manifest.ChunkSize)
+	for i, digest := range manifest.Chunks {
+		if bytes.Equal(received[i], digest) {
+			continue
+		}
+
+		data := buf[:manifest.chunkLen(i)]
+		if _, err := f.ReadAt(data, int64(i)*manifest.ChunkSize); err != nil {
+			return nil, err
+		}
+		// The snapshot must not change underneath the upload
+		if err := manifest.VerifyChunk(i, data); err != nil {
+			return nil, err
+		}
+
+		for attempt := 1; ; attempt++ {
+			err = uploader.PutChunk(manifest, i, data)
+			if err == nil {
+				break
+			}
+			if attempt >= uploadRetries {
+				return nil, fmt.Errorf("upload %s: chunk %d failed after %d attempts: %v", manifest.ID, i, attempt, err)
+			}
+		}
+	}
+
+	if err := uploader.Complete(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// StorageUploader is the ChunkUploader of a storage directory. It keeps the
+// chunks of each upload in uploads/<id> and saves a completed upload as a
+// snapshot of the directory, where it loads and compares like any other. serve
+// exposes it as its /uploads endpoints.
+type StorageUploader struct {
+	client *MerkleClient
+}
+
+// NewStorageUploader returns the uploader of a storage directory
+func NewStorageUploader(storageDir string) *StorageUploader {
+	return &StorageUploader{client: &MerkleClient{storageDir: storageDir, scan: defaultScanOptions()}}
+}
+
+// dir returns the directory holding the chunks of an upload
+func (u *StorageUploader) dir(uploadID string) (string, error) {
+	if !validUploadID(uploadID) {
+		return "", fmt.Errorf("%w: invalid upload ID %q", ErrUploadMismatch, uploadID)
+	}
+	return filepath.Join(u.client.storageDir, uploadsDir, uploadID), nil
+}
+
+// Manifest returns the manifest an upload was started with. The error wraps
+// os.ErrNotExist when no such upload is in progress.
+func (u *StorageUploader) Manifest(uploadID string) (*UploadManifest, error) {
+	dir, err := u.dir(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &UploadManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("upload %s: %v", uploadID, err)
+	}
+	return manifest, nil
+}
+
+// Start starts an upload, or resumes it if it was started with the same
+// manifest. An upload started with another chunk size starts over.
+func (u *StorageUploader) Start(manifest *UploadManifest) error {
+	if err := manifest.validate(); err != nil {
+		return err
+	}
+	unlock, err := u.client.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return u.start(manifest)
+}
+
+// start is Start with the storage directory locked
+func (u *StorageUploader) start(manifest *UploadManifest) error {
+	dir, err := u.dir(manifest.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if stored, err := os.ReadFile(filepath.Join(dir, "manifest.json")); err == nil && bytes.Equal(stored, data) {
+		return nil
+	}
+
+	// The chunks of another manifest cannot be reused
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, "manifest.json"), data)
+}
+
+// Received returns the digests of the chunks stored for an upload
+func (u *StorageUploader) Received(uploadID string) (map[int][]byte, error) {
+	dir, err := u.dir(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return map[int][]byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	received := make(map[int][]byte)
+	for _, entry := range entries {
+		index, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".chunk"))
+		if err != nil || !strings.HasSuffix(entry.Name(), ".chunk") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		received[index] = sum[:]
+	}
+	return received, nil
+}
+
+// PutChunk checks a chunk against the manifest and stores it, starting the
+// upload first if needed
+func (u *StorageUploader) PutChunk(manifest *UploadManifest, index int, data []byte) error {
+	if err := manifest.validate(); err != nil {
+		return err
+	}
+	if err := manifest.VerifyChunk(index, data); err != nil {
+		return err
+	}
+	unlock, err := u.client.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := u.start(manifest); err != nil {
+		return err
+	}
+	dir, _ := u.dir(manifest.ID)
+	return writeFileAtomic(filepath.Join(dir, fmt.Sprintf("%d.chunk", index)), data)
+}
+
+// Complete assembles the chunks of an upload, checks the result against the
+// manifest and that it loads as a snapshot, and saves it in the storage
+// directory under the manifest's name. The chunks are then removed.
+func (u *StorageUploader) Complete(manifest *UploadManifest) error {
+	if err := manifest.validate(); err != nil {
+		return err
+	}
+	unlock, err := u.client.lockStorage()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dir, _ := u.dir(manifest.ID)
+	out, err := os.CreateTemp(u.client.storageDir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	whole := sha256.New()
+	for i := range manifest.Chunks {
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.chunk", i)))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: upload %s: chunk %d has not been received", ErrUploadMismatch, manifest.ID, i)
+		}
+		if err != nil {
+			return err
+		}
+		if err := manifest.VerifyChunk(i, data); err != nil {
+			return err
+		}
+		whole.Write(data)
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(whole.Sum(nil), manifest.Digest) {
+		return fmt.Errorf("%w: upload %s: digest of the assembled snapshot differs", ErrUploadMismatch, manifest.ID)
+	}
+
+	// Only snapshots that load are kept
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := decodeSnapshot(out, LoadAuto); err != nil {
+		return fmt.Errorf("%w: upload %s is not a valid snapshot: %v", ErrUploadMismatch, manifest.ID, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	target := filepath.Join(u.client.storageDir, manifest.Name)
+	if existing, err := fileSHA256(target); err == nil && !bytes.Equal(existing, manifest.Digest) {
+		return fmt.Errorf("upload %s: a different snapshot %s already exists", manifest.ID, manifest.Name)
+	}
+	if err := os.Chmod(out.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(out.Name(), target); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// fileSHA256 returns the SHA-256 of a file
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// writeFileAtomic writes a file through a temporary file in the same
+// directory, so a crash never leaves a partial file under the final name
+func writeFileAtomic(path string, data []byte) error {
+	out, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
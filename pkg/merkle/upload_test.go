@@ -0,0 +1,136 @@
+//go:build !readonly
+
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// countingUploader counts the chunks sent through it and fails every chunk
+// after the first failAfter, as a connection that drops would
+type countingUploader struct {
+	ChunkUploader
+	puts      int
+	failAfter int // no failures when negative
+}
+
+func (u *countingUploader) PutChunk(manifest *UploadManifest, index int, data []byte) error {
+	if u.failAfter >= 0 && u.puts >= u.failAfter {
+		return errors.New("connection reset")
+	}
+	u.puts++
+	return u.ChunkUploader.PutChunk(manifest, index, data)
+}
+
+// savedSnapshot saves a snapshot of a folder of files and returns its file
+func savedSnapshot(t *testing.T, files int) string {
+	t.Helper()
+	dir := t.TempDir()
+	contents := make(map[string]string)
+	for i := 0; i < files; i++ {
+		contents[fmt.Sprintf("dir%d/file%03d.txt", i%4, i)] = strings.Repeat("x", i)
+	}
+	writeFiles(t, dir, contents)
+
+	client := NewClient(t.TempDir())
+	state, err := client.CreateSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SaveSnapshot(state, dir); err != nil {
+		t.Fatal(err)
+	}
+	file, err := client.FindLatestSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestUploadResumesAfterInterruption(t *testing.T) {
+	file := savedSnapshot(t, 40)
+	serverDir := t.TempDir()
+	server := httptest.NewServer(&Server{StorageDir: serverDir})
+	defer server.Close()
+
+	const chunkSize = 256
+	manifest, err := NewUploadManifest(file, chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Chunks) < 4 {
+		t.Fatalf("snapshot has %d chunks, want at least 4", len(manifest.Chunks))
+	}
+
+	interrupted := &countingUploader{ChunkUploader: &HTTPUploader{URL: server.URL}, failAfter: 2}
+	if _, err := UploadSnapshot(file, interrupted, chunkSize); err == nil {
+		t.Fatal("the interrupted upload succeeded")
+	}
+	received, err := (&HTTPUploader{URL: server.URL}).Received(manifest.ID)
+	if err != nil || len(received) != 2 {
+		t.Fatalf("server holds %d chunks (%v), want 2", len(received), err)
+	}
+
+	// A new client, as after a restart, only sends the missing chunks
+	resumed := &countingUploader{ChunkUploader: &HTTPUploader{URL: server.URL}, failAfter: -1}
+	if _, err := UploadSnapshot(file, resumed, chunkSize); err != nil {
+		t.Fatalf("resumed upload: %v", err)
+	}
+	if want := len(manifest.Chunks) - 2; resumed.puts != want {
+		t.Errorf("resumed upload sent %d chunks, want %d", resumed.puts, want)
+	}
+
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded := filepath.Join(serverDir, filepath.Base(file))
+	if got, err := os.ReadFile(uploaded); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("uploaded snapshot differs from the original (%v)", err)
+	}
+	if _, err := NewClient(serverDir).LoadSnapshot(uploaded); err != nil {
+		t.Errorf("uploaded snapshot does not load: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(serverDir, uploadsDir, manifest.ID)); !os.IsNotExist(err) {
+		t.Errorf("chunks of the committed upload were left behind: %v", err)
+	}
+}
+
+func TestStorageUploaderRejectsBadUploads(t *testing.T) {
+	file := savedSnapshot(t, 10)
+	manifest, err := NewUploadManifest(file, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploader := NewStorageUploader(t.TempDir())
+
+	corrupt := append([]byte(nil), data[:128]...)
+	corrupt[0] ^= 1
+	if err := uploader.PutChunk(manifest, 0, corrupt); !errors.Is(err, ErrUploadMismatch) {
+		t.Errorf("corrupt chunk: err = %v, want ErrUploadMismatch", err)
+	}
+
+	escaping := *manifest
+	escaping.Name = "../" + manifest.Name
+	if err := uploader.Start(&escaping); !errors.Is(err, ErrUploadMismatch) {
+		t.Errorf("name outside the storage directory: err = %v, want ErrUploadMismatch", err)
+	}
+
+	if err := uploader.PutChunk(manifest, 0, data[:128]); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploader.Complete(manifest); !errors.Is(err, ErrUploadMismatch) {
+		t.Errorf("incomplete upload: err = %v, want ErrUploadMismatch", err)
+	}
+}
@@ -0,0 +1,124 @@
+//go:build !readonly
+
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HTTPUploader is a ChunkUploader that sends uploads to the /uploads
+// endpoints of serve. The manifest of an upload is sent before its first
+// chunk, so an interrupted upload resumes with the chunks the server holds.
+type HTTPUploader struct {
+	URL       string       // base URL of the server, e.g. "http://127.0.0.1:8080"
+	Token     string       // bearer token; empty when the server requires none
+	Namespace string       // namespace to upload into; the caller's own when empty
+	Client    *http.Client // http.DefaultClient when nil
+
+	started map[string]bool // uploads whose manifest the server has
+}
+
+// Received asks the server which chunks of an upload it holds
+func (u *HTTPUploader) Received(uploadID string) (map[int][]byte, error) {
+	var status jsonUploadStatus
+	if err := u.do(http.MethodGet, uploadID, "", nil, &status); err != nil {
+		return nil, err
+	}
+	received := make(map[int][]byte, len(status.Chunks))
+	for _, chunk := range status.Chunks {
+		digest, err := hex.DecodeString(chunk.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("upload %s: chunk %d: %v", uploadID, chunk.Index, err)
+		}
+		received[chunk.Index] = digest
+	}
+	return received, nil
+}
+
+// PutChunk sends one chunk, and the manifest before the first one
+func (u *HTTPUploader) PutChunk(manifest *UploadManifest, index int, data []byte) error {
+	if err := u.start(manifest); err != nil {
+		return err
+	}
+	return u.do(http.MethodPut, manifest.ID, "/chunks/"+strconv.Itoa(index), data, nil)
+}
+
+// Complete asks the server to assemble and save the snapshot
+func (u *HTTPUploader) Complete(manifest *UploadManifest) error {
+	if err := u.start(manifest); err != nil {
+		return err
+	}
+	return u.do(http.MethodPost, manifest.ID, "/commit", nil, nil)
+}
+
+// start sends the manifest of an upload once
+func (u *HTTPUploader) start(manifest *UploadManifest) error {
+	if u.started[manifest.ID] {
+		return nil
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := u.do(http.MethodPut, manifest.ID, "", data, nil); err != nil {
+		return err
+	}
+	if u.started == nil {
+		u.started = make(map[string]bool)
+	}
+	u.started[manifest.ID] = true
+	return nil
+}
+
+// do sends a request to /uploads/<id><suffix> and decodes the JSON response
+// into out, if not nil
+func (u *HTTPUploader) do(method, uploadID, suffix string, body []byte, out any) error {
+	endpoint := strings.TrimSuffix(u.URL, "/") + "/uploads/" + url.PathEscape(uploadID) + suffix
+	if u.Namespace != "" {
+		endpoint += "?namespace=" + url.QueryEscape(u.Namespace)
+	}
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if u.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.Token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if suffix == "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) != nil || failure.Error == "" {
+			failure.Error = resp.Status
+		}
+		return fmt.Errorf("%s %s: %s", method, req.URL.Path, failure.Error)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+//go:build !readonly
+
+package merkle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// upload serves the /uploads endpoints of a namespace:
+//
+//	GET  /uploads/<id>             the chunks the server holds, none if unknown
+//	PUT  /uploads/<id>             start or resume an upload with its manifest
+//	PUT  /uploads/<id>/chunks/<n>  store chunk n
+//	POST /uploads/<id>/commit      assemble and save the snapshot
+func (s *Server) upload(client *serverClient, r *http.Request) (int, any, error) {
+	uploader := NewStorageUploader(client.dir)
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/uploads/"), "/")
+	id := parts[0]
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		// An unknown upload has no chunks yet
+		received, err := uploader.Received(id)
+		if err != nil {
+			return uploadStatus(err), nil, err
+		}
+		return http.StatusOK, newJSONUploadStatus(id, received), nil
+
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		manifest := &UploadManifest{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxUploadChunkSize)).Decode(manifest); err != nil {
+			return http.StatusBadRequest, nil, fmt.Errorf("invalid manifest: %v", err)
+		}
+		if manifest.ID != id {
+			return http.StatusBadRequest, nil, fmt.Errorf("manifest of upload %s sent to upload %s", manifest.ID, id)
+		}
+		if err := uploader.Start(manifest); err != nil {
+			return uploadStatus(err), nil, err
+		}
+		received, err := uploader.Received(id)
+		if err != nil {
+			return http.StatusInternalServerError, nil, err
+		}
+		return http.StatusOK, newJSONUploadStatus(id, received), nil
+
+	case len(parts) == 3 && parts[1] == "chunks" && r.Method == http.MethodPut:
+		index, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return http.StatusBadRequest, nil, fmt.Errorf("invalid chunk index %q", parts[2])
+		}
+		manifest, err := uploader.Manifest(id)
+		if err != nil {
+			return uploadStatus(err), nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(r.Body, manifest.ChunkSize+1))
+		if err != nil {
+			return http.StatusBadRequest, nil, err
+		}
+		if err := uploader.PutChunk(manifest, index, data); err != nil {
+			return uploadStatus(err), nil, err
+		}
+		return http.StatusOK, newJSONUploadStatus(id, map[int][]byte{index: manifest.Chunks[index]}), nil
+
+	case len(parts) == 2 && parts[1] == "commit" && r.Method == http.MethodPost:
+		manifest, err := uploader.Manifest(id)
+		if err != nil {
+			return uploadStatus(err), nil, err
+		}
+		if err := uploader.Complete(manifest); err != nil {
+			return uploadStatus(err), nil, err
+		}
+		status := newJSONUploadStatus(id, nil)
+		status.Snapshot = snapshotStem(manifest.Name)
+		return http.StatusCreated, status, nil
+	}
+	return http.StatusNotFound, nil, fmt.Errorf("no such endpoint: %s %s", r.Method, r.URL.Path)
+}
+
+// uploadStatus is the HTTP status of an upload error
+func uploadStatus(err error) int {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUploadMismatch):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrReadOnly):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,276 @@
+package merkle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VerifyAllOptions controls a VerifyAll
+type VerifyAllOptions struct {
+	// Folders lists the folders to verify. When empty, every folder with a
+	// baseline is verified, at the path recorded in the folder index.
+	Folders []string
+
+	// Budget limits how many files the folders hash at the same time and
+	// serves their files in turn, as for the watches of several folders.
+	// Without one the folders share a budget of GOMAXPROCS workers.
+	Budget *WorkerBudget
+
+	// RecheckDelay, when positive, hashes the changed files of a failing
+	// folder again after this delay, as RecheckChanges does
+	RecheckDelay time.Duration
+
+	// OnDone is called as each folder is verified, from the goroutine that
+	// verified it, so progress can be shown while slower folders finish
+	OnDone func(FolderVerification)
+}
+
+// FolderVerification is the outcome of verifying one folder against its baseline
+type FolderVerification struct {
+	Folder   string        // path of the folder; its name when no path was recorded
+	Baseline string        // baseline snapshot the folder was compared with
+	Report   *ChangeReport // changes since the baseline; nil when Err is set
+	Run      RunSummary    // summary of the scan and comparison for RecordRun; zero when Err is set
+	Err      error         // why the folder could not be verified
+}
+
+// ExitCode returns 0 if the folder passed, ExitError if it could not be
+// verified and otherwise the exit code of its report
+func (v FolderVerification) ExitCode() int {
+	if v.Err != nil {
+		return ExitError
+	}
+	return v.Report.ExitCode()
+}
+
+// Status returns "passed", "failed" or, when the folder could not be
+// verified, "error"
+func (v FolderVerification) Status() string {
+	switch {
+	case v.Err != nil:
+		return "error"
+	case v.Report.ExitCode() > 0:
+		return "failed"
+	}
+	return "passed"
+}
+
+// VerificationReport is the consolidated outcome of a VerifyAll
+type VerificationReport struct {
+	Timestamp time.Time            // when the verification started
+	Duration  time.Duration        // time until the last folder was verified
+	Folders   []FolderVerification // sorted by folder
+}
+
+// Passed returns the number of folders that passed
+func (r *VerificationReport) Passed() int {
+	passed := 0
+	for _, folder := range r.Folders {
+		if folder.ExitCode() == 0 {
+			passed++
+		}
+	}
+	return passed
+}
+
+// ExitCode returns the highest exit code of the folders, so a single failing
+// folder fails the whole verification, and one that could not be verified
+// outranks any change
+func (r *VerificationReport) ExitCode() int {
+	code := 0
+	for _, folder := range r.Folders {
+		code = max(code, folder.ExitCode())
+	}
+	return code
+}
+
+// VerifyAll verifies several folders against their baselines at the same
+// time, each as verify does: every file is hashed again, with no stat cache,
+// acknowledged changes do not fail a folder and nothing is saved. A folder
+// that cannot be verified, because it has no baseline or is gone, fails on
+// its own without stopping the others. Cancelling ctx stops the scans in
+// progress.
+func (c *MerkleClient) VerifyAll(ctx context.Context, opts VerifyAllOptions) (*VerificationReport, error) {
+	baselines, err := c.readBaselines()
+	if err != nil {
+		return nil, err
+	}
+
+	folders := opts.Folders
+	var unrecorded []FolderVerification
+	if len(folders) == 0 {
+		index, err := c.readFolderIndex()
+		if err != nil {
+			return nil, err
+		}
+		for name, baseline := range baselines {
+			if path := index[name]; path != "" {
+				folders = append(folders, path)
+				continue
+			}
+			unrecorded = append(unrecorded, FolderVerification{
+				Folder:   name,
+				Baseline: baseline.Snapshot,
+				Err:      fmt.Errorf("the path of %s is not recorded in %s", name, folderIndexFile),
+			})
+		}
+	}
+	budget := opts.Budget
+	if budget == nil {
+		budget = NewWorkerBudget(0)
+	}
+
+	report := &VerificationReport{Timestamp: time.Now(), Folders: make([]FolderVerification, len(folders))}
+	var verifying sync.WaitGroup
+	for i, folderPath := range folders {
+		i, folderPath := i, folderPath
+		verifying.Add(1)
+		go func() {
+			defer verifying.Done()
+			scan := c.scan
+			scan.budget = budget
+			scan.budgetQueue = folderPath
+			scan.ctx = ctx
+			report.Folders[i] = c.verifyFolder(folderPath, baselines, scan, opts.RecheckDelay)
+			if opts.OnDone != nil {
+				opts.OnDone(report.Folders[i])
+			}
+		}()
+	}
+	verifying.Wait()
+	report.Duration = time.Since(report.Timestamp)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	report.Folders = append(report.Folders, unrecorded...)
+	sort.Slice(report.Folders, func(i, j int) bool {
+		return report.Folders[i].Folder < report.Folders[j].Folder
+	})
+	return report, nil
+}
+
+// verifyFolder compares a fresh scan of a folder with its baseline
+func (c *MerkleClient) verifyFolder(folderPath string, baselines map[string]Baseline, scan scanOptions, recheckDelay time.Duration) FolderVerification {
+	started := time.Now()
+	verification := FolderVerification{Folder: folderPath}
+
+	baseline, ok := baselines[filepath.Base(folderPath)]
+	if !ok {
+		verification.Err = fmt.Errorf("no baseline has been promoted for %s", filepath.Base(folderPath))
+		return verification
+	}
+	verification.Baseline = baseline.Snapshot
+
+	previous, err := c.LoadSnapshot(baseline.Snapshot)
+	if err != nil {
+		verification.Err = fmt.Errorf("loading baseline: %w", err)
+		return verification
+	}
+	tree, err := c.buildTree(folderPath, scan)
+	if err != nil {
+		verification.Err = err
+		return verification
+	}
+	current := c.stateFromTree(tree)
+	report, err := c.CompareSnapshots(previous, current)
+	if err != nil {
+		verification.Err = err
+		return verification
+	}
+
+	// Drift that was reviewed as expected does not fail a verification
+	if err := c.MarkAcknowledged(folderPath, report); err != nil {
+		verification.Err = fmt.Errorf("reading acknowledged changes: %w", err)
+		return verification
+	}
+	if report.ExitCode() > 0 && recheckDelay > 0 {
+		if err := c.RecheckChanges(folderPath, report, recheckDelay); err != nil {
+			verification.Err = fmt.Errorf("re-hashing changed files: %w", err)
+			return verification
+		}
+	}
+
+	verification.Report = report
+	verification.Run = NewRunSummary(folderPath, "verify", current, report, time.Since(started))
+	return verification
+}
+
+// WriteVerificationReport writes a consolidated verification as text: one
+// line per folder with its outcome, then the totals
+func WriteVerificationReport(w io.Writer, report *VerificationReport) {
+	fmt.Fprintf(w, "=== Verification: %d folder(s) ===\n", len(report.Folders))
+	for _, folder := range report.Folders {
+		fmt.Fprintf(w, "  %-6s %s", strings.ToUpper(folder.Status()), folder.Folder)
+		switch {
+		case folder.Err != nil:
+			fmt.Fprintf(w, ": %v\n", folder.Err)
+		case len(folder.Report.Errors) > 0:
+			fmt.Fprintf(w, ": %d file(s) could not be hashed, %d change(s), %d files in %s\n",
+				len(folder.Report.Errors), len(folder.Report.Changes), folder.Run.Files, folder.Run.Duration.Round(time.Millisecond))
+		case len(folder.Report.Changes) > 0:
+			fmt.Fprintf(w, ": %d change(s), highest severity %s, %d files in %s\n",
+				len(folder.Report.Changes), folder.Report.MaxSeverity(), folder.Run.Files, folder.Run.Duration.Round(time.Millisecond))
+		default:
+			fmt.Fprintf(w, ": %d files in %s\n", folder.Run.Files, folder.Run.Duration.Round(time.Millisecond))
+		}
+	}
+	fmt.Fprintf(w, "%d of %d folder(s) passed in %s\n", report.Passed(), len(report.Folders), report.Duration.Round(time.Millisecond))
+}
+
+// jsonVerification is the JSON form of a consolidated verification
+type jsonVerification struct {
+	Timestamp  time.Time                `json:"timestamp"`
+	DurationMS int64                    `json:"duration_ms"`
+	Passed     bool                     `json:"passed"`
+	Folders    []jsonFolderVerification `json:"folders"`
+}
+
+// jsonFolderVerification is the JSON form of the verification of one folder
+type jsonFolderVerification struct {
+	Folder     string      `json:"folder"`
+	Status     string      `json:"status" enum:"verification"`
+	Baseline   string      `json:"baseline,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	DurationMS int64       `json:"duration_ms"`
+	Files      int         `json:"files"`
+	Report     *jsonReport `json:"report,omitempty"`
+}
+
+// WriteJSONVerification writes a consolidated verification as indented JSON,
+// with the full change report of every folder that could be verified
+func WriteJSONVerification(w io.Writer, report *VerificationReport) error {
+	out := jsonVerification{
+		Timestamp:  report.Timestamp,
+		DurationMS: report.Duration.Milliseconds(),
+		Passed:     report.ExitCode() == 0,
+		Folders:    make([]jsonFolderVerification, 0, len(report.Folders)),
+	}
+	for _, folder := range report.Folders {
+		entry := jsonFolderVerification{
+			Folder:     folder.Folder,
+			Status:     folder.Status(),
+			Baseline:   folder.Baseline,
+			DurationMS: folder.Run.Duration.Milliseconds(),
+			Files:      folder.Run.Files,
+		}
+		if folder.Err != nil {
+			entry.Error = folder.Err.Error()
+		} else {
+			changes := newJSONReport(folder.Report)
+			entry.Report = &changes
+		}
+		out.Folders = append(out.Folders, entry)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
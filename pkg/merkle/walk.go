@@ -0,0 +1,91 @@
+package merkle
+
+import "errors"
+
+// SkipSubtree can be returned by a Walk callback to skip the children of the
+// node it was called with, like filepath.SkipDir
+var SkipSubtree = errors.New("skip this subtree")
+
+// Walk calls fn for every node of the tree in pre-order, starting with the root
+// at depth 0. In trees before version 3, the last node of an odd level is
+// paired with itself; it is visited once. Walk stops at the first error fn returns, other than
+// SkipSubtree, and returns it.
+func (t *MerkleTree) Walk(fn func(node *MerkleNode, depth int) error) error {
+	if t == nil || t.Root == nil {
+		return nil
+	}
+
+	type entry struct {
+		node  *MerkleNode
+		depth int
+	}
+	stack := []entry{{t.Root, 0}}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		err := fn(e.node, e.depth)
+		if err == SkipSubtree {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if e.node.IsLeaf {
+			continue
+		}
+		if e.node.Right != nil && e.node.Right != e.node.Left {
+			stack = append(stack, entry{e.node.Right, e.depth + 1})
+		}
+		if e.node.Left != nil {
+			stack = append(stack, entry{e.node.Left, e.depth + 1})
+		}
+	}
+	return nil
+}
+
+// LeafIterator steps through the leaves of a tree in path order:
+//
+//	for it := tree.Leaves(); it.Next(); {
+//		fmt.Println(it.Node().FileName)
+//	}
+type LeafIterator struct {
+	stack []*MerkleNode
+	node  *MerkleNode
+}
+
+// Leaves returns an iterator over the leaves of the tree, each visited once
+func (t *MerkleTree) Leaves() *LeafIterator {
+	it := &LeafIterator{}
+	if t != nil && t.Root != nil {
+		it.stack = []*MerkleNode{t.Root}
+	}
+	return it
+}
+
+// Next advances to the next leaf and reports whether there is one
+func (it *LeafIterator) Next() bool {
+	for len(it.stack) > 0 {
+		node := it.stack[len(it.stack)-1]
+		it.stack = it.stack[:len(it.stack)-1]
+
+		if node.IsLeaf {
+			it.node = node
+			return true
+		}
+		if node.Right != nil && node.Right != node.Left {
+			it.stack = append(it.stack, node.Right)
+		}
+		if node.Left != nil {
+			it.stack = append(it.stack, node.Left)
+		}
+	}
+	it.node = nil
+	return false
+}
+
+// Node returns the current leaf
+func (it *LeafIterator) Node() *MerkleNode {
+	return it.node
+}
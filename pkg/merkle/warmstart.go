@@ -0,0 +1,132 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// statCacheDir holds, per folder, the stat of every file of the latest
+// snapshot a watch or an incremental scan saved. Snapshots record no file
+// times, so this is what lets a restarted watch or the next incremental scan
+// tell which files need hashing again.
+const statCacheDir = "statcache"
+
+// statCache is the on-disk form of a leaf cache, tied to one snapshot
+type statCache struct {
+	Settings string                `json:"settings"`  // scan settings the leaves were hashed with
+	RootHash string                `json:"root_hash"` // root hash of the snapshot the leaves belong to
+	Files    map[string]statRecord `json:"files"`
+}
+
+// statRecord is the stored form of a statSignature
+type statRecord struct {
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mtime"`
+	ChangeTime time.Time `json:"ctime"`
+	Inode      uint64    `json:"inode"`
+}
+
+// cacheSettings describes everything besides file content that goes into a
+// leaf, so cached leaves are only reused by scans that would produce the same
+func (opts scanOptions) cacheSettings() string {
+	settings := fmt.Sprintf("%s;digests=%s;chunks=%d", opts.scheme(), strings.Join(opts.extraDigests, ","), opts.chunkSize)
+	if len(opts.nested) > 0 {
+		// Files of nested folders left out of the cache must not be reported
+		// as deleted once they are, nor the other way around
+		settings += ";nested=" + strings.Join(opts.nested, ",")
+	}
+	return settings
+}
+
+// statCacheFile returns where the stat cache of a folder is kept
+func (c *MerkleClient) statCacheFile(folderPath string) string {
+	return filepath.Join(c.storageDir, statCacheDir, filepath.Base(folderPath)+".json")
+}
+
+// saveStatCache records the stat of the leaves of the last scan, which
+// produced the snapshot state that was just saved
+func (c *MerkleClient) saveStatCache(folderPath string, state *TreeState, opts scanOptions) error {
+	cache := statCache{
+		Settings: opts.cacheSettings(),
+		RootHash: hex.EncodeToString(state.RootHash),
+		Files:    make(map[string]statRecord, len(opts.cache.previous)),
+	}
+	for relPath, cached := range opts.cache.previous {
+		cache.Files[relPath] = statRecord{
+			Size:       cached.stat.size,
+			ModTime:    cached.stat.modTime,
+			ChangeTime: cached.stat.ctime,
+			Inode:      cached.stat.inode,
+		}
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	file := c.statCacheFile(folderPath)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	// Replace the file atomically so a crash never leaves a truncated cache
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// warmStart loads the latest snapshot of a folder and fills the leaf cache
+// from it and the folder's stat cache. It returns nil, and the scan starts
+// cold, when there is no snapshot, or the stat cache is missing, belongs to
+// another snapshot or was written with other scan settings.
+func (c *MerkleClient) warmStart(folderPath string, opts scanOptions) *TreeState {
+	latest, err := c.FindLatestSnapshot(folderPath)
+	if err != nil {
+		return nil
+	}
+	state, err := c.LoadSnapshot(latest)
+	if err != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.statCacheFile(folderPath))
+	if err != nil {
+		return nil
+	}
+	var cache statCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil
+	}
+	if cache.Settings != opts.cacheSettings() || cache.RootHash != hex.EncodeToString(state.RootHash) {
+		return nil
+	}
+
+	for relPath, record := range cache.Files {
+		hash, ok := state.FileHashes[relPath]
+		if !ok || state.Unstable[relPath] {
+			continue
+		}
+		node := MerkleNode{
+			Hash:     hash,
+			IsLeaf:   true,
+			FileName: relPath,
+			Size:     state.FileSizes[relPath],
+			Digests:  state.Digests[relPath],
+			Chunks:   state.Chunks[relPath],
+			Content:  state.Content[relPath],
+		}
+		opts.cache.keep(relPath, cachedLeaf{
+			stat: statSignature{size: record.Size, modTime: record.ModTime, ctime: record.ChangeTime, inode: record.Inode},
+			node: node,
+		})
+	}
+	opts.cache.commit()
+	return state
+}
@@ -0,0 +1,308 @@
+package merkle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is how often a polling Watch looks for changes when no
+// interval is given
+const DefaultWatchInterval = 2 * time.Second
+
+// watchSettle is how long a watch waits after a change notification before it
+// looks at the folder, so a burst of writes is handled by one scan
+const watchSettle = 100 * time.Millisecond
+
+// WatchOptions controls a Watch
+type WatchOptions struct {
+	// Interval is the time between two looks at the folder when polling
+	Interval time.Duration
+
+	// Poll looks at the folder every Interval instead of when the OS reports
+	// a change, for network shares and other file systems that do not
+	// deliver change notifications. A watch also polls when the folder cannot
+	// be registered for notifications.
+	Poll bool
+
+	// Save stores a snapshot of the folder when the watch starts and whenever
+	// changes are found, so history and verify see what the watcher saw. The
+	// stat of every file is stored alongside for WarmStart, and a summary of
+	// every scan that found changes in the run log.
+	Save bool
+
+	// WarmStart resumes from the latest snapshot of the folder instead of
+	// scanning it in full, if a watch that saved it left the stat of its files
+	// behind. Only files whose stat changed since are hashed, and the first
+	// report, sent right away, holds the changes made while nothing watched.
+	WarmStart bool
+
+	// Budget, when shared by the watches of several folders, limits how many
+	// files they hash at the same time and serves their files in turn, so a
+	// large folder cannot starve the scans of small ones. Each watch still
+	// scans its folder on its own schedule; a scan that outlasts the interval
+	// only delays the next scan of the same folder. Without a budget the
+	// scans of a watch use the client's own hash workers.
+	Budget *WorkerBudget
+
+	// Priority of the folder: when polling, a high priority folder is
+	// scanned four times as often as Interval and a low priority one a
+	// quarter as often. With a Budget, files of higher priority folders are
+	// hashed first.
+	Priority Priority
+
+	// Nested lists folders inside this one that are watched on their own,
+	// relative to it, as NestedFolders returns them. The watch leaves them
+	// out, so their files are hashed once and each change is reported by the
+	// watch of the most specific folder.
+	Nested []string
+
+	// OnError is called when a scan or save fails, and when the watch falls
+	// back to polling; the watch goes on and tries again at the next change or
+	// interval. Errors are dropped when nil.
+	OnError func(error)
+}
+
+// Watch monitors a folder and sends a change report whenever files change,
+// until ctx is cancelled, which also stops a scan in progress, after which
+// the channel is closed. The folder is scanned in full once, unless WarmStart
+// finds the leaves of the previous watch.
+//
+// Every directory of the folder is registered for the change notifications
+// of the OS (inotify, kqueue, FSEvents or ReadDirectoryChangesW through
+// fsnotify), and directories created later as they appear. A notification
+// starts a look at the folder, after a short pause that gathers the events
+// of a burst of writes. Each look only stats every file and re-hashes those
+// whose size, modification time, change time or inode differ, reusing the
+// leaves of all others to rebuild the tree. When notifications are
+// unavailable, or with Poll, the watch looks every Interval instead.
+//
+// File sets and container images cannot be watched, and filesystem snapshot
+// providers are not used: the watcher looks at the live folder.
+func (c *MerkleClient) Watch(ctx context.Context, folderPath string, opts WatchOptions) (<-chan ChangeReport, error) {
+	if IsSetPath(folderPath) || IsImagePath(folderPath) {
+		return nil, fmt.Errorf("cannot watch %s: only folders and files can be watched", folderPath)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultWatchInterval
+	}
+	onError := opts.OnError
+	if onError == nil {
+		onError = func(error) {}
+	}
+
+	scan := c.scan
+	scan.cache = &leafCache{}
+	scan.budget = opts.Budget
+	scan.budgetQueue = folderPath
+	scan.priority = opts.Priority
+	scan.nested = opts.Nested
+	scan.ctx = ctx
+
+	// Registering before the first scan means no change after it is missed
+	var events *folderEvents
+	if !opts.Poll {
+		var err error
+		if events, err = watchFolderEvents(folderPath, opts.Nested); err != nil {
+			onError(fmt.Errorf("change notifications unavailable for %s, polling every %s: %w",
+				folderPath, opts.Priority.interval(opts.Interval), err))
+		}
+	}
+
+	var previous *TreeState
+	if opts.WarmStart {
+		previous = c.warmStart(folderPath, scan)
+	}
+	warm := previous != nil
+	if !warm {
+		tree, err := createMerkleTreeFromFolder(folderPath, scan)
+		if err == nil {
+			previous = c.stateFromTree(tree)
+			if opts.Save {
+				err = c.saveWatchScan(previous, folderPath, scan)
+			}
+		}
+		if err != nil {
+			if events != nil {
+				events.close()
+			}
+			return nil, err
+		}
+	}
+
+	reports := make(chan ChangeReport)
+	go func() {
+		defer close(reports)
+		var changed <-chan struct{}
+		var tick <-chan time.Time
+		if events != nil {
+			defer events.close()
+			changed = events.changed
+		} else {
+			ticker := time.NewTicker(opts.Priority.interval(opts.Interval))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		// A warm start looks for changes made while nothing watched right away
+		for first := warm; ; first = false {
+			if !first {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tick:
+				case <-changed:
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(watchSettle):
+					}
+					// Events of the pause are covered by this look
+					select {
+					case <-changed:
+					default:
+					}
+				}
+			}
+
+			started := time.Now()
+			tree, err := createMerkleTreeFromFolder(folderPath, scan)
+			if err != nil {
+				if ctx.Err() != nil {
+					// Cancelled in the middle of the scan
+					return
+				}
+				onError(err)
+				continue
+			}
+			current := c.stateFromTree(tree)
+			report, err := c.CompareSnapshots(previous, current)
+			if err != nil {
+				onError(err)
+				continue
+			}
+			if len(report.Changes) == 0 {
+				continue
+			}
+
+			// Snapshot names have one-second resolution, so a save may replace
+			// the previous one when changes follow each other closely
+			if opts.Save {
+				if err := c.saveWatchScan(current, folderPath, scan); err != nil {
+					onError(err)
+				}
+				if err := c.RecordRun(NewRunSummary(folderPath, "watch", current, report, time.Since(started))); err != nil {
+					onError(err)
+				}
+			}
+			previous = current
+
+			select {
+			case reports <- *report:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return reports, nil
+}
+
+// saveWatchScan stores the snapshot of a watch scan along with the stat of its files
+func (c *MerkleClient) saveWatchScan(state *TreeState, folderPath string, scan scanOptions) error {
+	if err := c.SaveSnapshot(state, folderPath); err != nil {
+		return err
+	}
+	return c.saveStatCache(folderPath, state, scan)
+}
+
+// leafCache keeps the leaves of the previous scan with the stat they were
+// hashed at. A scan looks leaves up in the previous generation and stores them
+// in the next one, which replaces it once the scan succeeds, so files that
+// disappeared drop out of the cache.
+type leafCache struct {
+	previous map[string]cachedLeaf
+	next     map[string]cachedLeaf
+
+	mu sync.Mutex // guards next, which hash workers add to during the walk
+}
+
+// cachedLeaf is a leaf and the stat of the file when it was hashed
+type cachedLeaf struct {
+	stat statSignature
+	node MerkleNode
+}
+
+// statSignature holds the parts of a file's stat that change when its content
+// may have changed
+type statSignature struct {
+	size    int64
+	modTime time.Time
+	ctime   time.Time // zero where the platform has no change time
+	inode   uint64
+}
+
+// signatureOf returns the stat signature of a file
+func signatureOf(info os.FileInfo) statSignature {
+	meta := FileMetadata{}
+	fillSysMetadata(info, &meta)
+	return statSignature{size: info.Size(), modTime: info.ModTime(), ctime: meta.ChangeTime, inode: meta.Inode}
+}
+
+// equal reports whether two signatures describe the same stat
+func (s statSignature) equal(other statSignature) bool {
+	return s.size == other.size && s.inode == other.inode &&
+		s.modTime.Equal(other.modTime) && s.ctime.Equal(other.ctime)
+}
+
+// lookup returns a copy of the cached leaf of a file whose stat is unchanged,
+// with its attributes read again, or nil. It is a no-op on a nil cache.
+func (lc *leafCache) lookup(relPath string, info os.FileInfo) *MerkleNode {
+	if lc == nil {
+		return nil
+	}
+	cached, ok := lc.previous[relPath]
+	if !ok || !cached.stat.equal(signatureOf(info)) {
+		return nil
+	}
+
+	node := cached.node
+	node.Attributes, node.HasAttributes = fileAttributesOf(info)
+	lc.keep(relPath, cached)
+	return &node
+}
+
+// store remembers a freshly hashed leaf for the next scan
+func (lc *leafCache) store(relPath string, info os.FileInfo, node *MerkleNode) {
+	if lc == nil {
+		return
+	}
+	lc.keep(relPath, cachedLeaf{stat: signatureOf(info), node: *node})
+}
+
+// keep adds a leaf to the generation being built
+func (lc *leafCache) keep(relPath string, cached cachedLeaf) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.next == nil {
+		lc.next = make(map[string]cachedLeaf)
+	}
+	lc.next[relPath] = cached
+}
+
+// commit makes the generation built by a successful scan the one to look up
+func (lc *leafCache) commit() {
+	if lc == nil {
+		return
+	}
+	lc.previous, lc.next = lc.next, nil
+}
+
+// discard drops the generation of a failed scan
+func (lc *leafCache) discard() {
+	if lc == nil {
+		return
+	}
+	lc.next = nil
+}
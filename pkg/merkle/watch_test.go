@@ -0,0 +1,93 @@
+package merkle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// nextReport waits for a report of a watch, failing the test after timeout
+func nextReport(t *testing.T, reports <-chan ChangeReport, timeout time.Duration) ChangeReport {
+	t.Helper()
+	select {
+	case report, ok := <-reports:
+		if !ok {
+			t.Fatal("the watch stopped")
+		}
+		return report
+	case <-time.After(timeout):
+		t.Fatalf("no report within %s", timeout)
+		return ChangeReport{}
+	}
+}
+
+// hasChange reports whether a report holds a change of a file
+func hasChange(report ChangeReport, fileName string, changeType ChangeType) bool {
+	for _, change := range report.Changes {
+		if change.FileName == fileName && change.ChangeType == changeType {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWatchReportsOnNotification(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// An interval this long means every report came from a notification
+	var errs []error
+	reports, err := NewClient(t.TempDir()).Watch(ctx, dir, WatchOptions{
+		Interval: time.Hour,
+		OnError:  func(err error) { errs = append(errs, err) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, dir, map[string]string{"sub/b.txt": "b2"})
+	if report := nextReport(t, reports, 5*time.Second); !hasChange(report, "sub/b.txt", Modified) {
+		t.Errorf("changes = %v, want sub/b.txt modified", report.Changes)
+	}
+
+	// Directories created after the watch started are registered as well
+	writeFiles(t, dir, map[string]string{"new/deeper/c.txt": "c"})
+	if report := nextReport(t, reports, 5*time.Second); !hasChange(report, "new/deeper/c.txt", Added) {
+		t.Errorf("changes = %v, want new/deeper/c.txt added", report.Changes)
+	}
+	writeFiles(t, dir, map[string]string{"new/deeper/c.txt": "c2"})
+	if report := nextReport(t, reports, 5*time.Second); !hasChange(report, "new/deeper/c.txt", Modified) {
+		t.Errorf("changes = %v, want new/deeper/c.txt modified", report.Changes)
+	}
+
+	cancel()
+	for range reports {
+	}
+	if len(errs) > 0 {
+		t.Errorf("errors: %v", errs)
+	}
+}
+
+func TestWatchPolls(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reports, err := NewClient(t.TempDir()).Watch(ctx, dir, WatchOptions{Interval: 20 * time.Millisecond, Poll: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{"b.txt": "b"})
+	deadline := time.Now().Add(5 * time.Second)
+	for seen := (ChangeReport{}); !hasChange(seen, "b.txt", Added); {
+		seen = nextReport(t, reports, time.Until(deadline))
+	}
+}
@@ -0,0 +1,113 @@
+package merkle
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// folderEvents turns the change notifications of the OS for every directory
+// of a folder into a signal that the folder needs another look. Directories
+// created later are registered as they appear, so the whole tree stays
+// covered without a recursive watch, which most platforms lack.
+type folderEvents struct {
+	watcher *fsnotify.Watcher
+	root    string
+	file    string   // set when a single file is watched, through its directory
+	nested  []string // folders inside root that are watched on their own
+
+	// changed holds at most one pending signal: any number of events before
+	// the next look is one look
+	changed chan struct{}
+}
+
+// watchFolderEvents registers a folder, or the directory of a file, for
+// change notifications. It fails when the platform or file system does not
+// deliver them or the folder has more directories than may be registered.
+func watchFolderEvents(folderPath string, nested []string) (*folderEvents, error) {
+	info, err := os.Stat(folderPath)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	events := &folderEvents{watcher: watcher, root: folderPath, nested: nested, changed: make(chan struct{}, 1)}
+	if info.IsDir() {
+		err = events.addTree(folderPath)
+	} else {
+		events.file = folderPath
+		err = watcher.Add(filepath.Dir(folderPath))
+	}
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go events.forward()
+	return events, nil
+}
+
+// addTree registers a directory and every directory below it
+func (e *folderEvents) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories that vanish or cannot be read are left to the scans
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != e.root && isNested(canonicalPath(e.root, path), e.nested) {
+			return filepath.SkipDir
+		}
+		return e.watcher.Add(path)
+	})
+}
+
+// forward signals every relevant event until the watcher is closed
+func (e *folderEvents) forward() {
+	for {
+		select {
+		case event, ok := <-e.watcher.Events:
+			if !ok {
+				return
+			}
+			if e.file != "" && filepath.Clean(event.Name) != filepath.Clean(e.file) {
+				continue
+			}
+			if event.Has(fsnotify.Create) && e.file == "" {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					// Files may already be inside; the look that follows finds them
+					e.addTree(event.Name)
+				}
+			}
+			e.signal()
+		case _, ok := <-e.watcher.Errors:
+			if !ok {
+				return
+			}
+			// Events may have been dropped, such as on a queue overflow
+			e.signal()
+		}
+	}
+}
+
+// signal asks for another look unless one is already pending
+func (e *folderEvents) signal() {
+	select {
+	case e.changed <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the notifications
+func (e *folderEvents) close() error {
+	return e.watcher.Close()
+}
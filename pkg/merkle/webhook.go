@@ -0,0 +1,303 @@
+//go:build !readonly
+
+package merkle
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// WebhookAction is what a webhook does with the folder it scans
+type WebhookAction int
+
+const (
+	// WebhookSnapshot saves a snapshot and reports the changes since the
+	// previous one
+	WebhookSnapshot WebhookAction = iota
+	// WebhookVerify compares the folder with its baseline and saves nothing,
+	// for post-deploy verification
+	WebhookVerify
+)
+
+// String returns the name of the action as written in webhook files
+func (a WebhookAction) String() string {
+	switch a {
+	case WebhookSnapshot:
+		return "snapshot"
+	case WebhookVerify:
+		return "verify"
+	}
+	return fmt.Sprintf("WebhookAction(%d)", int(a))
+}
+
+// ParseWebhookAction parses "snapshot" or "verify"
+func ParseWebhookAction(name string) (WebhookAction, error) {
+	switch name {
+	case "snapshot":
+		return WebhookSnapshot, nil
+	case "verify":
+		return WebhookVerify, nil
+	}
+	return WebhookSnapshot, fmt.Errorf("unknown webhook action: %q (want snapshot or verify)", name)
+}
+
+// Webhook scans a configured folder when a deployment system, or anything
+// else holding its secret, posts to /hooks/<name> of a Server. The request
+// proves it knows the secret with an "X-Hub-Signature-256: sha256=<hex>"
+// header holding the HMAC-SHA256 of its body, as GitHub and many CI systems
+// send it, or by sending the secret itself as "X-Webhook-Token".
+type Webhook struct {
+	Name      string
+	Folder    string
+	Secret    string
+	Action    WebhookAction
+	Namespace string // storage namespace of the snapshots; empty for the storage directory
+}
+
+// WebhookResult is the outcome of a webhook call, passed to Server.OnWebhook
+type WebhookResult struct {
+	Hook     Webhook
+	Snapshot string        // the snapshot saved, if any
+	Report   *ChangeReport // the changes found; nil when there was nothing to compare with
+	Err      error
+}
+
+// maxWebhookBody limits how much of a webhook request is read to check its
+// signature; deployment payloads are far smaller
+const maxWebhookBody = 1 << 20
+
+// validWebhookName matches names that can be used in the hook's URL path
+var validWebhookName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// LoadWebhookFile reads a CSV webhook file with rows of
+// "name,folder,secret[,action[,namespace]]", where action is snapshot (the
+// default) or verify; lines starting with # are ignored
+func LoadWebhookFile(filename string) ([]Webhook, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+
+	var hooks []Webhook
+	seen := make(map[string]bool)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) < 3 || len(row) > 5 {
+			return nil, fmt.Errorf("invalid webhook file row: expected name,folder,secret[,action[,namespace]]")
+		}
+
+		hook := Webhook{Name: row[0], Folder: row[1], Secret: row[2]}
+		if !validWebhookName.MatchString(hook.Name) {
+			return nil, fmt.Errorf("invalid webhook name: %q", hook.Name)
+		}
+		if seen[hook.Name] {
+			return nil, fmt.Errorf("duplicate webhook: %q", hook.Name)
+		}
+		if hook.Folder == "" || hook.Secret == "" {
+			return nil, fmt.Errorf("webhook %s needs a folder and a secret", hook.Name)
+		}
+		if len(row) > 3 && row[3] != "" {
+			if hook.Action, err = ParseWebhookAction(row[3]); err != nil {
+				return nil, err
+			}
+		}
+		if len(row) > 4 && row[4] != "" {
+			if !ValidNamespace(row[4]) {
+				return nil, fmt.Errorf("invalid namespace in webhook file: %q", row[4])
+			}
+			hook.Namespace = row[4]
+		}
+		seen[hook.Name] = true
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, nil
+}
+
+// authenticate reports whether a request to the webhook proves it knows the
+// secret; body is the request body
+func (h Webhook) authenticate(r *http.Request, body []byte) bool {
+	if token := r.Header.Get("X-Webhook-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(h.Secret)) == 1
+	}
+	for _, header := range []string{"X-Hub-Signature-256", "X-Signature-256"} {
+		signature, ok := strings.CutPrefix(r.Header.Get(header), "sha256=")
+		if !ok {
+			continue
+		}
+		got, err := hex.DecodeString(signature)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+	return false
+}
+
+// serveWebhook handles a call of a webhook
+func (s *Server) serveWebhook(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/hooks/")
+	var hook *Webhook
+	for i := range s.Webhooks {
+		if s.Webhooks[i].Name == name {
+			hook = &s.Webhooks[i]
+			break
+		}
+	}
+	if hook == nil {
+		writeServerError(w, http.StatusNotFound, fmt.Errorf("no such webhook: %s", name))
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeServerError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
+	if err != nil {
+		writeServerError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body) > maxWebhookBody {
+		writeServerError(w, http.StatusRequestEntityTooLarge, errors.New("request body too large"))
+		return
+	}
+	if !hook.authenticate(r, body) {
+		writeServerError(w, http.StatusUnauthorized, errors.New("missing or invalid webhook signature"))
+		return
+	}
+
+	client, err := s.client(hook.Namespace)
+	if err != nil {
+		writeServerError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// A deployment system that gives up waiting does not cancel the scan, so
+	// its outcome still reaches OnWebhook
+	result := runWebhook(context.WithoutCancel(r.Context()), client, *hook)
+	if s.OnWebhook != nil {
+		s.OnWebhook(result)
+	}
+	if result.Err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(result.Err, errNoBaseline) {
+			status = http.StatusConflict
+		}
+		writeServerError(w, status, result.Err)
+		return
+	}
+	writeServerJSON(w, http.StatusOK, newJSONWebhookResult(result))
+}
+
+// errNoBaseline fails verify webhooks of folders without a baseline
+var errNoBaseline = errors.New("no baseline has been promoted")
+
+// runWebhook scans the folder of a webhook and compares it with its baseline,
+// or saves it and compares it with the previous snapshot
+func runWebhook(ctx context.Context, client *serverClient, hook Webhook) WebhookResult {
+	result := WebhookResult{Hook: hook}
+
+	var previousFile string
+	if hook.Action == WebhookVerify {
+		baseline, err := client.Baseline(hook.Folder)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		if baseline == nil {
+			result.Err = fmt.Errorf("%w for %s", errNoBaseline, hook.Folder)
+			return result
+		}
+		previousFile = baseline.Snapshot
+	} else if latest, err := client.FindLatestSnapshot(hook.Folder); err == nil {
+		previousFile = latest
+	}
+
+	current, err := client.CreateSnapshotContext(ctx, hook.Folder)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if previousFile != "" {
+		previous, err := client.LoadSnapshot(previousFile)
+		if err == nil {
+			result.Report, err = client.CompareSnapshots(previous, current)
+		}
+		if err == nil {
+			err = client.MarkAcknowledged(hook.Folder, result.Report)
+		}
+		if err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	if hook.Action == WebhookSnapshot {
+		if err := client.SaveSnapshot(current, hook.Folder); err != nil {
+			result.Err = err
+			return result
+		}
+		if result.Report != nil {
+			if err := client.RecordChanges(hook.Folder, result.Report); err != nil {
+				result.Err = err
+				return result
+			}
+		}
+		result.Snapshot, result.Err = client.FindLatestSnapshot(hook.Folder)
+	}
+	return result
+}
+
+// jsonWebhookResult is the JSON form of a webhook result
+type jsonWebhookResult struct {
+	Hook     string      `json:"hook"`
+	Action   string      `json:"action" enum:"webhook_action"`
+	Folder   string      `json:"folder"`
+	Snapshot string      `json:"snapshot,omitempty"`
+	ExitCode int         `json:"exit_code"`
+	Report   *jsonReport `json:"report,omitempty"`
+}
+
+// newJSONWebhookResult converts a successful webhook result to its JSON form
+func newJSONWebhookResult(result WebhookResult) jsonWebhookResult {
+	out := jsonWebhookResult{
+		Hook:   result.Hook.Name,
+		Action: result.Hook.Action.String(),
+		Folder: result.Hook.Folder,
+	}
+	if result.Snapshot != "" {
+		out.Snapshot = snapshotStem(result.Snapshot)
+	}
+	if result.Report != nil {
+		report := newJSONReport(result.Report)
+		out.Report = &report
+		out.ExitCode = result.Report.ExitCode()
+	}
+	return out
+}
@@ -0,0 +1,142 @@
+//go:build !readonly
+
+package merkle
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// callWebhook posts body to a webhook with the given headers and decodes its
+// JSON result into out, if not nil
+func callWebhook(t *testing.T, server *Server, method, name, body string, headers map[string]string, out any) int {
+	t.Helper()
+	r := httptest.NewRequest(method, "/hooks/"+name, strings.NewReader(body))
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if out != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s: %v\n%s", name, err, w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+// signature returns the X-Hub-Signature-256 header of a body
+func signature(secret, body string) map[string]string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return map[string]string{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(mac.Sum(nil))}
+}
+
+// Webhooks scan their folder only when the caller proves it knows the secret
+func TestWebhooksScanWhenSigned(t *testing.T) {
+	folder := filepath.Join(t.TempDir(), "app")
+	writeFiles(t, folder, map[string]string{"a.txt": "alpha"})
+	var results []WebhookResult
+	server := &Server{
+		StorageDir: t.TempDir(),
+		Tokens:     &TokenAuthorizer{tokens: map[string]Principal{HashToken("admin"): {Role: RoleAdmin}}},
+		Webhooks: []Webhook{
+			{Name: "deploy", Folder: folder, Secret: "s3cret"},
+			{Name: "check", Folder: folder, Secret: "s3cret", Action: WebhookVerify},
+		},
+		OnWebhook: func(result WebhookResult) { results = append(results, result) },
+	}
+	body := `{"ref":"main"}`
+
+	for _, tc := range []struct {
+		name, method, hook string
+		headers            map[string]string
+		want               int
+	}{
+		{"unsigned", http.MethodPost, "deploy", nil, http.StatusUnauthorized},
+		{"wrong secret", http.MethodPost, "deploy", signature("guess", body), http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "deploy", map[string]string{"X-Webhook-Token": "guess"}, http.StatusUnauthorized},
+		{"bearer token", http.MethodPost, "deploy", map[string]string{"Authorization": "Bearer admin"}, http.StatusUnauthorized},
+		{"not posted", http.MethodGet, "deploy", signature("s3cret", body), http.StatusMethodNotAllowed},
+		{"unknown hook", http.MethodPost, "other", signature("s3cret", body), http.StatusNotFound},
+		{"verify without baseline", http.MethodPost, "check", signature("s3cret", body), http.StatusConflict},
+	} {
+		if code := callWebhook(t, server, tc.method, tc.hook, body, tc.headers, nil); code != tc.want {
+			t.Errorf("%s: %d, want %d", tc.name, code, tc.want)
+		}
+	}
+	if len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("OnWebhook saw %+v, want only the failed verify", results)
+	}
+
+	var result struct {
+		Snapshot string `json:"snapshot"`
+		ExitCode int    `json:"exit_code"`
+		Report   *struct {
+			Changes []json.RawMessage `json:"changes"`
+		} `json:"report"`
+	}
+	if code := callWebhook(t, server, http.MethodPost, "deploy", body, signature("s3cret", body), &result); code != http.StatusOK {
+		t.Fatalf("signed call: %d", code)
+	}
+	if result.Snapshot == "" || result.Report != nil {
+		t.Errorf("first scan = %+v, want a snapshot and nothing to compare with", result)
+	}
+
+	writeFiles(t, folder, map[string]string{"b.txt": "beta"})
+	if code := callWebhook(t, server, http.MethodPost, "deploy", "", map[string]string{"X-Webhook-Token": "s3cret"}, &result); code != http.StatusOK {
+		t.Fatalf("call with the token: %d", code)
+	}
+	if result.Report == nil || len(result.Report.Changes) != 1 || result.ExitCode != 1 {
+		t.Errorf("second scan = %+v, want the added file", result)
+	}
+	if len(results) != 3 || results[2].Err != nil || results[2].Snapshot == "" {
+		t.Errorf("OnWebhook saw %+v", results)
+	}
+}
+
+// Webhook files name each hook once, with a folder, a secret and a known action
+func TestLoadWebhookFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "hooks.csv")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# name,folder,secret,action,namespace\ndeploy,/srv/app,s3cret\ncheck,/srv/app,s3cret,verify,team-a\n")
+	hooks, err := LoadWebhookFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Webhook{
+		{Name: "deploy", Folder: "/srv/app", Secret: "s3cret"},
+		{Name: "check", Folder: "/srv/app", Secret: "s3cret", Action: WebhookVerify, Namespace: "team-a"},
+	}
+	if len(hooks) != len(want) || hooks[0] != want[0] || hooks[1] != want[1] {
+		t.Errorf("hooks = %+v, want %+v", hooks, want)
+	}
+
+	for _, bad := range []string{
+		"deploy,/srv/app\n",
+		"../deploy,/srv/app,s3cret\n",
+		"deploy,/srv/app,\n",
+		"deploy,/srv/app,s3cret,restore\n",
+		"deploy,/srv/app,s3cret,verify,../team-a\n",
+		"deploy,/srv/app,s3cret\ndeploy,/srv/other,s3cret\n",
+	} {
+		write(bad)
+		if _, err := LoadWebhookFile(filename); err == nil {
+			t.Errorf("webhook file %q was loaded", bad)
+		}
+	}
+}
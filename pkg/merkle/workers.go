@@ -0,0 +1,177 @@
+package merkle
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// WithHashWorkers fixes how many files are hashed at the same time, instead of
+// tuning the number while scanning; see WithAdaptiveHashWorkers. The walk
+// itself stays sequential and the leaves are sorted afterwards, so the tree
+// does not depend on the number of workers. Values below 1 hash one file at a
+// time. With more than one worker, a LeafHasher or Transformer given to the
+// client must be safe for concurrent use.
+func WithHashWorkers(n int) Option {
+	return func(c *MerkleClient) {
+		c.scan.workers = n
+		c.scan.adaptive = false
+	}
+}
+
+// hashJob is a regular file found by the walk that still has to be hashed
+type hashJob struct {
+	path    string
+	relPath string
+	info    os.FileInfo
+	node    *MerkleNode // the file's leaf, filled in once it is hashed
+	first   *MerkleNode // leaf of the file hashing the same inode, or nil
+}
+
+// isRecordedError reports whether a hashing error is recorded as an errored
+// leaf rather than failing the scan
+func isRecordedError(err error) bool {
+	return err == errHashTimeout || err == errStaleHandle
+}
+
+// hashPool hashes the files the walk finds on opts.workers goroutines while
+// the walk goes on, of which a workerTuner lets as many hash at the same time
+// as pays off when opts.adaptive is set. Scans sharing a WorkerBudget are not
+// tuned: the budget sets their concurrency, and waiting for it would skew the
+// measurements. The walk waits while every worker is busy, so besides the
+// leaves only a few files are held at a time, however large the folder. Once
+// a file fails with an error that aborts the scan, or the scan is cancelled,
+// the files not yet started are skipped.
+type hashPool struct {
+	opts  scanOptions
+	jobs  chan hashJob
+	tuner *workerTuner
+	wg    sync.WaitGroup
+
+	mu  sync.Mutex
+	err error // the error that aborted the scan
+}
+
+// newHashPool starts the workers of a scan
+func newHashPool(opts scanOptions) *hashPool {
+	workers := max(1, opts.workers)
+	p := &hashPool{opts: opts, jobs: make(chan hashJob, workers)}
+	if opts.adaptive && workers > 1 && opts.budget == nil {
+		p.tuner = newWorkerTuner(workers)
+	}
+	for w := 0; w < workers; w++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// work hashes files until the walk is done
+func (p *hashPool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		if p.failed() != nil || p.opts.canceled() != nil {
+			continue
+		}
+		p.tuner.acquire()
+		start := time.Now()
+		digest, unstable, err := hashJobFile(job, p.opts)
+		p.tuner.release(digest.size, time.Since(start))
+		if err != nil && !isRecordedError(err) {
+			p.fail(err)
+			continue
+		}
+		fillLeaf(job, digest, unstable, err, p.opts)
+	}
+}
+
+// submit hands a file to the workers, waiting while all of them are busy. It
+// returns the error that aborted the scan, if any.
+func (p *hashPool) submit(job hashJob) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	select {
+	case p.jobs <- job:
+		return nil
+	case <-p.opts.done():
+		return p.opts.canceled()
+	}
+}
+
+// wait waits until the files handed over are hashed and returns the error
+// that aborted the scan, if any
+func (p *hashPool) wait() error {
+	close(p.jobs)
+	p.wg.Wait()
+	if err := p.opts.canceled(); err != nil {
+		return err
+	}
+	return p.failed()
+}
+
+// fail records the first error that aborts the scan
+func (p *hashPool) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// failed returns the error that aborted the scan, if any
+func (p *hashPool) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// hashAliases fills the leaves of hardlinks once the other files are hashed.
+// They reuse the digest of the first link unless it was unstable or errored,
+// in which case they are hashed themselves, as in a sequential scan.
+func hashAliases(aliases []hashJob, opts scanOptions) error {
+	for _, job := range aliases {
+		if first := job.first; first.Err == "" && !first.Unstable {
+			digest := fileDigest{hash: first.Hash, size: first.Size, extra: first.Digests, chunks: first.Chunks, kind: first.Content}
+			fillLeaf(job, digest, false, nil, opts)
+			continue
+		}
+		digest, unstable, err := hashJobFile(job, opts)
+		if err != nil && !isRecordedError(err) {
+			return err
+		}
+		fillLeaf(job, digest, unstable, err, opts)
+	}
+	return nil
+}
+
+// fillLeaf records the outcome of hashing the file of a job in its leaf
+func fillLeaf(job hashJob, digest fileDigest, unstable bool, err error, opts scanOptions) {
+	node := job.node
+	opts.tracker.processed(job.relPath, digest.size)
+	if err != nil {
+		node.Hash = hashData([]byte("error:" + job.relPath))
+		node.Err = err.Error()
+		return
+	}
+
+	node.Hash = digest.hash
+	node.Unstable = unstable
+	node.Size = digest.size
+	node.Digests = digest.extra
+	node.Chunks = digest.chunks
+	node.Content = digest.kind
+	node.Attributes, node.HasAttributes = fileAttributesOf(job.info)
+	if !unstable {
+		opts.cache.store(job.relPath, job.info, node)
+	}
+}
+
+// hashJobFile hashes the file of a job once a worker of the budget is free
+func hashJobFile(job hashJob, opts scanOptions) (fileDigest, bool, error) {
+	if err := opts.budget.acquire(opts.ctx, opts.budgetQueue, opts.priority); err != nil {
+		return fileDigest{}, false, err
+	}
+	defer opts.budget.release()
+	return hashRetryingStale(job.path, job.relPath, opts)
+}
@@ -0,0 +1,178 @@
+package merkle
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// WriteYAMLReport writes a change report as YAML, with the fields and values
+// of WriteJSONReport in the same order, for tools and people that prefer it
+func WriteYAMLReport(w io.Writer, report *ChangeReport) error {
+	return writeYAML(w, newJSONReport(report))
+}
+
+// writeYAML writes a value as a YAML document. The value is encoded as JSON
+// first, so it follows the JSON tags of its type and the YAML always says
+// what the JSON output says.
+func writeYAML(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	node, err := readYAMLNode(decoder)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	switch {
+	case node.isEmpty():
+		fmt.Fprintln(out, node.inline())
+	case node.keys != nil || node.items != nil:
+		node.write(out, 0)
+	default:
+		fmt.Fprintln(out, node.scalar)
+	}
+	return out.Flush()
+}
+
+// yamlNode is a decoded JSON value that keeps the order of object keys
+type yamlNode struct {
+	scalar string      // YAML form of a string, number, bool or null
+	keys   []string    // object keys in order; nil unless an object
+	values []*yamlNode // object values, by position of their key
+	items  []*yamlNode // array items; nil unless an array
+}
+
+// readYAMLNode decodes the next JSON value
+func readYAMLNode(decoder *json.Decoder) (*yamlNode, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		node := &yamlNode{}
+		if token == '{' {
+			node.keys = []string{}
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := readYAMLNode(decoder)
+				if err != nil {
+					return nil, err
+				}
+				node.keys = append(node.keys, key.(string))
+				node.values = append(node.values, value)
+			}
+		} else {
+			node.items = []*yamlNode{}
+			for decoder.More() {
+				item, err := readYAMLNode(decoder)
+				if err != nil {
+					return nil, err
+				}
+				node.items = append(node.items, item)
+			}
+		}
+		// The closing delimiter
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case string:
+		return &yamlNode{scalar: yamlString(token)}, nil
+	case json.Number:
+		return &yamlNode{scalar: token.String()}, nil
+	case bool:
+		return &yamlNode{scalar: fmt.Sprint(token)}, nil
+	default:
+		return &yamlNode{scalar: "null"}, nil
+	}
+}
+
+// isEmpty reports whether the node is an empty object or array
+func (n *yamlNode) isEmpty() bool {
+	return (n.keys != nil && len(n.keys) == 0) || (n.items != nil && len(n.items) == 0)
+}
+
+// inline returns the node when it fits on the line of its key: a scalar, or
+// an empty object or array
+func (n *yamlNode) inline() string {
+	switch {
+	case n.keys != nil && len(n.keys) == 0:
+		return "{}"
+	case n.items != nil && len(n.items) == 0:
+		return "[]"
+	}
+	return n.scalar
+}
+
+// isBlock reports whether the node is written on lines of its own
+func (n *yamlNode) isBlock() bool {
+	return (n.keys != nil || n.items != nil) && !n.isEmpty()
+}
+
+// write writes an object or array in block style at an indentation
+func (n *yamlNode) write(out *bufio.Writer, indent int) {
+	pad := strings.Repeat(" ", indent)
+	if n.keys != nil {
+		for i, key := range n.keys {
+			value := n.values[i]
+			if !value.isBlock() {
+				fmt.Fprintf(out, "%s%s: %s\n", pad, yamlString(key), value.inline())
+				continue
+			}
+			fmt.Fprintf(out, "%s%s:\n", pad, yamlString(key))
+			value.write(out, indent+2)
+		}
+		return
+	}
+
+	for _, item := range n.items {
+		switch {
+		case !item.isBlock():
+			fmt.Fprintf(out, "%s- %s\n", pad, item.inline())
+		case item.keys != nil:
+			// The first key goes on the line of the dash, the others line up
+			// with it
+			var buf bytes.Buffer
+			lines := bufio.NewWriter(&buf)
+			item.write(lines, indent+2)
+			lines.Flush()
+			fmt.Fprintf(out, "%s- %s", pad, strings.TrimPrefix(buf.String(), pad+"  "))
+		default:
+			fmt.Fprintf(out, "%s-\n", pad)
+			item.write(out, indent+2)
+		}
+	}
+}
+
+// plainYAML matches strings that read back as the same string without quotes
+var plainYAML = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_./-]*$`)
+
+// yamlKeywords are plain words YAML parsers read as booleans or null
+var yamlKeywords = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true,
+	"y": true, "n": true, "null": true,
+}
+
+// yamlString returns a string as a YAML scalar, double quoted unless it is
+// plainly a string. JSON string literals are valid double quoted YAML.
+func yamlString(s string) string {
+	if plainYAML.MatchString(s) && !yamlKeywords[strings.ToLower(s)] {
+		return s
+	}
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}